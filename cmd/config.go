@@ -15,6 +15,20 @@ type Config struct {
 	MempoolDumpDirectory   string
 	TXIndex                bool
 
+	// Mempool
+	MempoolMaxTxnAgeSeconds                 uint64
+	MempoolLightweightMode                  bool
+	BitcoinExchangeDustThresholdSatoshis    int64
+	MempoolDumpIntervalSeconds              uint64
+	ReadOnlyViewRegenerationIntervalSeconds uint64
+	MempoolEnableWAL                        bool
+	MempoolRecentlyConfirmedTxnsCacheSize   uint64
+	MempoolDumpGenerations                  uint64
+	MempoolComputeMetadataOnAccept          bool
+	MempoolDroppedTxnReasonsCacheSize       uint64
+	MempoolRejectTxnsWhileSyncing           bool
+	MempoolMaxCombinedTxSizeBytes           uint64
+
 	// Peers
 	ConnectIPs             []string
 	AddIPs                 []string
@@ -83,6 +97,20 @@ func LoadConfig() *Config {
 	config.MempoolDumpDirectory = viper.GetString("mempool-dump-dir")
 	config.TXIndex = viper.GetBool("txindex")
 
+	// Mempool
+	config.MempoolMaxTxnAgeSeconds = viper.GetUint64("mempool-max-txn-age-seconds")
+	config.MempoolLightweightMode = viper.GetBool("mempool-lightweight-mode")
+	config.BitcoinExchangeDustThresholdSatoshis = viper.GetInt64("bitcoin-exchange-dust-threshold-satoshis")
+	config.MempoolDumpIntervalSeconds = viper.GetUint64("mempool-dump-interval-seconds")
+	config.ReadOnlyViewRegenerationIntervalSeconds = viper.GetUint64("readonly-view-regeneration-interval-seconds")
+	config.MempoolEnableWAL = viper.GetBool("mempool-enable-wal")
+	config.MempoolRecentlyConfirmedTxnsCacheSize = viper.GetUint64("mempool-recently-confirmed-txns-cache-size")
+	config.MempoolDumpGenerations = viper.GetUint64("mempool-dump-generations")
+	config.MempoolComputeMetadataOnAccept = viper.GetBool("mempool-compute-metadata-on-accept")
+	config.MempoolDroppedTxnReasonsCacheSize = viper.GetUint64("mempool-dropped-txn-reasons-cache-size")
+	config.MempoolRejectTxnsWhileSyncing = viper.GetBool("mempool-reject-txns-while-syncing")
+	config.MempoolMaxCombinedTxSizeBytes = viper.GetUint64("mempool-max-combined-txn-size-bytes")
+
 	// Peers
 	config.ConnectIPs = viper.GetStringSlice("connect-ips")
 	config.AddIPs = viper.GetStringSlice("add-ips")
@@ -138,6 +166,54 @@ func (config *Config) Print() {
 		glog.Infof("Mempool Dump Directory: %s", config.MempoolDumpDirectory)
 	}
 
+	if config.MempoolMaxTxnAgeSeconds > 0 {
+		glog.Infof("Mempool Max Txn Age Seconds: %d", config.MempoolMaxTxnAgeSeconds)
+	}
+
+	if config.MempoolLightweightMode {
+		glog.Infof("MEMPOOL LIGHTWEIGHT MODE")
+	}
+
+	if config.BitcoinExchangeDustThresholdSatoshis != lib.DefaultBitcoinExchangeDustThresholdSatoshis {
+		glog.Infof("BitcoinExchange Dust Threshold Satoshis: %d", config.BitcoinExchangeDustThresholdSatoshis)
+	}
+
+	if config.MempoolDumpIntervalSeconds > 0 {
+		glog.Infof("Mempool Dump Interval Seconds: %d", config.MempoolDumpIntervalSeconds)
+	}
+
+	if config.ReadOnlyViewRegenerationIntervalSeconds > 0 {
+		glog.Infof("ReadOnly View Regeneration Interval Seconds: %d", config.ReadOnlyViewRegenerationIntervalSeconds)
+	}
+
+	if config.MempoolEnableWAL {
+		glog.Infof("Mempool WAL: ON")
+	}
+
+	if config.MempoolRecentlyConfirmedTxnsCacheSize != uint64(lib.DefaultRecentlyConfirmedTxnsCacheSize) {
+		glog.Infof("Mempool Recently Confirmed Txns Cache Size: %d", config.MempoolRecentlyConfirmedTxnsCacheSize)
+	}
+
+	if config.MempoolDumpGenerations > 1 {
+		glog.Infof("Mempool Dump Generations: %d", config.MempoolDumpGenerations)
+	}
+
+	if !config.MempoolComputeMetadataOnAccept {
+		glog.Infof("Mempool Compute Metadata On Accept: OFF")
+	}
+
+	if config.MempoolDroppedTxnReasonsCacheSize != uint64(lib.DefaultDroppedTxnReasonsCacheSize) {
+		glog.Infof("Mempool Dropped Txn Reasons Cache Size: %d", config.MempoolDroppedTxnReasonsCacheSize)
+	}
+
+	if !config.MempoolRejectTxnsWhileSyncing {
+		glog.Infof("Mempool Reject Txns While Syncing: OFF")
+	}
+
+	if config.MempoolMaxCombinedTxSizeBytes > 0 {
+		glog.Infof("Mempool Max Combined Txn Size Bytes: %d", config.MempoolMaxCombinedTxSizeBytes)
+	}
+
 	if len(config.ConnectIPs) > 0 {
 		glog.Infof("Connect IPs: %s", config.ConnectIPs)
 	}
@@ -113,6 +113,23 @@ func (node *Node) Start() {
 		lib.StartDBSummarySnapshots(node.chainDB)
 	}
 
+	// Setup the mempool options. They're applied before the mempool loads its dump
+	// and starts its background goroutines.
+	mempoolOpts := lib.DefaultMempoolOptions()
+	mempoolOpts.MaxTxnAge = time.Duration(node.Config.MempoolMaxTxnAgeSeconds) * time.Second
+	mempoolOpts.LightweightMode = node.Config.MempoolLightweightMode
+	mempoolOpts.BitcoinExchangeDustThresholdSatoshis = node.Config.BitcoinExchangeDustThresholdSatoshis
+	mempoolOpts.DumpInterval = time.Duration(node.Config.MempoolDumpIntervalSeconds) * time.Second
+	mempoolOpts.ReadOnlyViewRegenerationInterval =
+		time.Duration(node.Config.ReadOnlyViewRegenerationIntervalSeconds) * time.Second
+	mempoolOpts.EnableWAL = node.Config.MempoolEnableWAL
+	mempoolOpts.RecentlyConfirmedTxnsCacheSize = uint(node.Config.MempoolRecentlyConfirmedTxnsCacheSize)
+	mempoolOpts.NumRetainedDumpGenerations = int(node.Config.MempoolDumpGenerations)
+	mempoolOpts.ComputeMetadataOnAccept = node.Config.MempoolComputeMetadataOnAccept
+	mempoolOpts.DroppedTxnReasonsCacheSize = uint(node.Config.MempoolDroppedTxnReasonsCacheSize)
+	mempoolOpts.RejectTxnsWhileSyncing = node.Config.MempoolRejectTxnsWhileSyncing
+	mempoolOpts.MaxCombinedTxSizeBytes = node.Config.MempoolMaxCombinedTxSizeBytes
+
 	// Setup the server
 	node.Server, err = lib.NewServer(
 		node.Params,
@@ -135,6 +152,7 @@ func (node *Node) Start() {
 		true,
 		node.Config.DataDirectory,
 		node.Config.MempoolDumpDirectory,
+		mempoolOpts,
 		node.Config.DisableNetworking,
 		node.Config.ReadOnlyMode,
 		node.Config.IgnoreInboundInvs,
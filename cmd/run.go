@@ -51,6 +51,56 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("mempool-dump-dir", "",
 		"When set, the mempool is initialized using a db in the directory specified, and"+
 			"subsequent dumps are also written to this dir")
+	cmd.PersistentFlags().Uint64("mempool-max-txn-age-seconds", 0,
+		"When set to a non-zero value, txns that have been sitting in the mempool for "+
+			"longer than this many seconds are evicted. Useful for clearing out txns whose "+
+			"fee was once adequate but no longer is. Defaults to zero, which means txns "+
+			"never expire.")
+	cmd.PersistentFlags().Bool("mempool-lightweight-mode", false,
+		"When set to true, the mempool validates each txn against a throwaway copy "+
+			"of its view rather than keeping a second full view around. This roughly "+
+			"halves the memory used by the mempool at the cost of more CPU per txn, "+
+			"which is a good tradeoff for observer nodes. Replacing txns already in "+
+			"the mempool is not supported in this mode.")
+	cmd.PersistentFlags().Int64("bitcoin-exchange-dust-threshold-satoshis", 1000,
+		"BitcoinExchange txns whose Bitcoin txn has an output below this many "+
+			"satoshis are rejected by the mempool as dust. Set to zero to disable "+
+			"the check.")
+	cmd.PersistentFlags().Uint64("mempool-dump-interval-seconds", 0,
+		"How often, in seconds, the mempool dumps its txns to the --mempool-dump-dir. "+
+			"Defaults to zero, which means every 30 seconds.")
+	cmd.PersistentFlags().Uint64("readonly-view-regeneration-interval-seconds", 0,
+		"How often, in seconds, the mempool regenerates the read-only view it serves "+
+			"queries from when it hasn't already done so because of the number of txns "+
+			"processed. Defaults to zero, which means every second.")
+	cmd.PersistentFlags().Bool("mempool-enable-wal", false,
+		"When set to true, the mempool appends every txn it accepts to a log in "+
+			"--mempool-dump-dir and replays it on startup, so txns accepted since the "+
+			"last dump survive a crash. Has no effect without --mempool-dump-dir.")
+	cmd.PersistentFlags().Uint64("mempool-recently-confirmed-txns-cache-size", 10000,
+		"How many hashes of recently-mined txns the mempool remembers so that it can "+
+			"reject copies of them re-relayed by peers without validating them. Set "+
+			"to zero to disable the check.")
+	cmd.PersistentFlags().Uint64("mempool-dump-generations", 1,
+		"How many of its most recent txn dumps the mempool keeps in the "+
+			"--mempool-dump-dir. On startup it loads the newest one that can be read, "+
+			"so keeping more than one protects against a corrupt dump.")
+	cmd.PersistentFlags().Bool("mempool-compute-metadata-on-accept", true,
+		"When set to true, the mempool computes the txindex metadata for each txn "+
+			"as soon as it's accepted. Relay nodes that don't serve txindex data can "+
+			"set this to false to save the work; the metadata is then computed on "+
+			"demand if it's ever requested.")
+	cmd.PersistentFlags().Uint64("mempool-dropped-txn-reasons-cache-size", 10000,
+		"How many rejected or evicted txns the mempool remembers the reason for, so "+
+			"that users can find out why their txn was dropped. Set to zero to disable it.")
+	cmd.PersistentFlags().Bool("mempool-reject-txns-while-syncing", true,
+		"When set to true, the mempool rejects new txns until the node has finished "+
+			"syncing the chain, since they'd be validated against a partial chain. "+
+			"Single-node test setups that never finish syncing should set this to false.")
+	cmd.PersistentFlags().Uint64("mempool-max-combined-txn-size-bytes", 0,
+		"When non-zero, caps the combined size in bytes of the txns in the mempool "+
+			"and the unconnected txns waiting on their parents. Unconnected txns are "+
+			"evicted to stay under it. Useful on memory-constrained nodes. Zero disables it.")
 	cmd.PersistentFlags().Bool("txindex", false,
 		"When set to true, the node will generate an index mapping transaction "+
 			"ids to transaction information. This enables the use of certain API calls "+
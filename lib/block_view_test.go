@@ -5295,14 +5295,17 @@ func TestBitcoinManagerProcessHeadersFull(t *testing.T) {
 	}
 }
 
-func _dumpAndLoadMempool(mempool *BitCloutMempool) {
+func _dumpAndLoadMempool(t *testing.T, mempool *BitCloutMempool) {
+	require := require.New(t)
+
 	mempoolDir := os.TempDir()
 	mempool.mempoolDir = mempoolDir
 	mempool.DumpTxnsToDB()
-	newMempool := NewBitCloutMempool(
+	newMempool, err := NewBitCloutMempool(
 		mempool.bc, 0, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		mempool.dataDir, mempoolDir)
+		mempool.dataDir, mempoolDir, nil)
+	require.NoError(err)
 	mempool.mempoolDir = ""
 	mempool.resetPool(newMempool)
 }
@@ -5464,10 +5467,12 @@ func TestBitcoinExchange(t *testing.T) {
 	chain.bitcoinManager = bitcoinManager
 	chain.params = paramsCopy
 	// Reset the pool to give the mempool access to the new BitcoinManager object.
-	mempool.resetPool(NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
+	newPool, err := NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0, /* minFeeRateNanosPerKB */
 		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, ""))
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	mempool.resetPool(newPool)
 
 	// Validating the first Bitcoin burn transaction via a UtxoView should
 	// fail because the block corresponding to it is not yet in the BitcoinManager.
@@ -5513,6 +5518,12 @@ func TestBitcoinExchange(t *testing.T) {
 		require.NoError(err)
 		require.Equal(1, len(mempoolTxs))
 		require.Equal(1, len(mempool.poolMap))
+
+		// The txn should be indexed by the hash of the Bitcoin txn it embeds.
+		bitcoinTxHash := txnCopy.TxnMeta.(*BitcoinExchangeMetadata).BitcoinTransaction.TxHash()
+		require.Equal(mempoolTxs[0], mempool.GetMempoolTxForBitcoinHash(bitcoinTxHash.String()))
+		require.Nil(mempool.GetMempoolTxForBitcoinHash(
+			burnTxn2.TxnMeta.(*BitcoinExchangeMetadata).BitcoinTransaction.TxHash().String()))
 	}
 
 	// Trying to add the BitcoinExchange transaction a second time should fail.
@@ -5981,7 +5992,7 @@ func TestBitcoinExchange(t *testing.T) {
 
 	// Test that the mempool can be backed up properly by dumping them and then
 	// reloading them.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// The balances according to the mempool after applying all the transactions
 	// should be correct.
@@ -6016,7 +6027,7 @@ func TestBitcoinExchange(t *testing.T) {
 
 	// Check that removals hit the database properly by calling a dump and
 	// then reloading the db state into the view.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// The balances should be zero after removing transactions from the mempool.
 	{
@@ -6048,7 +6059,7 @@ func TestBitcoinExchange(t *testing.T) {
 	}
 
 	// Check the db one more time after adding back all the txns.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Mine a block with all the mempool transactions.
 	//
@@ -6067,15 +6078,15 @@ func TestBitcoinExchange(t *testing.T) {
 	require.NoError(err)
 	_ = finalBlock1
 	// Check the mempool dumps and loads from the db properly each time
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	finalBlock2, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	finalBlock3, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Mine a Bitcoin block to unlock the rest of the transactions
 	{
@@ -6088,7 +6099,7 @@ func TestBitcoinExchange(t *testing.T) {
 	}
 	finalBlock4, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Add one for the block reward.
 	assert.Equal(len(finalBlock1.Txns), 1)
@@ -6367,10 +6378,12 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 	chain.bitcoinManager = bitcoinManager
 	chain.params = paramsCopy
 	// Reset the pool to give the mempool access to the new BitcoinManager object.
-	mempool.resetPool(NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
+	newPool, err := NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0, /* minFeeRateNanosPerKB */
 		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, ""))
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	mempool.resetPool(newPool)
 
 	// Validating the first Bitcoin burn transaction via a UtxoView should
 	// fail because the block corresponding to it is not yet in the BitcoinManager.
@@ -6882,7 +6895,7 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 
 	// Test that the mempool can be backed up properly by dumping them and then
 	// reloading them.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// The balances according to the mempool after applying all the transactions
 	// should be correct.
@@ -6917,7 +6930,7 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 
 	// Check that removals hit the database properly by calling a dump and
 	// then reloading the db state into the view.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// The balances should be zero after removing transactions from the mempool.
 	{
@@ -6949,7 +6962,7 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 	}
 
 	// Check the db one more time after adding back all the txns.
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Mine a block with all the mempool transactions.
 	//
@@ -6968,15 +6981,15 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 	require.NoError(err)
 	_ = finalBlock1
 	// Check the mempool dumps and loads from the db properly each time
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	finalBlock2, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	finalBlock3, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Mine a Bitcoin block to unlock the rest of the transactions
 	{
@@ -6989,7 +7002,7 @@ func TestBitcoinExchangeGlobalParams(t *testing.T) {
 	}
 	finalBlock4, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
 	require.NoError(err)
-	_dumpAndLoadMempool(mempool)
+	_dumpAndLoadMempool(t, mempool)
 
 	// Add one for the block reward.
 	assert.Equal(len(finalBlock1.Txns), 1)
@@ -7265,10 +7278,12 @@ func TestSpendOffOfUnminedTxnsBitcoinExchange(t *testing.T) {
 	chain.bitcoinManager = bitcoinManager
 	chain.params = paramsCopy
 	// Reset the pool to give the mempool access to the new BitcoinManager object.
-	mempool.resetPool(NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
+	newPool, err := NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0, /* minFeeRateNanosPerKB */
 		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, ""))
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	mempool.resetPool(newPool)
 
 	// The amount of work on the first burn transaction should be zero.
 	burnTxn1 := bitcoinExchangeTxns[0]
@@ -8010,10 +8025,12 @@ func TestBitcoinExchangeWithAmountNanosNonZeroAtGenesis(t *testing.T) {
 	chain.bitcoinManager = bitcoinManager
 	chain.params = paramsCopy
 	// Reset the pool to give the mempool access to the new BitcoinManager object.
-	mempool.resetPool(NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
+	newPool, err := NewBitCloutMempool(chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0, /* minFeeRateNanosPerKB */
 		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, ""))
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	mempool.resetPool(newPool)
 
 	// The amount of work on the first burn transaction should be zero.
 	burnTxn1 := bitcoinExchangeTxns[0]
@@ -229,10 +229,11 @@ func NewTestMiner(t *testing.T, chain *Blockchain, params *BitCloutParams, isSen
 	_ = assert
 	_ = require
 
-	mempool := NewBitCloutMempool(
+	mempool, err := NewBitCloutMempool(
 		chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
 	minerPubKeys := []string{}
 	if isSender {
 		minerPubKeys = append(minerPubKeys, senderPkString)
@@ -202,10 +202,20 @@ var (
 	_PrefixRecloutedPostHashReclouterPubKeyRecloutPostHash = []byte{46}
 	_PrefixDiamondedPostHashDiamonderPKIDDiamondLevel      = []byte{47}
 
+	// Prefixes for the long-lived mempool dump db. Each dump is written to a free
+	// slot and then made the newest generation by rewriting the generations key, so
+	// the older generations stay intact until the new one is complete.
+	// <prefix, slot byte, time added uint64, tx hash BlockHash> -> <*MsgBitCloutTxn>
+	_PrefixMempoolDumpSlotTxn = []byte{48}
+	// The slots holding complete dumps, newest first. Dumps from before generations
+	// were retained have a single slot here.
+	// <prefix> -> <slot bytes>
+	_KeyMempoolDumpActiveSlot = []byte{49}
+
 	// TODO: This process is a bit error-prone. We should come up with a test or
 	// something to at least catch cases where people have two prefixes with the
 	// same ID.
-	// NEXT_TAG: 48
+	// NEXT_TAG: 50
 )
 
 // A PKID is an ID associated with a public key. In the DB, various fields are
@@ -2960,6 +2970,13 @@ type CreatorCoinTransferTxindexMetadata struct {
 	PostHashHex                string
 }
 
+// DiamondTxindexMetadata is set for any txn that carries a DiamondLevelKey in its
+// ExtraData, regardless of the txn type.
+type DiamondTxindexMetadata struct {
+	DiamondLevel int64
+	PostHashHex  string
+}
+
 type UpdateProfileTxindexMetadata struct {
 	ProfilePublicKeyBase58Check string
 
@@ -2983,6 +3000,10 @@ type SubmitPostTxindexMetadata struct {
 
 	// The profiles that are mentioned are in the AffectedPublicKeys
 	// MentionedPublicKeyBase58Check in AffectedPublicKeys
+
+	// All of the $ and @ tags parsed out of the body, without the prefix, whether
+	// or not they line up to a profile. $ tags come first.
+	ParsedMentions []string
 }
 type LikeTxindexMetadata struct {
 	// LikerPublicKeyBase58Check = TransactorPublicKeyBase58Check
@@ -3036,6 +3057,7 @@ type TransactionMetadata struct {
 	FollowTxindexMetadata              *FollowTxindexMetadata
 	PrivateMessageTxindexMetadata      *PrivateMessageTxindexMetadata
 	SwapIdentityTxindexMetadata        *SwapIdentityTxindexMetadata
+	DiamondTxindexMetadata             *DiamondTxindexMetadata
 }
 
 func DbGetTxindexTransactionRefByTxIDWithTxn(txn *badger.Txn, txID *BlockHash) *TransactionMetadata {
@@ -4640,7 +4662,7 @@ func DBGetPaginatedProfilesByBitCloutLocked(
 }
 
 // -------------------------------------------------------------------------------------
-// Mempool Txn mapping funcions
+// Mempool Txn mapping functions
 // <prefix, txn hash BlockHash> -> <*MsgBitCloutTxn>
 // -------------------------------------------------------------------------------------
 
@@ -4768,6 +4790,94 @@ func DbDeleteAllMempoolTxns(handle *badger.DB) error {
 	return nil
 }
 
+// -------------------------------------------------------------------------------------
+// Mempool dump slot functions
+// <prefix, slot byte, time added uint64, txn hash BlockHash> -> <*MsgBitCloutTxn>
+// <prefix> -> <active slot byte>
+// -------------------------------------------------------------------------------------
+
+func DbPrefixForMempoolDumpSlot(slot byte) []byte {
+	// Make a copy to avoid multiple calls to this function re-using the same slice.
+	prefixCopy := append([]byte{}, _PrefixMempoolDumpSlotTxn...)
+	return append(prefixCopy, slot)
+}
+
+func _dbKeyForMempoolDumpSlotTxn(slot byte, mempoolTx *MempoolTx) []byte {
+	key := DbPrefixForMempoolDumpSlot(slot)
+	key = append(key, EncodeUint64(uint64(mempoolTx.Added.UnixNano()))...)
+	key = append(key, mempoolTx.Hash[:]...)
+	return key
+}
+
+func DbPutMempoolDumpSlotTxnsWithTxn(txn *badger.Txn, slot byte, allTxns []*MempoolTx) error {
+	for _, mempoolTx := range allTxns {
+		mempoolTxnBytes, err := mempoolTx.Tx.ToBytes(false /*preSignatureBool*/)
+		if err != nil {
+			return errors.Wrapf(err, "DbPutMempoolDumpSlotTxnsWithTxn: Problem encoding "+
+				"mempool tx hash %s to bytes", mempoolTx.Hash.String())
+		}
+		if err := txn.Set(_dbKeyForMempoolDumpSlotTxn(slot, mempoolTx), mempoolTxnBytes); err != nil {
+			return errors.Wrapf(err, "DbPutMempoolDumpSlotTxnsWithTxn: Putting "+
+				"mempool tx hash %s failed", mempoolTx.Hash.String())
+		}
+	}
+
+	return nil
+}
+
+// DbGetMempoolDumpGenerations returns the slots holding complete dumps, newest first.
+// It returns no slots if no dump has been completed yet.
+func DbGetMempoolDumpGenerations(handle *badger.DB) []byte {
+	slots := []byte{}
+	handle.View(func(txn *badger.Txn) error {
+		slotsItem, err := txn.Get(_KeyMempoolDumpActiveSlot)
+		if err != nil {
+			return nil
+		}
+		return slotsItem.Value(func(valBytes []byte) error {
+			slots = append(slots, valBytes...)
+			return nil
+		})
+	})
+	return slots
+}
+
+func DbPutMempoolDumpGenerations(handle *badger.DB, slots []byte) error {
+	return handle.Update(func(txn *badger.Txn) error {
+		return txn.Set(_KeyMempoolDumpActiveSlot, slots)
+	})
+}
+
+// DbGetMempoolDumpActiveSlot returns the slot holding the last complete dump. The
+// bool is false if no dump has been completed yet.
+func DbGetMempoolDumpActiveSlot(handle *badger.DB) (_slot byte, _exists bool) {
+	slots := DbGetMempoolDumpGenerations(handle)
+	if len(slots) == 0 {
+		return 0, false
+	}
+	return slots[0], true
+}
+
+// DbGetMempoolDumpSlotTxnsSortedByTimeAdded returns the txns dumped to the given slot.
+func DbGetMempoolDumpSlotTxnsSortedByTimeAdded(handle *badger.DB, slot byte) (_mempoolTxns []*MsgBitCloutTxn, _error error) {
+	_, valuesFound := _enumerateKeysForPrefix(handle, DbPrefixForMempoolDumpSlot(slot))
+
+	mempoolTxns := []*MsgBitCloutTxn{}
+	for _, mempoolTxnBytes := range valuesFound {
+		mempoolTxn := &MsgBitCloutTxn{}
+		err := mempoolTxn.FromBytes(mempoolTxnBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "DbGetMempoolDumpSlotTxnsSortedByTimeAdded: failed to decode mempoolTxnBytes.")
+		}
+		mempoolTxns = append(mempoolTxns, mempoolTxn)
+	}
+
+	// Like DbGetAllMempoolTxnsSortedByTimeAdded, the keys include the time added so
+	// the txns come back in order.
+
+	return mempoolTxns, nil
+}
+
 func DbDeleteMempoolTxnWithTxn(txn *badger.Txn, mempoolTx *MempoolTx) error {
 
 	// When a mapping exists, delete it.
@@ -212,14 +212,25 @@ const (
 	HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent RuleError = "HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent"
 
 	TxErrorTooLarge                                                 RuleError = "TxErrorTooLarge"
+	TxErrorTooManyInputs                                            RuleError = "TxErrorTooManyInputs"
+	TxErrorTransactorHasNoUtxos                                     RuleError = "TxErrorTransactorHasNoUtxos"
+	TxErrorStillSyncing                                             RuleError = "TxErrorStillSyncing"
 	TxErrorDuplicate                                                RuleError = "TxErrorDuplicate"
 	TxErrorDuplicateBitcoinExchangeTxn                              RuleError = "TxErrorDuplicateBitcoinExchangeTxn"
+	TxErrorBitcoinExchangeHasNoOutputs                              RuleError = "TxErrorBitcoinExchangeHasNoOutputs"
+	TxErrorBitcoinExchangeHasNoBurnOutput                           RuleError = "TxErrorBitcoinExchangeHasNoBurnOutput"
 	TxErrorDoubleSpend                                              RuleError = "TxErrorDoubleSpend"
 	TxErrorIndividualBlockReward                                    RuleError = "TxErrorIndividualBlockReward"
+	TxErrorNilTxnMeta                                               RuleError = "TxErrorNilTxnMeta"
 	TxErrorInsufficientFeeMinFee                                    RuleError = "TxErrorInsufficientFeeMinFee"
 	TxErrorInsufficientFeeRateLimit                                 RuleError = "TxErrorInsufficientFeeRateLimit"
 	TxErrorInsufficientFeePriorityQueue                             RuleError = "TxErrorInsufficientFeePriorityQueue"
 	TxErrorUnconnectedTxnNotAllowed                                 RuleError = "TxErrorUnconnectedTxnNotAllowed"
+	TxErrorUnconnectedTxnOfferedTooOften                            RuleError = "TxErrorUnconnectedTxnOfferedTooOften"
+	TxErrorCombinedTxSizeLimitReached                               RuleError = "TxErrorCombinedTxSizeLimitReached"
+	TxErrorTooManyPendingForPublicKey                               RuleError = "TxErrorTooManyPendingForPublicKey"
+	TxErrorTxnTypeLimitReached                                      RuleError = "TxErrorTxnTypeLimitReached"
+	TxErrorTxnTypeByteLimitReached                                  RuleError = "TxErrorTxnTypeByteLimitReached"
 	TxErrorCannotProcessBitcoinExchangeUntilBitcoinManagerIsCurrent RuleError = "TxErrorCannotProcessBitcoinExchangeUntilBitcoinManagerIsCurrent"
 )
 
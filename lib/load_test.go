@@ -145,10 +145,12 @@ func TestComputeMaxTPS(t *testing.T) {
 
 	// At this point we have some number of transactions. Clear the mempool and see how
 	// long it takes to add them all to the mempool.
-	mempool.resetPool(NewBitCloutMempool(mempool.bc, 0, /* rateLimitFeeRateNanosPerKB */
+	newPool, err := NewBitCloutMempool(mempool.bc, 0, /* rateLimitFeeRateNanosPerKB */
 		0, /* minFeeRateNanosPerKB */
 		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, ""))
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	mempool.resetPool(newPool)
 	{
 		timeStart := time.Now()
 		for _, tx := range txns {
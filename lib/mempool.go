@@ -1,18 +1,22 @@
 package lib
 
 import (
+	"bufio"
 	"bytes"
 	"container/heap"
 	"container/list"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/btcsuite/btcutil"
 	"github.com/gernest/mention"
-	"log"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strings"
 	"sync/atomic"
@@ -22,6 +26,7 @@ import (
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/decred/dcrd/lru"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
@@ -46,6 +51,16 @@ const (
 
 	// The maximum number of bytes a single unconnected transaction can take up
 	MaxUnconnectedTxSizeBytes = 100000
+
+	// The number of txns a channel returned by SubscribePublicKey buffers. Txns
+	// that arrive while the buffer is full are dropped for that subscriber.
+	PublicKeySubscriptionBufferSize = 100
+
+	// The number of times the same unconnected txn can be offered to the pool before
+	// it's refused until UnconnectedTxnExpirationInterval has passed since the first
+	// offer. This stops a peer from keeping a txn that never connects around forever
+	// by re-sending it every time it's dropped.
+	MaxUnconnectedTxnOffers = 3
 )
 
 var (
@@ -57,12 +72,156 @@ var (
 	ReadOnlyUtxoViewRegenerationIntervalSeconds = float64(1.0)
 	ReadOnlyUtxoViewRegenerationIntervalTxns    = int64(1000)
 
+	// When non-zero, the readOnlyUtxoView is also regenerated whenever the number
+	// of accepted txns it's missing exceeds this value. Under heavy load, a few
+	// calls to processTransaction can promote many unconnectedTxns at once so the
+	// interval above can let the readOnly view fall far behind. See
+	// GetReadOnlyViewLag.
+	ReadOnlyUtxoViewMaxLagTxns = int64(0)
+
+	// How often the expired txn sweeper wakes up to check for txns that have
+	// been sitting in the pool for longer than maxTxnAge. Only relevant when
+	// maxTxnAge is set.
+	ExpiredTxnSweepIntervalSeconds = float64(60.0)
+
 	// LowFeeTxLimitBytesPerTenMinutes defines the number of bytes per 10 minutes of "low fee"
 	// transactions the mempool will tolerate before it starts rejecting transactions
 	// that fail to meet the MinTxFeePerKBNanos threshold.
 	LowFeeTxLimitBytesPerTenMinutes = 150000 // Allow 150KB per minute in low-fee txns.
+
+	// The runes that end an @ or $ mention when parsing SubmitPost bodies in
+	// ComputeTransactionMetadata.
+	MentionTerminators = []rune(" ,.\n&*()-_+~'\"[]{}")
+
+	// The maximum number of mentioned profiles ComputeTransactionMetadata adds to a
+	// SubmitPost txn's AffectedPublicKeys. Mentions beyond this are still recorded in
+	// ParsedMentions but otherwise ignored so that a post mentioning thousands of
+	// profiles can't blow up the metadata we store and serve.
+	MaxMentionedAffectedPublicKeys = 100
+
+	// BitcoinExchange txns whose Bitcoin txn has an output below this many satoshis
+	// are rejected as dust unless the pool is configured with a different threshold.
+	DefaultBitcoinExchangeDustThresholdSatoshis = int64(1000)
+
+	// How often StartMempoolDBDumper dumps the pool's txns to its mempoolDir unless
+	// the pool is configured with a different interval.
+	DefaultMempoolDBDumpInterval = 30 * time.Second
+
+	// How many complete dumps are kept in the mempoolDir unless the pool is
+	// configured with a different number. See SetNumRetainedDumpGenerations.
+	DefaultNumRetainedDumpGenerations = 1
+
+	// The number of recently-confirmed txn hashes the pool remembers in order to
+	// reject re-relayed copies of txns that were just mined, unless the pool is
+	// configured with a different size. This covers the last few blocks' worth.
+	DefaultRecentlyConfirmedTxnsCacheSize = uint(10000)
+
+	// The number of rejected or evicted txns the pool remembers the reason for so that
+	// users can find out why their txn was dropped, unless the pool is configured with
+	// a different size.
+	DefaultDroppedTxnReasonsCacheSize = uint(10000)
+
+	// How much weight each newly connected block gets in the pool's moving average
+	// of how full blocks are. See EstimateInclusionWithinBlocks.
+	BlockFillRateAverageWeight = 0.1
+
+	// GetMedianFeeRate falls back to the pool's minimum fee rate when the readOnly
+	// view holds fewer txns than this, since the median of a handful of txns says
+	// little about what a "normal" fee is.
+	MedianFeeRateMinTxns = 10
+)
+
+// The reasons passed to the callback set with SetOnEvict.
+const (
+	// The txn was explicitly removed, e.g. with InefficientRemoveTransaction.
+	EvictReasonRemoved = "removed"
+	// The txn was in the pool for longer than maxTxnAge.
+	EvictReasonExpired = "expired"
+	// The txn no longer connects because a txn it depends on was evicted.
+	EvictReasonDependencyEvicted = "dependency-evicted"
+	// The txn had the lowest fee rate of its type when a higher-fee txn of the same
+	// type arrived and the type was at its limit. See SetTxnTypeLimits.
+	EvictReasonTxnTypeLimit = "txn-type-limit"
+	// Like EvictReasonTxnTypeLimit but for the txn type's byte limit. See
+	// SetTxnTypeByteLimits.
+	EvictReasonTxnTypeByteLimit = "txn-type-byte-limit"
+	// The txn no longer connects after the pool was pointed at a different
+	// Blockchain. See SetBlockchain.
+	EvictReasonBlockchainChanged = "blockchain-changed"
+)
+
+// EvictionPolicy decides which txns get evicted to make room for a new txn when its
+// type is at one of its limits. See SetEvictionPolicy.
+type EvictionPolicy int
+
+const (
+	// Evict the txns with the lowest fee rate, and only for a txn that pays a higher
+	// fee rate than all of them. This is the default.
+	EvictionPolicyFeeBased EvictionPolicy = iota
+	// Evict the txns that were added first regardless of their fees. Useful on
+	// private networks where every txn pays the same fee rate, since under
+	// EvictionPolicyFeeBased no new txn could ever get in once a limit is reached.
+	EvictionPolicyOldestFirst
+)
+
+func (policy EvictionPolicy) String() string {
+	switch policy {
+	case EvictionPolicyFeeBased:
+		return "FeeBased"
+	case EvictionPolicyOldestFirst:
+		return "OldestFirst"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", int(policy))
+	}
+}
+
+// StatsHook receives timings for the stages of accepting a txn into the pool, e.g.
+// to feed latency metrics. Its methods are called with the pool's lock held so they
+// must be fast and must not call back into the pool. See SetStatsHook.
+type StatsHook interface {
+	// How long it took to connect the txn to the backup view.
+	ObserveConnectDuration(d time.Duration)
+	// How long it took to compute the txn's TransactionMetadata.
+	ObserveMetadataDuration(d time.Duration)
+	// How long it took to promote the unconnectedTxns that depended on the txn.
+	ObserveUnconnectedPromotionDuration(d time.Duration)
+}
+
+// The results a TxnDecision can have.
+const (
+	// The txn was added to the pool.
+	TxnDecisionResultAccepted = "accepted"
+	// The txn is missing parents. Whether it was then kept as an unconnectedTxn
+	// is up to the caller of tryAcceptTransaction.
+	TxnDecisionResultMissingParents = "missing-parents"
+	// The txn was rejected. See TxnDecision.Err for why.
+	TxnDecisionResultRejected = "rejected"
 )
 
+// TxnDecision describes what the pool decided to do with a txn passed to
+// tryAcceptTransaction. See TxnLogger.
+type TxnDecision struct {
+	// Nil if the txn had no TxnMeta.
+	Hash    *BlockHash
+	TxnType TxnType
+	// Only set when the txn was accepted, since a rejected txn may not get far
+	// enough for its fee to be computed.
+	Fee      uint64
+	FeePerKB uint64
+	// One of the TxnDecisionResult values.
+	Result string
+	// Why the txn was rejected. Nil unless Result is TxnDecisionResultRejected.
+	Err error
+}
+
+// TxnLogger receives a TxnDecision for every txn the pool accepts or rejects, e.g.
+// so operators using JSON logging can query the pool's decisions by field rather
+// than parsing glog lines. It's called with the pool's lock held so it must be fast
+// and must not call back into the pool. See SetTxnLogger.
+type TxnLogger interface {
+	LogTxnDecision(decision *TxnDecision)
+}
+
 // MempoolTx contains a transaction along with additional metadata like the
 // fee and time added.
 type MempoolTx struct {
@@ -91,10 +250,83 @@ type MempoolTx struct {
 	// The fee rate of the transaction in nanos per KB.
 	FeePerKB uint64
 
+	// Whether the txn was submitted directly to this node, e.g. through its API,
+	// rather than relayed to us by a peer. Local txns are never rate-limited, are
+	// relayed ahead of other txns, and should be skipped by any eviction that's
+	// based on the txFeeMinheap.
+	Local bool
+
+	// Tags are the caller-supplied key/value pairs the txn was accepted with through
+	// TryAcceptTransactionWithTags, e.g. to record where it came from for an audit
+	// trail. nil for txns accepted any other way. Treat as read-only.
+	Tags map[string]string
+
 	// index is used by the heap logic to allow for modification in-place.
 	index int
 }
 
+// MarshalJSON encodes the MempoolTx in a form that's convenient for external
+// tooling. The txn itself is encoded as hex, and the TransactorPublicKeyBase58Check
+// is pulled out of TxMeta, which is left out otherwise.
+func (mempoolTx *MempoolTx) MarshalJSON() ([]byte, error) {
+	txBytes, err := mempoolTx.Tx.ToBytes(false /*preSignature*/)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MempoolTx.MarshalJSON: Problem serializing txn: ")
+	}
+	transactorPublicKeyBase58Check := ""
+	if mempoolTx.TxMeta != nil {
+		transactorPublicKeyBase58Check = mempoolTx.TxMeta.TransactorPublicKeyBase58Check
+	}
+
+	return json.Marshal(struct {
+		Hash                           string
+		TxHex                          string
+		TxnType                        string
+		TransactorPublicKeyBase58Check string `json:",omitempty"`
+		Added                          time.Time
+		Height                         uint32
+		Fee                            uint64
+		FeePerKB                       uint64
+		TxSizeBytes                    uint64
+		Tags                           map[string]string `json:",omitempty"`
+	}{
+		Hash:                           hex.EncodeToString(mempoolTx.Hash[:]),
+		TxHex:                          hex.EncodeToString(txBytes),
+		TxnType:                        mempoolTx.Tx.TxnMeta.GetTxnType().String(),
+		TransactorPublicKeyBase58Check: transactorPublicKeyBase58Check,
+		Added:                          mempoolTx.Added,
+		Height:                         mempoolTx.Height,
+		Fee:                            mempoolTx.Fee,
+		FeePerKB:                       mempoolTx.FeePerKB,
+		TxSizeBytes:                    mempoolTx.TxSizeBytes,
+		Tags:                           mempoolTx.Tags,
+	})
+}
+
+// droppedTxnReason is an entry in the pool's droppedTxnReasonsList recording why a txn
+// was rejected or evicted, and when.
+type droppedTxnReason struct {
+	txHash BlockHash
+	reason string
+	when   time.Time
+}
+
+// evictedTxn pairs a txn that was evicted from the pool with the reason it was
+// evicted. Eviction paths collect these while holding the lock so that the OnEvict
+// callback can be invoked once the lock is released.
+type evictedTxn struct {
+	mempoolTx *MempoolTx
+	reason    string
+}
+
+// ReprocessDrop records a txn that was dropped from the pool because it failed to be
+// re-added while the pool was being rebuilt or loaded from disk. See
+// GetLastReprocessDrops.
+type ReprocessDrop struct {
+	Hash *BlockHash
+	Err  error
+}
+
 // Summary stats for a set of transactions of a specific type in the mempool.
 type SummaryStats struct {
 	// Number of transactions of this type in the mempool.
@@ -104,6 +336,25 @@ type SummaryStats struct {
 	TotalBytes uint64
 }
 
+// MempoolSnapshot is an immutable view of the transactions in the mempool as of a
+// particular readOnly sequence number. All of its fields are computed together when
+// the readOnly view is regenerated, so a caller that needs several of them (e.g. an
+// RPC response that reports the count, the txns, and the summary stats) can get a
+// consistent answer from a single snapshot. Callers must not modify its fields.
+type MempoolSnapshot struct {
+	// The readOnlyUtxoViewSequenceNumber at which this snapshot was taken.
+	SequenceNumber int64
+
+	// All of the txns in the pool ordered by when they were added.
+	Txns []*MempoolTx
+
+	// The same txns as above indexed by their hash.
+	TxnMap map[BlockHash]*MempoolTx
+
+	// Summary stats for the txns above broken down by txn type.
+	SummaryStats map[string]*SummaryStats
+}
+
 func (mempoolTx *MempoolTx) String() string {
 	return fmt.Sprintf("< Added: %v, index: %d, Fee: %d, Type: %v, Hash: %v", mempoolTx.Added, mempoolTx.index, mempoolTx.Fee, mempoolTx.Tx.TxnMeta.GetTxnType(), mempoolTx.Hash)
 }
@@ -115,7 +366,12 @@ func (pq MempoolTxFeeMinHeap) Len() int { return len(pq) }
 
 func (pq MempoolTxFeeMinHeap) Less(i, j int) bool {
 	// We want Pop to give us the lowest-fee transactions so we use < here.
-	return pq[i].FeePerKB < pq[j].FeePerKB
+	if pq[i].FeePerKB != pq[j].FeePerKB {
+		return pq[i].FeePerKB < pq[j].FeePerKB
+	}
+	// Among txns with the same fee rate, the most recently added one goes first,
+	// same as in _getLowestFeeTxnOfType.
+	return pq[i].Added.After(pq[j].Added)
 }
 
 func (pq MempoolTxFeeMinHeap) Swap(i, j int) {
@@ -148,6 +404,17 @@ type UnconnectedTx struct {
 	// removing unconnected transactions when a Peer disconnects.
 	peerID     uint64
 	expiration time.Time
+	// The serialized size of tx. See totalUnconnectedTxSizeBytes.
+	txSizeBytes uint64
+}
+
+// unconnectedTxnOffer tracks how many times an unconnected txn has been offered to
+// the pool. It outlives the txn's entry in unconnectedTxns so that re-sending a txn
+// after it's been dropped doesn't get it a fresh expiration.
+type unconnectedTxnOffer struct {
+	numOffers int
+	// When the txn expires, counting from its first offer.
+	expiration time.Time
 }
 
 // BitCloutMempool is the core mempool object. It's what any outside service should use
@@ -163,12 +430,93 @@ type BitCloutMempool struct {
 	// Transactions with a feerate below this threshold are outright rejected.
 	minFeeRateNanosPerKB uint64
 
+	// Txns with a feerate below this threshold can be admitted to the pool but
+	// shouldn't be relayed to peers. Zero means every txn is relayed. See
+	// SetRelayFeeRate and ShouldRelay.
+	relayFeeRateNanosPerKB uint64
+
+	// The feerate a replacement for a pool txn has to pay on top of the fees of the
+	// txns it would replace. See SetReplacementFeeBump and GetMinReplacementFee.
+	replacementFeeBumpNanosPerKB uint64
+
 	// rateLimitFeeRateNanosPerKB defines the minimum transaction feerate in "nanos per KB"
 	// before a transaction is considered for rate-limiting. Note that even if a
 	// transaction with a feerate below this threshold is not rate-limited, it must
 	// still have a high enough feerate to be considered as part of the mempool.
 	rateLimitFeeRateNanosPerKB uint64
 
+	// maxPendingTxnsPerPublicKey caps the number of txns a single public key can have
+	// in the pool as the transactor. Zero means there is no cap. See
+	// SetMaxPendingTxnsPerPublicKey.
+	maxPendingTxnsPerPublicKey int
+
+	// maxTxnSizeBytes caps the serialized size of a single txn in the pool so that
+	// one enormous txn can't take up a big chunk of it regardless of its fee. Zero
+	// means there is no cap. See SetMaxTxnSizeBytes.
+	maxTxnSizeBytes uint64
+
+	// maxInputsPerTxn caps the number of inputs a single txn can have. Every input
+	// has to be looked up while validating a txn, so this keeps a txn with a huge
+	// number of them from tying up the accept path. Zero means there is no cap. See
+	// SetMaxInputsPerTxn.
+	maxInputsPerTxn int
+
+	// requireTransactorUtxos enables a cheap pre-check that rejects a txn before it's
+	// connected if none of its inputs belong to its transactor, e.g. spam from a key
+	// that has no utxos at all. Off by default. See SetRequireTransactorUtxos.
+	requireTransactorUtxos bool
+
+	// maxCombinedTxSizeBytes caps the combined size of the txns in poolMap and the
+	// unconnectedTxns, so that a flood of large unconnectedTxns can't exhaust memory.
+	// Unconnected txns are evicted to make room for new ones once it's reached. Zero
+	// means there is no cap. See SetMaxCombinedTxSizeBytes.
+	maxCombinedTxSizeBytes uint64
+
+	// txnTypeLimits caps the number of txns of each type that can be in the pool.
+	// Types without an entry are unrestricted. See SetTxnTypeLimits.
+	txnTypeLimits map[TxnType]int
+
+	// txnTypeByteLimits caps the total serialized size of the txns of each type in the
+	// pool. Types without an entry are unrestricted. See SetTxnTypeByteLimits.
+	txnTypeByteLimits map[TxnType]uint64
+
+	// evictionPolicy decides which txns are evicted when a txn type is at its limit.
+	// See SetEvictionPolicy.
+	evictionPolicy EvictionPolicy
+
+	// computeMetadataOnAccept is whether tryAcceptTransaction computes the
+	// TransactionMetadata for each txn it accepts. Nodes that don't serve txindex
+	// data can turn it off to save the work, in which case MempoolTx.TxMeta is left
+	// nil. See SetComputeMetadataOnAccept.
+	computeMetadataOnAccept bool
+
+	// isSyncingFunc reports whether the node is still doing its initial block
+	// download. While it returns true, ProcessTransaction rejects txns with
+	// TxErrorStillSyncing since they'd be validated against a partial chain. Nil
+	// means txns are never rejected for this. See SetIsSyncingFunc.
+	isSyncingFunc func() bool
+
+	// trustedPeerIDs are peers whose txns are accepted without verifying their
+	// signatures, e.g. a relay we run ourselves. See SetTrustedPeerIDs.
+	trustedPeerIDs map[uint64]bool
+
+	// recentlyConfirmedTxns holds the hashes of the txns in the last few blocks that
+	// were connected so that a peer re-relaying one of them gets TxErrorDuplicate
+	// rather than having it validated from scratch. It's created on first use with
+	// room for recentlyConfirmedTxnsCacheSize hashes. A size of zero disables it. See
+	// SetRecentlyConfirmedTxnsCacheSize.
+	recentlyConfirmedTxns          *lru.Cache
+	recentlyConfirmedTxnsCacheSize uint
+
+	// droppedTxnReasons maps the hashes of recently rejected or evicted txns to the
+	// entry in droppedTxnReasonsList recording why. The list is ordered from most to
+	// least recently recorded, and the least recent entry is forgotten once there are
+	// more than droppedTxnReasonsCacheSize. Both are created on first use. A size of
+	// zero disables them. See GetLastRejectionReason and SetDroppedTxnReasonsCacheSize.
+	droppedTxnReasons          map[BlockHash]*list.Element
+	droppedTxnReasonsList      *list.List
+	droppedTxnReasonsCacheSize uint
+
 	mtx deadlock.RWMutex
 
 	// poolMap contains all of the transactions that have been validated by the pool.
@@ -183,6 +531,15 @@ type BitCloutMempool struct {
 	// use it to determine when the pool is nearing memory-exhaustion so we can start
 	// evicting transactions.
 	totalTxSizeBytes uint64
+	// totalFeeNanos is the sum of the fees of all of the transactions stored in poolMap.
+	// It's kept up to date as txns are added and removed so that GetTotalPendingFees
+	// doesn't have to scan the pool.
+	totalFeeNanos uint64
+	// avgBlockFillRate is a moving average of the fraction of MinerMaxBlockSizeBytes
+	// that connected blocks used. Only blocks that arrived while the pool held at
+	// least a full block's worth of txns are counted, since otherwise a block being
+	// small just means there wasn't much to mine. Starts out at 1.
+	avgBlockFillRate float64
 	// Stores the inputs for every transaction stored in poolMap. Used to quickly check
 	// if a transaction is double-spending.
 	outpoints map[UtxoKey]*MsgBitCloutTxn
@@ -192,6 +549,10 @@ type BitCloutMempool struct {
 	// Organizes unconnectedTxns by their UTXOs. Used when adding a transaction to determine
 	// which unconnectedTxns are no longer missing parents.
 	unconnectedTxnsByPrev map[UtxoKey]map[BlockHash]*MsgBitCloutTxn
+	// totalUnconnectedTxSizeBytes is the total size of all of the transactions stored in
+	// unconnectedTxns. It's kept separate from totalTxSizeBytes so that it only counts
+	// toward maxCombinedTxSizeBytes.
+	totalUnconnectedTxSizeBytes uint64
 	// An exponentially-decayed accumulator of "low-fee" transactions we've relayed.
 	// This is used to prevent someone from flooding the network with low-fee
 	// transactions.
@@ -199,19 +560,85 @@ type BitCloutMempool struct {
 	// The UNIX time (in seconds) when the last "low-fee" transaction was relayed.
 	lastLowFeeTxUnixTime int64
 
+	// Optional. Called for every txn that's evicted from the pool along with one of
+	// the EvictReason constants. It's always called without the lock held so it's
+	// safe for it to call back into the pool. See SetOnEvict.
+	onEvict func(mempoolTx *MempoolTx, reason string)
+	// The txns dropped by the most recent call to UpdateAfterConnectBlock,
+	// UpdateAfterDisconnectBlock, or LoadTxnsFromDB. See GetLastReprocessDrops.
+	lastReprocessDrops []*ReprocessDrop
+
+	// Optional. Receives timings from the txn accept path. See SetStatsHook.
+	statsHook StatsHook
+	// Optional. Receives the pool's decision on each txn it's offered. Decisions are
+	// logged with glog when it isn't set. See SetTxnLogger.
+	txnLogger TxnLogger
+	// Txns that were evicted while the lock was held and haven't been passed to
+	// onEvict yet. See _notifyPendingEvictedTxns.
+	pendingEvictedTxns []*evictedTxn
+
+	// The channels returned by SubscribePublicKey, by public key and then by an ID
+	// that lets each one be unsubscribed.
+	publicKeySubscriptions      map[PkMapKey]map[uint64]chan *MempoolTx
+	nextPublicKeySubscriptionID uint64
+
 	// pubKeyToTxnMap stores a mapping from the public key of outputs added
 	// to the mempool to the corresponding transaction that resulted in their
 	// addition. It is useful for figuring out how much BitClout a particular public
 	// key has available to spend.
 	pubKeyToTxnMap map[PkMapKey]map[BlockHash]*MempoolTx
 
+	// txnTypeToTxnMap indexes the txns in poolMap by their type. It's used to enforce
+	// txnTypeLimits without scanning the whole pool.
+	txnTypeToTxnMap map[TxnType]map[BlockHash]*MempoolTx
+	// txnTypeToTotalBytes is the total TxSizeBytes of the txns in each entry of
+	// txnTypeToTxnMap. It's used to enforce txnTypeByteLimits.
+	txnTypeToTotalBytes map[TxnType]uint64
+	// deferTxnIndexing is set while LoadTxnsFromDB bulk-loads a dump. While it's set,
+	// txns aren't added to the three maps above or to the postHashToTxnMap,
+	// profilePkToTxnMap, and affectedPubKeyToTxnMap below. They're all built afterwards
+	// by _rebuildDeferredTxnIndexes. See SetBulkIndexTxnsOnLoad.
+	deferTxnIndexing bool
+	// See SetBulkIndexTxnsOnLoad.
+	bulkIndexTxnsOnLoad bool
+
+	// postHashToTxnMap indexes the txns in poolMap by the post hashes they reference,
+	// e.g. the post being liked, reclouted, commented on, or given a diamond. See
+	// _getPostHashesReferencedByTxn.
+	postHashToTxnMap map[BlockHash]map[BlockHash]*MempoolTx
+
+	// profilePkToTxnMap indexes the UpdateProfile and SwapIdentity txns in poolMap by
+	// the public keys of the profiles they modify. See _getProfilePublicKeysModifiedByTxn.
+	profilePkToTxnMap map[PkMapKey]map[BlockHash]*MempoolTx
+
+	// affectedPubKeyToTxnMap indexes the txns in poolMap by every public key in the
+	// AffectedPublicKeys of their TxMeta, which is broader than what pubKeyToTxnMap
+	// covers, e.g. it includes mentioned keys and parent posters. Txns without TxMeta
+	// aren't indexed until it's computed. See _getAffectedPublicKeysForMempoolTx.
+	affectedPubKeyToTxnMap map[PkMapKey]map[BlockHash]*MempoolTx
+
+	// txnTags holds the Tags of the txns in poolMap that have them, keyed by txn
+	// hash. It's kept outside of poolMap so that it survives resetPool, which swaps in
+	// MempoolTxs from a rebuilt pool that know nothing about tags.
+	txnTags map[BlockHash]map[string]string
+
 	// BitcoinExchange transactions that contain Bitcoin transactions that have not
 	// yet been mined into a block, and therefore would fail a merkle root check.
 	unminedBitcoinTxns map[BlockHash]*MempoolTx
 
+	// Maps the hash of the Bitcoin txn embedded in each BitcoinExchange txn in
+	// poolMap, as a hex string, to its MempoolTx. Used to reconcile the pool with
+	// the Bitcoin chain without scanning every txn.
+	bitcoinHashToMempoolTx map[string]*MempoolTx
+
 	// The next time the unconnectTxn pool will be scanned for expired unconnectedTxns.
 	nextExpireScan time.Time
 
+	// The offers of unconnectedTxns that haven't expired yet, including ones that have
+	// since been removed from unconnectedTxns. See MaxUnconnectedTxnOffers. Pool
+	// rebuilds don't count as offers so this isn't replaced by resetPool.
+	unconnectedTxnOffers map[BlockHash]*unconnectedTxnOffer
+
 	// Optional. When set, we use the BlockCypher API to detect double-spends.
 	blockCypherAPIKey               string
 	blockCypherCheckDoubleSpendChan chan *MsgBitCloutTxn
@@ -221,16 +648,52 @@ type BitCloutMempool struct {
 	// backup view, and then restoring the backup view if there's an error. In
 	// the future, if we can figure out an easy way to rollback bad transactions
 	// on a single view, then we won't need the second view anymore.
-	backupUniversalUtxoView  *UtxoView
-	universalUtxoView        *UtxoView
+	backupUniversalUtxoView *UtxoView
+	universalUtxoView       *UtxoView
+
+	// universalTransactionList holds the txns in poolMap in the order they were added,
+	// which is the order they're connected to the universalUtxoView in. It's appended
+	// to by addTransaction and never holds txns that have left poolMap. The targeted
+	// removal, removeTransactionAndDescendants, trims it in place, and every other
+	// removal rebuilds the pool, which replaces it wholesale in resetPool.
 	universalTransactionList []*MempoolTx
 
 	// When set, transactions are initially read from this dir and dumped
 	// to this dir.
 	mempoolDir string
+	// Held for the duration of each dump so that the periodic dumper and the final
+	// dump done by Stop never write to the dump db at the same time.
+	dumpMtx deadlock.Mutex
+	// The long-lived badger handle dumps are written to. Nil until the first dump or
+	// load. Only touched while holding dumpMtx.
+	dumpDB *badger.DB
+	// How many complete dumps are kept in the dumpDB. Loading falls back to older
+	// ones if the newest can't be read. Only touched while holding dumpMtx. See
+	// SetNumRetainedDumpGenerations.
+	numRetainedDumpGenerations int
+	// Closed when the StartMempoolDBDumper goroutine exits. Nil if it was never
+	// started.
+	mempoolDBDumperDone chan struct{}
+	// How often StartMempoolDBDumper dumps txns to the mempoolDir. Dumping a huge
+	// pool causes I/O spikes, so nodes with big pools may want to do it less often,
+	// while nodes that reboot often may want to do it more often. Read under mtx.
+	// See SetDumpInterval.
+	dumpInterval time.Duration
+	// When set, every txn accepted into the pool is also appended to a write-ahead
+	// log in the mempoolDir so that txns accepted since the last dump survive a
+	// crash. The log is replayed by LoadTxnsFromDB and truncated after each dump.
+	// See MempoolOptions.EnableWAL.
+	enableWAL bool
+	// The open write-ahead log. Nil unless enableWAL is set and the pool has a
+	// mempoolDir. Only touched while holding mtx.
+	walFile *os.File
 
 	// Whether or not we should be computing readOnlyUtxoViews.
 	generateReadOnlyUtxoView bool
+	// How often StartReadOnlyUtxoViewRegenerator regenerates the readOnly view when
+	// it hasn't been regenerated because of the number of txns processed. Read under
+	// mtx. See SetReadOnlyViewRegenerationInterval.
+	readOnlyViewRegenerationInterval time.Duration
 	// A view that contains a *near* up-to-date snapshot of the mempool. It is
 	// updated periodically after N transactions OR after M  seconds, whichever
 	// comes first. It's useful because it can be obtained without acquiring a
@@ -251,6 +714,41 @@ type BitCloutMempool struct {
 	// This field isn't reset with ResetPool. It requires an explicit call to
 	// UpdateReadOnlyView.
 	readOnlyUtxoViewSequenceNumber int64
+	// When the readOnly view was last regenerated, according to nowFunc, in UNIX
+	// nanoseconds. Zero if it never has been. Accessed atomically. See
+	// IsReadOnlyViewHealthy.
+	lastReadOnlyViewRegenUnixNano int64
+	// The height of the block after the chain tip the pool was last brought up to date
+	// with, which is the height txns are validated at. Accessed atomically. See
+	// GetValidationHeight.
+	validationHeight uint32
+	// A copy of the readOnlyUtxoView that's shared by WithCachedAugmentedUniversalView
+	// callers until the readOnlyUtxoViewSequenceNumber moves past
+	// cachedAugmentedViewSequenceNumber. Both are only touched while holding
+	// cachedAugmentedViewMtx.
+	cachedAugmentedViewMtx            deadlock.Mutex
+	cachedAugmentedView               *UtxoView
+	cachedAugmentedViewSequenceNumber int64
+	// A snapshot bundling the readOnly txn list and map along with the sequence
+	// number they were generated at. It's swapped in as a single pointer so that
+	// readers never see fields from two different regenerations.
+	//
+	// This field isn't reset with ResetPool. It requires an explicit call to
+	// UpdateReadOnlyView.
+	readOnlySnapshot *MempoolSnapshot
+	// The readOnly txns sorted by fee rate, as returned by
+	// GetTransactionsOrderedByFeeRate, along with the sequence number of the snapshot
+	// they were sorted from. They're re-sorted lazily the first time they're requested
+	// after the sequence number changes. Guarded by feeRateSortedTxnsMtx rather than
+	// mtx so that callers don't contend with txn processing.
+	feeRateSortedTxnsMtx            deadlock.Mutex
+	feeRateSortedTxns               []*MempoolTx
+	feeRateSortedTxnsSequenceNumber int64
+	// The readOnly txns sorted by Added from oldest to newest, cached in the same way
+	// as feeRateSortedTxns for GetTransactionsOrderedByTimeAddedWithLimit.
+	timeSortedTxnsMtx            deadlock.Mutex
+	timeSortedTxns               []*MempoolTx
+	timeSortedTxnsSequenceNumber int64
 	// The total number of times we've called processTransaction. Used to
 	// determine whether we should update the readOnlyUtxoView.
 	//
@@ -261,6 +759,35 @@ type BitCloutMempool struct {
 	// We pass a copy of the data dir flag to the tx pool so that we can instantiate
 	// temp badger db instances and dump mempool txns to them.
 	dataDir string
+
+	// Connected txns that have been in the pool for longer than this are evicted
+	// periodically by the expired txn sweeper. This prevents txns whose fee was
+	// once adequate but no longer is from sitting in the pool forever. Zero means
+	// txns never expire. See SetMaxTxnAge.
+	maxTxnAge time.Duration
+	// Whether the expired txn sweeper has been started. It's started the first time
+	// a non-zero maxTxnAge is set and runs until the pool is stopped.
+	expiredTxnSweeperStarted bool
+
+	// Returns the current time. Everything in the pool that depends on the time,
+	// like expirations and rate-limit decay, goes through this rather than calling
+	// time.Now() directly so that tests can swap in a fake clock. Defaults to
+	// time.Now.
+	nowFunc func() time.Time
+
+	// When set, the pool doesn't keep a standing backupUniversalUtxoView. Instead, each
+	// txn is validated against a throwaway copy of the universalUtxoView that's
+	// discarded as soon as the txn is accepted or rejected. This roughly halves the
+	// memory used by the pool's views, which is worthwhile for observer nodes that
+	// just want to validate and index txns, at the cost of a full view copy per txn.
+	// Since there's no standing view holding the pre-txn state, replacing txns that
+	// are already in the pool (e.g. replace-by-fee) isn't supported in this mode.
+	lightweightMode bool
+
+	// BitcoinExchange txns whose Bitcoin txn has any output below this many satoshis
+	// are rejected as dust. Different networks want different thresholds so this is
+	// configurable rather than hardcoded. Zero disables the check.
+	bitcoinExchangeDustThresholdSatoshis int64
 }
 
 // See comment on RemoveUnconnectedTxn. The mempool lock must be called for writing
@@ -305,6 +832,7 @@ func (mp *BitCloutMempool) removeUnconnectedTxn(tx *MsgBitCloutTxn, removeRedeem
 
 	// Delete the txn from the unconnectedTxn map
 	delete(mp.unconnectedTxns, *txHash)
+	mp._decrementTotalUnconnectedTxSizeBytes(unconnectedTxn.txSizeBytes)
 }
 
 // ResetPool replaces all of the internal data associated with a pool object with the
@@ -319,16 +847,44 @@ func (mp *BitCloutMempool) resetPool(newPool *BitCloutMempool) {
 	mp.poolMap = newPool.poolMap
 	mp.txFeeMinheap = newPool.txFeeMinheap
 	mp.totalTxSizeBytes = newPool.totalTxSizeBytes
+	// Recompute the fee total from the new poolMap rather than trusting the new
+	// pool's running total.
+	mp.totalFeeNanos = 0
+	for _, mempoolTx := range newPool.poolMap {
+		mp.totalFeeNanos += mempoolTx.Fee
+	}
 	mp.outpoints = newPool.outpoints
 	mp.pubKeyToTxnMap = newPool.pubKeyToTxnMap
+	mp.txnTypeToTxnMap = newPool.txnTypeToTxnMap
+	mp.txnTypeToTotalBytes = newPool.txnTypeToTotalBytes
+	mp.postHashToTxnMap = newPool.postHashToTxnMap
+	mp.profilePkToTxnMap = newPool.profilePkToTxnMap
+	mp.affectedPubKeyToTxnMap = newPool.affectedPubKeyToTxnMap
 	mp.unconnectedTxns = newPool.unconnectedTxns
 	mp.unconnectedTxnsByPrev = newPool.unconnectedTxnsByPrev
+	mp.totalUnconnectedTxSizeBytes = newPool.totalUnconnectedTxSizeBytes
 	mp.unminedBitcoinTxns = newPool.unminedBitcoinTxns
+	mp.bitcoinHashToMempoolTx = newPool.bitcoinHashToMempoolTx
 	mp.nextExpireScan = newPool.nextExpireScan
 	mp.backupUniversalUtxoView = newPool.backupUniversalUtxoView
+	if mp.lightweightMode {
+		// Pools built for a rebuild keep a backup view. We don't want to hold onto it.
+		mp.backupUniversalUtxoView = nil
+	}
 	mp.universalUtxoView = newPool.universalUtxoView
 	mp.universalTransactionList = newPool.universalTransactionList
 
+	// txnTags is deliberately not replaced. Reattach the tags to the new pool's
+	// MempoolTxs and drop the ones for txns that didn't make it into the new pool.
+	for txHash, tags := range mp.txnTags {
+		mempoolTx, exists := mp.poolMap[txHash]
+		if !exists {
+			delete(mp.txnTags, txHash)
+			continue
+		}
+		mempoolTx.Tags = tags
+	}
+
 	// We don't adjust blockCypherAPIKey or blockCypherCheckDoubleSpendChan
 	// since those should be unaffected
 
@@ -341,6 +897,7 @@ func (mp *BitCloutMempool) resetPool(newPool *BitCloutMempool) {
 	// - readOnlyUniversalTransactionList    []*MempoolTx
 	// - readOnlyUniversalTransactionMap map[BlockHash]*MempoolTx
 	// - readOnlyOutpoints map[UtxoKey]*MsgBitCloutTxn
+	// - readOnlySnapshot *MempoolSnapshot
 	//
 	// Regenerate the view if needed.
 	if mp.generateReadOnlyUtxoView {
@@ -351,6 +908,48 @@ func (mp *BitCloutMempool) resetPool(newPool *BitCloutMempool) {
 	// the old values should be unaffected.
 }
 
+// SimulateConnectBlock reports which pool txns connecting the block would remove,
+// without modifying the pool or validating the block. evicted holds the pool txns
+// that are included in the block. conflicts holds the pool txns that spend an
+// outpoint also spent by one of the block's txns, along with every pool txn that
+// depends on them. Only conflicts over outpoints are detected. Acquires a read lock.
+func (mp *BitCloutMempool) SimulateConnectBlock(blk *MsgBitCloutBlock) (
+	_evicted []*BlockHash, _conflicts []*BlockHash) {
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	evicted := []*BlockHash{}
+	txnsInBlock := make(map[BlockHash]bool)
+	for _, txn := range blk.Txns {
+		txHash := txn.Hash()
+		txnsInBlock[*txHash] = true
+		if _, exists := mp.poolMap[*txHash]; exists {
+			evicted = append(evicted, txHash)
+		}
+	}
+
+	conflicts := []*BlockHash{}
+	conflictsSeen := make(map[BlockHash]bool)
+	for _, txn := range blk.Txns {
+		for _, txIn := range txn.TxInputs {
+			spendingTx, exists := mp.outpoints[UtxoKey(*txIn)]
+			if !exists || txnsInBlock[*spendingTx.Hash()] {
+				continue
+			}
+			for _, mempoolTx := range mp._getTransactionWithDescendants(spendingTx.Hash()) {
+				if conflictsSeen[*mempoolTx.Hash] {
+					continue
+				}
+				conflictsSeen[*mempoolTx.Hash] = true
+				conflicts = append(conflicts, mempoolTx.Hash)
+			}
+		}
+	}
+
+	return evicted, conflicts
+}
+
 // UpdateAfterConnectBlock updates the mempool after a block has been added to the
 // blockchain. It does this by basically removing all known transactions in the block
 // from the mempool as follows:
@@ -372,23 +971,24 @@ func (mp *BitCloutMempool) UpdateAfterConnectBlock(blk *MsgBitCloutBlock) (_txns
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
-	// Make a map of all the txns in the block except the block reward.
+	mp._updateValidationHeight()
+
+	// Make a map of all the txns in the block except the block reward. They're also
+	// remembered as recently confirmed so re-relayed copies can be rejected cheaply.
 	txnsInBlock := make(map[BlockHash]bool)
 	for _, txn := range blk.Txns[1:] {
 		txHash := txn.Hash()
 		txnsInBlock[*txHash] = true
+		mp._addRecentlyConfirmedTxn(txHash)
 	}
+	mp._updateAvgBlockFillRate(blk)
 
-	// Create a new pool object. No need to set the min fees as we're just using this
-	// as a temporary data structure for validation.
-	//
-	// Don't make the new pool object deal with the BlockCypher API.
-	newPool := NewBitCloutMempool(
-		mp.bc, 0, /* rateLimitFeeRateNanosPerKB */
-		0,     /* minFeeRateNanosPerKB */
-		"",    /*blockCypherAPIKey*/
-		false, /*runReadOnlyViewUpdater*/
-		"" /*dataDir*/, "")
+	// Create a new pool object to re-add the txns to.
+	newPool, err := mp._newRebuildPool()
+	if err != nil {
+		glog.Error(errors.Wrapf(err, "UpdateAfterConnectBlock: "))
+		return nil
+	}
 
 	// Get all the transactions from the old pool object.
 	oldMempoolTxns, oldUnconnectedTxns, err := mp._getTransactionsOrderedByTimeAdded()
@@ -398,7 +998,7 @@ func (mp *BitCloutMempool) UpdateAfterConnectBlock(blk *MsgBitCloutBlock) (_txns
 
 	// Add all the txns from the old pool into the new pool unless they are already
 	// present in the block.
-
+	reprocessDrops := []*ReprocessDrop{}
 	for _, mempoolTx := range oldMempoolTxns {
 		if _, exists := txnsInBlock[*mempoolTx.Hash]; exists {
 			continue
@@ -410,10 +1010,17 @@ func (mp *BitCloutMempool) UpdateAfterConnectBlock(blk *MsgBitCloutBlock) (_txns
 			0 /*peerID*/, false /*verifySignatures*/)
 		if err != nil {
 			glog.Warning(errors.Wrapf(err, "UpdateAfterConnectBlock: "))
+			reprocessDrops = append(reprocessDrops, &ReprocessDrop{mempoolTx.Hash, err})
 		}
 		if len(txnsAccepted) == 0 {
 			glog.Warningf("UpdateAfterConnectBlock: Dropping txn %v", mempoolTx.Tx)
+			continue
 		}
+		// Re-processing the txn sets its Added time to now. Carry over the time it
+		// was originally added so that its age survives the rebuild, otherwise
+		// maxTxnAge could never be reached by a txn that outlives a block. The Local
+		// flag is lost the same way so carry it over too.
+		newPool._carryOverMempoolTx(txnsAccepted[0], mempoolTx)
 	}
 
 	// Add all the unconnectedTxns from the old pool into the new pool unless they are already
@@ -433,7 +1040,9 @@ func (mp *BitCloutMempool) UpdateAfterConnectBlock(blk *MsgBitCloutBlock) (_txns
 		_, err := newPool.processTransaction(unconnectedTx.tx, unconnectedTxns, rateLimit, unconnectedTx.peerID, verifySignatures)
 		if err != nil {
 			glog.Warning(errors.Wrapf(err, "UpdateAfterConnectBlock: "))
+			reprocessDrops = append(reprocessDrops, &ReprocessDrop{unconnectedTxHash, err})
 		}
+		newPool._carryOverUnconnectedTxnExpiration(unconnectedTx)
 	}
 
 	// At this point, the new pool should contain an up-to-date view of the transactions
@@ -452,6 +1061,7 @@ func (mp *BitCloutMempool) UpdateAfterConnectBlock(blk *MsgBitCloutBlock) (_txns
 
 	// Now set the fields on the old pool to match the new pool.
 	mp.resetPool(newPool)
+	mp.lastReprocessDrops = reprocessDrops
 
 	// Return the newly accepted transactions now that we've fully updated our mempool.
 	return newlyAcceptedTxns
@@ -484,18 +1094,33 @@ func (mp *BitCloutMempool) UpdateAfterDisconnectBlock(blk *MsgBitCloutBlock) {
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
-	// Create a new BitCloutMempool. No need to set the min fees since we're just using
-	// this as a temporary data structure for validation.
-	//
-	// Don't make the new pool object deal with the BlockCypher API.
-	newPool := NewBitCloutMempool(mp.bc, 0, /* rateLimitFeeRateNanosPerKB */
-		0, /* minFeeRateNanosPerKB */
-		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, "")
+	mp._updateValidationHeight()
+
+	// Create a new pool object to re-add the txns to.
+	newPool, err := mp._newRebuildPool()
+	if err != nil {
+		glog.Error(errors.Wrapf(err, "UpdateAfterDisconnectBlock: "))
+		return
+	}
+
+	// The block's txns are no longer confirmed so they need to be accepted again.
+	for _, txn := range blk.Txns[1:] {
+		mp._removeRecentlyConfirmedTxn(txn.Hash())
+	}
 
 	// Add the transactions from the block to the new pool (except for the block reward,
 	// which should always be the first transaction). Break out if we encounter
 	// an error.
+	//
+	// A reorg on the Bitcoin side may have orphaned the Bitcoin block that a mined
+	// BitcoinExchange txn's merkle proof points to. Rather than dropping such txns for
+	// failing the merkle proof check, they're demoted back to unmined here and below.
+	//
+	// By the time we're notified of a disconnect during a reorg the tip may have
+	// already moved to the new chain, so validate the block's txns as of the height
+	// they were originally mined at rather than relying on the tip.
+	blockHeight := uint32(blk.Header.Height)
+	reprocessDrops := []*ReprocessDrop{}
 	for _, txn := range blk.Txns[1:] {
 		// For transactions being added from the block just set the peerID to zero. It
 		// shouldn't matter since these transactions won't be unconnectedTxns.
@@ -503,12 +1128,15 @@ func (mp *BitCloutMempool) UpdateAfterDisconnectBlock(blk *MsgBitCloutBlock) {
 		allowUnconnectedTxns := false
 		peerID := uint64(0)
 		verifySignatures := false
-		_, err := newPool.processTransaction(txn, allowUnconnectedTxns, rateLimit, peerID, verifySignatures)
+		_, err := newPool.processTransactionAtHeight(
+			mp._demoteOrphanedBitcoinExchange(txn), allowUnconnectedTxns, rateLimit,
+			peerID, verifySignatures, blockHeight)
 		if err != nil {
 			// Log errors but don't stop adding transactions. We do this because we'd prefer
 			// to drop a transaction here or there rather than lose the whole block because
 			// of one bad apple.
 			glog.Warning(errors.Wrapf(err, "UpdateAfterDisconnectBlock: "))
+			reprocessDrops = append(reprocessDrops, &ReprocessDrop{txn.Hash(), err})
 		}
 	}
 
@@ -523,14 +1151,19 @@ func (mp *BitCloutMempool) UpdateAfterDisconnectBlock(blk *MsgBitCloutBlock) {
 	for _, mempoolTx := range oldMempoolTxns {
 		// Attempt to add the txn to the mempool as we go. If it fails that's fine.
 		txnsAccepted, err := newPool.processTransaction(
-			mempoolTx.Tx, true /*allowUnconnectedTxns*/, false, /*rateLimit*/
-			0 /*peerID*/, false /*verifySignatures*/)
+			mp._demoteOrphanedBitcoinExchange(mempoolTx.Tx), true, /*allowUnconnectedTxns*/
+			false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
 		if err != nil {
 			glog.Warning(errors.Wrapf(err, "UpdateAfterDisconnectBlock: "))
+			reprocessDrops = append(reprocessDrops, &ReprocessDrop{mempoolTx.Hash, err})
 		}
 		if len(txnsAccepted) == 0 {
 			glog.Warningf("UpdateAfterDisconnectBlock: Dropping txn %v", mempoolTx.Tx)
+			continue
 		}
+		// Carry over the original Added time. See the comment in UpdateAfterConnectBlock.
+		// Otherwise every reorg would restart the maxTxnAge clock for the whole pool.
+		newPool._carryOverMempoolTx(txnsAccepted[0], mempoolTx)
 	}
 
 	// Iterate through the unconnectedTxns and add them to our new pool as well.
@@ -541,7 +1174,9 @@ func (mp *BitCloutMempool) UpdateAfterDisconnectBlock(blk *MsgBitCloutBlock) {
 		_, err := newPool.processTransaction(oTx.tx, allowUnconnectedTxns, rateLimit, oTx.peerID, verifySignatures)
 		if err != nil {
 			glog.Warning(errors.Wrapf(err, "UpdateAfterDisconnectBlock: "))
+			reprocessDrops = append(reprocessDrops, &ReprocessDrop{oTx.tx.Hash(), err})
 		}
+		newPool._carryOverUnconnectedTxnExpiration(oTx)
 	}
 
 	// At this point the new mempool should be a duplicate of the original mempool but with
@@ -551,6 +1186,7 @@ func (mp *BitCloutMempool) UpdateAfterDisconnectBlock(blk *MsgBitCloutBlock) {
 	// Replace the internal mappings of the original pool with the mappings of the new
 	// pool.
 	mp.resetPool(newPool)
+	mp.lastReprocessDrops = reprocessDrops
 }
 
 // Acquires a read lock before returning the transactions.
@@ -574,182 +1210,718 @@ func (mp *BitCloutMempool) GetTransactionsOrderedByTimeAdded() (_poolTxns []*Mem
 	return poolTxns, nil, nil
 }
 
-func (mp *BitCloutMempool) GetTransaction(txId *BlockHash) (txn *MempoolTx) {
-	return mp.readOnlyUniversalTransactionMap[*txId]
-}
-
-// GetTransactionsOrderedByTimeAdded returns all transactions in the mempool ordered
-// by when they were added to the mempool.
-func (mp *BitCloutMempool) _getTransactionsOrderedByTimeAdded() (_poolTxns []*MempoolTx, _unconnectedTxns []*UnconnectedTx, _err error) {
-	poolTxns := []*MempoolTx{}
-	for _, mempoolTx := range mp.poolMap {
-		poolTxns = append(poolTxns, mempoolTx)
+// GetTransactionsOrderedByTimeAddedWithLimit is like GetTransactionsOrderedByTimeAdded
+// but returns at most limit txns, starting from the newest when newestFirst is set and
+// from the oldest otherwise. A limit of zero or less returns every txn. The txns are
+// sorted once per regeneration of the readOnly view and cached, in the same way as
+// GetTransactionsOrderedByFeeRate, so each call only costs the txns it returns. This
+// suits "most recent N txns" feeds on a busy pool. Callers must not modify the
+// returned slice. Safe for concurrent access.
+func (mp *BitCloutMempool) GetTransactionsOrderedByTimeAddedWithLimit(limit int, newestFirst bool) []*MempoolTx {
+	sortedTxns := mp._getTimeSortedTxns()
+	if limit <= 0 || limit > len(sortedTxns) {
+		limit = len(sortedTxns)
 	}
-	// Sort the list based on when the transactions were added.
-	sort.Slice(poolTxns, func(ii, jj int) bool {
-		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
-	})
 
-	unconnectedTxns := []*UnconnectedTx{}
-	for _, oTx := range mp.unconnectedTxns {
-		unconnectedTxns = append(unconnectedTxns, oTx)
+	if !newestFirst {
+		// Cap the capacity so that appending to the result can't clobber the cache.
+		return sortedTxns[:limit:limit]
 	}
-
-	return poolTxns, unconnectedTxns, nil
+	limitedTxns := make([]*MempoolTx, limit)
+	for ii := range limitedTxns {
+		limitedTxns[ii] = sortedTxns[len(sortedTxns)-1-ii]
+	}
+	return limitedTxns
 }
 
-// Evicts unconnectedTxns if we're over the maximum number of unconnectedTxns allowed, or if
-// unconnectedTxns have exired. Must be called with the write lock held.
-func (mp *BitCloutMempool) limitNumUnconnectedTxns() error {
-	if now := time.Now(); now.After(mp.nextExpireScan) {
-		prevNumUnconnectedTxns := len(mp.unconnectedTxns)
-		for _, unconnectedTxn := range mp.unconnectedTxns {
-			if now.After(unconnectedTxn.expiration) {
-				mp.removeUnconnectedTxn(unconnectedTxn.tx, true)
-			}
-		}
-
-		numUnconnectedTxns := len(mp.unconnectedTxns)
-		if numExpired := prevNumUnconnectedTxns - numUnconnectedTxns; numExpired > 0 {
-			glog.Debugf("Expired %d unconnectedTxns (remaining: %d)", numExpired, numUnconnectedTxns)
-		}
-	}
+// _getTimeSortedTxns returns the txns in the readOnly view sorted by Added from oldest
+// to newest, re-sorting them only if the readOnly view has been regenerated since they
+// were last sorted. Callers must not modify the returned slice. Safe for concurrent
+// access.
+func (mp *BitCloutMempool) _getTimeSortedTxns() []*MempoolTx {
+	snapshot := mp.readOnlySnapshot
 
-	if len(mp.unconnectedTxns)+1 <= MaxUnconnectedTransactions {
-		return nil
-	}
+	mp.timeSortedTxnsMtx.Lock()
+	defer mp.timeSortedTxnsMtx.Unlock()
 
-	for _, otx := range mp.unconnectedTxns {
-		mp.removeUnconnectedTxn(otx.tx, false)
-		break
+	if mp.timeSortedTxns != nil && mp.timeSortedTxnsSequenceNumber == snapshot.SequenceNumber {
+		return mp.timeSortedTxns
 	}
 
-	return nil
-}
+	sortedTxns := make([]*MempoolTx, len(snapshot.Txns))
+	copy(sortedTxns, snapshot.Txns)
+	sort.SliceStable(sortedTxns, func(ii, jj int) bool {
+		return sortedTxns[ii].Added.Before(sortedTxns[jj].Added)
+	})
 
-// Adds an unconnected txn to the pool. Must be called with the write lock held.
-func (mp *BitCloutMempool) addUnconnectedTxn(tx *MsgBitCloutTxn, peerID uint64) {
-	if MaxUnconnectedTransactions <= 0 {
-		return
-	}
+	mp.timeSortedTxns = sortedTxns
+	mp.timeSortedTxnsSequenceNumber = snapshot.SequenceNumber
 
-	mp.limitNumUnconnectedTxns()
+	return sortedTxns
+}
 
-	txHash := tx.Hash()
-	if txHash == nil {
-		glog.Error(fmt.Errorf("addUnconnectedTxn: Problem hashing txn: "))
-		return
-	}
-	mp.unconnectedTxns[*txHash] = &UnconnectedTx{
-		tx:         tx,
-		peerID:     peerID,
-		expiration: time.Now().Add(UnconnectedTxnExpirationInterval),
-	}
-	for _, txIn := range tx.TxInputs {
-		if _, exists := mp.unconnectedTxnsByPrev[UtxoKey(*txIn)]; !exists {
-			mp.unconnectedTxnsByPrev[UtxoKey(*txIn)] =
-				make(map[BlockHash]*MsgBitCloutTxn)
+// GetTransactionsAddedAfter returns the connected txns in the readOnly view whose
+// Added time is strictly after the time passed in, ordered by Added. It's meant for
+// callers that poll the mempool and only care about what's new since their last poll.
+//
+// The readOnlyUniversalTransactionList is *mostly* in Added order, but pool rebuilds
+// don't strictly preserve it. E.g. UpdateAfterDisconnectBlock re-adds the block's
+// txns, with a fresh Added time, ahead of the txns that were already in the pool. So
+// rather than maintain a sorting invariant we do a filtered scan of the whole list and
+// only sort the txns that match. The scan is cheap relative to returning the full pool.
+func (mp *BitCloutMempool) GetTransactionsAddedAfter(addedAfter time.Time) []*MempoolTx {
+	poolTxns := []*MempoolTx{}
+	for _, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		if mempoolTx.Added.After(addedAfter) {
+			poolTxns = append(poolTxns, mempoolTx)
 		}
-		mp.unconnectedTxnsByPrev[UtxoKey(*txIn)][*txHash] = tx
 	}
 
-	glog.Debugf("Added unconnected transaction %v with total txns: %d)", txHash, len(mp.unconnectedTxns))
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
 }
 
-// Consider adding an unconnected txn to the pool. Must be called with the write lock held.
-func (mp *BitCloutMempool) tryAddUnconnectedTxn(tx *MsgBitCloutTxn, peerID uint64) error {
-	txBytes, err := tx.ToBytes(false)
-	if err != nil {
-		return errors.Wrapf(err, "tryAddUnconnectedTxn: Problem serializing txn: ")
-	}
-	serializedLen := len(txBytes)
-	if serializedLen > MaxUnconnectedTxSizeBytes {
-		return TxErrorTooLarge
+// GetTransactionsByHeight returns the connected txns in the readOnly view that were
+// added at the given height. A txn's Height is the tip height plus one at the time it
+// was added, so after a reorg this picks out txns that were validated against a tip
+// that may no longer be on the main chain.
+func (mp *BitCloutMempool) GetTransactionsByHeight(height uint32) []*MempoolTx {
+	poolTxns := []*MempoolTx{}
+	for _, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		if mempoolTx.Height == height {
+			poolTxns = append(poolTxns, mempoolTx)
+		}
 	}
+	return poolTxns
+}
 
-	mp.addUnconnectedTxn(tx, peerID)
+// GetTransactionsByTypes returns the txns in the pool whose type is any of the types
+// passed in, ordered by Added. It's built from the txnTypeToTxnMap index so it only
+// touches txns of the requested types, and duplicate types are only looked up once.
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionsByTypes(types []TxnType) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
 
-	return nil
-}
+	poolTxns := []*MempoolTx{}
+	typesAdded := make(map[TxnType]bool)
+	for _, txnType := range types {
+		if typesAdded[txnType] {
+			continue
+		}
+		typesAdded[txnType] = true
 
-// Remove unconnectedTxns that are no longer valid after applying the passed-in txn.
-func (mp *BitCloutMempool) removeUnconnectedTxnDoubleSpends(tx *MsgBitCloutTxn) {
-	for _, txIn := range tx.TxInputs {
-		for _, unconnectedTx := range mp.unconnectedTxnsByPrev[UtxoKey(*txIn)] {
-			mp.removeUnconnectedTxn(unconnectedTx, true)
+		for _, mempoolTx := range mp.txnTypeToTxnMap[txnType] {
+			poolTxns = append(poolTxns, mempoolTx)
 		}
 	}
+
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
 }
 
-// Must be called with the write lock held.
-func (mp *BitCloutMempool) isTransactionInPool(hash *BlockHash) bool {
-	if _, exists := mp.poolMap[*hash]; exists {
-		return true
+// GetTransactionQueuePosition returns where the txn with the given hash sits in the
+// queue of txns waiting to be mined, assuming blocks take txns in the order they were
+// added to the pool. The position is 1-based, so the first txn in the queue is at
+// position 1. Also returns the number of txns ahead of it and their total size. If
+// the txn isn't in the pool, the position is -1.
+//
+// This uses the readOnly view so a txn that was just added may not be found yet.
+// Safe for concurrent access.
+func (mp *BitCloutMempool) GetTransactionQueuePosition(txHash *BlockHash) (
+	_position int, _totalAhead int, _bytesAhead uint64) {
+
+	bytesAhead := uint64(0)
+	for ii, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		if *mempoolTx.Hash == *txHash {
+			return ii + 1, ii, bytesAhead
+		}
+		bytesAhead += mempoolTx.TxSizeBytes
 	}
 
-	return false
+	return -1, 0, 0
 }
 
-// Whether or not a txn is in the pool. Safe for concurrent access.
-func (mp *BitCloutMempool) IsTransactionInPool(hash *BlockHash) bool {
-	_, exists := mp.readOnlyUniversalTransactionMap[*hash]
-	return exists
+func (mp *BitCloutMempool) GetTransaction(txId *BlockHash) (txn *MempoolTx) {
+	return mp.readOnlyUniversalTransactionMap[*txId]
 }
 
-// Whether or not an unconnected txn is in the unconnected pool. Must be called with the write
-// lock held.
-func (mp *BitCloutMempool) isUnconnectedTxnInPool(hash *BlockHash) bool {
-	if _, exists := mp.unconnectedTxns[*hash]; exists {
-		return true
+// GetTransactionMetadata returns the TransactionMetadata for the pool txn with the
+// given hash, or nil if the txn isn't in the pool. In the common case this is just
+// the TxMeta that was computed when the txn was accepted, which avoids the cost of
+// reconnecting the txn. If that computation failed, the metadata is recomputed by
+// connecting the txn and its unconfirmed ancestors to a fresh view. The result is
+// cached by swapping in a copy of the MempoolTx that carries it, since the original
+// is shared with the readOnly view. See _replaceMempoolTx.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) GetTransactionMetadata(txHash *BlockHash) *TransactionMetadata {
+	mempoolTx := mp.readOnlyUniversalTransactionMap[*txHash]
+	if mempoolTx == nil {
+		return nil
+	}
+	if mempoolTx.TxMeta != nil {
+		return mempoolTx.TxMeta
 	}
 
-	return false
-}
+	// Take the write lock since we may be replacing the MempoolTx below.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
 
-func (mp *BitCloutMempool) DumpTxnsToDB() {
-	// Dump all mempool txns into data_dir_path/temp_mempool_dump.
-	err := mp.OpenTempDBAndDumpTxns()
-	if err != nil {
-		glog.Infof("DumpTxnsToDB: Problem opening temp db / dumping mempool txns: %v", err)
-		return
+	// Check again now that we hold the lock in case the txn was removed or its
+	// metadata was filled in by someone else while we were waiting.
+	mempoolTx, exists := mp.poolMap[*txHash]
+	if !exists {
+		return nil
+	}
+	if mempoolTx.TxMeta != nil {
+		return mempoolTx.TxMeta
 	}
 
-	// Now we shuffle the directories we created. The temp that we just created will become
-	// the latest dump and the latest dump will become the previous dump.  By doing this
-	// shuffle, we ensure that we always have a complete view of the mempool to load from.
-	tempDir := filepath.Join(mp.mempoolDir, "temp_mempool_dump")
-	previousDir := filepath.Join(mp.mempoolDir, "previous_mempool_dump")
-	latestDir := filepath.Join(mp.mempoolDir, "latest_mempool_dump")
+	utxoView, err := NewUtxoView(mp.bc.db, mp.bc.params, mp.bc.bitcoinManager)
+	if err != nil {
+		glog.Errorf("GetTransactionMetadata: Problem initializing UtxoView: %v", err)
+		return nil
+	}
+	bestHeight := uint32(mp.bc.blockTip().Height + 1)
 
-	// If latestDir exists, move latestDir --> previousDir.
-	// Check that latestDir exists before trying to move it.
-	_, err = os.Stat(latestDir)
-	if err == nil {
-		err = os.RemoveAll(previousDir)
-		if err != nil {
-			glog.Infof("DumpTxnsToDB: Problem deleting previous dir: %v", err)
-			return
-		}
-		err = os.Rename(latestDir, previousDir)
+	// The last txn returned is the one we're computing the metadata for. Everything
+	// before it is an ancestor that needs to be connected first.
+	txnsInOrder := mp._getTransactionWithAncestors(txHash)
+	for _, ancestorTxn := range txnsInOrder[:len(txnsInOrder)-1] {
+		_, _, _, _, err := utxoView._connectTransaction(
+			ancestorTxn, ancestorTxn.Hash(), 0, bestHeight, false, /*verifySignatures*/
+			false, /*checkMerkleProof*/
+			0, false /*ignoreUtxos*/)
 		if err != nil {
-			glog.Infof("DumpTxnsToDB: Problem moving latest mempool dir to previous: %v", err)
-			return
+			glog.Errorf("GetTransactionMetadata: Problem connecting ancestor %v "+
+				"of txn %v: %v", ancestorTxn.Hash(), txHash, err)
+			return nil
 		}
 	}
 
-	// Move tempDir --> latestDir. No need to delete latestDir, it was renamed above.
-	err = os.Rename(tempDir, latestDir)
+	txnMeta, err := ConnectTxnAndComputeTransactionMetadata(
+		mempoolTx.Tx, utxoView, txHash, bestHeight, uint64(0))
 	if err != nil {
-		glog.Infof("DumpTxnsToDB: Problem moving temp mempool dir to previous: %v", err)
-		return
+		glog.Errorf("GetTransactionMetadata: Problem computing metadata for "+
+			"txn %v: %v", txHash, err)
+		return nil
 	}
+	mempoolTxCopy := *mempoolTx
+	mempoolTxCopy.TxMeta = txnMeta
+	mp._replaceMempoolTx(mempoolTx, &mempoolTxCopy)
+	for ii, universalTx := range mp.universalTransactionList {
+		if universalTx == mempoolTx {
+			mp.universalTransactionList[ii] = &mempoolTxCopy
+			break
+		}
+	}
+
+	return txnMeta
 }
 
-// This function attempts to make the file path provided. Returns an =errors if a parent
-// directory in the path does not exist or another error is encountered.
-// User permissions are set to "rwx" so that it can be manipulated.
-// See: https://stackoverflow.com/questions/14249467/os-mkdir-and-os-mkdirall-permission-value/31151508
-func MakeDirIfNonExistent(filePath string) error {
+// RefreshAllTransactionMetadata recomputes the TxMeta of every txn in the pool so that
+// the usernames, affected public keys, etc. in it reflect the current chain state, e.g.
+// after a block that updates a profile is connected. The txns are replayed in order
+// on top of the tip, which is the same state the readOnly view is built from. Txns
+// whose TxMeta hasn't been computed yet are left alone since GetTransactionMetadata
+// computes it against the current state anyway. A txn that fails to connect or whose
+// metadata can't be computed is logged and keeps its current TxMeta.
+//
+// The TxMeta of a MempoolTx is never modified in place since the readOnly view shares
+// it with readers that don't hold the lock. Instead each refreshed txn is replaced by
+// a copy carrying the new TxMeta. See _replaceMempoolTx.
+//
+// The ChainLock must be held for reading calling this function. Acquires the write
+// lock.
+func (mp *BitCloutMempool) RefreshAllTransactionMetadata() error {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	utxoView, err := NewUtxoView(mp.bc.db, mp.bc.params, mp.bc.bitcoinManager)
+	if err != nil {
+		return errors.Wrapf(err, "RefreshAllTransactionMetadata: Problem initializing UtxoView: ")
+	}
+	bestHeight := uint32(mp.bc.blockTip().Height + 1)
+
+	for ii, mempoolTx := range mp.universalTransactionList {
+		if mempoolTx.TxMeta == nil {
+			_, _, _, _, err := utxoView._connectTransaction(
+				mempoolTx.Tx, mempoolTx.Hash, 0, bestHeight, false, /*verifySignatures*/
+				false, /*checkMerkleProof*/
+				0, false /*ignoreUtxos*/)
+			if err != nil {
+				glog.Errorf("RefreshAllTransactionMetadata: Problem connecting "+
+					"txn %v: %v", mempoolTx.Hash, err)
+			}
+			continue
+		}
+
+		txnMeta, err := ConnectTxnAndComputeTransactionMetadata(
+			mempoolTx.Tx, utxoView, mempoolTx.Hash, bestHeight, uint64(0))
+		if err != nil {
+			glog.Errorf("RefreshAllTransactionMetadata: Problem computing metadata "+
+				"for txn %v: %v", mempoolTx.Hash, err)
+			continue
+		}
+
+		mempoolTxCopy := *mempoolTx
+		mempoolTxCopy.TxMeta = txnMeta
+		mp._replaceMempoolTx(mempoolTx, &mempoolTxCopy)
+		mp.universalTransactionList[ii] = &mempoolTxCopy
+	}
+
+	return nil
+}
+
+// _replaceMempoolTx points every structure that references oldMempoolTx, other than
+// the universalTransactionList and the readOnly view, at newMempoolTx instead. The
+// two must be for the same txn. The txn is reindexed from scratch since the affected
+// public keys can change along with the rest of the metadata.
+func (mp *BitCloutMempool) _replaceMempoolTx(oldMempoolTx *MempoolTx, newMempoolTx *MempoolTx) {
+	mp._removeMempoolTxFromPubKeyOutputMap(oldMempoolTx)
+	mp._removeMempoolTxFromTxnTypeMap(oldMempoolTx)
+	mp._removeMempoolTxFromPostHashMap(oldMempoolTx)
+	mp._removeMempoolTxFromProfilePkMap(oldMempoolTx)
+	mp._removeMempoolTxFromAffectedPubKeyMap(oldMempoolTx)
+
+	mp.poolMap[*newMempoolTx.Hash] = newMempoolTx
+	// The copy keeps the heap index of the original.
+	mp.txFeeMinheap[newMempoolTx.index] = newMempoolTx
+	if mp.unminedBitcoinTxns[*newMempoolTx.Hash] == oldMempoolTx {
+		mp.unminedBitcoinTxns[*newMempoolTx.Hash] = newMempoolTx
+	}
+	if newMempoolTx.Tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
+		bitcoinTxHash := newMempoolTx.Tx.TxnMeta.(*BitcoinExchangeMetadata).BitcoinTransaction.TxHash()
+		if mp.bitcoinHashToMempoolTx[bitcoinTxHash.String()] == oldMempoolTx {
+			mp.bitcoinHashToMempoolTx[bitcoinTxHash.String()] = newMempoolTx
+		}
+	}
+
+	mp._addMempoolTxToPubKeyOutputMap(newMempoolTx)
+	mp._addMempoolTxToTxnTypeMap(newMempoolTx)
+	mp._addMempoolTxToPostHashMap(newMempoolTx)
+	mp._addMempoolTxToProfilePkMap(newMempoolTx)
+	mp._addMempoolTxToAffectedPubKeyMap(newMempoolTx)
+}
+
+// GetTransactionWithAncestors returns the txn with the given hash preceded by all of
+// its unconfirmed ancestors in the pool. The txns are ordered such that every txn
+// comes after all of the txns it spends from, which means they can be relayed to a
+// peer in order without any of them being treated as unconnected. Returns nil if the
+// txn isn't in the pool.
+//
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionWithAncestors(txHash *BlockHash) []*MsgBitCloutTxn {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp._getTransactionWithAncestors(txHash)
+}
+
+// The caller must hold at least the read lock.
+func (mp *BitCloutMempool) _getTransactionWithAncestors(txHash *BlockHash) []*MsgBitCloutTxn {
+	mempoolTx, exists := mp.poolMap[*txHash]
+	if !exists {
+		return nil
+	}
+
+	// Do a depth-first walk of the txn's inputs, only appending a txn once all of
+	// the pool txns it spends from have been appended.
+	txnsInOrder := []*MsgBitCloutTxn{}
+	visited := make(map[BlockHash]bool)
+	var visit func(mempoolTx *MempoolTx)
+	visit = func(mempoolTx *MempoolTx) {
+		visited[*mempoolTx.Hash] = true
+		for _, txIn := range mempoolTx.Tx.TxInputs {
+			if visited[txIn.TxID] {
+				continue
+			}
+			// Inputs that don't reference a pool txn are spending a confirmed utxo
+			// so there's nothing to relay for them.
+			if parentTx, isInPool := mp.poolMap[txIn.TxID]; isInPool {
+				visit(parentTx)
+			}
+		}
+		txnsInOrder = append(txnsInOrder, mempoolTx.Tx)
+	}
+	visit(mempoolTx)
+
+	return txnsInOrder
+}
+
+// GetTransactionsInRelayOrder returns the txns in the readOnly view in the order they
+// should be relayed to peers. Local txns go first, each one right after the pool txns
+// it spends from so that a peer doesn't treat it as unconnected, followed by the rest
+// in the order they appear in the readOnlyUniversalTransactionList.
+//
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionsInRelayOrder() []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	readOnlyTxnList := mp.readOnlyUniversalTransactionList
+	relayOrder := make([]*MempoolTx, 0, len(readOnlyTxnList))
+	queued := make(map[BlockHash]bool, len(readOnlyTxnList))
+	for _, mempoolTx := range readOnlyTxnList {
+		if !mempoolTx.Local {
+			continue
+		}
+		// Txns that have left the pool since the readOnly view was generated have
+		// no ancestors to look up. They're relayed with the rest below.
+		for _, txn := range mp._getTransactionWithAncestors(mempoolTx.Hash) {
+			txHash := txn.Hash()
+			if queued[*txHash] {
+				continue
+			}
+			queued[*txHash] = true
+			relayOrder = append(relayOrder, mp.poolMap[*txHash])
+		}
+	}
+	for _, mempoolTx := range readOnlyTxnList {
+		if queued[*mempoolTx.Hash] {
+			continue
+		}
+		queued[*mempoolTx.Hash] = true
+		relayOrder = append(relayOrder, mempoolTx)
+	}
+	return relayOrder
+}
+
+// _getTransactionWithDescendants returns the txn with the given hash followed by every
+// txn in the pool that spends one of its outputs, directly or through other pool txns,
+// in breadth-first order. Returns nil if the txn isn't in the pool. The caller must
+// hold at least the read lock.
+func (mp *BitCloutMempool) _getTransactionWithDescendants(txHash *BlockHash) []*MempoolTx {
+	mempoolTx, exists := mp.poolMap[*txHash]
+	if !exists {
+		return nil
+	}
+
+	// Do a breadth-first walk of the txn's outputs using the outpoints map to find
+	// the pool txns spending them. A txn that spends several outputs of txns in the
+	// set is only visited once.
+	txnsInOrder := []*MempoolTx{mempoolTx}
+	visited := map[BlockHash]bool{*txHash: true}
+	for ii := 0; ii < len(txnsInOrder); ii++ {
+		parentTx := txnsInOrder[ii]
+		for outputIndex := range parentTx.Tx.TxOutputs {
+			spendingTx, exists := mp.outpoints[UtxoKey{TxID: *parentTx.Hash, Index: uint32(outputIndex)}]
+			if !exists {
+				continue
+			}
+			childTx, isInPool := mp.poolMap[*spendingTx.Hash()]
+			if !isInPool || visited[*childTx.Hash] {
+				continue
+			}
+			visited[*childTx.Hash] = true
+			txnsInOrder = append(txnsInOrder, childTx)
+		}
+	}
+
+	return txnsInOrder
+}
+
+// GetTransactionsOrderedByTimeAdded returns all transactions in the mempool ordered
+// by when they were added to the mempool.
+func (mp *BitCloutMempool) _getTransactionsOrderedByTimeAdded() (_poolTxns []*MempoolTx, _unconnectedTxns []*UnconnectedTx, _err error) {
+	poolTxns := []*MempoolTx{}
+	for _, mempoolTx := range mp.poolMap {
+		poolTxns = append(poolTxns, mempoolTx)
+	}
+	// Sort the list based on when the transactions were added.
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	unconnectedTxns := []*UnconnectedTx{}
+	for _, oTx := range mp.unconnectedTxns {
+		unconnectedTxns = append(unconnectedTxns, oTx)
+	}
+
+	return poolTxns, unconnectedTxns, nil
+}
+
+// Evicts unconnectedTxns if we're over the maximum number of unconnectedTxns allowed, or if
+// unconnectedTxns have exired. Must be called with the write lock held.
+func (mp *BitCloutMempool) limitNumUnconnectedTxns() error {
+	if now := mp.nowFunc(); now.After(mp.nextExpireScan) {
+		prevNumUnconnectedTxns := len(mp.unconnectedTxns)
+		for _, unconnectedTxn := range mp.unconnectedTxns {
+			if now.After(unconnectedTxn.expiration) {
+				mp.removeUnconnectedTxn(unconnectedTxn.tx, true)
+			}
+		}
+
+		numUnconnectedTxns := len(mp.unconnectedTxns)
+		if numExpired := prevNumUnconnectedTxns - numUnconnectedTxns; numExpired > 0 {
+			glog.Debugf("Expired %d unconnectedTxns (remaining: %d)", numExpired, numUnconnectedTxns)
+		}
+
+		for txHash, offer := range mp.unconnectedTxnOffers {
+			if now.After(offer.expiration) {
+				delete(mp.unconnectedTxnOffers, txHash)
+			}
+		}
+	}
+
+	if len(mp.unconnectedTxns)+1 <= MaxUnconnectedTransactions {
+		return nil
+	}
+
+	for _, otx := range mp.unconnectedTxns {
+		mp.removeUnconnectedTxn(otx.tx, false)
+		break
+	}
+
+	return nil
+}
+
+// _decrementTotalUnconnectedTxSizeBytes subtracts numBytes from
+// totalUnconnectedTxSizeBytes, stopping at zero rather than wrapping around. Must be
+// called with the write lock held.
+func (mp *BitCloutMempool) _decrementTotalUnconnectedTxSizeBytes(numBytes uint64) {
+	if numBytes > mp.totalUnconnectedTxSizeBytes {
+		glog.Errorf("_decrementTotalUnconnectedTxSizeBytes: Removing %d bytes from "+
+			"totalUnconnectedTxSizeBytes %d would underflow; clamping to zero. This "+
+			"should never happen", numBytes, mp.totalUnconnectedTxSizeBytes)
+		mp.totalUnconnectedTxSizeBytes = 0
+		return
+	}
+	mp.totalUnconnectedTxSizeBytes -= numBytes
+}
+
+// _evictUnconnectedTxnsForCombinedSize evicts unconnectedTxns, soonest to expire
+// first, until an unconnected txn of newTxSizeBytes fits within maxCombinedTxSizeBytes
+// along with the rest of the pool. Does nothing when maxCombinedTxSizeBytes is zero.
+// Must be called with the write lock held.
+func (mp *BitCloutMempool) _evictUnconnectedTxnsForCombinedSize(newTxSizeBytes uint64) {
+	fits := func() bool {
+		return mp.totalTxSizeBytes+mp.totalUnconnectedTxSizeBytes+newTxSizeBytes <=
+			mp.maxCombinedTxSizeBytes
+	}
+	if mp.maxCombinedTxSizeBytes == 0 || fits() {
+		return
+	}
+
+	unconnectedTxns := make([]*UnconnectedTx, 0, len(mp.unconnectedTxns))
+	for _, unconnectedTxn := range mp.unconnectedTxns {
+		unconnectedTxns = append(unconnectedTxns, unconnectedTxn)
+	}
+	sort.Slice(unconnectedTxns, func(ii, jj int) bool {
+		return unconnectedTxns[ii].expiration.Before(unconnectedTxns[jj].expiration)
+	})
+
+	numEvicted := 0
+	for _, unconnectedTxn := range unconnectedTxns {
+		if fits() {
+			break
+		}
+		mp.removeUnconnectedTxn(unconnectedTxn.tx, false)
+		numEvicted++
+	}
+	glog.Debugf("_evictUnconnectedTxnsForCombinedSize: Evicted %d unconnectedTxns to "+
+		"stay within maxCombinedTxSizeBytes %d (remaining: %d)", numEvicted,
+		mp.maxCombinedTxSizeBytes, len(mp.unconnectedTxns))
+}
+
+// Adds an unconnected txn to the pool that expires at the given time. Must be called
+// with the write lock held.
+func (mp *BitCloutMempool) addUnconnectedTxn(tx *MsgBitCloutTxn, txSizeBytes uint64, peerID uint64, expiration time.Time) {
+	if MaxUnconnectedTransactions <= 0 {
+		return
+	}
+
+	mp.limitNumUnconnectedTxns()
+
+	txHash := tx.Hash()
+	if txHash == nil {
+		glog.Error(fmt.Errorf("addUnconnectedTxn: Problem hashing txn: "))
+		return
+	}
+	// A txn that's offered again replaces its old entry. The old entry is removed before
+	// evicting for size so that it's neither counted against the new one nor evicted,
+	// which would subtract its size twice. Txns that spend from it are kept since the
+	// new entry has the same outputs.
+	mp.removeUnconnectedTxn(tx, false /*removeRedeemers*/)
+	mp._evictUnconnectedTxnsForCombinedSize(txSizeBytes)
+	mp.unconnectedTxns[*txHash] = &UnconnectedTx{
+		tx:          tx,
+		peerID:      peerID,
+		expiration:  expiration,
+		txSizeBytes: txSizeBytes,
+	}
+	mp.totalUnconnectedTxSizeBytes += txSizeBytes
+	for _, txIn := range tx.TxInputs {
+		if _, exists := mp.unconnectedTxnsByPrev[UtxoKey(*txIn)]; !exists {
+			mp.unconnectedTxnsByPrev[UtxoKey(*txIn)] =
+				make(map[BlockHash]*MsgBitCloutTxn)
+		}
+		mp.unconnectedTxnsByPrev[UtxoKey(*txIn)][*txHash] = tx
+	}
+
+	glog.Debugf("Added unconnected transaction %v with total txns: %d)", txHash, len(mp.unconnectedTxns))
+}
+
+// Consider adding an unconnected txn to the pool. Must be called with the write lock held.
+func (mp *BitCloutMempool) tryAddUnconnectedTxn(tx *MsgBitCloutTxn, peerID uint64) error {
+	txBytes, err := tx.ToBytes(false)
+	if err != nil {
+		return errors.Wrapf(err, "tryAddUnconnectedTxn: Problem serializing txn: ")
+	}
+	serializedLen := len(txBytes)
+	if serializedLen > MaxUnconnectedTxSizeBytes {
+		return TxErrorTooLarge
+	}
+	// Evicting unconnectedTxns can't make room if the connected txns alone leave none.
+	if mp.maxCombinedTxSizeBytes != 0 &&
+		mp.totalTxSizeBytes+uint64(serializedLen) > mp.maxCombinedTxSizeBytes {
+
+		return TxErrorCombinedTxSizeLimitReached
+	}
+
+	// Re-offering a txn doesn't push back its expiration, and once it's been offered
+	// too many times it's refused until it would have expired.
+	txHash := tx.Hash()
+	now := mp.nowFunc()
+	offer, exists := mp.unconnectedTxnOffers[*txHash]
+	if !exists || now.After(offer.expiration) {
+		offer = &unconnectedTxnOffer{
+			expiration: now.Add(UnconnectedTxnExpirationInterval),
+		}
+		mp.unconnectedTxnOffers[*txHash] = offer
+	}
+	offer.numOffers++
+	if offer.numOffers > MaxUnconnectedTxnOffers {
+		return TxErrorUnconnectedTxnOfferedTooOften
+	}
+
+	mp.addUnconnectedTxn(tx, uint64(serializedLen), peerID, offer.expiration)
+
+	return nil
+}
+
+// _carryOverMempoolTx gives the MempoolTx that was just re-added to this pool during a
+// rebuild the Added time and Local flag that the txn had in the old pool. The
+// txFeeMinheap breaks fee rate ties on Added, so the txn's place in it is fixed up too.
+// Otherwise it would stay where its new Added time put it, e.g. if the txns are re-added
+// in a different order than they were originally added in, and it would no longer pop
+// in the order GetEvictionOrder reports. Must be called with the write lock held.
+func (mp *BitCloutMempool) _carryOverMempoolTx(newMempoolTx *MempoolTx, oldMempoolTx *MempoolTx) {
+	newMempoolTx.Added = oldMempoolTx.Added
+	newMempoolTx.Local = oldMempoolTx.Local
+	heap.Fix(&mp.txFeeMinheap, newMempoolTx.index)
+}
+
+// _carryOverUnconnectedTxnExpiration gives the unconnected txn that was just re-added
+// to this pool during a rebuild the expiration it had in the old pool. Otherwise every
+// rebuild would push the expiration back. Must be called with the write lock held.
+func (mp *BitCloutMempool) _carryOverUnconnectedTxnExpiration(oldUnconnectedTxn *UnconnectedTx) {
+	if unconnectedTxn, exists := mp.unconnectedTxns[*oldUnconnectedTxn.tx.Hash()]; exists {
+		unconnectedTxn.expiration = oldUnconnectedTxn.expiration
+	}
+}
+
+// RemoveUnconnectedTxnsFromPeer removes all of the unconnectedTxns that were sent by
+// the peer with the given ID, along with any unconnectedTxns that spend from them.
+// This should be called when a peer disconnects so that its unconnectedTxns don't
+// linger until they expire. Returns the number of unconnectedTxns removed.
+// Acquires the write lock.
+func (mp *BitCloutMempool) RemoveUnconnectedTxnsFromPeer(peerID uint64) int {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	prevNumUnconnectedTxns := len(mp.unconnectedTxns)
+	for _, unconnectedTxn := range mp.unconnectedTxns {
+		if unconnectedTxn.peerID == peerID {
+			mp.removeUnconnectedTxn(unconnectedTxn.tx, true)
+		}
+	}
+
+	numRemoved := prevNumUnconnectedTxns - len(mp.unconnectedTxns)
+	if numRemoved > 0 {
+		glog.Debugf("RemoveUnconnectedTxnsFromPeer: Removed %d unconnectedTxns from "+
+			"peer %d (remaining: %d)", numRemoved, peerID, len(mp.unconnectedTxns))
+	}
+	return numRemoved
+}
+
+// Remove unconnectedTxns that are no longer valid after applying the passed-in txn.
+func (mp *BitCloutMempool) removeUnconnectedTxnDoubleSpends(tx *MsgBitCloutTxn) {
+	for _, txIn := range tx.TxInputs {
+		for _, unconnectedTx := range mp.unconnectedTxnsByPrev[UtxoKey(*txIn)] {
+			mp.removeUnconnectedTxn(unconnectedTx, true)
+		}
+	}
+}
+
+// Must be called with the write lock held.
+func (mp *BitCloutMempool) isTransactionInPool(hash *BlockHash) bool {
+	if _, exists := mp.poolMap[*hash]; exists {
+		return true
+	}
+
+	return false
+}
+
+// Whether or not a txn is in the pool. Safe for concurrent access.
+func (mp *BitCloutMempool) IsTransactionInPool(hash *BlockHash) bool {
+	_, exists := mp.readOnlyUniversalTransactionMap[*hash]
+	return exists
+}
+
+// Whether or not an unconnected txn is in the unconnected pool. Must be called with at
+// least the read lock held.
+func (mp *BitCloutMempool) isUnconnectedTxnInPool(hash *BlockHash) bool {
+	if _, exists := mp.unconnectedTxns[*hash]; exists {
+		return true
+	}
+
+	return false
+}
+
+// HaveTransaction reports whether we've seen a txn, either as a connected txn in the
+// pool or as an unconnected txn waiting on its parents. It's meant for answering
+// peers during the inv/getdata exchange. The connected check uses the readOnly view so
+// it doesn't need a lock, and only the unconnected check acquires a read lock.
+func (mp *BitCloutMempool) HaveTransaction(hash *BlockHash) (_inPool bool, _unconnected bool) {
+	if mp.IsTransactionInPool(hash) {
+		return true, false
+	}
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return false, mp.isUnconnectedTxnInPool(hash)
+}
+
+func (mp *BitCloutMempool) DumpTxnsToDB() {
+	mp.dumpMtx.Lock()
+	defer mp.dumpMtx.Unlock()
+
+	// Dump all mempool txns into the dump db.
+	allTxns := mp.readOnlyUniversalTransactionList
+	err := mp._openTempDBAndDumpTxns(allTxns)
+	if err != nil {
+		glog.Infof("DumpTxnsToDB: Problem opening dump db / dumping mempool txns: %v", err)
+		return
+	}
+
+	// The dump is complete so the WAL no longer needs the txns in it.
+	mp.truncateWAL(allTxns)
+}
+
+// This function attempts to make the file path provided. Returns an =errors if a parent
+// directory in the path does not exist or another error is encountered.
+// User permissions are set to "rwx" so that it can be manipulated.
+// See: https://stackoverflow.com/questions/14249467/os-mkdir-and-os-mkdirall-permission-value/31151508
+func MakeDirIfNonExistent(filePath string) error {
 	_, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
 		err = os.Mkdir(filePath, 0700)
@@ -759,29 +1931,83 @@ func MakeDirIfNonExistent(filePath string) error {
 	} else if err != nil {
 		return fmt.Errorf("OpenTempDBAndDumpTxns: os.Stat() error: %v", err)
 	}
-	return nil
+	return nil
+}
+
+func (mp *BitCloutMempool) dumpDBDir() string {
+	return filepath.Join(mp.mempoolDir, "mempool_dump_db")
+}
+
+// _getDumpDB returns the pool's long-lived dump db, opening it the first time it's
+// needed. Opening badger is expensive, so the handle is kept open across dumps and
+// only closed by Stop. Must be called with dumpMtx held.
+func (mp *BitCloutMempool) _getDumpDB() (*badger.DB, error) {
+	if mp.dumpDB != nil {
+		return mp.dumpDB, nil
+	}
+
+	// Make the top-level folder if it doesn't exist.
+	err := MakeDirIfNonExistent(mp.mempoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("_getDumpDB: Error making top-level dir: %v", err)
+	}
+	dumpDBDir := mp.dumpDBDir()
+	glog.Infof("_getDumpDB: Opening dump db %v", dumpDBDir)
+	// Since the handle stays open we stick with badger's default MemTableSize rather
+	// than holding onto a huge memtable between dumps. Txns are written in small
+	// batches anyway.
+	dumpDBOpts := badger.DefaultOptions(dumpDBDir)
+	dumpDBOpts.ValueDir = dumpDBDir
+	dumpDB, err := badger.Open(dumpDBOpts)
+	if err != nil {
+		return nil, fmt.Errorf("_getDumpDB: Could not open dump db: %v", err)
+	}
+	mp.dumpDB = dumpDB
+
+	// Dumps used to be shuffled between these dirs. Anything in them was either
+	// loaded already or predates the dump db, so they can be cleaned up now.
+	for _, legacyDir := range []string{"temp_mempool_dump", "previous_mempool_dump", "latest_mempool_dump"} {
+		if err := os.RemoveAll(filepath.Join(mp.mempoolDir, legacyDir)); err != nil {
+			glog.Infof("_getDumpDB: Problem deleting legacy dump dir %v: %v", legacyDir, err)
+		}
+	}
+
+	return dumpDB, nil
 }
 
 func (mp *BitCloutMempool) OpenTempDBAndDumpTxns() error {
-	allTxns := mp.readOnlyUniversalTransactionList
+	mp.dumpMtx.Lock()
+	defer mp.dumpMtx.Unlock()
 
-	tempMempoolDBDir := filepath.Join(mp.mempoolDir, "temp_mempool_dump")
-	glog.Infof("OpenTempDBAndDumpTxns: Opening new temp db %v", tempMempoolDBDir)
-	// Make the top-level folder if it doesn't exist.
-	err := MakeDirIfNonExistent(mp.mempoolDir)
+	return mp._openTempDBAndDumpTxns(mp.readOnlyUniversalTransactionList)
+}
+
+// _openTempDBAndDumpTxns writes the txns to whichever of the dump db's two slots
+// isn't active and then flips the active slot to it. A crash partway through leaves
+// the previously active slot, and so the last complete dump, untouched. Must be
+// called with dumpMtx held.
+func (mp *BitCloutMempool) _openTempDBAndDumpTxns(allTxns []*MempoolTx) error {
+	dumpDB, err := mp._getDumpDB()
 	if err != nil {
-		return fmt.Errorf("OpenTempDBAndDumpTxns: Error making top-level dir: %v", err)
+		return fmt.Errorf("OpenTempDBAndDumpTxns: %v", err)
 	}
-	tempMempoolDBOpts := badger.DefaultOptions(tempMempoolDBDir)
-	tempMempoolDBOpts.ValueDir = tempMempoolDBDir
-	tempMempoolDBOpts.MemTableSize = 1024 << 20
-	tempMempoolDB, err := badger.Open(tempMempoolDBOpts)
-	if err != nil {
-		return fmt.Errorf("OpenTempDBAndDumpTxns: Could not open temp db to dump mempool: %v", err)
+
+	// Use the lowest slot that isn't holding a retained generation.
+	generations := DbGetMempoolDumpGenerations(dumpDB)
+	usedSlots := make(map[byte]bool, len(generations))
+	for _, slot := range generations {
+		usedSlots[slot] = true
+	}
+	newSlot := byte(0)
+	for usedSlots[newSlot] {
+		newSlot++
+	}
+	// Clear out anything left in the new slot by a dump that didn't complete.
+	if err := dumpDB.DropPrefix(DbPrefixForMempoolDumpSlot(newSlot)); err != nil {
+		return fmt.Errorf("OpenTempDBAndDumpTxns: Problem clearing slot %d: %v", newSlot, err)
 	}
-	defer tempMempoolDB.Close()
 
-	// Dump txns into the temp mempool db.
+	// Dump txns into the new slot.
 	startTime := time.Now()
 	// Flush the new mempool state to the DB.
 	//
@@ -793,8 +2019,8 @@ func (mp *BitCloutMempool) OpenTempDBAndDumpTxns() error {
 		// then dump the txns to disk
 		if len(txnsToDump)%1000 == 0 || ii == len(allTxns)-1 {
 			glog.Infof("OpenTempDBAndDumpTxns: Dumping txns %v to %v", ii-len(txnsToDump)+1, ii)
-			err := tempMempoolDB.Update(func(txn *badger.Txn) error {
-				return FlushMempoolToDbWithTxn(txn, txnsToDump)
+			err := dumpDB.Update(func(txn *badger.Txn) error {
+				return DbPutMempoolDumpSlotTxnsWithTxn(txn, newSlot, txnsToDump)
 			})
 			if err != nil {
 				return fmt.Errorf("OpenTempDBAndDumpTxns: Error flushing mempool txns to DB: %v", err)
@@ -802,12 +2028,149 @@ func (mp *BitCloutMempool) OpenTempDBAndDumpTxns() error {
 			txnsToDump = []*MempoolTx{}
 		}
 	}
+
+	// Now that the new slot has every txn, make it the newest generation and drop
+	// the generations that no longer need to be retained.
+	newGenerations := append([]byte{newSlot}, generations...)
+	droppedSlots := []byte{}
+	if len(newGenerations) > mp.numRetainedDumpGenerations {
+		droppedSlots = newGenerations[mp.numRetainedDumpGenerations:]
+		newGenerations = newGenerations[:mp.numRetainedDumpGenerations]
+	}
+	if err := DbPutMempoolDumpGenerations(dumpDB, newGenerations); err != nil {
+		return fmt.Errorf("OpenTempDBAndDumpTxns: Problem activating slot %d: %v", newSlot, err)
+	}
+	for _, droppedSlot := range droppedSlots {
+		if err := dumpDB.DropPrefix(DbPrefixForMempoolDumpSlot(droppedSlot)); err != nil {
+			// The next dump clears the slot before using it so this isn't fatal.
+			glog.Infof("OpenTempDBAndDumpTxns: Problem clearing old slot %d: %v", droppedSlot, err)
+		}
+	}
 	endTime := time.Now()
 	glog.Infof("OpenTempDBAndDumpTxns: Full txn dump of %v txns completed "+
 		"in %v seconds. Safe to reboot node", len(allTxns), endTime.Sub(startTime).Seconds())
 	return nil
 }
 
+func (mp *BitCloutMempool) walPath() string {
+	return filepath.Join(mp.mempoolDir, "mempool_wal")
+}
+
+// openWAL opens the pool's write-ahead log for appending, creating it and the
+// mempoolDir if needed.
+func (mp *BitCloutMempool) openWAL() error {
+	err := MakeDirIfNonExistent(mp.mempoolDir)
+	if err != nil {
+		return fmt.Errorf("openWAL: Error making top-level dir: %v", err)
+	}
+	walFile, err := os.OpenFile(mp.walPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("openWAL: Problem opening WAL file: %v", err)
+	}
+	mp.walFile = walFile
+	return nil
+}
+
+// _appendToWAL writes a single length-prefixed txn to the WAL and syncs it to
+// disk. Must be called with the write lock held.
+func (mp *BitCloutMempool) _appendToWAL(txBytes []byte) error {
+	record := append(UintToBuf(uint64(len(txBytes))), txBytes...)
+	if _, err := mp.walFile.Write(record); err != nil {
+		return err
+	}
+	return mp.walFile.Sync()
+}
+
+// readWAL returns the txns in the WAL in the order they were appended. A record
+// cut short by a crash mid-append ends the log rather than causing an error.
+func (mp *BitCloutMempool) readWAL() ([]*MsgBitCloutTxn, error) {
+	walFile, err := os.Open(mp.walPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer walFile.Close()
+
+	rr := bufio.NewReader(walFile)
+	txns := []*MsgBitCloutTxn{}
+	for {
+		txnLen, err := ReadUvarint(rr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			glog.Warningf("readWAL: Ignoring truncated record at end of WAL: %v", err)
+			break
+		}
+		txBytes := make([]byte, txnLen)
+		if _, err := io.ReadFull(rr, txBytes); err != nil {
+			glog.Warningf("readWAL: Ignoring truncated record at end of WAL: %v", err)
+			break
+		}
+		txn := &MsgBitCloutTxn{}
+		if err := txn.FromBytes(txBytes); err != nil {
+			return nil, errors.Wrapf(err, "readWAL: Problem decoding txn: ")
+		}
+		txns = append(txns, txn)
+	}
+	return txns, nil
+}
+
+// truncateWAL drops the records for txns that made it into a completed dump, along
+// with the records for txns that have since left the pool, e.g. because they were
+// mined or evicted. Only txns that are still in the pool but were accepted after the
+// dump's snapshot was taken are kept. Acquires the write lock.
+func (mp *BitCloutMempool) truncateWAL(dumpedTxns []*MempoolTx) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	if !mp.enableWAL || mp.walFile == nil {
+		return
+	}
+
+	dumpedHashes := make(map[BlockHash]bool, len(dumpedTxns))
+	for _, mempoolTx := range dumpedTxns {
+		dumpedHashes[*mempoolTx.Hash] = true
+	}
+	walTxns, err := mp.readWAL()
+	if err != nil {
+		glog.Errorf("truncateWAL: Problem reading WAL: %v", err)
+		return
+	}
+	remainingRecords := []byte{}
+	for _, txn := range walTxns {
+		txHash := txn.Hash()
+		if _, inPool := mp.poolMap[*txHash]; !inPool || dumpedHashes[*txHash] {
+			continue
+		}
+		txBytes, err := txn.ToBytes(false)
+		if err != nil {
+			glog.Errorf("truncateWAL: Problem serializing txn: %v", err)
+			return
+		}
+		remainingRecords = append(remainingRecords, UintToBuf(uint64(len(txBytes)))...)
+		remainingRecords = append(remainingRecords, txBytes...)
+	}
+
+	// Write the remaining records to a temp file and swap it in so a crash
+	// mid-truncate leaves either the old or the new log intact.
+	tempWALPath := mp.walPath() + ".tmp"
+	if err := ioutil.WriteFile(tempWALPath, remainingRecords, 0600); err != nil {
+		glog.Errorf("truncateWAL: Problem writing temp WAL: %v", err)
+		return
+	}
+	mp.walFile.Close()
+	mp.walFile = nil
+	if err := os.Rename(tempWALPath, mp.walPath()); err != nil {
+		glog.Errorf("truncateWAL: Problem replacing WAL: %v", err)
+	}
+	if err := mp.openWAL(); err != nil {
+		glog.Errorf("truncateWAL: Problem reopening WAL: %v", err)
+	}
+}
+
 // Adds a txn to the pool. This function does not do any validation, and so it should
 // only be called when one is sure that a transaction is valid. Otherwise, it could
 // mess up the UtxoViews that we store internally.
@@ -827,85 +2190,565 @@ func (mp *BitCloutMempool) addTransaction(
 		return nil, errors.Wrapf(err, "addTransaction: Problem hashing tx: ")
 	}
 
-	// If this txn would put us over our threshold then don't accept it.
-	//
-	// TODO: We don't replace txns in the mempool right now. Instead, a node can be
-	// rebooted with a higher fee if the transactions start to get rejected due to
-	// the mempool being full.
-	if serializedLen+mp.totalTxSizeBytes > MaxTotalTransactionSizeBytes {
-		return nil, errors.Wrapf(TxErrorInsufficientFeePriorityQueue, "addTransaction: ")
+	// If this txn would put us over our threshold then don't accept it.
+	//
+	// TODO: We don't replace txns in the mempool right now. Instead, the min fee can
+	// be raised with SetMinFeeRate if the transactions start to get rejected due to
+	// the mempool being full.
+	if mp.totalTxSizeBytes > MaxTotalTransactionSizeBytes ||
+		serializedLen > MaxTotalTransactionSizeBytes-mp.totalTxSizeBytes {
+
+		return nil, errors.Wrapf(TxErrorInsufficientFeePriorityQueue, "addTransaction: ")
+	}
+
+	// If this txn's type is at its limit then it can only get in by evicting a txn
+	// of the same type. Under EvictionPolicyFeeBased that's the lowest-fee one, and
+	// only if this txn pays a higher fee rate. Under EvictionPolicyOldestFirst it's
+	// the oldest one.
+	var txnToEvict *MempoolTx
+	txnType := tx.TxnMeta.GetTxnType()
+	if typeLimit, hasLimit := mp.txnTypeLimits[txnType]; hasLimit &&
+		len(mp.txnTypeToTxnMap[txnType]) >= typeLimit {
+
+		if mp.evictionPolicy == EvictionPolicyOldestFirst {
+			txnToEvict = mp._getOldestTxnOfType(txnType)
+			if txnToEvict == nil {
+				return nil, errors.Wrapf(TxErrorTxnTypeLimitReached, "addTransaction: ")
+			}
+		} else {
+			txnToEvict = mp._getLowestFeeTxnOfType(txnType)
+			if txnToEvict == nil || _computeFeePerKB(fee, serializedLen) <= txnToEvict.FeePerKB {
+				return nil, errors.Wrapf(TxErrorTxnTypeLimitReached, "addTransaction: ")
+			}
+		}
+		// Evicting a txn that this one spends from would take this one out with it.
+		if mp._spendsFromAnyOf(tx, []*MempoolTx{txnToEvict}) {
+			return nil, errors.Wrapf(TxErrorTxnTypeLimitReached, "addTransaction: Txn "+
+				"spends from txn %v, which would have to be evicted to make room for it: ",
+				txnToEvict.Hash)
+		}
+	}
+
+	// Similarly, if this txn would put its type over its byte limit then it can only
+	// get in by evicting enough txns of the same type to make room.
+	var txnsToEvictForBytes []*MempoolTx
+	if byteLimit, hasByteLimit := mp.txnTypeByteLimits[txnType]; hasByteLimit {
+		var canFit bool
+		txnsToEvictForBytes, canFit = mp._getTxnsToEvictForTxnTypeByteLimit(
+			txnType, byteLimit, serializedLen, _computeFeePerKB(fee, serializedLen), txnToEvict)
+		if !canFit {
+			return nil, errors.Wrapf(TxErrorTxnTypeByteLimitReached, "addTransaction: ")
+		}
+		if mp._spendsFromAnyOf(tx, txnsToEvictForBytes) {
+			return nil, errors.Wrapf(TxErrorTxnTypeByteLimitReached, "addTransaction: Txn "+
+				"spends from one of the txns that would have to be evicted to make room for it: ")
+		}
+	}
+
+	// At this point we are certain that the mempool has enough room to accomodate
+	// this transaction.
+
+	mempoolTx := &MempoolTx{
+		Tx:          tx,
+		Hash:        txHash,
+		TxSizeBytes: uint64(serializedLen),
+		Added:       mp.nowFunc(),
+		Height:      height,
+		Fee:         fee,
+		FeePerKB:    _computeFeePerKB(fee, serializedLen),
+		// index will be set by the heap code.
+	}
+
+	// Connect the txn to the views before touching any of the pool's bookkeeping so
+	// that a failure leaves the pool exactly as it was. We assume the txn was already
+	// added to the backup view unless we're asked to update it.
+	_, _, _, _, err = mp.universalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
+		false /*verifySignatures*/, false, /*checkMerkleProof*/
+		0,
+		false /*ignoreUtxos*/)
+	if err != nil {
+		glog.Errorf("ERROR addTransaction: _connectTransaction failed on "+
+			"universalUtxoView for txn %v; rejecting it: %v", txHash, err)
+		mp._restoreUniversalView()
+		return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
+			"failed on universalUtxoView; this is a HUGE problem and should never happen")
+	}
+	if updateBackupView {
+		_, _, _, _, err = mp.backupUniversalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
+			false /*verifySignatures*/, false, /*checkMerkleProof*/
+			0,
+			false /*ignoreUtxos*/)
+		if err != nil {
+			glog.Errorf("ERROR addTransaction: _connectTransaction failed on "+
+				"backupUniversalUtxoView for txn %v; rejecting it: %v", txHash, err)
+			mp._restoreUniversalView()
+			mp.rebuildBackupView()
+			return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
+				"failed on backupUniversalUtxoView; this is a HUGE problem and should never happen")
+		}
+	}
+
+	// Both views accepted the txn so it's safe to commit it to the pool.
+	//
+	// Add the transaction to the main pool map.
+	mp.poolMap[*txHash] = mempoolTx
+	// Add the transaction to the outpoints map.
+	for _, txIn := range tx.TxInputs {
+		mp.outpoints[UtxoKey(*txIn)] = tx
+	}
+	// Add the transaction to the min heap.
+	heap.Push(&mp.txFeeMinheap, mempoolTx)
+	// Update the size of the mempool to reflect the added transaction.
+	mp._incrementTotalTxSizeBytes(mempoolTx.TxSizeBytes)
+	mp.totalFeeNanos += mempoolTx.Fee
+
+	// Whenever transactions are accepted into the mempool, add a mapping
+	// for each public key that they send an output to. This is useful so
+	// we can find all of these outputs if, for example, the user wants
+	// to know her balance while factoring in mempool transactions.
+	if !mp.deferTxnIndexing {
+		mp._addMempoolTxToPubKeyOutputMap(mempoolTx)
+		mp._addMempoolTxToTxnTypeMap(mempoolTx)
+		mp._addMempoolTxToPostHashMap(mempoolTx)
+		mp._addMempoolTxToProfilePkMap(mempoolTx)
+	}
+
+	// Index BitcoinExchange txns by the hash of the Bitcoin txn they embed.
+	if tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
+		bitcoinTxHash := tx.TxnMeta.(*BitcoinExchangeMetadata).BitcoinTransaction.TxHash()
+		mp.bitcoinHashToMempoolTx[bitcoinTxHash.String()] = mempoolTx
+	}
+
+	// Add it to the universalTransactionList now that it's in the view.
+	mp.universalTransactionList = append(mp.universalTransactionList, mempoolTx)
+
+	// Only kick off the double-spend check once we know the txn is staying in the
+	// pool.
+	if mp.blockCypherAPIKey != "" && tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange &&
+		IsUnminedBitcoinExchange(tx.TxnMeta.(*BitcoinExchangeMetadata)) &&
+		!IsForgivenBitcoinTransaction(tx) {
+
+		go func(txnToCheck *MsgBitCloutTxn) {
+			// Ten seconds is roughly how long it takes a Bitcoin transaction to fully
+			// propagate through the network. See post from Satoshi in this thread:
+			// https://bitcointalk.org/index.php?topic=423.20
+			time.Sleep(30 * time.Second)
+
+			// Adding the txn to this channel will trigger a double spend check.
+			mp.blockCypherCheckDoubleSpendChan <- txnToCheck
+		}(tx)
+	}
+
+	// Now that the txn is in, make room for it by evicting the txns picked above.
+	if txnToEvict != nil || len(txnsToEvictForBytes) > 0 {
+		if err := mp._evictForTxnTypeLimits(txnToEvict, txnsToEvictForBytes, txHash); err != nil {
+			return nil, errors.Wrapf(err, "addTransaction: ")
+		}
+		// If the eviction had to fall back to rebuilding the pool then all of its
+		// MempoolTxs were replaced, including the one for this txn.
+		mempoolTx = mp.poolMap[*txHash]
+	}
+
+	// Log the txn so it survives a crash before the next dump. A failure here
+	// shouldn't cost us the txn, so we only log it.
+	if mp.walFile != nil {
+		if err := mp._appendToWAL(txBytes); err != nil {
+			glog.Errorf("addTransaction: Problem appending txn %v to WAL: %v", txHash, err)
+		}
+	}
+
+	return mempoolTx, nil
+}
+
+// _evictForTxnTypeLimits removes the txns picked to make room for the txn with
+// addedTxHash, txnToEvict for its type's count limit and txnsToEvictForBytes for its
+// type's byte limit, along with anything that depends on them, in a single pass.
+// Either may be empty. The evicted txns are queued up for onEvict, with the picked
+// txns getting EvictReasonTxnTypeLimit or EvictReasonTxnTypeByteLimit. The caller
+// should have checked that the added txn doesn't spend from any of them, but it can
+// still depend on them in other ways, e.g. by updating a profile one of them created,
+// in which case it gets evicted as well and the limit's error is returned. Must be
+// called with the write lock held.
+func (mp *BitCloutMempool) _evictForTxnTypeLimits(txnToEvict *MempoolTx,
+	txnsToEvictForBytes []*MempoolTx, addedTxHash *BlockHash) error {
+
+	evictReasons := make(map[BlockHash]string)
+	txHashesToEvict := []*BlockHash{}
+	limitErr := TxErrorTxnTypeByteLimitReached
+	if txnToEvict != nil {
+		evictReasons[*txnToEvict.Hash] = EvictReasonTxnTypeLimit
+		txHashesToEvict = append(txHashesToEvict, txnToEvict.Hash)
+		limitErr = TxErrorTxnTypeLimitReached
+	}
+	for _, mempoolTx := range txnsToEvictForBytes {
+		evictReasons[*mempoolTx.Hash] = EvictReasonTxnTypeByteLimit
+		txHashesToEvict = append(txHashesToEvict, mempoolTx.Hash)
+	}
+	glog.Debugf("_evictForTxnTypeLimits: Evicting txns %v to make room for txn %v",
+		txHashesToEvict, addedTxHash)
+
+	for _, evicted := range mp.removeTransactionsAndDescendants(txHashesToEvict) {
+		// As far as the caller is concerned, the added txn never made it in.
+		if *evicted.mempoolTx.Hash == *addedTxHash {
+			continue
+		}
+		if reason, wasPicked := evictReasons[*evicted.mempoolTx.Hash]; wasPicked {
+			evicted.reason = reason
+		}
+		mp.pendingEvictedTxns = append(mp.pendingEvictedTxns, evicted)
+	}
+
+	if _, exists := mp.poolMap[*addedTxHash]; !exists {
+		return limitErr
+	}
+	return nil
+}
+
+// _spendsFromAnyOf returns true if tx spends an output of one of the given pool txns
+// or of any pool txn that descends from them. Must be called with at least the read
+// lock held.
+func (mp *BitCloutMempool) _spendsFromAnyOf(tx *MsgBitCloutTxn, mempoolTxns []*MempoolTx) bool {
+	hashes := make(map[BlockHash]bool)
+	for _, mempoolTx := range mempoolTxns {
+		for _, descendantTx := range mp._getTransactionWithDescendants(mempoolTx.Hash) {
+			hashes[*descendantTx.Hash] = true
+		}
+	}
+	for _, txIn := range tx.TxInputs {
+		if hashes[txIn.TxID] {
+			return true
+		}
+	}
+	return false
+}
+
+// _getTxnsToEvictForTxnTypeByteLimit picks the txns of the given type to evict so that
+// a new txn of that type with the given size and fee rate fits under byteLimit. Under
+// EvictionPolicyFeeBased txns are picked from the lowest fee rate up and only if they
+// pay less than the new txn. Local txns are exempt from fee-based eviction so they're
+// never picked. Under EvictionPolicyOldestFirst they're picked from the oldest up
+// regardless of fee. alreadyEvicting is a txn that's being evicted anyway, e.g. for
+// the count limit, and counts toward the room made. Returns false if the new txn can't be made to fit.
+// Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _getTxnsToEvictForTxnTypeByteLimit(txnType TxnType, byteLimit uint64,
+	txSizeBytes uint64, feePerKB uint64, alreadyEvicting *MempoolTx) (_txnsToEvict []*MempoolTx, _canFit bool) {
+
+	if txSizeBytes > byteLimit {
+		return nil, false
+	}
+	typeBytes := mp.txnTypeToTotalBytes[txnType]
+	if alreadyEvicting != nil {
+		typeBytes -= alreadyEvicting.TxSizeBytes
+	}
+	if typeBytes+txSizeBytes <= byteLimit {
+		return nil, true
+	}
+
+	candidates := []*MempoolTx{}
+	if mp.evictionPolicy == EvictionPolicyOldestFirst {
+		// The universalTransactionList is in the order txns were added.
+		for _, mempoolTx := range mp.universalTransactionList {
+			if mempoolTx.Tx.TxnMeta.GetTxnType() != txnType ||
+				(alreadyEvicting != nil && *mempoolTx.Hash == *alreadyEvicting.Hash) {
+
+				continue
+			}
+			candidates = append(candidates, mempoolTx)
+		}
+	} else {
+		// Go from the lowest fee rate up. Among txns with the same fee rate, the most
+		// recently added ones go first, same as in _getLowestFeeTxnOfType.
+		for _, mempoolTx := range mp.txnTypeToTxnMap[txnType] {
+			if mempoolTx.Local || (alreadyEvicting != nil && *mempoolTx.Hash == *alreadyEvicting.Hash) {
+				continue
+			}
+			candidates = append(candidates, mempoolTx)
+		}
+		sort.Slice(candidates, func(ii, jj int) bool {
+			if candidates[ii].FeePerKB != candidates[jj].FeePerKB {
+				return candidates[ii].FeePerKB < candidates[jj].FeePerKB
+			}
+			return candidates[ii].Added.After(candidates[jj].Added)
+		})
+	}
+
+	txnsToEvict := []*MempoolTx{}
+	for _, mempoolTx := range candidates {
+		if mp.evictionPolicy != EvictionPolicyOldestFirst && mempoolTx.FeePerKB >= feePerKB {
+			break
+		}
+		txnsToEvict = append(txnsToEvict, mempoolTx)
+		typeBytes -= mempoolTx.TxSizeBytes
+		if typeBytes+txSizeBytes <= byteLimit {
+			return txnsToEvict, true
+		}
+	}
+	return nil, false
+}
+
+// _getOldestTxnOfType returns the txn of the given type that was added to the pool
+// first, or nil if there are none. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _getOldestTxnOfType(txnType TxnType) *MempoolTx {
+	if len(mp.txnTypeToTxnMap[txnType]) == 0 {
+		return nil
+	}
+	// The universalTransactionList is in the order txns were added.
+	for _, mempoolTx := range mp.universalTransactionList {
+		if mempoolTx.Tx.TxnMeta.GetTxnType() == txnType {
+			return mempoolTx
+		}
+	}
+	return nil
+}
+
+// _getLowestFeeTxnOfType returns the non-Local txn of the given type with the lowest
+// FeePerKB, or nil if there are none. Among txns with the same FeePerKB, the most
+// recently added one is returned. Local txns are skipped since they're exempt from
+// fee-based eviction. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _getLowestFeeTxnOfType(txnType TxnType) *MempoolTx {
+	var lowestFeeTx *MempoolTx
+	for _, mempoolTx := range mp.txnTypeToTxnMap[txnType] {
+		if mempoolTx.Local {
+			continue
+		}
+		if lowestFeeTx == nil || mempoolTx.FeePerKB < lowestFeeTx.FeePerKB ||
+			(mempoolTx.FeePerKB == lowestFeeTx.FeePerKB && mempoolTx.Added.After(lowestFeeTx.Added)) {
+
+			lowestFeeTx = mempoolTx
+		}
+	}
+	return lowestFeeTx
+}
+
+func (mp *BitCloutMempool) _addMempoolTxToTxnTypeMap(mempoolTx *MempoolTx) {
+	txnType := mempoolTx.Tx.TxnMeta.GetTxnType()
+	mapForType, exists := mp.txnTypeToTxnMap[txnType]
+	if !exists {
+		mapForType = make(map[BlockHash]*MempoolTx)
+		mp.txnTypeToTxnMap[txnType] = mapForType
+	}
+	if _, alreadyAdded := mapForType[*mempoolTx.Hash]; !alreadyAdded {
+		mp.txnTypeToTotalBytes[txnType] += mempoolTx.TxSizeBytes
+	}
+	mapForType[*mempoolTx.Hash] = mempoolTx
+}
+
+func (mp *BitCloutMempool) _removeMempoolTxFromTxnTypeMap(mempoolTx *MempoolTx) {
+	txnType := mempoolTx.Tx.TxnMeta.GetTxnType()
+	mapForType, exists := mp.txnTypeToTxnMap[txnType]
+	if !exists {
+		return
+	}
+	if _, isInMap := mapForType[*mempoolTx.Hash]; !isInMap {
+		return
+	}
+	delete(mapForType, *mempoolTx.Hash)
+	mp.txnTypeToTotalBytes[txnType] -= mempoolTx.TxSizeBytes
+
+	if len(mapForType) == 0 {
+		delete(mp.txnTypeToTxnMap, txnType)
+		delete(mp.txnTypeToTotalBytes, txnType)
+	}
+}
+
+// _getPostHashesReferencedByTxn returns the hashes of the posts a txn refers to: the
+// post a SubmitPost modifies or comments on, the post a Like is for, and the posts
+// named by the RecloutedPostHash and DiamondPostHashKey ExtraData keys. A
+// ParentStakeID is only counted when it's the size of a post hash, since it can also
+// be a public key.
+func _getPostHashesReferencedByTxn(txn *MsgBitCloutTxn) []*BlockHash {
+	postHashes := []*BlockHash{}
+	addPostHashBytes := func(postHashBytes []byte) {
+		if len(postHashBytes) != HashSizeBytes {
+			return
+		}
+		postHash := &BlockHash{}
+		copy(postHash[:], postHashBytes)
+		postHashes = append(postHashes, postHash)
+	}
+
+	switch txMeta := txn.TxnMeta.(type) {
+	case *SubmitPostMetadata:
+		addPostHashBytes(txMeta.PostHashToModify)
+		addPostHashBytes(txMeta.ParentStakeID)
+	case *LikeMetadata:
+		if txMeta.LikedPostHash != nil {
+			postHashes = append(postHashes, txMeta.LikedPostHash)
+		}
+	}
+	addPostHashBytes(txn.ExtraData[RecloutedPostHash])
+	addPostHashBytes(txn.ExtraData[DiamondPostHashKey])
+
+	return postHashes
+}
+
+func (mp *BitCloutMempool) _addMempoolTxToPostHashMap(mempoolTx *MempoolTx) {
+	for _, postHash := range _getPostHashesReferencedByTxn(mempoolTx.Tx) {
+		mapForPostHash, exists := mp.postHashToTxnMap[*postHash]
+		if !exists {
+			mapForPostHash = make(map[BlockHash]*MempoolTx)
+			mp.postHashToTxnMap[*postHash] = mapForPostHash
+		}
+		mapForPostHash[*mempoolTx.Hash] = mempoolTx
+	}
+}
+
+func (mp *BitCloutMempool) _removeMempoolTxFromPostHashMap(mempoolTx *MempoolTx) {
+	for _, postHash := range _getPostHashesReferencedByTxn(mempoolTx.Tx) {
+		mapForPostHash, exists := mp.postHashToTxnMap[*postHash]
+		if !exists {
+			continue
+		}
+		delete(mapForPostHash, *mempoolTx.Hash)
+		if len(mapForPostHash) == 0 {
+			delete(mp.postHashToTxnMap, *postHash)
+		}
+	}
+}
+
+// _getProfilePublicKeysModifiedByTxn returns the public keys of the profiles a txn
+// modifies: the profile an UpdateProfile updates, which is the transactor's own when
+// ProfilePublicKey isn't set, and both profiles in a SwapIdentity.
+func _getProfilePublicKeysModifiedByTxn(txn *MsgBitCloutTxn) [][]byte {
+	switch txMeta := txn.TxnMeta.(type) {
+	case *UpdateProfileMetadata:
+		if len(txMeta.ProfilePublicKey) == btcec.PubKeyBytesLenCompressed {
+			return [][]byte{txMeta.ProfilePublicKey}
+		}
+		return [][]byte{txn.PublicKey}
+	case *SwapIdentityMetadataa:
+		return [][]byte{txMeta.FromPublicKey, txMeta.ToPublicKey}
 	}
+	return nil
+}
 
-	// At this point we are certain that the mempool has enough room to accomodate
-	// this transaction.
+func (mp *BitCloutMempool) _addMempoolTxToProfilePkMap(mempoolTx *MempoolTx) {
+	for _, profilePkBytes := range _getProfilePublicKeysModifiedByTxn(mempoolTx.Tx) {
+		pkMapKey := MakePkMapKey(profilePkBytes)
+		mapForPk, exists := mp.profilePkToTxnMap[pkMapKey]
+		if !exists {
+			mapForPk = make(map[BlockHash]*MempoolTx)
+			mp.profilePkToTxnMap[pkMapKey] = mapForPk
+		}
+		mapForPk[*mempoolTx.Hash] = mempoolTx
+	}
+}
 
-	mempoolTx := &MempoolTx{
-		Tx:          tx,
-		Hash:        txHash,
-		TxSizeBytes: uint64(serializedLen),
-		Added:       time.Now(),
-		Height:      height,
-		Fee:         fee,
-		FeePerKB:    fee * 1000 / serializedLen,
-		// index will be set by the heap code.
+func (mp *BitCloutMempool) _removeMempoolTxFromProfilePkMap(mempoolTx *MempoolTx) {
+	for _, profilePkBytes := range _getProfilePublicKeysModifiedByTxn(mempoolTx.Tx) {
+		pkMapKey := MakePkMapKey(profilePkBytes)
+		mapForPk, exists := mp.profilePkToTxnMap[pkMapKey]
+		if !exists {
+			continue
+		}
+		delete(mapForPk, *mempoolTx.Hash)
+		if len(mapForPk) == 0 {
+			delete(mp.profilePkToTxnMap, pkMapKey)
+		}
 	}
+}
 
-	// Add the transaction to the main pool map.
-	mp.poolMap[*txHash] = mempoolTx
-	// Add the transaction to the outpoints map.
-	for _, txIn := range tx.TxInputs {
-		mp.outpoints[UtxoKey(*txIn)] = tx
+// _getAffectedPublicKeysForMempoolTx returns the public keys in the AffectedPublicKeys
+// of the txn's TxMeta, or nil if it doesn't have any TxMeta. Keys that fail to decode
+// are skipped.
+func (mp *BitCloutMempool) _getAffectedPublicKeysForMempoolTx(mempoolTx *MempoolTx) [][]byte {
+	if mempoolTx.TxMeta == nil {
+		return nil
 	}
-	// Add the transaction to the min heap.
-	heap.Push(&mp.txFeeMinheap, mempoolTx)
-	// Update the size of the mempool to reflect the added transaction.
-	mp.totalTxSizeBytes += mempoolTx.TxSizeBytes
 
-	// Whenever transactions are accepted into the mempool, add a mapping
-	// for each public key that they send an output to. This is useful so
-	// we can find all of these outputs if, for example, the user wants
-	// to know her balance while factoring in mempool transactions.
-	mp._addMempoolTxToPubKeyOutputMap(mempoolTx)
+	affectedPks := [][]byte{}
+	for _, affectedPk := range mempoolTx.TxMeta.AffectedPublicKeys {
+		pkBytes, _, err := Base58CheckDecode(affectedPk.PublicKeyBase58Check)
+		if err != nil {
+			glog.Errorf("_getAffectedPublicKeysForMempoolTx: Problem decoding affected "+
+				"public key %v for txn %v: %v", affectedPk.PublicKeyBase58Check, mempoolTx.Hash, err)
+			continue
+		}
+		affectedPks = append(affectedPks, pkBytes)
+	}
+	return affectedPks
+}
 
-	if mp.blockCypherAPIKey != "" && tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange &&
-		IsUnminedBitcoinExchange(tx.TxnMeta.(*BitcoinExchangeMetadata)) &&
-		!IsForgivenBitcoinTransaction(tx) {
+func (mp *BitCloutMempool) _addMempoolTxToAffectedPubKeyMap(mempoolTx *MempoolTx) {
+	for _, pkBytes := range mp._getAffectedPublicKeysForMempoolTx(mempoolTx) {
+		pkMapKey := MakePkMapKey(pkBytes)
+		mapForPk, exists := mp.affectedPubKeyToTxnMap[pkMapKey]
+		if !exists {
+			mapForPk = make(map[BlockHash]*MempoolTx)
+			mp.affectedPubKeyToTxnMap[pkMapKey] = mapForPk
+		}
+		mapForPk[*mempoolTx.Hash] = mempoolTx
+	}
+}
 
-		go func(txnToCheck *MsgBitCloutTxn) {
-			// Ten seconds is roughly how long it takes a Bitcoin transaction to fully
-			// propagate through the network. See post from Satoshi in this thread:
-			// https://bitcointalk.org/index.php?topic=423.20
-			time.Sleep(30 * time.Second)
+func (mp *BitCloutMempool) _removeMempoolTxFromAffectedPubKeyMap(mempoolTx *MempoolTx) {
+	for _, pkBytes := range mp._getAffectedPublicKeysForMempoolTx(mempoolTx) {
+		pkMapKey := MakePkMapKey(pkBytes)
+		mapForPk, exists := mp.affectedPubKeyToTxnMap[pkMapKey]
+		if !exists {
+			continue
+		}
+		delete(mapForPk, *mempoolTx.Hash)
+		if len(mapForPk) == 0 {
+			delete(mp.affectedPubKeyToTxnMap, pkMapKey)
+		}
+	}
+}
 
-			// Adding the txn to this channel will trigger a double spend check.
-			mp.blockCypherCheckDoubleSpendChan <- txnToCheck
-		}(tx)
+// _restoreUniversalView undoes a txn that addTransaction connected to the
+// universalUtxoView but didn't commit to the pool. Since a failed _connectTransaction
+// can leave a view partially modified, the view is rebuilt from the
+// universalTransactionList, which the txn was never added to. Must be called with the
+// write lock held.
+func (mp *BitCloutMempool) _restoreUniversalView() {
+	if err := mp._reconnectUniversalView(); err != nil {
+		glog.Errorf("ERROR _restoreUniversalView: %v", err)
 	}
+}
 
-	// Add it to the universal view. We assume the txn was already added to the
-	// backup view.
-	_, _, _, _, err = mp.universalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
-		false /*verifySignatures*/, false, /*checkMerkleProof*/
-		0,
-		false /*ignoreUtxos*/)
+// _reconnectUniversalView replaces the universalUtxoView with a fresh view that has
+// every txn in the universalTransactionList connected to it, in order. The view is
+// left untouched if any of them fail to connect. Must be called with the write lock
+// held.
+func (mp *BitCloutMempool) _reconnectUniversalView() error {
+	universalUtxoView, err := NewUtxoView(mp.bc.db, mp.bc.params, mp.bc.bitcoinManager)
 	if err != nil {
-		return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
-			"failed on universalUtxoView; this is a HUGE problem and should never happen")
+		return errors.Wrapf(err, "_reconnectUniversalView: Problem initializing UtxoView: ")
 	}
-	// Add it to the universalTransactionList if it made it through the view
-	mp.universalTransactionList = append(mp.universalTransactionList, mempoolTx)
-	if updateBackupView {
-		_, _, _, _, err = mp.backupUniversalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
+	for _, poolTx := range mp.universalTransactionList {
+		_, _, _, _, err = universalUtxoView._connectTransaction(poolTx.Tx, poolTx.Hash, int64(poolTx.TxSizeBytes), poolTx.Height,
 			false /*verifySignatures*/, false, /*checkMerkleProof*/
 			0,
 			false /*ignoreUtxos*/)
 		if err != nil {
-			return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
-				"failed on backupUniversalUtxoView; this is a HUGE problem and should never happen")
+			return errors.Wrapf(err, "_reconnectUniversalView: Problem reconnecting txn %v "+
+				"to universalUtxoView: ", poolTx.Hash)
 		}
 	}
+	mp.universalUtxoView = universalUtxoView
+	return nil
+}
 
-	return mempoolTx, nil
+// GetMempoolTxForBitcoinHash returns the BitcoinExchange txn in the pool that embeds
+// the Bitcoin txn with the given hash, or nil if there isn't one. The hash should be
+// formatted the same way as chainhash.Hash.String(). Acquires a read lock.
+func (mp *BitCloutMempool) GetMempoolTxForBitcoinHash(bitcoinHash string) *MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.bitcoinHashToMempoolTx[bitcoinHash]
+}
+
+// Returns the hashes of the BitcoinExchange txns in the pool that embed any of the
+// Bitcoin txns passed in. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _getTxHashesForBitcoinHashes(bitcoinTxnHashes []string) map[BlockHash]bool {
+	txHashes := make(map[BlockHash]bool)
+	for _, bitcoinHash := range bitcoinTxnHashes {
+		if mempoolTx, exists := mp.bitcoinHashToMempoolTx[bitcoinHash]; exists {
+			txHashes[*mempoolTx.Hash] = true
+		}
+	}
+	return txHashes
 }
 
 func (mp *BitCloutMempool) CheckSpend(op UtxoKey) *MsgBitCloutTxn {
@@ -914,6 +2757,96 @@ func (mp *BitCloutMempool) CheckSpend(op UtxoKey) *MsgBitCloutTxn {
 	return txR
 }
 
+// ArePendingSpent checks each of the outpoints passed in against the txns in the
+// readOnly view and returns whether each one is spent by one of them. Every outpoint
+// passed in has an entry in the result. This lets a wallet's coin selector exclude
+// the outpoints its pending txns already spend in one call rather than calling
+// CheckSpend for each candidate. Like CheckSpend, txns added since the readOnly view
+// was last regenerated aren't accounted for. Safe for concurrent access.
+func (mp *BitCloutMempool) ArePendingSpent(ops []UtxoKey) map[UtxoKey]bool {
+	// Load the map once so that every outpoint is checked against the same
+	// regeneration.
+	readOnlyOutpoints := mp.readOnlyOutpoints
+
+	pendingSpent := make(map[UtxoKey]bool, len(ops))
+	for _, op := range ops {
+		_, isSpent := readOnlyOutpoints[op]
+		pendingSpent[op] = isSpent
+	}
+	return pendingSpent
+}
+
+// GetConflictingTransactions returns the connected txns in the pool that spend any of
+// the same outpoints as the passed-in txn, without adding it to the pool or touching
+// any view. Each conflicting txn is returned once, in the order of the inputs it
+// conflicts on. The txn itself isn't considered a conflict if it's already in the
+// pool. Acquires a read lock.
+func (mp *BitCloutMempool) GetConflictingTransactions(tx *MsgBitCloutTxn) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	conflictingTxns := []*MempoolTx{}
+	seenHashes := make(map[BlockHash]bool)
+	seenHashes[*tx.Hash()] = true
+	for _, txIn := range tx.TxInputs {
+		spendingTxn, exists := mp.outpoints[UtxoKey(*txIn)]
+		if !exists {
+			continue
+		}
+		spendingTxHash := spendingTxn.Hash()
+		if seenHashes[*spendingTxHash] {
+			continue
+		}
+		seenHashes[*spendingTxHash] = true
+		if mempoolTx, exists := mp.poolMap[*spendingTxHash]; exists {
+			conflictingTxns = append(conflictingTxns, mempoolTx)
+		}
+	}
+
+	return conflictingTxns
+}
+
+// GetTransactionsSpendingOutput returns every txn in the pool that spends the passed-in
+// outpoint, including unconnectedTxns. Unlike CheckSpend, which only returns the single
+// connected spender, this surfaces all of the txns that conflict over the outpoint so
+// that double-spends can be presented to users. The connected spender, if there is one,
+// comes first. Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionsSpendingOutput(op UtxoKey) []*MsgBitCloutTxn {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	spendingTxns := []*MsgBitCloutTxn{}
+	seenHashes := make(map[BlockHash]bool)
+	if connectedTxn, exists := mp.outpoints[op]; exists {
+		spendingTxns = append(spendingTxns, connectedTxn)
+		seenHashes[*connectedTxn.Hash()] = true
+	}
+	for unconnectedTxHash, unconnectedTxn := range mp.unconnectedTxnsByPrev[op] {
+		if seenHashes[unconnectedTxHash] {
+			continue
+		}
+		spendingTxns = append(spendingTxns, unconnectedTxn)
+		seenHashes[unconnectedTxHash] = true
+	}
+
+	return spendingTxns
+}
+
+// GetSpentOutpoints returns a copy of the outpoints spent by the connected txns in the
+// pool, mapped to the hash of the txn spending each one. UnconnectedTxns aren't
+// included. Unlike CheckSpend, this reads the pool's current outpoints rather than the
+// readOnly view's. Acquires a read lock.
+func (mp *BitCloutMempool) GetSpentOutpoints() map[UtxoKey]*BlockHash {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	spentOutpoints := make(map[UtxoKey]*BlockHash, len(mp.outpoints))
+	for utxoKey, spendingTxn := range mp.outpoints {
+		spentOutpoints[utxoKey] = spendingTxn.Hash()
+	}
+	return spentOutpoints
+}
+
 // GetAugmentedUtxoViewForPublicKey creates a UtxoView that has connected all of
 // the transactions that could result in utxos for the passed-in public key
 // plus all of the dependencies of those transactions. This is useful for
@@ -926,9 +2859,9 @@ func (mp *BitCloutMempool) GetAugmentedUtxoViewForPublicKey(pkBytes []byte, opti
 }
 
 // GetAugmentedUniversalView creates a view that just connects everything
-// in the mempool...
-// TODO(performance): We should make a read-only version of the universal view that
-// you can get from the mempool.
+// in the mempool... The view is a fresh copy that the caller is free to mutate.
+// Callers that only read from the view should use WithCachedAugmentedUniversalView,
+// which avoids the copy.
 func (mp *BitCloutMempool) GetAugmentedUniversalView() (*UtxoView, error) {
 	newView, err := mp.readOnlyUtxoView.CopyUtxoView()
 	if err != nil {
@@ -937,6 +2870,108 @@ func (mp *BitCloutMempool) GetAugmentedUniversalView() (*UtxoView, error) {
 	return newView, nil
 }
 
+// WithReadOnlyView calls fn with the readOnly view itself rather than a copy, for
+// callers that only read what the pool's txns put in the view and want to avoid the
+// cost of GetAugmentedUniversalView and WithCachedAugmentedUniversalView.
+//
+// The view's maps are shared with the rest of the pool, so fn must treat them as
+// immutable and must not hold onto the view after returning. A lookup that misses the
+// view would normally fall through to the DB and cache what it reads in those maps,
+// so fn is given a view without a DB handle instead. A lookup that needs the DB fails
+// before anything is cached and WithReadOnlyView returns an error; use
+// WithCachedAugmentedUniversalView for those. Callers can compare
+// GetReadOnlyViewSequenceNumber across calls to tell whether the view has changed.
+// Safe for concurrent access.
+func (mp *BitCloutMempool) WithReadOnlyView(fn func(view *UtxoView) error) (_err error) {
+	// The readOnly view is replaced, not modified, when it's regenerated, so a copy of
+	// the struct shares its maps without racing with the regeneration.
+	view := *mp.readOnlyUtxoView
+	view.Handle = nil
+
+	defer func() {
+		if r := recover(); r != nil {
+			_err = fmt.Errorf("WithReadOnlyView: fn tried to read from the DB, which "+
+				"the readOnly view can't do: %v", r)
+		}
+	}()
+	return fn(&view)
+}
+
+// GetReadOnlyViewSequenceNumber returns the number of times the readOnly view has been
+// regenerated. Callers of WithReadOnlyView, or that got a view from
+// GetAugmentedUniversalView, can compare it to the value they saw when they got the
+// view to tell whether the view is stale. Safe for concurrent access.
+func (mp *BitCloutMempool) GetReadOnlyViewSequenceNumber() int64 {
+	return atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+}
+
+// GetValidationHeight returns the height txns are validated at, i.e. that of the block
+// after the chain tip the pool was last brought up to date with. This is the height
+// MempoolTx.Height is generally set to. During sync it can lag the chain's tip, which
+// means the pool is validating against a stale tip. Cheap and safe for concurrent
+// access without the ChainLock.
+func (mp *BitCloutMempool) GetValidationHeight() uint32 {
+	return atomic.LoadUint32(&mp.validationHeight)
+}
+
+// _updateValidationHeight sets the validationHeight from the chain's current tip. It
+// should be called whenever the pool is brought up to date with the chain.
+func (mp *BitCloutMempool) _updateValidationHeight() {
+	tip := mp.bc.blockTip()
+	if tip == nil {
+		return
+	}
+	atomic.StoreUint32(&mp.validationHeight, uint32(tip.Height+1))
+}
+
+// WithCachedAugmentedUniversalView calls fn with a copy of the readOnly view that's
+// shared with other callers and only re-copied once the readOnly view is regenerated.
+// This saves copying the whole view on every call for callers that only read from it.
+//
+// fn must not connect txns to the view or otherwise modify it, and it must not hold
+// onto the view after returning. Even lookups cache DB reads in the view's maps, so
+// calls are serialized while fn runs. Use GetAugmentedUniversalView to get a view
+// that can be modified or used for longer.
+func (mp *BitCloutMempool) WithCachedAugmentedUniversalView(fn func(view *UtxoView) error) error {
+	mp.cachedAugmentedViewMtx.Lock()
+	defer mp.cachedAugmentedViewMtx.Unlock()
+
+	// Load the sequence number before the view. regenerateReadOnlyView swaps the view
+	// in before bumping the number, so this order can only ever label a newer view with
+	// an older number, which just means it gets copied again next time.
+	seqNum := atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+	if mp.cachedAugmentedView == nil || seqNum != mp.cachedAugmentedViewSequenceNumber {
+		newView, err := mp.readOnlyUtxoView.CopyUtxoView()
+		if err != nil {
+			return errors.Wrapf(err, "WithCachedAugmentedUniversalView: Problem copying view: ")
+		}
+		mp.cachedAugmentedView = newView
+		mp.cachedAugmentedViewSequenceNumber = seqNum
+	}
+
+	return fn(mp.cachedAugmentedView)
+}
+
+// PreviewTransactionMetadata computes the TransactionMetadata the txn would get if it
+// were added to the pool, without adding it. The txn is connected to a copy of the
+// readOnly view that's thrown away afterward so no pool state is modified. The txn
+// must connect on top of the pool's txns or an error is returned.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) PreviewTransactionMetadata(tx *MsgBitCloutTxn) (*TransactionMetadata, error) {
+	utxoView, err := mp.GetAugmentedUniversalView()
+	if err != nil {
+		return nil, errors.Wrapf(err, "PreviewTransactionMetadata: Problem copying view: ")
+	}
+
+	txnMeta, err := ConnectTxnAndComputeTransactionMetadata(
+		tx, utxoView, tx.Hash(), uint32(mp.bc.blockTip().Height+1), 0 /*txnIndexInBlock*/)
+	if err != nil {
+		return nil, errors.Wrapf(err, "PreviewTransactionMetadata: ")
+	}
+	return txnMeta, nil
+}
+
 func (mp *BitCloutMempool) FetchTransaction(txHash *BlockHash) *MempoolTx {
 	if mempoolTx, exists := mp.readOnlyUniversalTransactionMap[*txHash]; exists {
 		return mempoolTx
@@ -944,13 +2979,46 @@ func (mp *BitCloutMempool) FetchTransaction(txHash *BlockHash) *MempoolTx {
 	return nil
 }
 
+// FetchTransactionByHashString is like FetchTransaction but takes the txn hash as a
+// hex string, which is how most callers get it from JSON. Returns an error if the
+// string isn't a valid hex-encoded hash and nil if the txn isn't in the pool.
+func (mp *BitCloutMempool) FetchTransactionByHashString(hashHex string) (*MempoolTx, error) {
+	hashBytes, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return nil, fmt.Errorf("FetchTransactionByHashString: Problem decoding "+
+			"hash hex %v: %v", hashHex, err)
+	}
+	if len(hashBytes) != HashSizeBytes {
+		return nil, fmt.Errorf("FetchTransactionByHashString: Hash has length %d "+
+			"but should be %d", len(hashBytes), HashSizeBytes)
+	}
+	txHash := &BlockHash{}
+	copy(txHash[:], hashBytes)
+
+	return mp.FetchTransaction(txHash), nil
+}
+
+// FetchTransactions is like FetchTransaction but looks up a batch of hashes at once.
+// Only the txns that were found are included in the returned map. Like
+// FetchTransaction it uses the readOnly view so it doesn't need the lock.
+func (mp *BitCloutMempool) FetchTransactions(txHashes []*BlockHash) map[BlockHash]*MempoolTx {
+	txMap := mp.readOnlyUniversalTransactionMap
+	foundTxns := make(map[BlockHash]*MempoolTx)
+	for _, txHash := range txHashes {
+		if mempoolTx, exists := txMap[*txHash]; exists {
+			foundTxns[*txHash] = mempoolTx
+		}
+	}
+	return foundTxns
+}
+
 // TODO(performance): This function is slow, and the only reason we have it is because
 // we need to validate BitcoinExchange transactions both before they have valid merkle
 // proofs and *after* they have valid merkle proofs. In the latter case we can't use
 // the universal view because the transaction is in the "middle" of the sorted list of
 // transactions ordered by time added.
 func (mp *BitCloutMempool) _quickCheckBitcoinExchangeTxn(
-	tx *MsgBitCloutTxn, txHash *BlockHash, checkMerkleProof bool) (
+	tx *MsgBitCloutTxn, txHash *BlockHash, checkMerkleProof bool, validationHeight uint32) (
 	_fees uint64, _err error) {
 
 	// Create a view that we'll use to validate this txn.
@@ -967,7 +3035,7 @@ func (mp *BitCloutMempool) _quickCheckBitcoinExchangeTxn(
 	// Connnect all of this transaction's dependencies to the UtxoView in order. Note
 	// that we can do this because _findMempoolDependencies returns the transactions in
 	// sorted order based on when transactions were added.
-	bestHeight := uint32(mp.bc.blockTip().Height + 1)
+	//
 	// Don't verify signatures since this transaction is already in the mempool.
 	//
 	// Additionally mempool verification does not require that BitcoinExchange
@@ -976,7 +3044,7 @@ func (mp *BitCloutMempool) _quickCheckBitcoinExchangeTxn(
 	// has the block corresponding to the transaction.
 	// We skip verifying txn size for bitcoin exchange transactions.
 	_, _, _, txFee, err := utxoView._connectTransaction(
-		tx, txHash, 0, bestHeight, false,
+		tx, txHash, 0, validationHeight, false,
 		checkMerkleProof, /*checkMerkleProof*/
 		0, false /*ignoreUtxos*/)
 	if err != nil {
@@ -996,7 +3064,36 @@ func IsUnminedBitcoinExchange(txnMeta *BitcoinExchangeMetadata) bool {
 	return *txnMeta.BitcoinMerkleRoot == zeroBlockHash
 }
 
-func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn) (
+// _demoteOrphanedBitcoinExchange returns an unmined copy of a mined BitcoinExchange
+// txn whose Bitcoin block is no longer on the main Bitcoin chain, e.g. because that
+// block got orphaned while the BitClout block carrying the txn was being disconnected.
+// The copy has its merkle proof fields zeroed so it's accepted through the unmined
+// path and can be upgraded again once the Bitcoin txn is re-mined. The hash of the
+// txn is unchanged since it's derived from the Bitcoin txn only. Any other txn is
+// returned as-is.
+func (mp *BitCloutMempool) _demoteOrphanedBitcoinExchange(tx *MsgBitCloutTxn) *MsgBitCloutTxn {
+	if tx.TxnMeta.GetTxnType() != TxnTypeBitcoinExchange || IsForgivenBitcoinTransaction(tx) {
+		return tx
+	}
+	txMeta := tx.TxnMeta.(*BitcoinExchangeMetadata)
+	if IsUnminedBitcoinExchange(txMeta) || mp.bc.bitcoinManager == nil ||
+		mp.bc.bitcoinManager.GetBitcoinBlockNode(txMeta.BitcoinBlockHash) != nil {
+
+		return tx
+	}
+
+	glog.Infof("_demoteOrphanedBitcoinExchange: Demoting txn %v to unmined because "+
+		"Bitcoin block %v is no longer on the main Bitcoin chain", tx.Hash(), txMeta.BitcoinBlockHash)
+	txCopy := *tx
+	txCopy.TxnMeta = &BitcoinExchangeMetadata{
+		BitcoinTransaction: txMeta.BitcoinTransaction,
+		BitcoinBlockHash:   &BlockHash{},
+		BitcoinMerkleRoot:  &BlockHash{},
+	}
+	return &txCopy
+}
+
+func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn, validationHeight uint32) (
 	_missingParents []*BlockHash, _mempoolTx *MempoolTx, _err error) {
 
 	if IsNukedBitcoinTransaction(tx) {
@@ -1045,9 +3142,29 @@ func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn) (
 		txInputHashes[key] = true
 	}
 
+	// Verify that the Bitcoin txn actually burns something. Without this, a txn
+	// with no outputs would pass the dust check below vacuously and only fail
+	// later with a much less obvious error.
+	if len(txMeta.BitcoinTransaction.TxOut) == 0 {
+		return nil, nil, errors.Wrapf(TxErrorBitcoinExchangeHasNoOutputs,
+			"tryAcceptBitcoinExchangeTxn: BitClout hash: %v, Bitcoin hash: %v",
+			tx.Hash(), txMeta.BitcoinTransaction.TxHash())
+	}
+	burnOutputSatoshis, err := _computeBitcoinBurnOutput(
+		txMeta.BitcoinTransaction, mp.bc.params.BitcoinBurnAddress,
+		mp.bc.params.BitcoinBtcdParams)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "tryAcceptBitcoinExchangeTxn: Problem "+
+			"computing burn output: ")
+	}
+	if burnOutputSatoshis <= 0 {
+		return nil, nil, errors.Wrapf(TxErrorBitcoinExchangeHasNoBurnOutput,
+			"tryAcceptBitcoinExchangeTxn: BitClout hash: %v, Bitcoin hash: %v",
+			tx.Hash(), txMeta.BitcoinTransaction.TxHash())
+	}
+
 	// Verify that the BitcoinExchange txn is not a dust transaction.
-	// TODO: Is 1,000 enough for the dust threshold?
-	dustOutputSatoshis := int64(1000)
+	dustOutputSatoshis := mp.bitcoinExchangeDustThresholdSatoshis
 	for _, txOut := range txMeta.BitcoinTransaction.TxOut {
 		if txOut.Value < dustOutputSatoshis {
 			// Get the Bitcoin txn bytes
@@ -1073,11 +3190,10 @@ func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn) (
 	// If the transaction does not have a merkle proof then we are dealing with
 	// a BitcoinExchange transaction whose corresponding Bitcoin transaction
 	// has not yet been mined into a block.
-	bestHeight := uint32(mp.bc.blockTip().Height + 1)
+	bestHeight := validationHeight
 	if IsUnminedBitcoinExchange(txMeta) {
 		// Just do a vanilla check and a vanilla add using the backup view.
 		// Don't check merkle proofs yet.
-		bestHeight = uint32(mp.bc.blockTip().Height + 1)
 		_, _, _, txFee, err := mp.backupUniversalUtxoView._connectTransaction(
 			tx, tx.Hash(), txnSize, bestHeight, false, /*verifySignatures*/
 			false, /*checkMerkleProof*/
@@ -1127,7 +3243,7 @@ func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn) (
 
 		// Check the validity of the txn
 		_, err := mp._quickCheckBitcoinExchangeTxn(
-			tx, txHash, true /*checkFinalMerkleProof*/)
+			tx, txHash, true /*checkFinalMerkleProof*/, validationHeight)
 		if err != nil {
 			return nil, nil, errors.Wrapf(
 				err, "tryAcceptBitcoinExchangeTxn: "+
@@ -1178,6 +3294,13 @@ func (mp *BitCloutMempool) tryAcceptBitcoinExchangeTxn(tx *MsgBitCloutTxn) (
 }
 
 func (mp *BitCloutMempool) rebuildBackupView() {
+	// In lightweight mode the backup view is thrown away after every txn so there's
+	// nothing to rebuild.
+	if mp.lightweightMode {
+		mp.backupUniversalUtxoView = nil
+		return
+	}
+
 	// We need to rebuild the backup view since the _connectTransaction broke it.
 	var copyErr error
 	mp.backupUniversalUtxoView, copyErr = mp.universalUtxoView.CopyUtxoView()
@@ -1187,23 +3310,207 @@ func (mp *BitCloutMempool) rebuildBackupView() {
 	}
 }
 
+// _checkExplicitOutputsDontExceedInputs is a cheap sanity check that runs before a
+// txn is connected to the backup view. It sums the txn's explicit inputs, using the
+// universalUtxoView to find their amounts, and its explicit outputs, and rejects it
+// if the outputs are larger. This is safe because the only txns with implicit inputs
+// are BlockRewards and BitcoinExchanges, which never reach this check, and txns with
+// implicit outputs (e.g. CreatorCoin sells) count them as both input and output, so
+// a txn that fails here would always fail the same check in _connectTransaction.
+//
+// If anything about the inputs can't be determined then the txn is let through and
+// _connectTransaction gets the final say. Must be called with the write lock held.
+func (mp *BitCloutMempool) _checkExplicitOutputsDontExceedInputs(tx *MsgBitCloutTxn) error {
+	totalInput := uint64(0)
+	for _, txIn := range tx.TxInputs {
+		utxoKey := UtxoKey(*txIn)
+		utxoEntry := mp.universalUtxoView.GetUtxoEntryForUtxoKey(&utxoKey)
+		if utxoEntry == nil || totalInput > math.MaxUint64-utxoEntry.AmountNanos {
+			return nil
+		}
+		totalInput += utxoEntry.AmountNanos
+	}
+
+	totalOutput := uint64(0)
+	for _, txOut := range tx.TxOutputs {
+		// An overflow here means the outputs are enormous, which _connectTransaction
+		// would reject anyway.
+		if totalOutput > math.MaxUint64-txOut.AmountNanos {
+			return RuleErrorTxnOutputWithInvalidAmount
+		}
+		totalOutput += txOut.AmountNanos
+	}
+
+	if totalOutput > totalInput {
+		return RuleErrorTxnOutputExceedsInput
+	}
+	return nil
+}
+
+// _transactorHasUtxos returns false if the txn is of a type that has to be funded by
+// its inputs but none of its inputs are utxos owned by its transactor. Such a txn can
+// never connect. If the transactor has no utxos at all then this is necessarily the
+// case, since every input must already be in the universalUtxoView by the time this is
+// called. Only the txn's own inputs are looked up so this is cheap, unlike enumerating
+// every utxo for the transactor. BitcoinExchange and PrivateMessage txns are allowed
+// to have no inputs and always pass. So do txns with no inputs and no outputs, since
+// they don't spend anything and pay no fee, e.g. a zero-fee follow or like.
+func (mp *BitCloutMempool) _transactorHasUtxos(tx *MsgBitCloutTxn) bool {
+	txnType := tx.TxnMeta.GetTxnType()
+	if txnType == TxnTypeBitcoinExchange || txnType == TxnTypePrivateMessage {
+		return true
+	}
+	if len(tx.TxInputs) == 0 && len(tx.TxOutputs) == 0 {
+		return true
+	}
+
+	for _, txIn := range tx.TxInputs {
+		utxoKey := UtxoKey(*txIn)
+		utxoEntry := mp.universalUtxoView.GetUtxoEntryForUtxoKey(&utxoKey)
+		if utxoEntry != nil && bytes.Equal(utxoEntry.PublicKey, tx.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// In lightweightMode, sets the backupUniversalUtxoView to a fresh copy of the
+// universalUtxoView so that a txn can be validated against it. The caller should
+// discard it with rebuildBackupView once it's done. Does nothing in normal mode since
+// the backup view is always kept up to date. Must be called with the write lock held.
+func (mp *BitCloutMempool) _prepareLightweightBackupView() error {
+	if !mp.lightweightMode {
+		return nil
+	}
+
+	var err error
+	mp.backupUniversalUtxoView, err = mp.universalUtxoView.CopyUtxoView()
+	if err != nil {
+		return errors.Wrapf(err, "_prepareLightweightBackupView: Problem copying view: ")
+	}
+	return nil
+}
+
 // See TryAcceptTransaction. The write lock must be held when calling this function.
+func (mp *BitCloutMempool) tryAcceptTransaction(
+	tx *MsgBitCloutTxn, rateLimit bool, rejectDupUnconnected bool, verifySignatures bool,
+	isLocal bool) (
+	_missingParents []*BlockHash, _mempoolTx *MempoolTx, _err error) {
+
+	return mp.tryAcceptTransactionAtHeight(tx, rateLimit, rejectDupUnconnected,
+		verifySignatures, isLocal, uint32(mp.bc.blockTip().Height+1), nil /*tags*/)
+}
+
+// tryAcceptTransactionAtHeight is like tryAcceptTransaction but validates the txn as
+// though it were going into a block at validationHeight rather than the block after
+// the current tip. If the txn is accepted, the given tags, if any, are attached to it
+// and it's sent to the SubscribePublicKey subscribers. That's done here, once every
+// field of the MempoolTx has been set, since subscribers read it without the lock. The
+// decision is passed to the pool's TxnLogger. The write lock must be held when calling
+// this function.
+func (mp *BitCloutMempool) tryAcceptTransactionAtHeight(
+	tx *MsgBitCloutTxn, rateLimit bool, rejectDupUnconnected bool, verifySignatures bool,
+	isLocal bool, validationHeight uint32, tags map[string]string) (
+	_missingParents []*BlockHash, _mempoolTx *MempoolTx, _err error) {
+
+	missingParents, mempoolTx, err := mp._tryAcceptTransactionAtHeight(tx, rateLimit,
+		rejectDupUnconnected, verifySignatures, isLocal, validationHeight)
+	mp._logTxnDecision(tx, missingParents, mempoolTx, err)
+	if err == nil && mempoolTx != nil {
+		if len(tags) > 0 {
+			tagsCopy := make(map[string]string, len(tags))
+			for key, value := range tags {
+				tagsCopy[key] = value
+			}
+			mempoolTx.Tags = tagsCopy
+			mp.txnTags[*mempoolTx.Hash] = tagsCopy
+		}
+		mp._notifyPublicKeySubscribers(mempoolTx)
+	}
+
+	// Remember why the txn was rejected, unless it's a copy of a txn we already have,
+	// in which case there's nothing to tell the user. A txn that's accepted on a
+	// later attempt shouldn't still look dropped.
+	if tx != nil && tx.TxnMeta != nil {
+		if err != nil && errors.Cause(err) != TxErrorDuplicate {
+			mp._recordDroppedTxnReason(tx.Hash(), err.Error())
+		} else if mempoolTx != nil {
+			mp._forgetDroppedTxnReason(mempoolTx.Hash)
+		}
+	}
+
+	return missingParents, mempoolTx, err
+}
+
+// _logTxnDecision passes the result of _tryAcceptTransactionAtHeight to the pool's
+// TxnLogger, or logs it with glog if there isn't one. The write lock must be held
+// when calling this function.
+func (mp *BitCloutMempool) _logTxnDecision(tx *MsgBitCloutTxn, missingParents []*BlockHash,
+	mempoolTx *MempoolTx, err error) {
+
+	// A txn without metadata can't be serialized, and so can't be hashed either.
+	decision := &TxnDecision{}
+	if tx != nil && tx.TxnMeta != nil {
+		decision.Hash = tx.Hash()
+		decision.TxnType = tx.TxnMeta.GetTxnType()
+	}
+	if err != nil {
+		decision.Result = TxnDecisionResultRejected
+		decision.Err = err
+	} else if len(missingParents) > 0 {
+		decision.Result = TxnDecisionResultMissingParents
+	} else {
+		decision.Result = TxnDecisionResultAccepted
+		if mempoolTx != nil {
+			decision.Fee = mempoolTx.Fee
+			decision.FeePerKB = mempoolTx.FeePerKB
+		}
+	}
+
+	if mp.txnLogger != nil {
+		mp.txnLogger.LogTxnDecision(decision)
+		return
+	}
+	glog.Tracef("tryAcceptTransaction: Txn %v of type %v with fee %d (%d per KB): %v (err: %v)",
+		decision.Hash, decision.TxnType, decision.Fee, decision.FeePerKB, decision.Result,
+		decision.Err)
+}
+
+// _tryAcceptTransactionAtHeight does the work for tryAcceptTransactionAtHeight. The
+// write lock must be held when calling this function.
 //
 // TODO: Allow replacing a transaction with a higher fee.
-func (mp *BitCloutMempool) tryAcceptTransaction(
-	tx *MsgBitCloutTxn, rateLimit bool, rejectDupUnconnected bool, verifySignatures bool) (
+func (mp *BitCloutMempool) _tryAcceptTransactionAtHeight(
+	tx *MsgBitCloutTxn, rateLimit bool, rejectDupUnconnected bool, verifySignatures bool,
+	isLocal bool, validationHeight uint32) (
 	_missingParents []*BlockHash, _mempoolTx *MempoolTx, _err error) {
 
+	// A txn without metadata can't be validated and would cause a panic below.
+	if tx == nil || tx.TxnMeta == nil {
+		return nil, nil, TxErrorNilTxnMeta
+	}
+
 	// Block reward transactions shouldn't appear individually
-	if tx.TxnMeta != nil && tx.TxnMeta.GetTxnType() == TxnTypeBlockReward {
+	if tx.TxnMeta.GetTxnType() == TxnTypeBlockReward {
 		return nil, nil, TxErrorIndividualBlockReward
 	}
 
 	// The BitcoinExchange logic is so customized that we break it out into its
 	// own function. We do this in order to support "fast" BitClout purchases
 	// in the UI that feel virtually instant without compromising on security.
-	if tx.TxnMeta != nil && tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
-		return mp.tryAcceptBitcoinExchangeTxn(tx)
+	if tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
+		if err := mp._prepareLightweightBackupView(); err != nil {
+			return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
+		}
+		if mp.lightweightMode {
+			defer mp.rebuildBackupView()
+		}
+
+		missingParents, mempoolTx, err := mp.tryAcceptBitcoinExchangeTxn(tx, validationHeight)
+		if mempoolTx != nil && isLocal {
+			mempoolTx.Local = true
+		}
+		return missingParents, mempoolTx, err
 	}
 
 	// Compute the hash of the transaction.
@@ -1212,11 +3519,43 @@ func (mp *BitCloutMempool) tryAcceptTransaction(
 		return nil, nil, fmt.Errorf("tryAcceptTransaction: Problem computing tx hash: ")
 	}
 
-	// Reject the txn if it already exists.
-	if mp.isTransactionInPool(txHash) || (rejectDupUnconnected &&
-		mp.isUnconnectedTxnInPool(txHash)) {
+	// Reject the txn if it already exists.
+	if mp.isTransactionInPool(txHash) || (rejectDupUnconnected &&
+		mp.isUnconnectedTxnInPool(txHash)) {
+
+		return nil, nil, TxErrorDuplicate
+	}
+
+	// Reject the txn if it was mined in one of the last few blocks. It would fail to
+	// connect anyway since its inputs are spent, but this saves validating it.
+	if mp._isRecentlyConfirmedTxn(txHash) {
+		return nil, nil, TxErrorDuplicate
+	}
+
+	// Reject the txn if it has too many inputs. This is checked before anything
+	// that looks at the inputs one by one.
+	if mp.maxInputsPerTxn > 0 && len(tx.TxInputs) > mp.maxInputsPerTxn {
+		return nil, nil, errors.Wrapf(TxErrorTooManyInputs, "tryAcceptTransaction: Txn has %d "+
+			"inputs, which exceeds the max of %d: ", len(tx.TxInputs), mp.maxInputsPerTxn)
+	}
+
+	// Reject the txn if it spends the same outpoint more than once. Such a txn would
+	// never connect, but it could still sit in the unconnected pool, and it would
+	// clobber its own entries in the outpoints map if it were ever added.
+	spentOutpoints := make(map[UtxoKey]bool, len(tx.TxInputs))
+	for _, txIn := range tx.TxInputs {
+		utxoKey := UtxoKey(*txIn)
+		if spentOutpoints[utxoKey] {
+			return nil, nil, RuleErrorDuplicateInputs
+		}
+		spentOutpoints[utxoKey] = true
+	}
 
-		return nil, nil, TxErrorDuplicate
+	// Reject the txn if its transactor already has too many txns in the pool.
+	if mp.maxPendingTxnsPerPublicKey > 0 &&
+		mp._getPendingTxnCountForTransactor(tx.PublicKey) >= mp.maxPendingTxnsPerPublicKey {
+
+		return nil, nil, TxErrorTooManyPendingForPublicKey
 	}
 
 	// Iterate over the transaction's inputs. If any of them don't have utxos in the
@@ -1243,16 +3582,55 @@ func (mp *BitCloutMempool) tryAcceptTransaction(
 		return missingParents, nil, nil
 	}
 
+	// Reject the txn if its transactor has nothing to pay for it with. This is much
+	// cheaper than finding out by connecting it.
+	if mp.requireTransactorUtxos && !mp._transactorHasUtxos(tx) {
+		return nil, nil, errors.Wrapf(TxErrorTransactorHasNoUtxos, "tryAcceptTransaction: "+
+			"Transactor %v doesn't own any of the txn's inputs: ",
+			PkToString(tx.PublicKey, mp.bc.params))
+	}
+
+	// Reject the txn if it's too big. This is checked before connecting it since
+	// connecting is the expensive part.
+	if mp.maxTxnSizeBytes > 0 {
+		txBytes, err := tx.ToBytes(false)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem serializing txn: ")
+		}
+		if uint64(len(txBytes)) > mp.maxTxnSizeBytes {
+			return nil, nil, errors.Wrapf(TxErrorTooLarge, "tryAcceptTransaction: Txn size %d "+
+				"exceeds max of %d: ", len(txBytes), mp.maxTxnSizeBytes)
+		}
+	}
+
+	// Shed txns whose explicit outputs plainly exceed their inputs before we go
+	// through the trouble of connecting them to the backup view and rolling it back.
+	if err := mp._checkExplicitOutputsDontExceedInputs(tx); err != nil {
+		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
+	}
+
+	// In lightweightMode there's no standing backup view so make a throwaway one for
+	// this txn, and throw it away once we're done with it.
+	if err := mp._prepareLightweightBackupView(); err != nil {
+		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
+	}
+	if mp.lightweightMode {
+		defer mp.rebuildBackupView()
+	}
+
 	// Attempt to add the transaction to the backup view. If it fails, reconstruct the backup
 	// view and return an error.
 	totalNanosPurchasedBefore := mp.backupUniversalUtxoView.NanosPurchased
 	usdCentsPerBitcoinBefore := mp.backupUniversalUtxoView.GetCurrentUSDCentsPerBitcoin()
-	bestHeight := uint32(mp.bc.blockTip().Height + 1)
 	// We can skip verifying the transaction size as related to the minimum fee here.
+	connectStartTime := time.Now()
 	_, totalInput, totalOutput, txFee, err := mp.backupUniversalUtxoView._connectTransaction(
-		tx, txHash, 0, bestHeight, verifySignatures,
+		tx, txHash, 0, validationHeight, verifySignatures,
 		false, /*checkMerkleProof*/
 		0, false /*ignoreUtxos*/)
+	if mp.statsHook != nil {
+		mp.statsHook.ObserveConnectDuration(time.Since(connectStartTime))
+	}
 	if err != nil {
 		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem "+
@@ -1266,7 +3644,7 @@ func (mp *BitCloutMempool) tryAcceptTransaction(
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem serializing txn: ")
 	}
 	serializedLen := uint64(len(txBytes))
-	txFeePerKB := txFee * 1000 / serializedLen
+	txFeePerKB := _computeFeePerKB(txFee, serializedLen)
 
 	// Transactions with a feerate below the minimum threshold will be outright
 	// rejected. This is the first line of defense against attacks against the
@@ -1287,8 +3665,10 @@ func (mp *BitCloutMempool) tryAcceptTransaction(
 	// to flood the network with low-value transacitons. This avoids a form of amplification
 	// DDOS attack brought on by the fact that a single broadcast results in all nodes
 	// communicating with each other.
-	if rateLimit && txFeePerKB < mp.rateLimitFeeRateNanosPerKB {
-		nowUnix := time.Now().Unix()
+	//
+	// Txns submitted locally are exempt since they didn't come from the network.
+	if rateLimit && !isLocal && txFeePerKB < mp.rateLimitFeeRateNanosPerKB {
+		nowUnix := mp.nowFunc().Unix()
 
 		// Exponentially decay the accumulator by a factor of 2 every 10m.
 		mp.lowFeeTxSizeAccumulator /= math.Pow(2.0,
@@ -1310,17 +3690,27 @@ func (mp *BitCloutMempool) tryAcceptTransaction(
 
 	// Add to transaction pool. Don't update the backup view since the call above
 	// will have already done this.
-	mempoolTx, err := mp.addTransaction(tx, bestHeight, txFee, false /*updateBackupUniversalView*/)
+	mempoolTx, err := mp.addTransaction(tx, validationHeight, txFee, false /*updateBackupUniversalView*/)
 	if err != nil {
 		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
 	}
+	mempoolTx.Local = isLocal
 
 	// Calculate metadata
-	txnMeta, err := ComputeTransactionMetadata(tx, mp.backupUniversalUtxoView, tx.Hash(), totalNanosPurchasedBefore,
-		usdCentsPerBitcoinBefore, totalInput, totalOutput, txFee, uint64(0))
-	if err == nil {
-		mempoolTx.TxMeta = txnMeta
+	if mp.computeMetadataOnAccept {
+		metadataStartTime := time.Now()
+		txnMeta, err := ComputeTransactionMetadata(tx, mp.backupUniversalUtxoView, tx.Hash(), totalNanosPurchasedBefore,
+			usdCentsPerBitcoinBefore, totalInput, totalOutput, txFee, uint64(0))
+		if mp.statsHook != nil {
+			mp.statsHook.ObserveMetadataDuration(time.Since(metadataStartTime))
+		}
+		if err == nil {
+			mempoolTx.TxMeta = txnMeta
+			if !mp.deferTxnIndexing {
+				mp._addMempoolTxToAffectedPubKeyMap(mempoolTx)
+			}
+		}
 	}
 
 	glog.Tracef("tryAcceptTransaction: Accepted transaction %v (pool size: %v)", txHash,
@@ -1366,6 +3756,40 @@ func ComputeTransactionMetadata(txn *MsgBitCloutTxn, utxoView *UtxoView, blockHa
 		})
 	}
 
+	// Diamonds can be attached to any txn type via ExtraData so parse them out
+	// before looking at the type-specific metadata.
+	diamondLevelBytes, hasDiamondLevel := extraData[DiamondLevelKey]
+	if hasDiamondLevel {
+		diamondLevel, bytesRead := Varint(diamondLevelBytes)
+		if bytesRead <= 0 {
+			// Only CreatorCoinTransfer txns have their diamond fields validated when
+			// they're connected so a malformed level on any other type is ignored
+			// rather than failing the whole txn.
+			if txn.TxnMeta.GetTxnType() == TxnTypeCreatorCoinTransfer {
+				return nil, fmt.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
+			}
+		} else {
+			diamondPostHashBytes := extraData[DiamondPostHashKey]
+			txnMeta.DiamondTxindexMetadata = &DiamondTxindexMetadata{
+				DiamondLevel: diamondLevel,
+				PostHashHex:  hex.EncodeToString(diamondPostHashBytes),
+			}
+
+			// Notify the poster that they received a diamond.
+			if len(diamondPostHashBytes) == HashSizeBytes {
+				postHash := &BlockHash{}
+				copy(postHash[:], diamondPostHashBytes)
+				postEntry := utxoView.GetPostEntryForPostHash(postHash)
+				if postEntry != nil {
+					txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
+						PublicKeyBase58Check: PkToString(postEntry.PosterPublicKey, utxoView.Params),
+						Metadata:             "DiamondPosterPublicKeyBase58Check",
+					})
+				}
+			}
+		}
+	}
+
 	if txn.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
 		txnMeta.BitcoinExchangeTxindexMetadata, txnMeta.TransactorPublicKeyBase58Check, err =
 			_computeBitcoinExchangeFields(utxoView.Params, txn.TxnMeta.(*BitcoinExchangeMetadata),
@@ -1415,20 +3839,21 @@ func ComputeTransactionMetadata(txn *MsgBitCloutTxn, utxoView *UtxoView, blockHa
 	}
 	if txn.TxnMeta.GetTxnType() == TxnTypeCreatorCoinTransfer {
 		realTxMeta := txn.TxnMeta.(*CreatorCoinTransferMetadataa)
-		creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
 		txnMeta.CreatorCoinTransferTxindexMetadata = &CreatorCoinTransferTxindexMetadata{
-			CreatorUsername:            string(creatorProfileEntry.Username),
 			CreatorCoinToTransferNanos: realTxMeta.CreatorCoinToTransferNanos,
 		}
+		// The creator's profile can be missing from the view, e.g. if it was swapped
+		// or removed after the txn was mined, in which case the username is left empty.
+		creatorProfileEntry := utxoView.GetProfileEntryForPublicKey(realTxMeta.ProfilePublicKey)
+		if creatorProfileEntry != nil {
+			txnMeta.CreatorCoinTransferTxindexMetadata.CreatorUsername = string(creatorProfileEntry.Username)
+		}
 
-		diamondLevelBytes, hasDiamondLevel := txn.ExtraData[DiamondLevelKey]
-		if hasDiamondLevel {
-			diamondLevel, bytesRead := Varint(diamondLevelBytes)
-			if bytesRead < 0 {
-				return nil, fmt.Errorf("Update TxIndex: Error reading diamond level for txn: %v", txn.Hash().String())
-			}
-			txnMeta.CreatorCoinTransferTxindexMetadata.DiamondLevel = diamondLevel
-			txnMeta.CreatorCoinTransferTxindexMetadata.PostHashHex = hex.EncodeToString(txn.ExtraData[DiamondPostHashKey])
+		// Keep the diamond fields here populated for consumers that predate
+		// DiamondTxindexMetadata.
+		if txnMeta.DiamondTxindexMetadata != nil {
+			txnMeta.CreatorCoinTransferTxindexMetadata.DiamondLevel = txnMeta.DiamondTxindexMetadata.DiamondLevel
+			txnMeta.CreatorCoinTransferTxindexMetadata.PostHashHex = txnMeta.DiamondTxindexMetadata.PostHashHex
 		}
 
 		txnMeta.AffectedPublicKeys = append(txnMeta.AffectedPublicKeys, &AffectedPublicKey{
@@ -1509,11 +3934,19 @@ func ComputeTransactionMetadata(txn *MsgBitCloutTxn, utxoView *UtxoView, blockHa
 			glog.Tracef("UpdateTxindex: Error parsing post body for @ mentions: "+
 				"%v %v", string(realTxMeta.Body), err)
 		} else {
-			terminators := []rune(" ,.\n&*()-_+~'\"[]{}")
-			dollarTagsFound := mention.GetTagsAsUniqueStrings('$', bodyObj.Body, terminators...)
-			atTagsFound := mention.GetTagsAsUniqueStrings('@', bodyObj.Body, terminators...)
+			dollarTagsFound := mention.GetTagsAsUniqueStrings('$', bodyObj.Body, MentionTerminators...)
+			atTagsFound := mention.GetTagsAsUniqueStrings('@', bodyObj.Body, MentionTerminators...)
 			tagsFound := append(dollarTagsFound, atTagsFound...)
+			// Record every tag, even ones that don't line up to a profile, so mentions
+			// of profiles that don't exist yet can still be analyzed.
+			txnMeta.SubmitPostTxindexMetadata.ParsedMentions = tagsFound
+			numMentionsAdded := 0
 			for _, tag := range tagsFound {
+				if numMentionsAdded >= MaxMentionedAffectedPublicKeys {
+					glog.Tracef("UpdateTxindex: Ignoring mentions past the first %d in post %v",
+						MaxMentionedAffectedPublicKeys, txn.Hash())
+					break
+				}
 				profileFound := utxoView.GetProfileEntryForUsername([]byte(strings.ToLower(tag)))
 				// Don't worry about tags that don't line up to a profile.
 				if profileFound == nil {
@@ -1524,6 +3957,7 @@ func ComputeTransactionMetadata(txn *MsgBitCloutTxn, utxoView *UtxoView, blockHa
 					PublicKeyBase58Check: PkToString(profileFound.PublicKey, utxoView.Params),
 					Metadata:             "MentionedPublicKeyBase58Check",
 				})
+				numMentionsAdded++
 			}
 			// Additionally, we need to check if this post is a reclout and
 			// fetch the original poster
@@ -1712,15 +4146,229 @@ func ConnectTxnAndComputeTransactionMetadata(
 //
 // The ChainLock must be held for reading calling this function.
 func (mp *BitCloutMempool) TryAcceptTransaction(tx *MsgBitCloutTxn, rateLimit bool, verifySignatures bool) ([]*BlockHash, *MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
 	// Protect concurrent access.
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
-	hashes, mempoolTx, err := mp.tryAcceptTransaction(tx, rateLimit, true, verifySignatures)
+	hashes, mempoolTx, err := mp.tryAcceptTransaction(tx, rateLimit, true, verifySignatures, false /*isLocal*/)
 
 	return hashes, mempoolTx, err
 }
 
+// TryAcceptTransactionWithContext is like TryAcceptTransaction but gives up and
+// returns ctx.Err() if the lock can't be acquired before ctx is done. Once the lock
+// is held the txn is processed to completion regardless of ctx.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) TryAcceptTransactionWithContext(ctx context.Context, tx *MsgBitCloutTxn,
+	rateLimit bool, verifySignatures bool) ([]*BlockHash, *MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	if err := mp._lockWithContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer mp.mtx.Unlock()
+
+	return mp.tryAcceptTransaction(tx, rateLimit, true, verifySignatures, false /*isLocal*/)
+}
+
+// TryAcceptTransactionWithTags is like TryAcceptTransaction but attaches the given
+// tags to the txn if it's accepted. The tags are copied, set as the MempoolTx's Tags,
+// and kept for as long as the txn remains in the pool, including across rebuilds. They
+// aren't attached if the txn is rejected or is missing parents. See
+// GetTransactionsByTag.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) TryAcceptTransactionWithTags(tx *MsgBitCloutTxn, rateLimit bool,
+	verifySignatures bool, tags map[string]string) ([]*BlockHash, *MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.tryAcceptTransactionAtHeight(tx, rateLimit, true, verifySignatures,
+		false /*isLocal*/, uint32(mp.bc.blockTip().Height+1), tags)
+}
+
+// TryAcceptTransactionBatchAtomic accepts every txn in the batch or none of them, e.g.
+// for a multi-txn operation that's only safe if all of its legs land. Txns may spend
+// the outputs of earlier txns in the batch but not of txns that aren't in the pool, so
+// none of them end up as unconnected txns. rateLimit and verifySignatures apply to
+// every txn in the batch the same way they do for TryAcceptTransaction.
+//
+// The whole batch is first run through a throwaway clone of the pool, so it goes
+// through every check a single txn does, fee and limit checks included. Only once
+// every txn has passed is the batch added to the pool itself, so a rejected batch
+// leaves no trace: nothing is written to the WAL, sent to subscribers, counted toward
+// the low-fee rate limit, or evicted. Returns the MempoolTxs in batch order. Acquires
+// the write lock.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) TryAcceptTransactionBatchAtomic(txns []*MsgBitCloutTxn, rateLimit bool,
+	verifySignatures bool) ([]*MempoolTx, error) {
+
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.tryAcceptTransactionBatchAtomic(txns, rateLimit, verifySignatures)
+}
+
+// See TryAcceptTransactionBatchAtomic. The write lock must be held when calling this
+// function.
+func (mp *BitCloutMempool) tryAcceptTransactionBatchAtomic(txns []*MsgBitCloutTxn, rateLimit bool,
+	verifySignatures bool) ([]*MempoolTx, error) {
+
+	if err := mp._validateTransactionBatch(txns, rateLimit, verifySignatures); err != nil {
+		return nil, errors.Wrapf(err, "tryAcceptTransactionBatchAtomic: ")
+	}
+
+	// The pool is in the same state the clone started out in, so every txn should
+	// make it in here too.
+	mempoolTxns := []*MempoolTx{}
+	for ii, tx := range txns {
+		missingParents, mempoolTx, err := mp.tryAcceptTransaction(
+			tx, rateLimit, true /*rejectDupUnconnected*/, verifySignatures, false /*isLocal*/)
+		if err == nil && len(missingParents) > 0 {
+			err = fmt.Errorf("Missing parents %v", missingParents)
+		}
+		if err != nil {
+			glog.Errorf("tryAcceptTransactionBatchAtomic: Txn %d (%v) in batch was rejected "+
+				"after passing validation; removing the rest of the batch. This should "+
+				"never happen: %v", ii, tx.Hash(), err)
+			acceptedHashes := []*BlockHash{}
+			for _, mempoolTx := range mempoolTxns {
+				acceptedHashes = append(acceptedHashes, mempoolTx.Hash)
+			}
+			mp.removeTransactionsAndDescendants(acceptedHashes)
+			return nil, errors.Wrapf(err, "tryAcceptTransactionBatchAtomic: Txn %d (%v) "+
+				"in batch was rejected: ", ii, tx.Hash())
+		}
+		mempoolTxns = append(mempoolTxns, mempoolTx)
+	}
+
+	return mempoolTxns, nil
+}
+
+// _validateTransactionBatch adds the txns in order to a throwaway clone of the pool and
+// returns an error if any of them is rejected, is left unconnected, or is evicted to
+// make room for a later txn in the batch. The clone has no WAL, subscribers, or onEvict
+// callback, and its rate limit accumulator and evictions are thrown away with it, so
+// the pool itself is left untouched. The write lock must be held when calling this
+// function.
+func (mp *BitCloutMempool) _validateTransactionBatch(txns []*MsgBitCloutTxn, rateLimit bool,
+	verifySignatures bool) error {
+
+	validationPool, err := mp.clone()
+	if err != nil {
+		return errors.Wrapf(err, "_validateTransactionBatch: Problem cloning pool: ")
+	}
+	// The clone doesn't copy the recently confirmed txns. It only reads them, so it
+	// can share the pool's.
+	validationPool.recentlyConfirmedTxns = mp.recentlyConfirmedTxns
+
+	for ii, tx := range txns {
+		if tx == nil || tx.TxnMeta == nil {
+			return errors.Wrapf(TxErrorNilTxnMeta, "_validateTransactionBatch: Txn %d in batch: ", ii)
+		}
+		missingParents, _, err := validationPool.tryAcceptTransaction(
+			tx, rateLimit, true /*rejectDupUnconnected*/, verifySignatures, false /*isLocal*/)
+		if err != nil {
+			return errors.Wrapf(err, "_validateTransactionBatch: Txn %d (%v) in batch was "+
+				"rejected: ", ii, tx.Hash())
+		}
+		if len(missingParents) > 0 {
+			return fmt.Errorf("_validateTransactionBatch: Txn %d (%v) in batch is missing "+
+				"parents %v", ii, tx.Hash(), missingParents)
+		}
+	}
+	for ii, tx := range txns {
+		if _, exists := validationPool.poolMap[*tx.Hash()]; !exists {
+			return fmt.Errorf("_validateTransactionBatch: Txn %d (%v) in batch was evicted "+
+				"to make room for a later txn in the batch", ii, tx.Hash())
+		}
+	}
+	return nil
+}
+
+// _lockWithContext acquires the write lock, or returns ctx.Err() if ctx is done
+// first. The lock is polled with TryLock, backing off between attempts, rather than
+// waited on with Lock. That way a caller that gives up never leaves a queued Lock
+// behind, which would still take the lock and keep new readers out after the caller
+// is gone. The flip side is that a poller doesn't hold back new readers either, so it
+// can keep losing out on a pool with a steady stream of them until ctx is done.
+func (mp *BitCloutMempool) _lockWithContext(ctx context.Context) error {
+	const minPollInterval = 100 * time.Microsecond
+	const maxPollInterval = 10 * time.Millisecond
+
+	pollInterval := minPollInterval
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if mp.mtx.TryLock() {
+			return nil
+		}
+
+		pollTimer := time.NewTimer(pollInterval)
+		select {
+		case <-pollTimer.C:
+		case <-ctx.Done():
+			pollTimer.Stop()
+			return ctx.Err()
+		}
+		if pollInterval *= 2; pollInterval > maxPollInterval {
+			pollInterval = maxPollInterval
+		}
+	}
+}
+
+// TryAcceptLocalTransaction is like TryAcceptTransaction but for txns that were
+// submitted directly to this node rather than relayed by a peer. The resulting
+// MempoolTx is marked as Local, which exempts it from rate-limiting and causes it to
+// be relayed ahead of other txns.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) TryAcceptLocalTransaction(tx *MsgBitCloutTxn, verifySignatures bool) ([]*BlockHash, *MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.tryAcceptTransaction(tx, true /*rateLimit*/, true, verifySignatures, true /*isLocal*/)
+}
+
+// TryAcceptTransactionAtHeight is like TryAcceptTransaction but validates the txn as
+// of validationHeight instead of the block after the current tip. This is useful
+// during a reorg, when a txn needs to be checked against the height of the block it
+// originally appeared in.
+//
+// The ChainLock must be held for reading calling this function.
+func (mp *BitCloutMempool) TryAcceptTransactionAtHeight(tx *MsgBitCloutTxn, rateLimit bool,
+	verifySignatures bool, validationHeight uint32) ([]*BlockHash, *MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.tryAcceptTransactionAtHeight(
+		tx, rateLimit, true, verifySignatures, false /*isLocal*/, validationHeight, nil /*tags*/)
+}
+
 // See comment on ProcessUnconnectedTransactions
 func (mp *BitCloutMempool) processUnconnectedTransactions(acceptedTx *MsgBitCloutTxn, rateLimit bool, verifySignatures bool) []*MempoolTx {
 	var acceptedTxns []*MempoolTx
@@ -1744,9 +4392,12 @@ func (mp *BitCloutMempool) processUnconnectedTransactions(acceptedTx *MsgBitClou
 				continue
 			}
 
-			for _, tx := range unconnectedTxns {
+			// Iterating over the map directly would make the order in which competing
+			// unconnectedTxns get promoted random. Sort them so that promotion is
+			// deterministic and prefers the txn paying the highest feerate.
+			for _, tx := range mp._sortUnconnectedTxnsByFeeRate(unconnectedTxns) {
 				missing, mempoolTx, err := mp.tryAcceptTransaction(
-					tx, rateLimit, false, verifySignatures)
+					tx, rateLimit, false, verifySignatures, false /*isLocal*/)
 				if err != nil {
 					mp.removeUnconnectedTxn(tx, true)
 					break
@@ -1773,28 +4424,294 @@ func (mp *BitCloutMempool) processUnconnectedTransactions(acceptedTx *MsgBitClou
 	return acceptedTxns
 }
 
-// ProcessUnconnectedTransactions tries to see if any unconnectedTxns can now be added to the pool.
-func (mp *BitCloutMempool) ProcessUnconnectedTransactions(acceptedTx *MsgBitCloutTxn, rateLimit bool, verifySignatures bool) []*MempoolTx {
-	mp.mtx.Lock()
-	acceptedTxns := mp.processUnconnectedTransactions(acceptedTx, rateLimit, verifySignatures)
-	mp.mtx.Unlock()
+// _sortUnconnectedTxnsByFeeRate returns the passed-in unconnectedTxns ordered by
+// feerate from highest to lowest, breaking ties by txn hash. The feerate is computed
+// using the utxos in the universalUtxoView, so a txn with inputs that can't be found
+// there, or with implicit outputs that make its outputs exceed its inputs, is
+// treated as paying a feerate of zero. Must be called with the write lock held.
+func (mp *BitCloutMempool) _sortUnconnectedTxnsByFeeRate(
+	unconnectedTxns map[BlockHash]*MsgBitCloutTxn) []*MsgBitCloutTxn {
+
+	type txnAndFeeRate struct {
+		txn      *MsgBitCloutTxn
+		hash     BlockHash
+		feePerKB uint64
+	}
+	sortedTxns := []*txnAndFeeRate{}
+	for txHash, txn := range unconnectedTxns {
+		sortedTxns = append(sortedTxns, &txnAndFeeRate{
+			txn:      txn,
+			hash:     txHash,
+			feePerKB: mp._estimateFeeRateNanosPerKB(txn),
+		})
+	}
+	sort.Slice(sortedTxns, func(ii, jj int) bool {
+		if sortedTxns[ii].feePerKB != sortedTxns[jj].feePerKB {
+			return sortedTxns[ii].feePerKB > sortedTxns[jj].feePerKB
+		}
+		return bytes.Compare(sortedTxns[ii].hash[:], sortedTxns[jj].hash[:]) < 0
+	})
+
+	txns := make([]*MsgBitCloutTxn, len(sortedTxns))
+	for ii, sortedTxn := range sortedTxns {
+		txns[ii] = sortedTxn.txn
+	}
+	return txns
+}
+
+// _computeFeePerKB returns the feerate of a txn with the given fee and size, rounded
+// to the nearest nano. Truncating instead would put a txn that pays exactly the min
+// feerate just below it whenever its size doesn't divide evenly into its fee.
+func _computeFeePerKB(feeNanos uint64, txSizeBytes uint64) uint64 {
+	return (feeNanos*1000 + txSizeBytes/2) / txSizeBytes
+}
+
+// _estimateFeeRateNanosPerKB computes the feerate of a txn that hasn't been connected
+// yet by looking up its inputs in the universalUtxoView. It returns zero if the feerate
+// can't be computed. Must be called with the write lock held.
+func (mp *BitCloutMempool) _estimateFeeRateNanosPerKB(txn *MsgBitCloutTxn) uint64 {
+	totalInput := uint64(0)
+	for _, txIn := range txn.TxInputs {
+		utxoKey := UtxoKey(*txIn)
+		utxoEntry := mp.universalUtxoView.GetUtxoEntryForUtxoKey(&utxoKey)
+		if utxoEntry == nil {
+			return 0
+		}
+		totalInput += utxoEntry.AmountNanos
+	}
+	totalOutput := uint64(0)
+	for _, txOut := range txn.TxOutputs {
+		totalOutput += txOut.AmountNanos
+	}
+	if totalOutput > totalInput {
+		return 0
+	}
+
+	txBytes, err := txn.ToBytes(false)
+	if err != nil || len(txBytes) == 0 {
+		return 0
+	}
+	return _computeFeePerKB(totalInput-totalOutput, uint64(len(txBytes)))
+}
+
+// ProcessUnconnectedTransactions tries to see if any unconnectedTxns can now be added to the pool.
+func (mp *BitCloutMempool) ProcessUnconnectedTransactions(acceptedTx *MsgBitCloutTxn, rateLimit bool, verifySignatures bool) []*MempoolTx {
+	mp.mtx.Lock()
+	acceptedTxns := mp.processUnconnectedTransactions(acceptedTx, rateLimit, verifySignatures)
+	mp.mtx.Unlock()
+
+	mp._notifyPendingEvictedTxns()
+
+	return acceptedTxns
+}
+
+func (mp *BitCloutMempool) _addTxnToPublicKeyMap(mempoolTx *MempoolTx, publicKey []byte) {
+	pkMapKey := MakePkMapKey(publicKey)
+	mapForPk, exists := mp.pubKeyToTxnMap[pkMapKey]
+	if !exists {
+		mapForPk = make(map[BlockHash]*MempoolTx)
+		mp.pubKeyToTxnMap[pkMapKey] = mapForPk
+	}
+	mapForPk[*mempoolTx.Hash] = mempoolTx
+}
+
+// _removeTxnFromPublicKeyMap is the inverse of _addTxnToPublicKeyMap. If the txn was
+// the last one indexed under the public key then the public key's entry is deleted
+// altogether so that pubKeyToTxnMap doesn't accumulate empty maps forever.
+func (mp *BitCloutMempool) _removeTxnFromPublicKeyMap(mempoolTx *MempoolTx, publicKey []byte) {
+	pkMapKey := MakePkMapKey(publicKey)
+	mapForPk, exists := mp.pubKeyToTxnMap[pkMapKey]
+	if !exists {
+		return
+	}
+	delete(mapForPk, *mempoolTx.Hash)
+
+	if len(mapForPk) == 0 {
+		delete(mp.pubKeyToTxnMap, pkMapKey)
+	}
+}
+
+func (mp *BitCloutMempool) PublicKeyTxnMap(publicKey []byte) (txnMap map[BlockHash]*MempoolTx) {
+	pkMapKey := MakePkMapKey(publicKey)
+	return mp.pubKeyToTxnMap[pkMapKey]
+}
+
+// GetMempoolTxsForPublicKeys returns the txns in the pool touching each of the given
+// public keys, sorted by when they were added, under a single read lock. Keys without
+// any pending txns are left out of the result, and duplicate keys are only looked up
+// once.
+func (mp *BitCloutMempool) GetMempoolTxsForPublicKeys(pkBytesList [][]byte) map[PkMapKey][]*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	mempoolTxsByPublicKey := make(map[PkMapKey][]*MempoolTx)
+	for _, pkBytes := range pkBytesList {
+		pkMapKey := MakePkMapKey(pkBytes)
+		if _, alreadyAdded := mempoolTxsByPublicKey[pkMapKey]; alreadyAdded {
+			continue
+		}
+		txnMap := mp.pubKeyToTxnMap[pkMapKey]
+		if len(txnMap) == 0 {
+			continue
+		}
+
+		mempoolTxs := make([]*MempoolTx, 0, len(txnMap))
+		for _, mempoolTx := range txnMap {
+			mempoolTxs = append(mempoolTxs, mempoolTx)
+		}
+		sort.Slice(mempoolTxs, func(ii, jj int) bool {
+			return mempoolTxs[ii].Added.Before(mempoolTxs[jj].Added)
+		})
+		mempoolTxsByPublicKey[pkMapKey] = mempoolTxs
+	}
+	return mempoolTxsByPublicKey
+}
+
+// GetTransactionsForUsername returns the txns in the pool touching the public key of
+// the profile with the given username, sorted by when they were added. The username
+// is resolved with the same view as WithCachedAugmentedUniversalView, so profiles
+// created or renamed by txns in the pool are taken into account once the readOnly
+// view has caught up with them. Returns an error if no profile has the username.
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionsForUsername(username string) ([]*MempoolTx, error) {
+	var profilePkBytes []byte
+	err := mp.WithCachedAugmentedUniversalView(func(view *UtxoView) error {
+		profileEntry := view.GetProfileEntryForUsername([]byte(username))
+		if profileEntry == nil || profileEntry.isDeleted {
+			return fmt.Errorf("No profile found for username %v", username)
+		}
+		profilePkBytes = profileEntry.PublicKey
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetTransactionsForUsername: ")
+	}
+
+	return mp.GetMempoolTxsForPublicKeys([][]byte{profilePkBytes})[MakePkMapKey(profilePkBytes)], nil
+}
+
+// GetTransactionsReferencingPost returns the txns in the pool that reference the given
+// post hash, e.g. likes, reclouts, comments, and diamonds for it, ordered by Added.
+// See _getPostHashesReferencedByTxn for what counts as a reference. Acquires a read
+// lock.
+func (mp *BitCloutMempool) GetTransactionsReferencingPost(postHash *BlockHash) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txnMap := mp.postHashToTxnMap[*postHash]
+	poolTxns := make([]*MempoolTx, 0, len(txnMap))
+	for _, mempoolTx := range txnMap {
+		poolTxns = append(poolTxns, mempoolTx)
+	}
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
+}
+
+// GetProfileModifyingTxns returns the txns in the pool that would modify the profile
+// with the given public key, i.e. UpdateProfile txns targeting it and SwapIdentity
+// txns involving it, ordered by Added. Acquires a read lock.
+func (mp *BitCloutMempool) GetProfileModifyingTxns(profilePkBytes []byte) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txnMap := mp.profilePkToTxnMap[MakePkMapKey(profilePkBytes)]
+	poolTxns := make([]*MempoolTx, 0, len(txnMap))
+	for _, mempoolTx := range txnMap {
+		poolTxns = append(poolTxns, mempoolTx)
+	}
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
+}
+
+// GetTransactionsByTag returns the txns in the pool that were accepted with the given
+// tag key set to the given value, ordered by Added. See TryAcceptTransactionWithTags.
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionsByTag(key string, value string) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	poolTxns := []*MempoolTx{}
+	for txHash, tags := range mp.txnTags {
+		if tagValue, exists := tags[key]; !exists || tagValue != value {
+			continue
+		}
+		if mempoolTx, exists := mp.poolMap[txHash]; exists {
+			poolTxns = append(poolTxns, mempoolTx)
+		}
+	}
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
+}
+
+// GetTransactionsAffectingPublicKey returns the txns in the pool that list the given
+// public key among the AffectedPublicKeys of their TxMeta, ordered by Added. This
+// covers every party a txn notifies, e.g. output recipients, mentioned keys, and parent
+// posters, rather than just the keys in pubKeyToTxnMap. Txns whose TxMeta hasn't been
+// computed, e.g. because computeMetadataOnAccept is off, aren't included. Acquires a
+// read lock.
+func (mp *BitCloutMempool) GetTransactionsAffectingPublicKey(pkBytes []byte) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txnMap := mp.affectedPubKeyToTxnMap[MakePkMapKey(pkBytes)]
+	poolTxns := make([]*MempoolTx, 0, len(txnMap))
+	for _, mempoolTx := range txnMap {
+		poolTxns = append(poolTxns, mempoolTx)
+	}
+	sort.Slice(poolTxns, func(ii, jj int) bool {
+		return poolTxns[ii].Added.Before(poolTxns[jj].Added)
+	})
+
+	return poolTxns
+}
+
+// GetPublicKeysWithPendingTxns returns every public key that has at least one txn in
+// the pool touching it, either as the transactor or as an output or otherwise affected
+// key. The keys are copies so callers are free to modify them. Acquires a read lock.
+func (mp *BitCloutMempool) GetPublicKeysWithPendingTxns() [][]byte {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
 
-	return acceptedTxns
+	publicKeys := make([][]byte, 0, len(mp.pubKeyToTxnMap))
+	for pkMapKey := range mp.pubKeyToTxnMap {
+		publicKey := make([]byte, len(pkMapKey))
+		copy(publicKey, pkMapKey[:])
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys
 }
 
-func (mp *BitCloutMempool) _addTxnToPublicKeyMap(mempoolTx *MempoolTx, publicKey []byte) {
-	pkMapKey := MakePkMapKey(publicKey)
-	mapForPk, exists := mp.pubKeyToTxnMap[pkMapKey]
-	if !exists {
-		mapForPk = make(map[BlockHash]*MempoolTx)
-		mp.pubKeyToTxnMap[pkMapKey] = mapForPk
-	}
-	mapForPk[*mempoolTx.Hash] = mempoolTx
+// GetPendingTxnCountForPublicKey returns the number of txns in the pool for which the
+// public key is the transactor. Txns that merely send an output to the public key
+// aren't counted. This is the count that maxPendingTxnsPerPublicKey is enforced
+// against. Acquires a read lock.
+func (mp *BitCloutMempool) GetPendingTxnCountForPublicKey(pkBytes []byte) int {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp._getPendingTxnCountForTransactor(pkBytes)
 }
 
-func (mp *BitCloutMempool) PublicKeyTxnMap(publicKey []byte) (txnMap map[BlockHash]*MempoolTx) {
-	pkMapKey := MakePkMapKey(publicKey)
-	return mp.pubKeyToTxnMap[pkMapKey]
+// The caller must hold at least the read lock.
+func (mp *BitCloutMempool) _getPendingTxnCountForTransactor(pkBytes []byte) int {
+	// pubKeyToTxnMap indexes txns under both their transactor and their output
+	// public keys so filter out the latter.
+	count := 0
+	for _, mempoolTx := range mp.pubKeyToTxnMap[MakePkMapKey(pkBytes)] {
+		if bytes.Equal(mempoolTx.Tx.PublicKey, pkBytes) {
+			count++
+		}
+	}
+	return count
 }
 
 // TODO: This needs to consolidate with ConnectTxnAndComputeTransactionMetadata which
@@ -1882,10 +4799,44 @@ func (mp *BitCloutMempool) _addMempoolTxToPubKeyOutputMap(mempoolTx *MempoolTx)
 	}
 }
 
+// _removeMempoolTxFromPubKeyOutputMap undoes _addMempoolTxToPubKeyOutputMap. Pool
+// rebuilds get this for free by starting from an empty map, but any path that removes
+// a txn from poolMap in place must call this or pubKeyToTxnMap will leak the txn.
+func (mp *BitCloutMempool) _removeMempoolTxFromPubKeyOutputMap(mempoolTx *MempoolTx) {
+	// The same public keys that were indexed on the way in are unindexed here.
+	publicKeysToIndex := _getPublicKeysToIndexForTxn(mempoolTx.Tx, mp.bc.params)
+	for _, pkToIndex := range publicKeysToIndex {
+		mp._removeTxnFromPublicKeyMap(mempoolTx, pkToIndex)
+	}
+}
+
 func (mp *BitCloutMempool) processTransaction(
 	tx *MsgBitCloutTxn, allowUnconnectedTxn, rateLimit bool,
 	peerID uint64, verifySignatures bool) ([]*MempoolTx, error) {
 
+	// Txns from trusted peers skip signature verification. See SetTrustedPeerIDs.
+	if mp.trustedPeerIDs[peerID] {
+		verifySignatures = false
+	}
+
+	return mp.processTransactionAtHeight(tx, allowUnconnectedTxn, rateLimit, peerID,
+		verifySignatures, uint32(mp.bc.blockTip().Height+1))
+}
+
+// processTransactionAtHeight is like processTransaction but validates the txn as of
+// validationHeight. Any unconnectedTxns that the txn allows into the pool are still
+// validated as of the block after the current tip. The write lock must be held when
+// calling this function.
+func (mp *BitCloutMempool) processTransactionAtHeight(
+	tx *MsgBitCloutTxn, allowUnconnectedTxn, rateLimit bool,
+	peerID uint64, verifySignatures bool, validationHeight uint32) ([]*MempoolTx, error) {
+
+	// Check this before anything else since almost everything below, including
+	// hashing and logging the txn, assumes the metadata is set.
+	if tx == nil || tx.TxnMeta == nil {
+		return nil, TxErrorNilTxnMeta
+	}
+
 	txHash := tx.Hash()
 	if txHash == nil {
 		return nil, fmt.Errorf("ProcessTransaction: Problem hashing tx")
@@ -1893,8 +4844,8 @@ func (mp *BitCloutMempool) processTransaction(
 	glog.Tracef("Processing transaction %v", txHash)
 
 	// Run validation and try to add this txn to the pool.
-	missingParents, mempoolTx, err := mp.tryAcceptTransaction(
-		tx, rateLimit, true, verifySignatures)
+	missingParents, mempoolTx, err := mp.tryAcceptTransactionAtHeight(
+		tx, rateLimit, true, verifySignatures, false /*isLocal*/, validationHeight, nil /*tags*/)
 	if err != nil {
 		return nil, err
 	}
@@ -1906,44 +4857,716 @@ func (mp *BitCloutMempool) processTransaction(
 		mp.totalProcessTransactionCalls%ReadOnlyUtxoViewRegenerationIntervalTxns == 0 {
 		// We call the version that doesn't lock.
 		mp.regenerateReadOnlyView()
+	} else if mp.generateReadOnlyUtxoView && ReadOnlyUtxoViewMaxLagTxns > 0 &&
+		mp._getReadOnlyViewLag() > ReadOnlyUtxoViewMaxLagTxns {
+		// Force a regeneration if the readOnly view has fallen too far behind.
+		glog.Debugf("processTransaction: Regenerating readOnly view early because "+
+			"it's %d txns behind", mp._getReadOnlyViewLag())
+		mp.regenerateReadOnlyView()
 	}
 	// Update the total number of transactions we've processed.
 	mp.totalProcessTransactionCalls += 1
 
 	if len(missingParents) == 0 {
+		promotionStartTime := time.Now()
 		newTxs := mp.processUnconnectedTransactions(tx, rateLimit, verifySignatures)
+		if mp.statsHook != nil {
+			mp.statsHook.ObserveUnconnectedPromotionDuration(time.Since(promotionStartTime))
+		}
 		acceptedTxs := make([]*MempoolTx, len(newTxs)+1)
 
 		acceptedTxs[0] = mempoolTx
 		copy(acceptedTxs[1:], newTxs)
 
-		return acceptedTxs, nil
+		return acceptedTxs, nil
+	}
+
+	// Reject the txn if it's an unconnected txn and we're set up to reject unconnectedTxns.
+	if !allowUnconnectedTxn {
+		glog.Tracef("BitCloutMempool.processTransaction: TxErrorUnconnectedTxnNotAllowed: %v %v",
+			tx.Hash(), tx.TxnMeta.GetTxnType())
+		return nil, TxErrorUnconnectedTxnNotAllowed
+	}
+
+	// Try to add the the transaction to the pool as an unconnected txn.
+	err = mp.tryAddUnconnectedTxn(tx, peerID)
+	if err != nil {
+		glog.Tracef("BitCloutMempool.processTransaction: Error adding transaction as unconnected txn: %v", err)
+	}
+	return nil, err
+}
+
+// ProcessTransaction is the main function called by outside services to potentially
+// add a transaction to the mempool. It will try to add the txn to the main pool, and
+// then try to add it as an unconnected txn if that fails.
+func (mp *BitCloutMempool) ProcessTransaction(tx *MsgBitCloutTxn, allowUnconnectedTxn bool, rateLimit bool, peerID uint64, verifySignatures bool) ([]*MempoolTx, error) {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	// Txns validated against a partial chain are pointless to keep around, so don't
+	// take any until the node has caught up. See SetIsSyncingFunc.
+	if mp.isSyncingFunc != nil && mp.isSyncingFunc() {
+		return nil, TxErrorStillSyncing
+	}
+
+	return mp.processTransaction(tx, allowUnconnectedTxn, rateLimit, peerID, verifySignatures)
+}
+
+// SetMinFeeRate updates the feerate below which txns are outright rejected. This lets
+// an operator tighten fee policy when the pool fills up without rebooting the node.
+// Txns already in the pool are unaffected. Acquires the write lock.
+func (mp *BitCloutMempool) SetMinFeeRate(minFeeRateNanosPerKB uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMinFeeRate: Updating minFeeRateNanosPerKB from %d to %d",
+		mp.minFeeRateNanosPerKB, minFeeRateNanosPerKB)
+	mp.minFeeRateNanosPerKB = minFeeRateNanosPerKB
+}
+
+// SetRelayFeeRate updates the feerate below which pool txns aren't relayed to peers.
+// See ShouldRelay. Acquires the write lock.
+func (mp *BitCloutMempool) SetRelayFeeRate(relayFeeRateNanosPerKB uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetRelayFeeRate: Updating relayFeeRateNanosPerKB from %d to %d",
+		mp.relayFeeRateNanosPerKB, relayFeeRateNanosPerKB)
+	mp.relayFeeRateNanosPerKB = relayFeeRateNanosPerKB
+}
+
+// SetReplacementFeeBump updates the feerate that a replacement for a pool txn has to
+// pay on top of the fees of the txns it would replace. See GetMinReplacementFee.
+// Acquires the write lock.
+func (mp *BitCloutMempool) SetReplacementFeeBump(replacementFeeBumpNanosPerKB uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetReplacementFeeBump: Updating replacementFeeBumpNanosPerKB from %d to %d",
+		mp.replacementFeeBumpNanosPerKB, replacementFeeBumpNanosPerKB)
+	mp.replacementFeeBumpNanosPerKB = replacementFeeBumpNanosPerKB
+}
+
+// GetMinReplacementFee returns the minimum total fee a txn replacing the pool txn with
+// the given hash has to pay. Replacing a txn also drops every pool txn that depends on
+// it, so the replacement has to cover the fees of the txn and all of its descendants,
+// plus the replacement fee bump applied to the txn's size. The replacement is assumed
+// to be about the same size as the txn it replaces. Returns an error if the txn isn't
+// in the pool. Acquires a read lock.
+func (mp *BitCloutMempool) GetMinReplacementFee(txHash *BlockHash) (uint64, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	replacedTxns := mp._getTransactionWithDescendants(txHash)
+	if len(replacedTxns) == 0 {
+		return 0, fmt.Errorf("GetMinReplacementFee: Txn %v is not in the pool", txHash)
+	}
+
+	replacedFees := uint64(0)
+	for _, mempoolTx := range replacedTxns {
+		replacedFees += mempoolTx.Fee
+	}
+	// Round the bump up so the replacement never pays less than the bump feerate.
+	txSizeBytes := replacedTxns[0].TxSizeBytes
+	feeBump := (mp.replacementFeeBumpNanosPerKB*txSizeBytes + 999) / 1000
+
+	return replacedFees + feeBump, nil
+}
+
+// ShouldRelay returns whether the pool txn pays enough to be relayed to peers. Txns
+// below the relay feerate can still sit in the pool and be mined, they just aren't
+// worth the bandwidth to pass along. Local txns are always relayed since they didn't
+// come from the network. Acquires a read lock.
+func (mp *BitCloutMempool) ShouldRelay(mempoolTx *MempoolTx) bool {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mempoolTx.Local || mempoolTx.FeePerKB >= mp.relayFeeRateNanosPerKB
+}
+
+// SetRateLimitFeeRate updates the feerate below which txns are subject to
+// rate-limiting. See the comment on rateLimitFeeRateNanosPerKB. Acquires the write
+// lock.
+func (mp *BitCloutMempool) SetRateLimitFeeRate(rateLimitFeeRateNanosPerKB uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetRateLimitFeeRate: Updating rateLimitFeeRateNanosPerKB from %d to %d",
+		mp.rateLimitFeeRateNanosPerKB, rateLimitFeeRateNanosPerKB)
+	mp.rateLimitFeeRateNanosPerKB = rateLimitFeeRateNanosPerKB
+}
+
+// SetMaxPendingTxnsPerPublicKey updates the maximum number of txns a single public
+// key can have in the pool as the transactor. Txns beyond the cap are rejected with
+// TxErrorTooManyPendingForPublicKey. Zero disables the cap. Txns already in the pool
+// are unaffected. Acquires the write lock.
+func (mp *BitCloutMempool) SetMaxPendingTxnsPerPublicKey(maxPendingTxnsPerPublicKey int) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMaxPendingTxnsPerPublicKey: Updating maxPendingTxnsPerPublicKey from %d to %d",
+		mp.maxPendingTxnsPerPublicKey, maxPendingTxnsPerPublicKey)
+	mp.maxPendingTxnsPerPublicKey = maxPendingTxnsPerPublicKey
+}
+
+// SetMaxTxnSizeBytes updates the maximum serialized size of a single txn in the pool.
+// Larger txns are rejected with TxErrorTooLarge. Zero disables the cap. Txns already
+// in the pool are unaffected. Acquires the write lock.
+func (mp *BitCloutMempool) SetMaxTxnSizeBytes(maxTxnSizeBytes uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMaxTxnSizeBytes: Updating maxTxnSizeBytes from %d to %d",
+		mp.maxTxnSizeBytes, maxTxnSizeBytes)
+	mp.maxTxnSizeBytes = maxTxnSizeBytes
+}
+
+// SetMaxInputsPerTxn updates the maximum number of inputs a single txn in the pool can
+// have. Txns with more are rejected with TxErrorTooManyInputs. Zero disables the cap.
+// Txns already in the pool are unaffected. Acquires the write lock.
+func (mp *BitCloutMempool) SetMaxInputsPerTxn(maxInputsPerTxn int) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMaxInputsPerTxn: Updating maxInputsPerTxn from %d to %d",
+		mp.maxInputsPerTxn, maxInputsPerTxn)
+	mp.maxInputsPerTxn = maxInputsPerTxn
+}
+
+// SetRequireTransactorUtxos turns on or off the pre-check that rejects a txn with
+// TxErrorTransactorHasNoUtxos, before it's connected, if none of its inputs are owned
+// by its transactor. This sheds spam from keys without any utxos cheaply. Txns already
+// in the pool are unaffected. Acquires the write lock.
+func (mp *BitCloutMempool) SetRequireTransactorUtxos(requireTransactorUtxos bool) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetRequireTransactorUtxos: Updating requireTransactorUtxos from %v to %v",
+		mp.requireTransactorUtxos, requireTransactorUtxos)
+	mp.requireTransactorUtxos = requireTransactorUtxos
+}
+
+// SetBulkIndexTxnsOnLoad turns on or off deferring the pool's indexes while
+// LoadTxnsFromDB restores a dump. When on, the indexes are built in a single pass once
+// every txn in the dump has been added, rather than growing them one txn at a time,
+// which speeds up restoring a large dump. It's on by default. It only affects later
+// calls to LoadTxnsFromDB. Use MempoolOptions.BulkIndexTxnsOnLoad to set it for the
+// load done by NewBitCloutMempool. Acquires the write lock.
+func (mp *BitCloutMempool) SetBulkIndexTxnsOnLoad(bulkIndexTxnsOnLoad bool) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetBulkIndexTxnsOnLoad: Updating bulkIndexTxnsOnLoad from %v to %v",
+		mp.bulkIndexTxnsOnLoad, bulkIndexTxnsOnLoad)
+	mp.bulkIndexTxnsOnLoad = bulkIndexTxnsOnLoad
+}
+
+// SetMaxCombinedTxSizeBytes caps the combined size of the txns in the pool and the
+// unconnectedTxns. Normally only the former is bounded by size, while unconnectedTxns
+// are only bounded by count. Once the cap is reached, new unconnectedTxns evict the
+// ones closest to expiring to make room, and are rejected with
+// TxErrorCombinedTxSizeLimitReached if the connected txns alone leave none. Connected
+// txns aren't subject to the cap. Zero disables it. Acquires the write lock.
+func (mp *BitCloutMempool) SetMaxCombinedTxSizeBytes(maxCombinedTxSizeBytes uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMaxCombinedTxSizeBytes: Updating maxCombinedTxSizeBytes from %d to %d",
+		mp.maxCombinedTxSizeBytes, maxCombinedTxSizeBytes)
+	mp.maxCombinedTxSizeBytes = maxCombinedTxSizeBytes
+}
+
+// SetTxnTypeLimits caps the number of txns of each type that can be in the pool, e.g.
+// to curb Like or Follow spam. Types without an entry are unrestricted. When a txn
+// arrives for a type that's at its limit, the lowest-fee txn of that type is evicted
+// to make room if the new txn pays a higher fee rate. Otherwise the new txn is
+// rejected with TxErrorTxnTypeLimitReached. Txns already in the pool are unaffected
+// until more txns of their type arrive. Acquires the write lock.
+func (mp *BitCloutMempool) SetTxnTypeLimits(txnTypeLimits map[TxnType]int) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetTxnTypeLimits: Updating txnTypeLimits from %v to %v",
+		mp.txnTypeLimits, txnTypeLimits)
+	mp.txnTypeLimits = make(map[TxnType]int, len(txnTypeLimits))
+	for txnType, typeLimit := range txnTypeLimits {
+		mp.txnTypeLimits[txnType] = typeLimit
+	}
+}
+
+// SetRecentlyConfirmedTxnsCacheSize sets how many recently-confirmed txn hashes the
+// pool remembers in order to reject re-relayed copies of mined txns. Zero disables
+// the check. Changing the size forgets the hashes remembered so far. Acquires the
+// write lock.
+func (mp *BitCloutMempool) SetRecentlyConfirmedTxnsCacheSize(cacheSize uint) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetRecentlyConfirmedTxnsCacheSize: Updating recentlyConfirmedTxnsCacheSize from %d to %d",
+		mp.recentlyConfirmedTxnsCacheSize, cacheSize)
+	mp.recentlyConfirmedTxnsCacheSize = cacheSize
+	mp.recentlyConfirmedTxns = nil
+}
+
+// _addRecentlyConfirmedTxn remembers that the txn with the given hash was just mined,
+// creating the cache if needed. Must be called with the write lock held.
+func (mp *BitCloutMempool) _addRecentlyConfirmedTxn(txHash *BlockHash) {
+	if mp.recentlyConfirmedTxnsCacheSize == 0 {
+		return
+	}
+	if mp.recentlyConfirmedTxns == nil {
+		recentlyConfirmedTxns := lru.NewCache(mp.recentlyConfirmedTxnsCacheSize)
+		mp.recentlyConfirmedTxns = &recentlyConfirmedTxns
+	}
+	mp.recentlyConfirmedTxns.Add(*txHash)
+}
+
+// _removeRecentlyConfirmedTxn forgets the txn with the given hash, e.g. because the
+// block it was mined in was disconnected. Must be called with the write lock held.
+func (mp *BitCloutMempool) _removeRecentlyConfirmedTxn(txHash *BlockHash) {
+	if mp.recentlyConfirmedTxns == nil {
+		return
+	}
+	mp.recentlyConfirmedTxns.Delete(*txHash)
+}
+
+// _isRecentlyConfirmedTxn returns true if the txn with the given hash was mined in one
+// of the last few blocks connected. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _isRecentlyConfirmedTxn(txHash *BlockHash) bool {
+	if mp.recentlyConfirmedTxns == nil {
+		return false
+	}
+	return mp.recentlyConfirmedTxns.Contains(*txHash)
+}
+
+// SetDroppedTxnReasonsCacheSize sets how many rejected or evicted txns the pool
+// remembers the reason for. Zero disables it. Changing the size forgets the reasons
+// remembered so far. Acquires the write lock.
+func (mp *BitCloutMempool) SetDroppedTxnReasonsCacheSize(cacheSize uint) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetDroppedTxnReasonsCacheSize: Updating droppedTxnReasonsCacheSize from %d to %d",
+		mp.droppedTxnReasonsCacheSize, cacheSize)
+	mp.droppedTxnReasonsCacheSize = cacheSize
+	mp.droppedTxnReasons = nil
+	mp.droppedTxnReasonsList = nil
+}
+
+// _recordDroppedTxnReason remembers that the txn with the given hash was just rejected
+// or evicted for the given reason, replacing any earlier reason for it and forgetting
+// the least recently recorded reason if the cache is full. Must be called with the
+// write lock held.
+func (mp *BitCloutMempool) _recordDroppedTxnReason(txHash *BlockHash, reason string) {
+	if mp.droppedTxnReasonsCacheSize == 0 {
+		return
+	}
+	if mp.droppedTxnReasons == nil {
+		mp.droppedTxnReasons = make(map[BlockHash]*list.Element)
+		mp.droppedTxnReasonsList = list.New()
+	}
+
+	mp._forgetDroppedTxnReason(txHash)
+	mp.droppedTxnReasons[*txHash] = mp.droppedTxnReasonsList.PushFront(&droppedTxnReason{
+		txHash: *txHash,
+		reason: reason,
+		when:   mp.nowFunc(),
+	})
+	for uint(mp.droppedTxnReasonsList.Len()) > mp.droppedTxnReasonsCacheSize {
+		oldest := mp.droppedTxnReasonsList.Remove(mp.droppedTxnReasonsList.Back()).(*droppedTxnReason)
+		delete(mp.droppedTxnReasons, oldest.txHash)
+	}
+}
+
+// _recordEvictedTxnReasons records the reason each of the given txns was evicted. Must
+// be called with the write lock held.
+func (mp *BitCloutMempool) _recordEvictedTxnReasons(evictedTxns []*evictedTxn) {
+	for _, evicted := range evictedTxns {
+		mp._recordDroppedTxnReason(evicted.mempoolTx.Hash, "evicted: "+evicted.reason)
+	}
+}
+
+// _forgetDroppedTxnReason forgets why the txn with the given hash was dropped, e.g.
+// because it has since been accepted. Must be called with the write lock held.
+func (mp *BitCloutMempool) _forgetDroppedTxnReason(txHash *BlockHash) {
+	if mp.droppedTxnReasons == nil {
+		return
+	}
+	if element, exists := mp.droppedTxnReasons[*txHash]; exists {
+		mp.droppedTxnReasonsList.Remove(element)
+		delete(mp.droppedTxnReasons, *txHash)
+	}
+}
+
+// GetLastRejectionReason returns why the txn with the given hash was most recently
+// rejected or evicted by the pool, and when. Eviction reasons are prefixed with
+// "evicted: ". ok is false if the pool doesn't remember the txn being dropped, either
+// because it wasn't or because the reason has been pushed out of the cache by more
+// recent ones. Acquires a read lock.
+func (mp *BitCloutMempool) GetLastRejectionReason(txHash *BlockHash) (
+	_reason string, _when time.Time, _ok bool) {
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	if mp.droppedTxnReasons == nil {
+		return "", time.Time{}, false
+	}
+	element, exists := mp.droppedTxnReasons[*txHash]
+	if !exists {
+		return "", time.Time{}, false
+	}
+	dropped := element.Value.(*droppedTxnReason)
+	return dropped.reason, dropped.when, true
+}
+
+// SetTrustedPeerIDs replaces the set of peers whose txns skip signature verification.
+// This saves CPU on txns from a relay we control, which has already verified them.
+//
+// Only trust peers that are fully under your control. A trusted peer can get txns
+// with invalid or missing signatures into the pool, and from there they can spend
+// anyone's outputs in the pool's view, get relayed to other peers, and end up in
+// block templates that the rest of the network will reject. Peer IDs are assigned
+// per connection so the set needs to be updated whenever a trusted peer reconnects.
+// Acquires the write lock.
+func (mp *BitCloutMempool) SetTrustedPeerIDs(peerIDs []uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetTrustedPeerIDs: Updating trustedPeerIDs from %v to %v",
+		mp.trustedPeerIDs, peerIDs)
+	mp.trustedPeerIDs = make(map[uint64]bool, len(peerIDs))
+	for _, peerID := range peerIDs {
+		mp.trustedPeerIDs[peerID] = true
+	}
+}
+
+// SetEvictionPolicy sets how txns are picked for eviction when a txn type is at one of
+// the limits set with SetTxnTypeLimits or SetTxnTypeByteLimits. The default is
+// EvictionPolicyFeeBased. Acquires the write lock.
+func (mp *BitCloutMempool) SetEvictionPolicy(evictionPolicy EvictionPolicy) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetEvictionPolicy: Updating evictionPolicy from %v to %v",
+		mp.evictionPolicy, evictionPolicy)
+	mp.evictionPolicy = evictionPolicy
+}
+
+// SetComputeMetadataOnAccept sets whether the TransactionMetadata for each accepted
+// txn is computed up front. It's on by default. When it's off, MempoolTx.TxMeta is
+// left nil and GetTransactionMetadata computes it on demand instead, which suits
+// relay nodes that don't serve txindex data. Acquires the write lock.
+func (mp *BitCloutMempool) SetComputeMetadataOnAccept(computeMetadataOnAccept bool) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetComputeMetadataOnAccept: Updating computeMetadataOnAccept from %v to %v",
+		mp.computeMetadataOnAccept, computeMetadataOnAccept)
+	mp.computeMetadataOnAccept = computeMetadataOnAccept
+}
+
+// SetIsSyncingFunc sets the callback ProcessTransaction consults to find out whether
+// the node is still syncing, e.g. Blockchain.isSyncing. While it returns true, new
+// txns are rejected with TxErrorStillSyncing. Passing nil turns the check off, which
+// single-node test setups that never finish syncing need. The callback is run with
+// the pool's write lock held. Acquires the write lock.
+func (mp *BitCloutMempool) SetIsSyncingFunc(isSyncingFunc func() bool) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetIsSyncingFunc: Updating whether txns are rejected while syncing from %v to %v",
+		mp.isSyncingFunc != nil, isSyncingFunc != nil)
+	mp.isSyncingFunc = isSyncingFunc
+}
+
+// SetTxnTypeByteLimits caps the total size in bytes of the txns of each type in the
+// pool, e.g. so SubmitPost txns can't take up most of it. Types without an entry are
+// unrestricted. It works like SetTxnTypeLimits: a txn that would put its type over
+// its limit evicts the lowest-fee txns of that type to make room as long as they pay
+// a lower fee rate than it. Otherwise it's rejected with
+// TxErrorTxnTypeByteLimitReached. Acquires the write lock.
+func (mp *BitCloutMempool) SetTxnTypeByteLimits(txnTypeByteLimits map[TxnType]uint64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetTxnTypeByteLimits: Updating txnTypeByteLimits from %v to %v",
+		mp.txnTypeByteLimits, txnTypeByteLimits)
+	mp.txnTypeByteLimits = make(map[TxnType]uint64, len(txnTypeByteLimits))
+	for txnType, byteLimit := range txnTypeByteLimits {
+		mp.txnTypeByteLimits[txnType] = byteLimit
+	}
+}
+
+// SetNumRetainedDumpGenerations sets how many complete dumps DumpTxnsToDB keeps. When
+// the newest dump can't be read on startup, LoadTxnsFromDB falls back to the older
+// ones newest-first. Values below one are treated as one. Waits for any dump in
+// progress to finish.
+func (mp *BitCloutMempool) SetNumRetainedDumpGenerations(numGenerations int) {
+	mp.dumpMtx.Lock()
+	defer mp.dumpMtx.Unlock()
+
+	numGenerations = _clampNumRetainedDumpGenerations(numGenerations)
+	glog.Infof("SetNumRetainedDumpGenerations: Updating numRetainedDumpGenerations from %d to %d",
+		mp.numRetainedDumpGenerations, numGenerations)
+	mp.numRetainedDumpGenerations = numGenerations
+}
+
+// SetMaxTxnAge sets how long a connected txn can sit in the pool before the expired
+// txn sweeper evicts it. Zero means txns never expire. The sweeper is started the
+// first time a non-zero age is set. Acquires the write lock.
+func (mp *BitCloutMempool) SetMaxTxnAge(maxTxnAge time.Duration) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetMaxTxnAge: Updating maxTxnAge from %v to %v", mp.maxTxnAge, maxTxnAge)
+	mp.maxTxnAge = maxTxnAge
+	if mp.maxTxnAge != 0 && !mp.expiredTxnSweeperStarted {
+		mp.expiredTxnSweeperStarted = true
+		mp.StartExpiredTxnSweeper()
+	}
+}
+
+// SetLightweightMode sets whether the pool keeps a standing backupUniversalUtxoView.
+// See the comment on lightweightMode. The backup view is dropped when it's turned on
+// and rebuilt from the universalUtxoView when it's turned off. Does nothing if the
+// mode isn't changing. Acquires the write lock.
+func (mp *BitCloutMempool) SetLightweightMode(lightweightMode bool) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	if lightweightMode == mp.lightweightMode {
+		return
+	}
+	glog.Infof("SetLightweightMode: Updating lightweightMode from %v to %v",
+		mp.lightweightMode, lightweightMode)
+	mp.lightweightMode = lightweightMode
+	mp.rebuildBackupView()
+}
+
+// SetBitcoinExchangeDustThreshold sets the output size in satoshis below which
+// BitcoinExchange txns are rejected as dust. Zero disables the check. Defaults to
+// DefaultBitcoinExchangeDustThresholdSatoshis. Acquires the write lock.
+func (mp *BitCloutMempool) SetBitcoinExchangeDustThreshold(dustThresholdSatoshis int64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	glog.Infof("SetBitcoinExchangeDustThreshold: Updating bitcoinExchangeDustThresholdSatoshis from %d to %d",
+		mp.bitcoinExchangeDustThresholdSatoshis, dustThresholdSatoshis)
+	mp.bitcoinExchangeDustThresholdSatoshis = dustThresholdSatoshis
+}
+
+// SetDumpInterval sets how often StartMempoolDBDumper dumps txns to the mempoolDir.
+// Zero resets it to DefaultMempoolDBDumpInterval. The new interval takes effect after
+// the dump that's currently scheduled. Acquires the write lock.
+func (mp *BitCloutMempool) SetDumpInterval(dumpInterval time.Duration) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	dumpInterval = _dumpIntervalOrDefault(dumpInterval)
+	glog.Infof("SetDumpInterval: Updating dumpInterval from %v to %v", mp.dumpInterval, dumpInterval)
+	mp.dumpInterval = dumpInterval
+}
+
+// SetReadOnlyViewRegenerationInterval sets how often the readOnly view is regenerated
+// when it hasn't been regenerated because of the number of txns processed. Zero resets
+// it to ReadOnlyUtxoViewRegenerationIntervalSeconds. The new interval takes effect
+// after the regeneration that's currently scheduled. Acquires the write lock.
+func (mp *BitCloutMempool) SetReadOnlyViewRegenerationInterval(regenerationInterval time.Duration) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	regenerationInterval = _readOnlyViewRegenerationIntervalOrDefault(regenerationInterval)
+	glog.Infof("SetReadOnlyViewRegenerationInterval: Updating readOnlyViewRegenerationInterval from %v to %v",
+		mp.readOnlyViewRegenerationInterval, regenerationInterval)
+	mp.readOnlyViewRegenerationInterval = regenerationInterval
+}
+
+// SetRejectTxnsWhileSyncing sets whether ProcessTransaction rejects txns with
+// TxErrorStillSyncing while the pool's Blockchain is still syncing. It's a shorthand
+// for SetIsSyncingFunc with the Blockchain's own check. Acquires the write lock.
+func (mp *BitCloutMempool) SetRejectTxnsWhileSyncing(rejectTxnsWhileSyncing bool) {
+	if !rejectTxnsWhileSyncing {
+		mp.SetIsSyncingFunc(nil)
+		return
+	}
+	mp.SetIsSyncingFunc(mp._isBlockchainSyncing)
+}
+
+// _isBlockchainSyncing is the isSyncingFunc used to reject txns while the pool's
+// Blockchain is syncing. It's run with the write lock held so reading mp.bc here is
+// safe even if SetBlockchain swaps it out.
+func (mp *BitCloutMempool) _isBlockchainSyncing() bool {
+	return mp.bc.isSyncing()
+}
+
+// _dumpIntervalOrDefault returns the given dump interval, or
+// DefaultMempoolDBDumpInterval if it's zero.
+func _dumpIntervalOrDefault(dumpInterval time.Duration) time.Duration {
+	if dumpInterval == 0 {
+		return DefaultMempoolDBDumpInterval
+	}
+	return dumpInterval
+}
+
+// _readOnlyViewRegenerationIntervalOrDefault returns the given regeneration interval,
+// or ReadOnlyUtxoViewRegenerationIntervalSeconds if it's zero.
+func _readOnlyViewRegenerationIntervalOrDefault(regenerationInterval time.Duration) time.Duration {
+	if regenerationInterval == 0 {
+		return time.Duration(ReadOnlyUtxoViewRegenerationIntervalSeconds) * time.Second
 	}
+	return regenerationInterval
+}
 
-	// Reject the txn if it's an unconnected txn and we're set up to reject unconnectedTxns.
-	if !allowUnconnectedTxn {
-		glog.Tracef("BitCloutMempool.processTransaction: TxErrorUnconnectedTxnNotAllowed: %v %v",
-			tx.Hash(), tx.TxnMeta.GetTxnType())
-		return nil, TxErrorUnconnectedTxnNotAllowed
+// _clampNumRetainedDumpGenerations returns the given number of dump generations to
+// keep, clamped to the range the dump slots support.
+func _clampNumRetainedDumpGenerations(numGenerations int) int {
+	// Slots are a single byte and a new dump needs a free one.
+	if numGenerations < 1 {
+		return 1
+	} else if numGenerations > 255 {
+		return 255
 	}
+	return numGenerations
+}
 
-	// Try to add the the transaction to the pool as an unconnected txn.
-	err = mp.tryAddUnconnectedTxn(tx, peerID)
+// GetMempoolAsJSON returns the txns in the readOnly view as a JSON array, in the
+// order they were added. See MempoolTx.MarshalJSON for the format of each txn.
+// Safe for concurrent access.
+func (mp *BitCloutMempool) GetMempoolAsJSON() ([]byte, error) {
+	mempoolJSON, err := json.Marshal(mp.readOnlyUniversalTransactionList)
 	if err != nil {
-		glog.Tracef("BitCloutMempool.processTransaction: Error adding transaction as unconnected txn: %v", err)
+		return nil, errors.Wrapf(err, "GetMempoolAsJSON: ")
 	}
-	return nil, err
+	return mempoolJSON, nil
 }
 
-// ProcessTransaction is the main function called by outside services to potentially
-// add a transaction to the mempool. It will try to add the txn to the main pool, and
-// then try to add it as an unconnected txn if that fails.
-func (mp *BitCloutMempool) ProcessTransaction(tx *MsgBitCloutTxn, allowUnconnectedTxn bool, rateLimit bool, peerID uint64, verifySignatures bool) ([]*MempoolTx, error) {
-	// Protect concurrent access.
+// _incrementTotalTxSizeBytes adds numBytes to totalTxSizeBytes, saturating at
+// math.MaxUint64 rather than wrapping around. Must be called with the write lock held.
+func (mp *BitCloutMempool) _incrementTotalTxSizeBytes(numBytes uint64) {
+	if numBytes > math.MaxUint64-mp.totalTxSizeBytes {
+		glog.Errorf("_incrementTotalTxSizeBytes: Adding %d bytes to totalTxSizeBytes %d "+
+			"would overflow; saturating. This should never happen",
+			numBytes, mp.totalTxSizeBytes)
+		mp.totalTxSizeBytes = math.MaxUint64
+		return
+	}
+	mp.totalTxSizeBytes += numBytes
+}
+
+// _decrementTotalTxSizeBytes subtracts numBytes from totalTxSizeBytes, stopping at
+// zero rather than wrapping around. An underflow here means the accounting has
+// drifted, and wrapping would leave the pool looking permanently full. Must be called
+// with the write lock held.
+func (mp *BitCloutMempool) _decrementTotalTxSizeBytes(numBytes uint64) {
+	if numBytes > mp.totalTxSizeBytes {
+		glog.Errorf("_decrementTotalTxSizeBytes: Removing %d bytes from totalTxSizeBytes "+
+			"%d would underflow; clamping to zero. This should never happen",
+			numBytes, mp.totalTxSizeBytes)
+		mp.totalTxSizeBytes = 0
+		return
+	}
+	mp.totalTxSizeBytes -= numBytes
+}
+
+// _decrementTotalFeeNanos subtracts feeNanos from totalFeeNanos, stopping at zero
+// rather than wrapping around, for the same reason as _decrementTotalTxSizeBytes.
+// Must be called with the write lock held.
+func (mp *BitCloutMempool) _decrementTotalFeeNanos(feeNanos uint64) {
+	if feeNanos > mp.totalFeeNanos {
+		glog.Errorf("_decrementTotalFeeNanos: Removing %d nanos from totalFeeNanos "+
+			"%d would underflow; clamping to zero. This should never happen",
+			feeNanos, mp.totalFeeNanos)
+		mp.totalFeeNanos = 0
+		return
+	}
+	mp.totalFeeNanos -= feeNanos
+}
+
+// RecomputeTotalTxSizeBytes recomputes totalTxSizeBytes from the txns in poolMap and
+// corrects it if it has drifted. It returns the old and new values, which are equal
+// if there was no drift. Acquires the write lock.
+func (mp *BitCloutMempool) RecomputeTotalTxSizeBytes() (_oldTotalTxSizeBytes uint64, _newTotalTxSizeBytes uint64) {
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
-	return mp.processTransaction(tx, allowUnconnectedTxn, rateLimit, peerID, verifySignatures)
+	oldTotalTxSizeBytes := mp.totalTxSizeBytes
+	mp.totalTxSizeBytes = 0
+	for _, mempoolTx := range mp.poolMap {
+		mp._incrementTotalTxSizeBytes(mempoolTx.TxSizeBytes)
+	}
+	if mp.totalTxSizeBytes != oldTotalTxSizeBytes {
+		glog.Errorf("RecomputeTotalTxSizeBytes: Corrected totalTxSizeBytes from %d to %d",
+			oldTotalTxSizeBytes, mp.totalTxSizeBytes)
+	}
+
+	return oldTotalTxSizeBytes, mp.totalTxSizeBytes
+}
+
+// GetTotalPendingFees returns the sum of the fees of all of the txns in the pool.
+// Unlike Count, this isn't served from the readOnly view so it's always up to date.
+// Acquires a read lock.
+func (mp *BitCloutMempool) GetTotalPendingFees() uint64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.totalFeeNanos
+}
+
+// GetLowFeeAccumulatorState returns the lowFeeTxSizeAccumulator decayed to the current
+// time, the limit it's checked against, and the lastLowFeeTxUnixTime it was last
+// updated at. Low-fee txns are rejected with TxErrorInsufficientFeeRateLimit while
+// currentBytes is at or above limitBytes. Doesn't modify the accumulator. Acquires a
+// read lock.
+func (mp *BitCloutMempool) GetLowFeeAccumulatorState() (
+	_currentBytes float64, _limitBytes float64, _lastUpdateUnix int64) {
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp._getDecayedLowFeeTxSizeAccumulator(), float64(LowFeeTxLimitBytesPerTenMinutes),
+		mp.lastLowFeeTxUnixTime
+}
+
+// _getDecayedLowFeeTxSizeAccumulator returns the lowFeeTxSizeAccumulator decayed to
+// the current time the same way tryAcceptTransaction decays it. Must be called with
+// at least the read lock held.
+func (mp *BitCloutMempool) _getDecayedLowFeeTxSizeAccumulator() float64 {
+	nowUnix := mp.nowFunc().Unix()
+	return mp.lowFeeTxSizeAccumulator / math.Pow(2.0,
+		float64(nowUnix-mp.lastLowFeeTxUnixTime)/(10*60))
+}
+
+// GetAllStats returns the pool's gauges as a flat map so that a metrics exporter can
+// range over them without knowing what they are. The keys are stable. Per-type counts
+// and bytes are keyed by the lowercased TxnType, e.g. "type_count_basic_transfer",
+// and, like GetMempoolSummaryStats, come from the readOnly view. Everything else is
+// up to date. Acquires a read lock.
+func (mp *BitCloutMempool) GetAllStats() map[string]float64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	allStats := map[string]float64{
+		"pool_count":                float64(len(mp.poolMap)),
+		"pool_bytes":                float64(mp.totalTxSizeBytes),
+		"unconnected_count":         float64(len(mp.unconnectedTxns)),
+		"total_fee_nanos":           float64(mp.totalFeeNanos),
+		"low_fee_accumulator_bytes": mp._getDecayedLowFeeTxSizeAccumulator(),
+		"low_fee_limit_bytes":       float64(LowFeeTxLimitBytesPerTenMinutes),
+		"read_only_sequence_number": float64(atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)),
+		"read_only_view_lag":        float64(mp._getReadOnlyViewLag()),
+	}
+	for txnType, summaryStats := range mp.GetMempoolSummaryStats() {
+		typeKey := strings.ToLower(txnType)
+		allStats["type_count_"+typeKey] = float64(summaryStats.Count)
+		allStats["type_bytes_"+typeKey] = float64(summaryStats.TotalBytes)
+	}
+
+	return allStats
 }
 
 // Returns an estimate of the number of txns in the mempool. This is an estimate because
@@ -1981,9 +5604,230 @@ func (mp *BitCloutMempool) MempoolTxs() []*MempoolTx {
 	return descs
 }
 
+// GetOldestTransaction returns the txn with the earliest Added time in the pool, or
+// nil if the pool is empty. Acquires a read lock for the duration of a linear scan of
+// the universalTransactionList.
+func (mp *BitCloutMempool) GetOldestTransaction() *MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	// The universalTransactionList is *mostly* in Added order, but pool rebuilds
+	// don't strictly preserve it. E.g. UpdateAfterDisconnectBlock re-adds the
+	// block's txns, with a fresh Added time, ahead of the txns that were already in
+	// the pool. See GetTransactionsAddedAfter. So the front of the list isn't
+	// necessarily the oldest txn and we have to scan the whole thing.
+	var oldestTx *MempoolTx
+	for _, mempoolTx := range mp.universalTransactionList {
+		if oldestTx == nil || mempoolTx.Added.Before(oldestTx.Added) {
+			oldestTx = mempoolTx
+		}
+	}
+	return oldestTx
+}
+
+// GetHighestFeeTransaction returns the txn with the highest FeePerKB in the pool, or
+// nil if the pool is empty. Acquires a read lock for the duration of a linear scan of
+// the txFeeMinheap, which is cheaper than materializing and sorting the pool.
+func (mp *BitCloutMempool) GetHighestFeeTransaction() *MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	// The heap only orders txns from lowest to highest fee so the max could be
+	// anywhere among the leaves. Just scan the whole thing.
+	var highestFeeTx *MempoolTx
+	for _, mempoolTx := range mp.txFeeMinheap {
+		if highestFeeTx == nil || mempoolTx.FeePerKB > highestFeeTx.FeePerKB {
+			highestFeeTx = mempoolTx
+		}
+	}
+	return highestFeeTx
+}
+
+// GetEvictionOrder returns up to limit txns from the txFeeMinheap in the order it would
+// pop them, i.e. lowest FeePerKB first, which is the order fee-based eviction takes
+// them in. Among txns with the same FeePerKB the most recently added one comes first.
+// A limit of zero or less returns every txn. The heap itself isn't touched since
+// popping it would reorder the pool's txns. Acquires a read lock.
+func (mp *BitCloutMempool) GetEvictionOrder(limit int) []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	evictionOrder := make(MempoolTxFeeMinHeap, len(mp.txFeeMinheap))
+	copy(evictionOrder, mp.txFeeMinheap)
+	// Sorting with the heap's own Less keeps this in sync with the order it pops in.
+	sort.Slice(evictionOrder, evictionOrder.Less)
+	if limit > 0 && limit < len(evictionOrder) {
+		evictionOrder = evictionOrder[:limit]
+	}
+	return evictionOrder
+}
+
 func (mp *BitCloutMempool) GetMempoolSummaryStats() (_summaryStatsMap map[string]*SummaryStats) {
-	allTxns := mp.readOnlyUniversalTransactionList
+	return _computeSummaryStats(mp.readOnlyUniversalTransactionList)
+}
+
+// GetTransactionCountByType returns the number of txns of the given type in the pool.
+// It's served from the txnTypeToTxnMap index, so unlike GetMempoolSummaryStats it
+// doesn't scan the pool, and it's always up to date rather than reflecting the last
+// regeneration of the readOnly view. Acquires a read lock.
+func (mp *BitCloutMempool) GetTransactionCountByType(txnType TxnType) uint32 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return uint32(len(mp.txnTypeToTxnMap[txnType]))
+}
+
+// GetMempoolSnapshot returns the txns, summary stats, and sequence number from the
+// most recent regeneration of the readOnly view. Unlike calling Count, MempoolTxs,
+// and GetMempoolSummaryStats separately, everything in the snapshot is guaranteed to
+// come from the same regeneration. Safe for concurrent access.
+func (mp *BitCloutMempool) GetMempoolSnapshot() *MempoolSnapshot {
+	return mp.readOnlySnapshot
+}
+
+// GetTransactionsOrderedByFeeRate returns the txns in the readOnly view sorted by
+// FeePerKB from highest to lowest, with ties broken by the time they were added. The
+// sorted slice is cached until the next regeneration of the readOnly view, so calling
+// this repeatedly is cheap. Callers must not modify the returned slice. Safe for
+// concurrent access.
+func (mp *BitCloutMempool) GetTransactionsOrderedByFeeRate() []*MempoolTx {
+	snapshot := mp.readOnlySnapshot
+
+	mp.feeRateSortedTxnsMtx.Lock()
+	defer mp.feeRateSortedTxnsMtx.Unlock()
+
+	if mp.feeRateSortedTxns != nil && mp.feeRateSortedTxnsSequenceNumber == snapshot.SequenceNumber {
+		return mp.feeRateSortedTxns
+	}
+
+	sortedTxns := make([]*MempoolTx, len(snapshot.Txns))
+	copy(sortedTxns, snapshot.Txns)
+	sort.Slice(sortedTxns, func(ii, jj int) bool {
+		if sortedTxns[ii].FeePerKB != sortedTxns[jj].FeePerKB {
+			return sortedTxns[ii].FeePerKB > sortedTxns[jj].FeePerKB
+		}
+		return sortedTxns[ii].Added.Before(sortedTxns[jj].Added)
+	})
+
+	mp.feeRateSortedTxns = sortedTxns
+	mp.feeRateSortedTxnsSequenceNumber = snapshot.SequenceNumber
+
+	return sortedTxns
+}
+
+// GetMedianFeeRate returns the median FeePerKB of the txns in the readOnly view as a
+// suggestion for a "normal" fee. It returns minFeeRateNanosPerKB when the view holds
+// fewer than MedianFeeRateMinTxns txns. It's computed from the sorted txns cached by
+// GetTransactionsOrderedByFeeRate so repeated calls between regenerations of the
+// readOnly view don't re-sort anything. Safe for concurrent access.
+func (mp *BitCloutMempool) GetMedianFeeRate() uint64 {
+	sortedTxns := mp.GetTransactionsOrderedByFeeRate()
+	if len(sortedTxns) == 0 || len(sortedTxns) < MedianFeeRateMinTxns {
+		mp.mtx.RLock()
+		defer mp.mtx.RUnlock()
+
+		return mp.minFeeRateNanosPerKB
+	}
+
+	// With an even number of txns, average the two in the middle.
+	midIndex := len(sortedTxns) / 2
+	if len(sortedTxns)%2 == 0 {
+		return (sortedTxns[midIndex-1].FeePerKB + sortedTxns[midIndex].FeePerKB) / 2
+	}
+	return sortedTxns[midIndex].FeePerKB
+}
+
+// _updateAvgBlockFillRate folds the block's size into avgBlockFillRate if the pool
+// held at least a full block's worth of txns when it arrived. Must be called with the
+// write lock held, before the block's txns are removed from the pool.
+func (mp *BitCloutMempool) _updateAvgBlockFillRate(blk *MsgBitCloutBlock) {
+	maxBlockSizeBytes := mp.bc.params.MinerMaxBlockSizeBytes
+	if maxBlockSizeBytes == 0 || mp.totalTxSizeBytes < maxBlockSizeBytes {
+		return
+	}
+
+	blockTxnBytes := uint64(0)
+	for _, txn := range blk.Txns[1:] {
+		txnBytes, err := txn.ToBytes(false)
+		if err != nil {
+			glog.Errorf("_updateAvgBlockFillRate: Problem serializing txn %v: %v", txn.Hash(), err)
+			return
+		}
+		blockTxnBytes += uint64(len(txnBytes))
+	}
+	blockFillRate := math.Min(float64(blockTxnBytes)/float64(maxBlockSizeBytes), 1)
+	mp.avgBlockFillRate = (1-BlockFillRateAverageWeight)*mp.avgBlockFillRate +
+		BlockFillRateAverageWeight*blockFillRate
+}
+
+// EstimateInclusionWithinBlocks estimates the probability that a txn paying feePerKB
+// is mined within the next n blocks. It's a heuristic: the txns with at least the
+// same FeePerKB are assumed to be mined first, and blocks are assumed to hold
+// avgBlockFillRate of MinerMaxBlockSizeBytes worth of them. The estimate is 1 when
+// nothing is ahead, 0.5 when exactly n blocks' worth is ahead, and falls off
+// quickly from there. It never decreases as feePerKB increases.
+//
+// This uses the fee histogram of the readOnly view so a txn that was just added may
+// not be counted yet. Safe for concurrent access.
+func (mp *BitCloutMempool) EstimateInclusionWithinBlocks(feePerKB uint64, n int) float64 {
+	if n <= 0 {
+		return 0
+	}
+
+	mp.mtx.RLock()
+	blockCapacityBytes := mp.avgBlockFillRate * float64(mp.bc.params.MinerMaxBlockSizeBytes)
+	mp.mtx.RUnlock()
+
+	bytesAhead := mp.GetFeeHistogram([]uint64{feePerKB})[feePerKB].TotalBytes
+	if bytesAhead == 0 {
+		return 1
+	}
+	if blockCapacityBytes <= 0 {
+		return 0
+	}
+
+	fillRatio := float64(bytesAhead) / (blockCapacityBytes * float64(n))
+	return 1 / (1 + math.Pow(fillRatio, 4))
+}
+
+// GetFeeHistogram buckets the txns in the readOnly view by FeePerKB. The buckets
+// passed in are the lower bounds of each bucket, and a txn is counted in the bucket
+// with the largest lower bound that doesn't exceed its FeePerKB. Txns whose FeePerKB
+// is below every lower bound aren't counted, so include a zero bucket to capture
+// everything. Every lower bound passed in has an entry in the result, even if no txns
+// fall into it. Safe for concurrent access.
+func (mp *BitCloutMempool) GetFeeHistogram(buckets []uint64) map[uint64]SummaryStats {
+	// Sort a copy so we can binary search it without touching the caller's slice.
+	sortedBuckets := make([]uint64, len(buckets))
+	copy(sortedBuckets, buckets)
+	sort.Slice(sortedBuckets, func(ii, jj int) bool {
+		return sortedBuckets[ii] < sortedBuckets[jj]
+	})
+
+	feeHistogram := make(map[uint64]SummaryStats)
+	for _, lowerBound := range sortedBuckets {
+		feeHistogram[lowerBound] = SummaryStats{}
+	}
+	for _, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		// Find the first bucket that's above the txn's feerate. The one before it is
+		// the bucket the txn belongs in.
+		bucketIndex := sort.Search(len(sortedBuckets), func(ii int) bool {
+			return sortedBuckets[ii] > mempoolTx.FeePerKB
+		}) - 1
+		if bucketIndex < 0 {
+			continue
+		}
 
+		summaryStats := feeHistogram[sortedBuckets[bucketIndex]]
+		summaryStats.Count++
+		summaryStats.TotalBytes += mempoolTx.TxSizeBytes
+		feeHistogram[sortedBuckets[bucketIndex]] = summaryStats
+	}
+
+	return feeHistogram
+}
+
+func _computeSummaryStats(allTxns []*MempoolTx) map[string]*SummaryStats {
 	transactionSummaryStats := make(map[string]*SummaryStats)
 	for _, mempoolTx := range allTxns {
 		// Update the mempool summary stats.
@@ -2006,30 +5850,74 @@ func (mp *BitCloutMempool) GetMempoolSummaryStats() (_summaryStatsMap map[string
 	return transactionSummaryStats
 }
 
-func (mp *BitCloutMempool) inefficientRemoveTransaction(tx *MsgBitCloutTxn) {
+// inefficientRemoveTransaction removes the txn from the pool along with any txns that
+// depend on it, and returns the txns that were evicted. The write lock must be held
+// when calling this function.
+func (mp *BitCloutMempool) inefficientRemoveTransaction(tx *MsgBitCloutTxn) []*evictedTxn {
 	// In this case we remove the transaction by re-adding all the txns we can
 	// to the mempool except this one.
 	// TODO(performance): This could be a bit slow.
-	//
-	// Create a new BitCloutMempool. No need to set the min fees since we're just using
-	// this as a temporary data structure for validation.
-	//
-	// Don't make the new pool object deal with the BlockCypher API.
-	newPool := NewBitCloutMempool(mp.bc, 0, /* rateLimitFeeRateNanosPerKB */
-		0, /* minFeeRateNanosPerKB */
-		"" /*blockCypherAPIKey*/, false,
-		"" /*dataDir*/, "")
-	// At this point the block txns have been added to the new pool. Now we need to
-	// add the txns from the original pool. Start by fetching them in slice form.
-	oldMempoolTxns, oldUnconnectedTxns, err := mp._getTransactionsOrderedByTimeAdded()
+	txHash := tx.Hash()
+	return mp._rebuildPoolWithout(func(mempoolTx *MempoolTx) string {
+		if *mempoolTx.Hash == *txHash {
+			return EvictReasonRemoved
+		}
+		return ""
+	}, EvictReasonDependencyEvicted)
+}
+
+// _newRebuildPool creates the empty pool that a rebuild re-adds the txns to before
+// swapping it in with resetPool. The per-pool settings that affect how txns are
+// accepted are copied over so the rebuilt pool treats them the same way. No need to
+// set the min fees since it's just a temporary data structure for validation, and it
+// doesn't deal with the BlockCypher API.
+func (mp *BitCloutMempool) _newRebuildPool() (*BitCloutMempool, error) {
+	newPool, err := NewBitCloutMempool(mp.bc, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", /*blockCypherAPIKey*/
+		false /*runReadOnlyViewUpdater*/, "" /*dataDir*/, "" /*mempoolDir*/, nil /*opts*/)
 	if err != nil {
-		glog.Warning(errors.Wrapf(err, "inefficientRemoveTransaction: "))
+		return nil, errors.Wrapf(err, "_newRebuildPool: Problem creating temporary pool: ")
+	}
+	// Share our clock with the new pool so that the txns it adds are timestamped
+	// consistently with ours.
+	newPool.nowFunc = mp.nowFunc
+	newPool.computeMetadataOnAccept = mp.computeMetadataOnAccept
+	newPool.bitcoinExchangeDustThresholdSatoshis = mp.bitcoinExchangeDustThresholdSatoshis
+
+	return newPool, nil
+}
+
+// rebuildPool re-adds all of the pool's txns to a fresh pool built on top of mp.bc and
+// swaps it in, dropping any txns that no longer connect. Returns the dropped txns with
+// the given reason. The write lock must be held when calling this function.
+func (mp *BitCloutMempool) rebuildPool(reason string) []*evictedTxn {
+	return mp._rebuildPoolWithout(nil, reason)
+}
+
+// _rebuildPoolWithout is like rebuildPool but leaves out every txn for which
+// skipReason returns a non-empty reason, which it's evicted with. Txns that no longer
+// connect without them are evicted with dropReason. skipReason may be nil. The write
+// lock must be held when calling this function.
+func (mp *BitCloutMempool) _rebuildPoolWithout(skipReason func(mempoolTx *MempoolTx) string,
+	dropReason string) []*evictedTxn {
+
+	newPool, err := mp._newRebuildPool()
+	if err != nil {
+		glog.Error(errors.Wrapf(err, "rebuildPool: "))
+		return nil
 	}
-	// Iterate through the pool transactions and add them to our new pool.
 
+	oldMempoolTxns, oldUnconnectedTxns, err := mp._getTransactionsOrderedByTimeAdded()
+	if err != nil {
+		glog.Warning(errors.Wrapf(err, "rebuildPool: "))
+	}
+	evictedTxns := []*evictedTxn{}
 	for _, mempoolTx := range oldMempoolTxns {
-		if *(mempoolTx.Tx.Hash()) == *(tx.Hash()) {
-			continue
+		if skipReason != nil {
+			if reason := skipReason(mempoolTx); reason != "" {
+				evictedTxns = append(evictedTxns, &evictedTxn{mempoolTx, reason})
+				continue
+			}
 		}
 
 		// Attempt to add the txn to the mempool as we go. If it fails that's fine.
@@ -2037,61 +5925,352 @@ func (mp *BitCloutMempool) inefficientRemoveTransaction(tx *MsgBitCloutTxn) {
 			mempoolTx.Tx, false /*allowUnconnectedTxn*/, false, /*rateLimit*/
 			0 /*peerID*/, false /*verifySignatures*/)
 		if err != nil {
-			glog.Warning(errors.Wrapf(err, "inefficientRemoveTransaction: "))
+			glog.Warning(errors.Wrapf(err, "rebuildPool: "))
+		}
+		if len(txnsAccepted) == 0 {
+			glog.Warningf("rebuildPool: Dropping txn %v", mempoolTx.Tx)
+			evictedTxns = append(evictedTxns, &evictedTxn{mempoolTx, dropReason})
+			continue
+		}
+		// Carry over the original Added time. See the comment in UpdateAfterConnectBlock.
+		newPool._carryOverMempoolTx(txnsAccepted[0], mempoolTx)
+	}
+	// Iterate through the unconnectedTxns and add them to our new pool as well.
+	for _, oTx := range oldUnconnectedTxns {
+		_, err := newPool.processTransaction(oTx.tx, true /*allowUnconnectedTxn*/, false, /*rateLimit*/
+			oTx.peerID, false /*verifySignatures*/)
+		if err != nil {
+			glog.Warning(errors.Wrapf(err, "rebuildPool: "))
+		}
+		newPool._carryOverUnconnectedTxnExpiration(oTx)
+	}
+
+	// Replace the internal mappings of the original pool with the mappings of the new
+	// pool.
+	mp.resetPool(newPool)
+
+	return evictedTxns
+}
+
+// SetBlockchain points the pool at a new Blockchain, e.g. one that was rebuilt during
+// maintenance, without losing the pool's txns. The pool's views are rebuilt against
+// the new chain's db and any txns that no longer connect are evicted with
+// EvictReasonBlockchainChanged. Returns an error without changing anything if the new
+// chain's params don't match the current one's. Acquires the write lock.
+func (mp *BitCloutMempool) SetBlockchain(bc *Blockchain) error {
+	// Evictions are reported once the lock below has been released.
+	defer mp._notifyPendingEvictedTxns()
+
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	if bc == nil {
+		return fmt.Errorf("SetBlockchain: Blockchain is nil")
+	}
+	if bc.params.NetworkType != mp.bc.params.NetworkType ||
+		bc.params.GenesisBlockHashHex != mp.bc.params.GenesisBlockHashHex {
+
+		return fmt.Errorf("SetBlockchain: New Blockchain has params for network %v "+
+			"with genesis %v but the pool's has params for network %v with genesis %v",
+			bc.params.NetworkType, bc.params.GenesisBlockHashHex,
+			mp.bc.params.NetworkType, mp.bc.params.GenesisBlockHashHex)
+	}
+
+	glog.Infof("SetBlockchain: Rebuilding pool of %d txns against new Blockchain", len(mp.poolMap))
+	mp.bc = bc
+	mp._updateValidationHeight()
+	mp.pendingEvictedTxns = append(mp.pendingEvictedTxns, mp.rebuildPool(EvictReasonBlockchainChanged)...)
+	// The rebuild only regenerates the readOnly view if it's being kept up to date,
+	// but it has to stop reading from the old chain's db regardless.
+	if !mp.generateReadOnlyUtxoView {
+		if err := mp.regenerateReadOnlyView(); err != nil {
+			return errors.Wrapf(err, "SetBlockchain: ")
+		}
+	}
+
+	return nil
+}
+
+func (mp *BitCloutMempool) InefficientRemoveTransaction(tx *MsgBitCloutTxn) {
+	mp.mtx.Lock()
+	evictedTxns := mp.inefficientRemoveTransaction(tx)
+	mp._recordEvictedTxnReasons(evictedTxns)
+	onEvict := mp.onEvict
+	mp.mtx.Unlock()
+
+	_notifyEvictedTxns(onEvict, evictedTxns)
+}
+
+// RemoveTransactionAndDescendants removes the txn with the given hash from the pool
+// along with every pool txn that spends its outputs, directly or indirectly, and
+// returns the hashes of the removed txns. Unlike InefficientRemoveTransaction this
+// doesn't re-validate every txn in the pool, but it's still O(pool): the universal
+// view is reconnected from the remaining txns, the backup view is rebuilt from it and
+// the readOnly view is regenerated if it's being kept up to date. If a remaining txn
+// no longer connects, e.g. because it updated a profile a removed txn created, it
+// falls back to rebuilding the whole pool the way InefficientRemoveTransaction does.
+// Returns nil if the txn isn't in the pool. Acquires the write lock.
+func (mp *BitCloutMempool) RemoveTransactionAndDescendants(txHash *BlockHash) []*BlockHash {
+	mp.mtx.Lock()
+	evictedTxns := mp.removeTransactionAndDescendants(txHash)
+	mp._recordEvictedTxnReasons(evictedTxns)
+	onEvict := mp.onEvict
+	mp.mtx.Unlock()
+
+	_notifyEvictedTxns(onEvict, evictedTxns)
+
+	var removedHashes []*BlockHash
+	for _, evicted := range evictedTxns {
+		removedHashes = append(removedHashes, evicted.mempoolTx.Hash)
+	}
+	return removedHashes
+}
+
+// See comment on RemoveTransactionAndDescendants. Must be called with the write lock
+// held.
+func (mp *BitCloutMempool) removeTransactionAndDescendants(txHash *BlockHash) []*evictedTxn {
+	return mp.removeTransactionsAndDescendants([]*BlockHash{txHash})
+}
+
+// removeTransactionsAndDescendants does what removeTransactionAndDescendants does for
+// several txns at once, paying for the views to be reconnected only once. The txns
+// with the given hashes are evicted with EvictReasonRemoved and their descendants with
+// EvictReasonDependencyEvicted. Hashes that aren't in the pool are skipped. Must be
+// called with the write lock held.
+func (mp *BitCloutMempool) removeTransactionsAndDescendants(txHashes []*BlockHash) []*evictedTxn {
+	rootHashes := make(map[BlockHash]bool, len(txHashes))
+	txnsToRemove := []*MempoolTx{}
+	removedHashes := make(map[BlockHash]bool)
+	for _, txHash := range txHashes {
+		rootHashes[*txHash] = true
+		for _, mempoolTx := range mp._getTransactionWithDescendants(txHash) {
+			if removedHashes[*mempoolTx.Hash] {
+				continue
+			}
+			removedHashes[*mempoolTx.Hash] = true
+			txnsToRemove = append(txnsToRemove, mempoolTx)
+		}
+	}
+	if len(txnsToRemove) == 0 {
+		return nil
+	}
+
+	evictedTxns := []*evictedTxn{}
+	for _, mempoolTx := range txnsToRemove {
+		reason := EvictReasonDependencyEvicted
+		if rootHashes[*mempoolTx.Hash] {
+			reason = EvictReasonRemoved
+		}
+		evictedTxns = append(evictedTxns, &evictedTxn{mempoolTx, reason})
+
+		delete(mp.poolMap, *mempoolTx.Hash)
+		for _, txIn := range mempoolTx.Tx.TxInputs {
+			utxoKey := UtxoKey(*txIn)
+			if mp.outpoints[utxoKey] == mempoolTx.Tx {
+				delete(mp.outpoints, utxoKey)
+			}
+		}
+		heap.Remove(&mp.txFeeMinheap, mempoolTx.index)
+		mp._decrementTotalTxSizeBytes(mempoolTx.TxSizeBytes)
+		mp._decrementTotalFeeNanos(mempoolTx.Fee)
+		mp._removeMempoolTxFromPubKeyOutputMap(mempoolTx)
+		mp._removeMempoolTxFromTxnTypeMap(mempoolTx)
+		mp._removeMempoolTxFromPostHashMap(mempoolTx)
+		mp._removeMempoolTxFromProfilePkMap(mempoolTx)
+		mp._removeMempoolTxFromAffectedPubKeyMap(mempoolTx)
+		delete(mp.txnTags, *mempoolTx.Hash)
+		if mempoolTx.Tx.TxnMeta.GetTxnType() == TxnTypeBitcoinExchange {
+			bitcoinTxHash := mempoolTx.Tx.TxnMeta.(*BitcoinExchangeMetadata).BitcoinTransaction.TxHash()
+			if mp.bitcoinHashToMempoolTx[bitcoinTxHash.String()] == mempoolTx {
+				delete(mp.bitcoinHashToMempoolTx, bitcoinTxHash.String())
+			}
+		}
+	}
+
+	remainingTxns := make([]*MempoolTx, 0, len(mp.universalTransactionList))
+	for _, mempoolTx := range mp.universalTransactionList {
+		if !removedHashes[*mempoolTx.Hash] {
+			remainingTxns = append(remainingTxns, mempoolTx)
+		}
+	}
+	mp.universalTransactionList = remainingTxns
+
+	// A txn can depend on a removed txn without spending its outputs, e.g. by
+	// updating a profile the removed txn created. Such a txn won't reconnect, so
+	// fall back to rebuilding the pool, which drops it.
+	if err := mp._reconnectUniversalView(); err != nil {
+		glog.Warningf("removeTransactionsAndDescendants: Rebuilding pool after "+
+			"removing txns %v: %v", txHashes, err)
+		evictedTxns = append(evictedTxns, mp.rebuildPool(EvictReasonDependencyEvicted)...)
+		return evictedTxns
+	}
+	mp.rebuildBackupView()
+	if mp.generateReadOnlyUtxoView {
+		mp.regenerateReadOnlyView()
+	}
+
+	return evictedTxns
+}
+
+// SetOnEvict sets a callback that's invoked for every txn evicted from the pool,
+// along with one of the EvictReason constants explaining why. This lets the caller
+// tell whoever submitted the txn so they can resubmit it, e.g. with a higher fee.
+// The callback is invoked after the pool's lock has been released, so it may call
+// back into the pool. Pass nil to remove the callback. Acquires the write lock.
+func (mp *BitCloutMempool) SetOnEvict(onEvict func(mempoolTx *MempoolTx, reason string)) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.onEvict = onEvict
+}
+
+// GetLastReprocessDrops returns the txns that failed to be re-added, along with why,
+// the last time the pool was rebuilt by UpdateAfterConnectBlock or
+// UpdateAfterDisconnectBlock or loaded by LoadTxnsFromDB. Each of those replaces the
+// drops recorded by the previous one. Acquires a read lock.
+func (mp *BitCloutMempool) GetLastReprocessDrops() []*ReprocessDrop {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	reprocessDrops := make([]*ReprocessDrop, len(mp.lastReprocessDrops))
+	copy(reprocessDrops, mp.lastReprocessDrops)
+	return reprocessDrops
+}
+
+// SetTxnLogger sets a TxnLogger that receives the pool's decision on each txn it's
+// offered. Pass nil to go back to logging decisions with glog. Acquires the write
+// lock.
+func (mp *BitCloutMempool) SetTxnLogger(txnLogger TxnLogger) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.txnLogger = txnLogger
+}
+
+// SetStatsHook sets a StatsHook that receives timings from the txn accept path. Pass
+// nil to remove it. Acquires the write lock.
+func (mp *BitCloutMempool) SetStatsHook(statsHook StatsHook) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.statsHook = statsHook
+}
+
+// SubscribePublicKey returns a channel that receives every txn added to the pool that
+// touches the given public key, i.e. every txn that gets indexed under it in
+// pubKeyToTxnMap, along with a func that cancels the subscription and closes the
+// channel. The channel is buffered and txns are dropped rather than waited on if the
+// subscriber falls behind, so it should be drained promptly. Txns that are re-added
+// when the pool is rebuilt aren't sent again. Acquires the write lock, as does the
+// unsubscribe func.
+func (mp *BitCloutMempool) SubscribePublicKey(pkBytes []byte) (<-chan *MempoolTx, func()) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	pkMapKey := MakePkMapKey(pkBytes)
+	subscriptionID := mp.nextPublicKeySubscriptionID
+	mp.nextPublicKeySubscriptionID++
+	txnChan := make(chan *MempoolTx, PublicKeySubscriptionBufferSize)
+	if _, exists := mp.publicKeySubscriptions[pkMapKey]; !exists {
+		mp.publicKeySubscriptions[pkMapKey] = make(map[uint64]chan *MempoolTx)
+	}
+	mp.publicKeySubscriptions[pkMapKey][subscriptionID] = txnChan
+
+	unsubscribe := func() {
+		mp.mtx.Lock()
+		defer mp.mtx.Unlock()
+
+		// Only the first call closes the channel.
+		subscriptionsForPk := mp.publicKeySubscriptions[pkMapKey]
+		if _, exists := subscriptionsForPk[subscriptionID]; !exists {
+			return
 		}
-		if len(txnsAccepted) == 0 {
-			glog.Warningf("inefficientRemoveTransaction: Dropping txn %v", mempoolTx.Tx)
+		delete(subscriptionsForPk, subscriptionID)
+		if len(subscriptionsForPk) == 0 {
+			delete(mp.publicKeySubscriptions, pkMapKey)
 		}
+		close(txnChan)
 	}
-	// Iterate through the unconnectedTxns and add them to our new pool as well.
-	for _, oTx := range oldUnconnectedTxns {
-		rateLimit := false
-		allowUnconnectedTxn := true
-		verifySignatures := false
-		_, err := newPool.processTransaction(oTx.tx, allowUnconnectedTxn, rateLimit, oTx.peerID, verifySignatures)
-		if err != nil {
-			glog.Warning(errors.Wrapf(err, "inefficientRemoveTransaction: "))
-		}
+	return txnChan, unsubscribe
+}
+
+// _notifyPublicKeySubscribers sends the txn to every SubscribePublicKey channel for the
+// public keys it touches. Each channel gets the txn at most once. Must be called with
+// the write lock held, which keeps the channels from being closed while sending.
+func (mp *BitCloutMempool) _notifyPublicKeySubscribers(mempoolTx *MempoolTx) {
+	if len(mp.publicKeySubscriptions) == 0 {
+		return
 	}
 
-	// At this point the new mempool should be a duplicate of the original mempool but with
-	// the non-double-spend transactions added (with timestamps set before the transactions that
-	// were in the original pool.
+	notifiedKeys := make(map[PkMapKey]bool)
+	for _, pkBytes := range _getPublicKeysToIndexForTxn(mempoolTx.Tx, mp.bc.params) {
+		pkMapKey := MakePkMapKey(pkBytes)
+		if notifiedKeys[pkMapKey] {
+			continue
+		}
+		notifiedKeys[pkMapKey] = true
 
-	// Replace the internal mappings of the original pool with the mappings of the new
-	// pool.
-	mp.resetPool(newPool)
+		for _, txnChan := range mp.publicKeySubscriptions[pkMapKey] {
+			select {
+			case txnChan <- mempoolTx:
+			default:
+				glog.Warningf("_notifyPublicKeySubscribers: Dropping txn %v for a "+
+					"subscriber to %v that isn't keeping up", mempoolTx.Hash,
+					PkToString(pkBytes, mp.bc.params))
+			}
+		}
+	}
 }
 
-func (mp *BitCloutMempool) InefficientRemoveTransaction(tx *MsgBitCloutTxn) {
+// _notifyPendingEvictedTxns passes the txns queued up in pendingEvictedTxns to
+// onEvict. Acquires the write lock briefly to take the queue, then invokes the
+// callback without it, so it must be called without the lock held.
+func (mp *BitCloutMempool) _notifyPendingEvictedTxns() {
 	mp.mtx.Lock()
-	defer mp.mtx.Unlock()
+	evictedTxns := mp.pendingEvictedTxns
+	mp.pendingEvictedTxns = nil
+	mp._recordEvictedTxnReasons(evictedTxns)
+	onEvict := mp.onEvict
+	mp.mtx.Unlock()
+
+	_notifyEvictedTxns(onEvict, evictedTxns)
+}
 
-	mp.inefficientRemoveTransaction(tx)
+// Invokes onEvict for each of the evicted txns. Must be called without the lock held.
+func _notifyEvictedTxns(onEvict func(mempoolTx *MempoolTx, reason string), evictedTxns []*evictedTxn) {
+	if onEvict == nil {
+		return
+	}
+	for _, evicted := range evictedTxns {
+		onEvict(evicted.mempoolTx, evicted.reason)
+	}
 }
 
 func (mp *BitCloutMempool) EvictUnminedBitcoinTransactions(bitcoinTxnHashes []string, dryRun bool) (int64, map[string]int64, []string, []string) {
 	var mempoolTxns []*MempoolTx
 
+	// Use the bitcoinHashToMempoolTx index to find the txns to evict up front rather
+	// than comparing every BitcoinExchange against every hash passed in.
+	var txHashesToEvict map[BlockHash]bool
 	if !dryRun {
 		mp.mtx.Lock()
 		defer mp.mtx.Unlock()
 
 		mempoolTxns = mp.universalTransactionList
+		txHashesToEvict = mp._getTxHashesForBitcoinHashes(bitcoinTxnHashes)
 	} else {
 		mempoolTxns = mp.readOnlyUniversalTransactionList
+
+		mp.mtx.RLock()
+		txHashesToEvict = mp._getTxHashesForBitcoinHashes(bitcoinTxnHashes)
+		mp.mtx.RUnlock()
 	}
 
 	// Create a new pool to apply them to.
-	newPool := NewBitCloutMempool(mp.bc, 0, 0, "", false, "", "")
-
-	isHashToEvict := func(evictHash string) bool {
-		for _, txnHash := range bitcoinTxnHashes {
-			if txnHash == evictHash {
-				return true
-			}
-		}
-		return false
+	newPool, err := mp._newRebuildPool()
+	if err != nil {
+		glog.Error(errors.Wrapf(err, "EvictUnminedBitcoinTransactions: "))
+		return 0, nil, nil, nil
 	}
 
 	evictedTxnsMap := make(map[string]int64)
@@ -2103,7 +6282,7 @@ func (mp *BitCloutMempool) EvictUnminedBitcoinTransactions(bitcoinTxnHashes []st
 			unminedBitcoinExchangeTxns = append(unminedBitcoinExchangeTxns, fmt.Sprintf("%s:%d", evictHash, ii))
 
 			// Don't add transactions if they're in our list of txns to evict
-			if isHashToEvict(evictHash) {
+			if txHashesToEvict[*mempoolTx.Hash] {
 				evictedTxnsMap[mempoolTx.Tx.TxnMeta.GetTxnType().String()] += 1
 				evictedTxnsList = append(evictedTxnsList, mempoolTx.Tx.Hash().String()+":"+PkToStringMainnet(mempoolTx.Tx.Hash()[:]))
 				continue
@@ -2133,32 +6312,75 @@ func (mp *BitCloutMempool) EvictUnminedBitcoinTransactions(bitcoinTxnHashes []st
 	return newPoolTxnCount, evictedTxnsMap, evictedTxnsList, unminedBitcoinExchangeTxns
 }
 
-func (mp *BitCloutMempool) StartReadOnlyUtxoViewRegenerator() {
-	glog.Info("Calling StartReadOnlyUtxoViewRegenerator...")
+// removeExpiredTransactions evicts all of the connected txns that were added to the
+// pool more than maxTxnAge ago. Like inefficientRemoveTransaction, it does this by
+// re-processing every txn that hasn't expired into a new pool and swapping it in.
+// Txns that depend on an expired txn are dropped along with it since they can no
+// longer connect. Returns the number of expired txns that were removed, along with
+// all of the txns that were evicted, including the dependents.
+//
+// The write lock must be held when calling this function.
+func (mp *BitCloutMempool) removeExpiredTransactions() (_numExpired int, _evictedTxns []*evictedTxn) {
+	if mp.maxTxnAge == 0 {
+		return 0, nil
+	}
+	expirationCutoff := mp.nowFunc().Add(-mp.maxTxnAge)
+
+	// Rebuilding the pool is expensive so only do it if at least one txn has
+	// actually expired.
+	hasExpiredTxn := false
+	for _, mempoolTx := range mp.poolMap {
+		if mempoolTx.Added.Before(expirationCutoff) {
+			hasExpiredTxn = true
+			break
+		}
+	}
+	if !hasExpiredTxn {
+		return 0, nil
+	}
+
+	numExpired := 0
+	evictedTxns := mp._rebuildPoolWithout(func(mempoolTx *MempoolTx) string {
+		if !mempoolTx.Added.Before(expirationCutoff) {
+			return ""
+		}
+		glog.Tracef("removeExpiredTransactions: Expiring txn %v added at %v",
+			mempoolTx.Hash, mempoolTx.Added)
+		numExpired++
+		return EvictReasonExpired
+	}, EvictReasonDependencyEvicted)
+
+	return numExpired, evictedTxns
+}
+
+func (mp *BitCloutMempool) RemoveExpiredTransactions() int {
+	mp.mtx.Lock()
+	numExpired, evictedTxns := mp.removeExpiredTransactions()
+	mp._recordEvictedTxnReasons(evictedTxns)
+	onEvict := mp.onEvict
+	mp.mtx.Unlock()
+
+	_notifyEvictedTxns(onEvict, evictedTxns)
+
+	return numExpired
+}
+
+// StartExpiredTxnSweeper kicks off a goroutine that periodically evicts txns that
+// have been in the pool for longer than maxTxnAge. See removeExpiredTransactions.
+func (mp *BitCloutMempool) StartExpiredTxnSweeper() {
+	glog.Info("Calling StartExpiredTxnSweeper...")
 
 	go func() {
-		var oldSeqNum int64
 	out:
 		for {
 			select {
-			case <-time.After(time.Duration(ReadOnlyUtxoViewRegenerationIntervalSeconds) * time.Second):
-				glog.Tracef("StartReadOnlyUtxoViewRegenerator: Woke up!")
-
-				// When we wake up, only do an update if one didn't occur since before
-				// we slept. Note that the number of transactions being processed can
-				// also trigger an update, which is why this check is necessary.
-				newSeqNum := atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
-				if oldSeqNum == newSeqNum {
-					glog.Tracef("StartReadOnlyUtxoViewRegenerator: Updating view at prescribed interval")
-					// Acquire a read lock when we do this.
-					mp.RegenerateReadOnlyView()
-					glog.Tracef("StartReadOnlyUtxoViewRegenerator: Finished view update at prescribed interval")
-				} else {
-					glog.Tracef("StartReadOnlyUtxoViewRegenerator: View updated while sleeping; nothing to do")
-				}
+			case <-time.After(time.Duration(ExpiredTxnSweepIntervalSeconds) * time.Second):
+				glog.Tracef("StartExpiredTxnSweeper: Woke up!")
 
-				// Get the sequence number before our timer hits.
-				oldSeqNum = atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+				numExpired := mp.RemoveExpiredTransactions()
+				if numExpired > 0 {
+					glog.Infof("StartExpiredTxnSweeper: Expired %d txns", numExpired)
+				}
 
 			case <-mp.quit:
 				break out
@@ -2167,6 +6389,75 @@ func (mp *BitCloutMempool) StartReadOnlyUtxoViewRegenerator() {
 	}()
 }
 
+// _getReadOnlyViewRegenerationInterval returns the readOnlyViewRegenerationInterval.
+// See SetReadOnlyViewRegenerationInterval. Acquires the read lock.
+func (mp *BitCloutMempool) _getReadOnlyViewRegenerationInterval() time.Duration {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.readOnlyViewRegenerationInterval
+}
+
+func (mp *BitCloutMempool) StartReadOnlyUtxoViewRegenerator() {
+	glog.Info("Calling StartReadOnlyUtxoViewRegenerator...")
+
+	go mp._runReadOnlyUtxoViewRegenerator()
+}
+
+// _runReadOnlyUtxoViewRegenerator is the goroutine started by
+// StartReadOnlyUtxoViewRegenerator. If a regeneration panics, the panic is logged and
+// the goroutine is restarted, since otherwise the readOnly view would silently stop
+// updating. See IsReadOnlyViewHealthy.
+func (mp *BitCloutMempool) _runReadOnlyUtxoViewRegenerator() {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("StartReadOnlyUtxoViewRegenerator: Restarting after panic: %v\n%s",
+				r, debug.Stack())
+			go mp._runReadOnlyUtxoViewRegenerator()
+		}
+	}()
+
+	var oldSeqNum int64
+out:
+	for {
+		select {
+		case <-time.After(mp._getReadOnlyViewRegenerationInterval()):
+			glog.Tracef("StartReadOnlyUtxoViewRegenerator: Woke up!")
+
+			// When we wake up, only do an update if one didn't occur since before
+			// we slept. Note that the number of transactions being processed can
+			// also trigger an update, which is why this check is necessary.
+			newSeqNum := atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+			if oldSeqNum == newSeqNum {
+				glog.Tracef("StartReadOnlyUtxoViewRegenerator: Updating view at prescribed interval")
+				// Acquire a read lock when we do this.
+				mp.RegenerateReadOnlyView()
+				glog.Tracef("StartReadOnlyUtxoViewRegenerator: Finished view update at prescribed interval")
+			} else {
+				glog.Tracef("StartReadOnlyUtxoViewRegenerator: View updated while sleeping; nothing to do")
+			}
+
+			// Get the sequence number before our timer hits.
+			oldSeqNum = atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+
+		case <-mp.quit:
+			break out
+		}
+	}
+}
+
+// IsReadOnlyViewHealthy returns true if the readOnly view has been regenerated within
+// the last maxStaleness. When the regenerator is running, the view is regenerated at
+// least every readOnlyViewRegenerationInterval, so a maxStaleness of a few intervals
+// catches a regenerator that has stopped. Doesn't acquire the lock.
+func (mp *BitCloutMempool) IsReadOnlyViewHealthy(maxStaleness time.Duration) bool {
+	lastRegenUnixNano := atomic.LoadInt64(&mp.lastReadOnlyViewRegenUnixNano)
+	if lastRegenUnixNano == 0 {
+		return false
+	}
+	return mp.nowFunc().Sub(time.Unix(0, lastRegenUnixNano)) <= maxStaleness
+}
+
 func (mp *BitCloutMempool) regenerateReadOnlyView() error {
 	newView, err := mp.universalUtxoView.CopyUtxoView()
 	if err != nil {
@@ -2187,10 +6478,267 @@ func (mp *BitCloutMempool) regenerateReadOnlyView() error {
 	mp.readOnlyUniversalTransactionList = newTxnList
 	mp.readOnlyUniversalTransactionMap = txMap
 
-	atomic.AddInt64(&mp.readOnlyUtxoViewSequenceNumber, 1)
+	// Swap in a fresh copy rather than updating the old map in place so that readers
+	// like CheckSpend never see it mid-update.
+	newOutpoints := make(map[UtxoKey]*MsgBitCloutTxn, len(mp.outpoints))
+	for utxoKey, spendingTxn := range mp.outpoints {
+		newOutpoints[utxoKey] = spendingTxn
+	}
+	mp.readOnlyOutpoints = newOutpoints
+
+	newSeqNum := atomic.AddInt64(&mp.readOnlyUtxoViewSequenceNumber, 1)
+	atomic.StoreInt64(&mp.lastReadOnlyViewRegenUnixNano, mp.nowFunc().UnixNano())
+
+	// Swap in the snapshot last, and all at once, so that readers of it get a
+	// consistent set of fields.
+	mp.readOnlySnapshot = &MempoolSnapshot{
+		SequenceNumber: newSeqNum,
+		Txns:           newTxnList,
+		TxnMap:         txMap,
+		SummaryStats:   _computeSummaryStats(newTxnList),
+	}
+
 	return nil
 }
 
+// Clone returns a frozen copy of the pool as it is right now, which can be inspected
+// without affecting, or being affected by, the live pool. All of the pool's maps,
+// the heap, and the MempoolTxs they hold are copied, as are the views. Txns
+// themselves are shared since they're never modified once they're in the pool, and
+// so is the Blockchain.
+//
+// The clone doesn't run any of the background services the original might, like the
+// readOnly view regenerator, DB dumper, or expired txn sweeper. Its readOnly view is
+// a copy of the original's, as of the original's last regeneration. Acquires a read
+// lock.
+func (mp *BitCloutMempool) Clone() (*BitCloutMempool, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.clone()
+}
+
+// See Clone. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) clone() (*BitCloutMempool, error) {
+	// Each MempoolTx is copied exactly once so that every structure in the clone that
+	// references a particular txn points to the same copy, just like in the original.
+	mempoolTxCopies := make(map[*MempoolTx]*MempoolTx)
+	copyMempoolTx := func(mempoolTx *MempoolTx) *MempoolTx {
+		if mempoolTxCopy, exists := mempoolTxCopies[mempoolTx]; exists {
+			return mempoolTxCopy
+		}
+		mempoolTxCopy := *mempoolTx
+		mempoolTxCopies[mempoolTx] = &mempoolTxCopy
+		return &mempoolTxCopy
+	}
+
+	universalUtxoView, err := mp.universalUtxoView.CopyUtxoView()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Clone: Problem copying universalUtxoView: ")
+	}
+	var backupUniversalUtxoView *UtxoView
+	if mp.backupUniversalUtxoView != nil {
+		backupUniversalUtxoView, err = mp.backupUniversalUtxoView.CopyUtxoView()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Clone: Problem copying backupUniversalUtxoView: ")
+		}
+	}
+	readOnlyUtxoView, err := mp.readOnlyUtxoView.CopyUtxoView()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Clone: Problem copying readOnlyUtxoView: ")
+	}
+
+	poolMap := make(map[BlockHash]*MempoolTx, len(mp.poolMap))
+	for txHash, mempoolTx := range mp.poolMap {
+		poolMap[txHash] = copyMempoolTx(mempoolTx)
+	}
+	// The copies keep their heap index so copying the heap in order keeps it valid.
+	txFeeMinheap := make(MempoolTxFeeMinHeap, 0, len(mp.txFeeMinheap))
+	for _, mempoolTx := range mp.txFeeMinheap {
+		txFeeMinheap = append(txFeeMinheap, copyMempoolTx(mempoolTx))
+	}
+	universalTransactionList := make([]*MempoolTx, 0, len(mp.universalTransactionList))
+	for _, mempoolTx := range mp.universalTransactionList {
+		universalTransactionList = append(universalTransactionList, copyMempoolTx(mempoolTx))
+	}
+	outpoints := make(map[UtxoKey]*MsgBitCloutTxn, len(mp.outpoints))
+	for utxoKey, tx := range mp.outpoints {
+		outpoints[utxoKey] = tx
+	}
+	unconnectedTxns := make(map[BlockHash]*UnconnectedTx, len(mp.unconnectedTxns))
+	for txHash, unconnectedTxn := range mp.unconnectedTxns {
+		unconnectedTxnCopy := *unconnectedTxn
+		unconnectedTxns[txHash] = &unconnectedTxnCopy
+	}
+	unconnectedTxnOffers := make(map[BlockHash]*unconnectedTxnOffer, len(mp.unconnectedTxnOffers))
+	for txHash, offer := range mp.unconnectedTxnOffers {
+		offerCopy := *offer
+		unconnectedTxnOffers[txHash] = &offerCopy
+	}
+	unconnectedTxnsByPrev := make(map[UtxoKey]map[BlockHash]*MsgBitCloutTxn, len(mp.unconnectedTxnsByPrev))
+	for utxoKey, txnsForPrev := range mp.unconnectedTxnsByPrev {
+		txnsForPrevCopy := make(map[BlockHash]*MsgBitCloutTxn, len(txnsForPrev))
+		for txHash, tx := range txnsForPrev {
+			txnsForPrevCopy[txHash] = tx
+		}
+		unconnectedTxnsByPrev[utxoKey] = txnsForPrevCopy
+	}
+	pubKeyToTxnMap := make(map[PkMapKey]map[BlockHash]*MempoolTx, len(mp.pubKeyToTxnMap))
+	for pkMapKey, txnsForPk := range mp.pubKeyToTxnMap {
+		txnsForPkCopy := make(map[BlockHash]*MempoolTx, len(txnsForPk))
+		for txHash, mempoolTx := range txnsForPk {
+			txnsForPkCopy[txHash] = copyMempoolTx(mempoolTx)
+		}
+		pubKeyToTxnMap[pkMapKey] = txnsForPkCopy
+	}
+	txnTypeToTxnMap := make(map[TxnType]map[BlockHash]*MempoolTx, len(mp.txnTypeToTxnMap))
+	for txnType, txnsForType := range mp.txnTypeToTxnMap {
+		txnsForTypeCopy := make(map[BlockHash]*MempoolTx, len(txnsForType))
+		for txHash, mempoolTx := range txnsForType {
+			txnsForTypeCopy[txHash] = copyMempoolTx(mempoolTx)
+		}
+		txnTypeToTxnMap[txnType] = txnsForTypeCopy
+	}
+	postHashToTxnMap := make(map[BlockHash]map[BlockHash]*MempoolTx, len(mp.postHashToTxnMap))
+	for postHash, txnsForPostHash := range mp.postHashToTxnMap {
+		txnsForPostHashCopy := make(map[BlockHash]*MempoolTx, len(txnsForPostHash))
+		for txHash, mempoolTx := range txnsForPostHash {
+			txnsForPostHashCopy[txHash] = copyMempoolTx(mempoolTx)
+		}
+		postHashToTxnMap[postHash] = txnsForPostHashCopy
+	}
+	profilePkToTxnMap := make(map[PkMapKey]map[BlockHash]*MempoolTx, len(mp.profilePkToTxnMap))
+	for pkMapKey, txnsForPk := range mp.profilePkToTxnMap {
+		txnsForPkCopy := make(map[BlockHash]*MempoolTx, len(txnsForPk))
+		for txHash, mempoolTx := range txnsForPk {
+			txnsForPkCopy[txHash] = copyMempoolTx(mempoolTx)
+		}
+		profilePkToTxnMap[pkMapKey] = txnsForPkCopy
+	}
+	affectedPubKeyToTxnMap := make(map[PkMapKey]map[BlockHash]*MempoolTx, len(mp.affectedPubKeyToTxnMap))
+	for pkMapKey, txnsForPk := range mp.affectedPubKeyToTxnMap {
+		txnsForPkCopy := make(map[BlockHash]*MempoolTx, len(txnsForPk))
+		for txHash, mempoolTx := range txnsForPk {
+			txnsForPkCopy[txHash] = copyMempoolTx(mempoolTx)
+		}
+		affectedPubKeyToTxnMap[pkMapKey] = txnsForPkCopy
+	}
+	// The tags themselves are read-only so they can be shared with the clone.
+	txnTags := make(map[BlockHash]map[string]string, len(mp.txnTags))
+	for txHash, tags := range mp.txnTags {
+		txnTags[txHash] = tags
+	}
+	txnTypeLimits := make(map[TxnType]int, len(mp.txnTypeLimits))
+	for txnType, typeLimit := range mp.txnTypeLimits {
+		txnTypeLimits[txnType] = typeLimit
+	}
+	txnTypeByteLimits := make(map[TxnType]uint64, len(mp.txnTypeByteLimits))
+	for txnType, byteLimit := range mp.txnTypeByteLimits {
+		txnTypeByteLimits[txnType] = byteLimit
+	}
+	txnTypeToTotalBytes := make(map[TxnType]uint64, len(mp.txnTypeToTotalBytes))
+	for txnType, totalBytes := range mp.txnTypeToTotalBytes {
+		txnTypeToTotalBytes[txnType] = totalBytes
+	}
+	trustedPeerIDs := make(map[uint64]bool, len(mp.trustedPeerIDs))
+	for peerID := range mp.trustedPeerIDs {
+		trustedPeerIDs[peerID] = true
+	}
+	unminedBitcoinTxns := make(map[BlockHash]*MempoolTx, len(mp.unminedBitcoinTxns))
+	for txHash, mempoolTx := range mp.unminedBitcoinTxns {
+		unminedBitcoinTxns[txHash] = copyMempoolTx(mempoolTx)
+	}
+	bitcoinHashToMempoolTx := make(map[string]*MempoolTx, len(mp.bitcoinHashToMempoolTx))
+	for bitcoinHash, mempoolTx := range mp.bitcoinHashToMempoolTx {
+		bitcoinHashToMempoolTx[bitcoinHash] = copyMempoolTx(mempoolTx)
+	}
+
+	// The readOnly fields can point to MempoolTxs from before the last pool rebuild,
+	// which copyMempoolTx handles the same way as everything else.
+	readOnlyUniversalTransactionList := make([]*MempoolTx, 0, len(mp.readOnlyUniversalTransactionList))
+	for _, mempoolTx := range mp.readOnlyUniversalTransactionList {
+		readOnlyUniversalTransactionList = append(readOnlyUniversalTransactionList, copyMempoolTx(mempoolTx))
+	}
+	readOnlyUniversalTransactionMap := make(map[BlockHash]*MempoolTx, len(mp.readOnlyUniversalTransactionMap))
+	for txHash, mempoolTx := range mp.readOnlyUniversalTransactionMap {
+		readOnlyUniversalTransactionMap[txHash] = copyMempoolTx(mempoolTx)
+	}
+	readOnlyOutpoints := make(map[UtxoKey]*MsgBitCloutTxn, len(mp.readOnlyOutpoints))
+	for utxoKey, tx := range mp.readOnlyOutpoints {
+		readOnlyOutpoints[utxoKey] = tx
+	}
+	readOnlySequenceNumber := atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
+
+	return &BitCloutMempool{
+		quit:                             make(chan struct{}),
+		bc:                               mp.bc,
+		minFeeRateNanosPerKB:             mp.minFeeRateNanosPerKB,
+		rateLimitFeeRateNanosPerKB:       mp.rateLimitFeeRateNanosPerKB,
+		maxPendingTxnsPerPublicKey:       mp.maxPendingTxnsPerPublicKey,
+		maxTxnSizeBytes:                  mp.maxTxnSizeBytes,
+		maxInputsPerTxn:                  mp.maxInputsPerTxn,
+		requireTransactorUtxos:           mp.requireTransactorUtxos,
+		maxCombinedTxSizeBytes:           mp.maxCombinedTxSizeBytes,
+		relayFeeRateNanosPerKB:           mp.relayFeeRateNanosPerKB,
+		replacementFeeBumpNanosPerKB:     mp.replacementFeeBumpNanosPerKB,
+		txnTypeLimits:                    txnTypeLimits,
+		txnTypeByteLimits:                txnTypeByteLimits,
+		evictionPolicy:                   mp.evictionPolicy,
+		computeMetadataOnAccept:          mp.computeMetadataOnAccept,
+		isSyncingFunc:                    mp.isSyncingFunc,
+		trustedPeerIDs:                   trustedPeerIDs,
+		recentlyConfirmedTxnsCacheSize:   mp.recentlyConfirmedTxnsCacheSize,
+		droppedTxnReasonsCacheSize:       mp.droppedTxnReasonsCacheSize,
+		poolMap:                          poolMap,
+		txFeeMinheap:                     txFeeMinheap,
+		totalTxSizeBytes:                 mp.totalTxSizeBytes,
+		totalFeeNanos:                    mp.totalFeeNanos,
+		avgBlockFillRate:                 mp.avgBlockFillRate,
+		outpoints:                        outpoints,
+		unconnectedTxns:                  unconnectedTxns,
+		unconnectedTxnOffers:             unconnectedTxnOffers,
+		publicKeySubscriptions:           make(map[PkMapKey]map[uint64]chan *MempoolTx),
+		unconnectedTxnsByPrev:            unconnectedTxnsByPrev,
+		totalUnconnectedTxSizeBytes:      mp.totalUnconnectedTxSizeBytes,
+		lowFeeTxSizeAccumulator:          mp.lowFeeTxSizeAccumulator,
+		lastLowFeeTxUnixTime:             mp.lastLowFeeTxUnixTime,
+		pubKeyToTxnMap:                   pubKeyToTxnMap,
+		txnTypeToTxnMap:                  txnTypeToTxnMap,
+		txnTypeToTotalBytes:              txnTypeToTotalBytes,
+		postHashToTxnMap:                 postHashToTxnMap,
+		profilePkToTxnMap:                profilePkToTxnMap,
+		affectedPubKeyToTxnMap:           affectedPubKeyToTxnMap,
+		bulkIndexTxnsOnLoad:              mp.bulkIndexTxnsOnLoad,
+		txnTags:                          txnTags,
+		unminedBitcoinTxns:               unminedBitcoinTxns,
+		bitcoinHashToMempoolTx:           bitcoinHashToMempoolTx,
+		nextExpireScan:                   mp.nextExpireScan,
+		blockCypherCheckDoubleSpendChan:  make(chan *MsgBitCloutTxn),
+		backupUniversalUtxoView:          backupUniversalUtxoView,
+		universalUtxoView:                universalUtxoView,
+		universalTransactionList:         universalTransactionList,
+		readOnlyUtxoView:                 readOnlyUtxoView,
+		readOnlyUniversalTransactionList: readOnlyUniversalTransactionList,
+		readOnlyUniversalTransactionMap:  readOnlyUniversalTransactionMap,
+		readOnlyOutpoints:                readOnlyOutpoints,
+		readOnlyUtxoViewSequenceNumber:   readOnlySequenceNumber,
+		lastReadOnlyViewRegenUnixNano:    atomic.LoadInt64(&mp.lastReadOnlyViewRegenUnixNano),
+		validationHeight:                 atomic.LoadUint32(&mp.validationHeight),
+		readOnlySnapshot: &MempoolSnapshot{
+			SequenceNumber: readOnlySequenceNumber,
+			Txns:           readOnlyUniversalTransactionList,
+			TxnMap:         readOnlyUniversalTransactionMap,
+			SummaryStats:   _computeSummaryStats(readOnlyUniversalTransactionList),
+		},
+		totalProcessTransactionCalls:         mp.totalProcessTransactionCalls,
+		dataDir:                              mp.dataDir,
+		maxTxnAge:                            mp.maxTxnAge,
+		nowFunc:                              mp.nowFunc,
+		lightweightMode:                      mp.lightweightMode,
+		bitcoinExchangeDustThresholdSatoshis: mp.bitcoinExchangeDustThresholdSatoshis,
+	}, nil
+}
+
 func (mp *BitCloutMempool) RegenerateReadOnlyView() error {
 	mp.mtx.RLock()
 	defer mp.mtx.RUnlock()
@@ -2198,6 +6746,28 @@ func (mp *BitCloutMempool) RegenerateReadOnlyView() error {
 	return mp.regenerateReadOnlyView()
 }
 
+// See GetReadOnlyViewLag. Must be called with at least the read lock held.
+func (mp *BitCloutMempool) _getReadOnlyViewLag() int64 {
+	// Between regenerations the universalTransactionList is only ever appended to,
+	// and a pool rebuild regenerates the readOnly view right away, so the readOnly
+	// list is a prefix of the universal one and the difference in length is exactly
+	// the number of txns it's missing.
+	lag := int64(len(mp.universalTransactionList)) - int64(len(mp.readOnlyUniversalTransactionList))
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// GetReadOnlyViewLag returns the number of txns that have been accepted into the pool
+// but aren't reflected in the readOnly view yet. Acquires a read lock.
+func (mp *BitCloutMempool) GetReadOnlyViewLag() int64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp._getReadOnlyViewLag()
+}
+
 func (mp *BitCloutMempool) BlockUntilReadOnlyViewRegenerated() {
 	oldSeqNum := atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber)
 	newSeqNum := oldSeqNum
@@ -2209,14 +6779,25 @@ func (mp *BitCloutMempool) BlockUntilReadOnlyViewRegenerated() {
 	}
 }
 
+// _getDumpInterval returns the dumpInterval. See SetDumpInterval. Acquires the read
+// lock.
+func (mp *BitCloutMempool) _getDumpInterval() time.Duration {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.dumpInterval
+}
+
 func (mp *BitCloutMempool) StartMempoolDBDumper() {
 	// If we were instructed to dump txns to the db, then do so periodically
 	// Note this acquired a very minimal lock on the universalTransactionList
+	mp.mempoolDBDumperDone = make(chan struct{})
 	go func() {
+		defer close(mp.mempoolDBDumperDone)
 	out:
 		for {
 			select {
-			case <-time.After(30 * time.Second):
+			case <-time.After(mp._getDumpInterval()):
 				glog.Info("StartMempoolDBDumper: Waking up! Dumping txns now...")
 
 				// Dump the txns and time it.
@@ -2230,11 +6811,124 @@ func (mp *BitCloutMempool) StartMempoolDBDumper() {
 }
 
 func (mp *BitCloutMempool) LoadTxnsFromDB() {
+	mp.lastReprocessDrops = []*ReprocessDrop{}
+	mp.loadTxnsFromLatestDump()
+
+	// Txns accepted after the dump above was taken are only in the WAL.
+	if mp.enableWAL {
+		mp.replayWAL()
+	}
+}
+
+func (mp *BitCloutMempool) replayWAL() {
+	walTxns, err := mp.readWAL()
+	if err != nil {
+		glog.Errorf("replayWAL: Problem reading WAL: %v", err)
+		return
+	}
+
+	numReplayed := 0
+	for _, txn := range walTxns {
+		_, err := mp.processTransaction(txn, false, false, 0, false)
+		if err != nil {
+			// The dump usually already has most of the txns in the WAL.
+			if errors.Cause(err) == TxErrorDuplicate {
+				continue
+			}
+			glog.Warning(errors.Wrapf(err, "replayWAL: Not adding txn from WAL "+
+				"because it had an error: "))
+			mp.lastReprocessDrops = append(mp.lastReprocessDrops, &ReprocessDrop{txn.Hash(), err})
+			continue
+		}
+		numReplayed++
+	}
+	glog.Infof("replayWAL: Replayed %v of %v txns from WAL", numReplayed, len(walTxns))
+}
+
+func (mp *BitCloutMempool) loadTxnsFromLatestDump() {
 	glog.Infof("LoadTxnsFromDB: Loading mempool txns from db because --load_mempool_txns_from_db was set")
 	startTime := time.Now()
 
-	// The mempool shuffles dumped txns between temp, previous, and latest dirs. By dumping txns
-	// to temp first, we ensure that we always have a full set of txns in latest and previous dir.
+	// Nodes that haven't dumped since upgrading only have a dump in the legacy dirs.
+	var dbMempoolTxnsOrderedByTime []*MsgBitCloutTxn
+	if _, err := os.Stat(mp.dumpDBDir()); err == nil {
+		dbMempoolTxnsOrderedByTime = mp._loadTxnsFromDumpDB()
+	} else {
+		dbMempoolTxnsOrderedByTime = mp._loadTxnsFromLegacyDumpDirs()
+	}
+
+	// The limits enforced while adding txns rely on the indexes, so they can only be
+	// deferred if none are configured.
+	if mp.bulkIndexTxnsOnLoad && len(mp.txnTypeLimits) == 0 && len(mp.txnTypeByteLimits) == 0 &&
+		mp.maxPendingTxnsPerPublicKey == 0 {
+
+		mp.deferTxnIndexing = true
+	}
+	for _, mempoolTxn := range dbMempoolTxnsOrderedByTime {
+		_, err := mp.processTransaction(mempoolTxn, false, false, 0, false)
+		if err != nil {
+			// Log errors but don't stop adding transactions. We do this because we'd prefer
+			// to drop a transaction here or there rather than lose the whole block because
+			// of one bad apple.
+			glog.Warning(errors.Wrapf(err, "NewBitCloutMempool: Not adding txn from DB "+
+				"because it had an error: "))
+			mp.lastReprocessDrops = append(mp.lastReprocessDrops, &ReprocessDrop{mempoolTxn.Hash(), err})
+		}
+	}
+	if mp.deferTxnIndexing {
+		mp.deferTxnIndexing = false
+		mp._rebuildDeferredTxnIndexes()
+	}
+	endTime := time.Now()
+	glog.Infof("LoadTxnsFromDB: Loaded %v txns in %v seconds", len(dbMempoolTxnsOrderedByTime), endTime.Sub(startTime).Seconds())
+}
+
+// _rebuildDeferredTxnIndexes builds every index that deferTxnIndexing holds off on
+// from scratch out of the poolMap, with the larger outer maps sized up front. It's
+// used after bulk-loading txns with deferTxnIndexing set.
+func (mp *BitCloutMempool) _rebuildDeferredTxnIndexes() {
+	mp.pubKeyToTxnMap = make(map[PkMapKey]map[BlockHash]*MempoolTx, len(mp.poolMap))
+	mp.txnTypeToTxnMap = make(map[TxnType]map[BlockHash]*MempoolTx)
+	mp.txnTypeToTotalBytes = make(map[TxnType]uint64)
+	mp.postHashToTxnMap = make(map[BlockHash]map[BlockHash]*MempoolTx)
+	mp.profilePkToTxnMap = make(map[PkMapKey]map[BlockHash]*MempoolTx)
+	mp.affectedPubKeyToTxnMap = make(map[PkMapKey]map[BlockHash]*MempoolTx, len(mp.poolMap))
+	for _, mempoolTx := range mp.poolMap {
+		mp._addMempoolTxToPubKeyOutputMap(mempoolTx)
+		mp._addMempoolTxToTxnTypeMap(mempoolTx)
+		mp._addMempoolTxToPostHashMap(mempoolTx)
+		mp._addMempoolTxToProfilePkMap(mempoolTx)
+		mp._addMempoolTxToAffectedPubKeyMap(mempoolTx)
+	}
+}
+
+func (mp *BitCloutMempool) _loadTxnsFromDumpDB() []*MsgBitCloutTxn {
+	mp.dumpMtx.Lock()
+	defer mp.dumpMtx.Unlock()
+
+	dumpDB, err := mp._getDumpDB()
+	if err != nil {
+		glog.Infof("LoadTxnsFromDB: %v", err)
+		return nil
+	}
+
+	// Try each generation newest-first so that one bad dump doesn't cost us the
+	// txns in the ones before it.
+	for _, slot := range DbGetMempoolDumpGenerations(dumpDB) {
+		dbMempoolTxnsOrderedByTime, err := DbGetMempoolDumpSlotTxnsSortedByTimeAdded(dumpDB, slot)
+		if err != nil {
+			glog.Errorf("LoadTxnsFromDB: Problem reading dump in slot %d, trying the "+
+				"previous one: %v", slot, err)
+			continue
+		}
+		return dbMempoolTxnsOrderedByTime
+	}
+	return nil
+}
+
+func (mp *BitCloutMempool) _loadTxnsFromLegacyDumpDirs() []*MsgBitCloutTxn {
+	// The mempool shuffled dumped txns between temp, previous, and latest dirs. By dumping txns
+	// to temp first, we ensured that we always had a full set of txns in latest and previous dir.
 	// Note that it is possible for previousDir to exist even if latestDir does not because
 	// the machine could crash after moving latest to previous. Thus, we check both.
 	savedTxnsDir := filepath.Join(mp.mempoolDir, "latest_mempool_dump")
@@ -2244,11 +6938,11 @@ func (mp *BitCloutMempool) LoadTxnsFromDB() {
 		_, err = os.Stat(savedTxnsDir)
 		if err != nil {
 			glog.Infof("LoadTxnsFromDB: os.Stat(previousDir) error: %v", err)
-			return
+			return nil
 		}
 	} else if err != nil {
 		glog.Infof("LoadTxnsFromDB: os.Stat(latestDir) error: %v", err)
-		return
+		return nil
 	}
 
 	// If we make it this far, we found a mempool dump to load.  Woohoo!
@@ -2259,63 +6953,189 @@ func (mp *BitCloutMempool) LoadTxnsFromDB() {
 	tempMempoolDB, err := badger.Open(tempMempoolDBOpts)
 	if err != nil {
 		glog.Infof("LoadTxnsFrom: Could not open temp db to dump mempool: %v", err)
-		return
+		return nil
 	}
 	defer tempMempoolDB.Close()
 
 	// Get all saved mempool transactions from the DB.
 	dbMempoolTxnsOrderedByTime, err := DbGetAllMempoolTxnsSortedByTimeAdded(tempMempoolDB)
 	if err != nil {
-		log.Fatalf("NewBitCloutMempool: Failed to get mempoolTxs from the DB: %v", err)
-	}
-
-	for _, mempoolTxn := range dbMempoolTxnsOrderedByTime {
-		_, err := mp.processTransaction(mempoolTxn, false, false, 0, false)
-		if err != nil {
-			// Log errors but don't stop adding transactions. We do this because we'd prefer
-			// to drop a transaction here or there rather than lose the whole block because
-			// of one bad apple.
-			glog.Warning(errors.Wrapf(err, "NewBitCloutMempool: Not adding txn from DB "+
-				"because it had an error: "))
-		}
+		// A corrupt dump shouldn't keep the node from starting. It can run fine with
+		// an empty pool, and the txns will be re-relayed by its peers anyway.
+		glog.Errorf("LoadTxnsFromDB: Problem reading mempool txns from dump %v, "+
+			"starting with an empty pool: %v", savedTxnsDir, err)
+		return nil
 	}
-	endTime := time.Now()
-	glog.Infof("LoadTxnsFromDB: Loaded %v txns in %v seconds", len(dbMempoolTxnsOrderedByTime), endTime.Sub(startTime).Seconds())
+	return dbMempoolTxnsOrderedByTime
 }
 
+// Stop shuts down the pool's background goroutines. If the pool is dumping its txns
+// to a mempoolDir, it also waits for the dumper to exit and then does one last dump
+// before returning so that txns added since the last periodic dump aren't lost.
 func (mp *BitCloutMempool) Stop() {
 	close(mp.quit)
+
+	if mp.mempoolDir == "" {
+		return
+	}
+	if mp.mempoolDBDumperDone != nil {
+		<-mp.mempoolDBDumperDone
+	}
+	// Make sure the dump includes txns that haven't made it into the readOnly view yet.
+	if err := mp.RegenerateReadOnlyView(); err != nil {
+		glog.Error(errors.Wrapf(err, "Stop: Problem regenerating readOnly view for final dump: "))
+	}
+	glog.Info("Stop: Dumping txns before shutting down...")
+	mp.DumpTxnsToDB()
+
+	mp.dumpMtx.Lock()
+	if mp.dumpDB != nil {
+		mp.dumpDB.Close()
+		mp.dumpDB = nil
+	}
+	mp.dumpMtx.Unlock()
+
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+	if mp.walFile != nil {
+		mp.walFile.Close()
+		mp.walFile = nil
+	}
+}
+
+// MempoolOptions holds the settings NewBitCloutMempool applies before it loads the
+// mempoolDir's dump and starts its background goroutines, so that they're in effect
+// from the first txn the pool sees. Start from DefaultMempoolOptions since several of
+// the zero values turn features off rather than picking their defaults. The settings
+// that can also be changed while the pool is running have a Set* method, whose
+// comment describes the setting.
+type MempoolOptions struct {
+	// See SetMaxTxnAge. When non-zero the expired txn sweeper is started along with
+	// the pool's other goroutines.
+	MaxTxnAge time.Duration
+	// See SetLightweightMode. When set, the pool never builds a backup view.
+	LightweightMode bool
+	// See SetBitcoinExchangeDustThreshold.
+	BitcoinExchangeDustThresholdSatoshis int64
+	// See SetDumpInterval.
+	DumpInterval time.Duration
+	// See SetReadOnlyViewRegenerationInterval.
+	ReadOnlyViewRegenerationInterval time.Duration
+	// When set, every txn accepted into the pool is also appended to a write-ahead
+	// log in the mempoolDir. The log is replayed along with the dump when the pool
+	// is created, so txns accepted since the last dump survive a crash. Has no
+	// effect without a mempoolDir.
+	EnableWAL bool
+	// See SetRecentlyConfirmedTxnsCacheSize.
+	RecentlyConfirmedTxnsCacheSize uint
+	// See SetNumRetainedDumpGenerations.
+	NumRetainedDumpGenerations int
+	// See SetComputeMetadataOnAccept.
+	ComputeMetadataOnAccept bool
+	// See SetDroppedTxnReasonsCacheSize.
+	DroppedTxnReasonsCacheSize uint
+	// See SetRejectTxnsWhileSyncing.
+	RejectTxnsWhileSyncing bool
+	// See SetMaxCombinedTxSizeBytes.
+	MaxCombinedTxSizeBytes uint64
+	// See SetBulkIndexTxnsOnLoad.
+	BulkIndexTxnsOnLoad bool
 }
 
-// Create a new pool with no transactions in it.
+// DefaultMempoolOptions returns the options NewBitCloutMempool uses when it's passed
+// nil.
+func DefaultMempoolOptions() *MempoolOptions {
+	return &MempoolOptions{
+		BitcoinExchangeDustThresholdSatoshis: DefaultBitcoinExchangeDustThresholdSatoshis,
+		DumpInterval:                         DefaultMempoolDBDumpInterval,
+		ReadOnlyViewRegenerationInterval:     time.Duration(ReadOnlyUtxoViewRegenerationIntervalSeconds) * time.Second,
+		RecentlyConfirmedTxnsCacheSize:       DefaultRecentlyConfirmedTxnsCacheSize,
+		NumRetainedDumpGenerations:           DefaultNumRetainedDumpGenerations,
+		ComputeMetadataOnAccept:              true,
+		DroppedTxnReasonsCacheSize:           DefaultDroppedTxnReasonsCacheSize,
+		BulkIndexTxnsOnLoad:                  true,
+	}
+}
+
+// Create a new pool with no transactions in it, configured with the given options,
+// or DefaultMempoolOptions if they're nil. Returns an error if any of the pool's
+// views can't be initialized or the WAL can't be opened.
 func NewBitCloutMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 	_minFeerateNanosPerKB uint64, _blockCypherAPIKey string,
-	_runReadOnlyViewUpdater bool, _dataDir string, _mempoolDumpDir string) *BitCloutMempool {
+	_runReadOnlyViewUpdater bool, _dataDir string, _mempoolDumpDir string,
+	_opts *MempoolOptions) (*BitCloutMempool, error) {
+
+	if _opts == nil {
+		_opts = DefaultMempoolOptions()
+	}
 
-	utxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
-	backupUtxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
-	readOnlyUtxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
+	utxoView, err := NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewBitCloutMempool: Problem initializing universalUtxoView: ")
+	}
+	// In lightweight mode there's no standing backup view to initialize.
+	var backupUtxoView *UtxoView
+	if !_opts.LightweightMode {
+		backupUtxoView, err = NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewBitCloutMempool: Problem initializing backupUniversalUtxoView: ")
+		}
+	}
+	readOnlyUtxoView, err := NewUtxoView(_bc.db, _bc.params, _bc.bitcoinManager)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewBitCloutMempool: Problem initializing readOnlyUtxoView: ")
+	}
 	newPool := &BitCloutMempool{
-		quit:                            make(chan struct{}),
-		bc:                              _bc,
-		rateLimitFeeRateNanosPerKB:      _rateLimitFeerateNanosPerKB,
-		minFeeRateNanosPerKB:            _minFeerateNanosPerKB,
-		poolMap:                         make(map[BlockHash]*MempoolTx),
-		unconnectedTxns:                 make(map[BlockHash]*UnconnectedTx),
-		unconnectedTxnsByPrev:           make(map[UtxoKey]map[BlockHash]*MsgBitCloutTxn),
-		outpoints:                       make(map[UtxoKey]*MsgBitCloutTxn),
-		pubKeyToTxnMap:                  make(map[PkMapKey]map[BlockHash]*MempoolTx),
-		unminedBitcoinTxns:              make(map[BlockHash]*MempoolTx),
-		blockCypherAPIKey:               _blockCypherAPIKey,
-		blockCypherCheckDoubleSpendChan: make(chan *MsgBitCloutTxn),
-		backupUniversalUtxoView:         backupUtxoView,
-		universalUtxoView:               utxoView,
-		mempoolDir:                      _mempoolDumpDir,
-		generateReadOnlyUtxoView:        _runReadOnlyViewUpdater,
-		readOnlyUtxoView:                readOnlyUtxoView,
-		readOnlyUniversalTransactionMap: make(map[BlockHash]*MempoolTx),
-		readOnlyOutpoints:               make(map[UtxoKey]*MsgBitCloutTxn),
-		dataDir:                         _dataDir,
+		quit:                                 make(chan struct{}),
+		bc:                                   _bc,
+		rateLimitFeeRateNanosPerKB:           _rateLimitFeerateNanosPerKB,
+		minFeeRateNanosPerKB:                 _minFeerateNanosPerKB,
+		poolMap:                              make(map[BlockHash]*MempoolTx),
+		unconnectedTxns:                      make(map[BlockHash]*UnconnectedTx),
+		unconnectedTxnOffers:                 make(map[BlockHash]*unconnectedTxnOffer),
+		publicKeySubscriptions:               make(map[PkMapKey]map[uint64]chan *MempoolTx),
+		unconnectedTxnsByPrev:                make(map[UtxoKey]map[BlockHash]*MsgBitCloutTxn),
+		outpoints:                            make(map[UtxoKey]*MsgBitCloutTxn),
+		pubKeyToTxnMap:                       make(map[PkMapKey]map[BlockHash]*MempoolTx),
+		txnTypeToTxnMap:                      make(map[TxnType]map[BlockHash]*MempoolTx),
+		txnTypeToTotalBytes:                  make(map[TxnType]uint64),
+		postHashToTxnMap:                     make(map[BlockHash]map[BlockHash]*MempoolTx),
+		profilePkToTxnMap:                    make(map[PkMapKey]map[BlockHash]*MempoolTx),
+		affectedPubKeyToTxnMap:               make(map[PkMapKey]map[BlockHash]*MempoolTx),
+		bulkIndexTxnsOnLoad:                  _opts.BulkIndexTxnsOnLoad,
+		txnTags:                              make(map[BlockHash]map[string]string),
+		unminedBitcoinTxns:                   make(map[BlockHash]*MempoolTx),
+		bitcoinHashToMempoolTx:               make(map[string]*MempoolTx),
+		blockCypherAPIKey:                    _blockCypherAPIKey,
+		blockCypherCheckDoubleSpendChan:      make(chan *MsgBitCloutTxn),
+		backupUniversalUtxoView:              backupUtxoView,
+		universalUtxoView:                    utxoView,
+		mempoolDir:                           _mempoolDumpDir,
+		generateReadOnlyUtxoView:             _runReadOnlyViewUpdater,
+		readOnlyUtxoView:                     readOnlyUtxoView,
+		readOnlyUniversalTransactionMap:      make(map[BlockHash]*MempoolTx),
+		readOnlyOutpoints:                    make(map[UtxoKey]*MsgBitCloutTxn),
+		dataDir:                              _dataDir,
+		nowFunc:                              time.Now,
+		avgBlockFillRate:                     1,
+		computeMetadataOnAccept:              _opts.ComputeMetadataOnAccept,
+		bitcoinExchangeDustThresholdSatoshis: _opts.BitcoinExchangeDustThresholdSatoshis,
+		dumpInterval:                         _dumpIntervalOrDefault(_opts.DumpInterval),
+		readOnlyViewRegenerationInterval:     _readOnlyViewRegenerationIntervalOrDefault(_opts.ReadOnlyViewRegenerationInterval),
+		numRetainedDumpGenerations:           _clampNumRetainedDumpGenerations(_opts.NumRetainedDumpGenerations),
+		recentlyConfirmedTxnsCacheSize:       _opts.RecentlyConfirmedTxnsCacheSize,
+		droppedTxnReasonsCacheSize:           _opts.DroppedTxnReasonsCacheSize,
+		maxTxnAge:                            _opts.MaxTxnAge,
+		lightweightMode:                      _opts.LightweightMode,
+		enableWAL:                            _opts.EnableWAL,
+		maxCombinedTxSizeBytes:               _opts.MaxCombinedTxSizeBytes,
+		readOnlySnapshot: &MempoolSnapshot{
+			TxnMap:       make(map[BlockHash]*MempoolTx),
+			SummaryStats: make(map[string]*SummaryStats),
+		},
+	}
+	if _opts.RejectTxnsWhileSyncing {
+		newPool.isSyncingFunc = newPool._isBlockchainSyncing
 	}
 
 	// TODO: DELETEME: This code is no longer needed because we check for double-spends up-front.
@@ -2329,8 +7149,18 @@ func NewBitCloutMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 
 	if newPool.mempoolDir != "" {
 		newPool.LoadTxnsFromDB()
+
+		// Open the WAL only once it's been replayed so that the replayed txns aren't
+		// appended to it again.
+		if newPool.enableWAL {
+			if err := newPool.openWAL(); err != nil {
+				return nil, errors.Wrapf(err, "NewBitCloutMempool: Problem opening WAL: ")
+			}
+		}
 	}
 
+	newPool._updateValidationHeight()
+
 	// If the caller wants the readOnlyUtxoView to update periodically then kick
 	// that off here.
 	if newPool.generateReadOnlyUtxoView {
@@ -2341,5 +7171,10 @@ func NewBitCloutMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 		newPool.StartMempoolDBDumper()
 	}
 
-	return newPool
+	if newPool.maxTxnAge != 0 {
+		newPool.expiredTxnSweeperStarted = true
+		newPool.StartExpiredTxnSweeper()
+	}
+
+	return newPool, nil
 }
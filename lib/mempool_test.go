@@ -1,9 +1,21 @@
 package lib
 
 import (
+	"container/heap"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/wire"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -40,6 +52,17 @@ func _setupFiveBlocks(t *testing.T) (*Blockchain, *BitCloutParams, []byte, []byt
 	return chain, params, senderPkBytes, recipientPkBytes
 }
 
+// _newTestMempool returns an empty pool with no fee limits, no readOnly view
+// updater, and no dump dir, which is what most mempool tests want.
+func _newTestMempool(t *testing.T, chain *Blockchain) *BitCloutMempool {
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	require.NoError(t, err)
+	return mp
+}
+
 // Create a chain of transactions that is too long for our mempool to
 // handle and ensure it gets rejected.
 func TestMempoolLongChainOfDependencies(t *testing.T) {
@@ -53,11 +76,12 @@ func TestMempoolLongChainOfDependencies(t *testing.T) {
 		senderPkString, recipientPkString, senderPrivString, nil)
 
 	// Validate this txn.
-	mp := NewBitCloutMempool(
+	mp, err := NewBitCloutMempool(
 		chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
-	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	_, err = mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
 	require.NoError(err)
 
 	prevTxn := txn1
@@ -112,10 +136,11 @@ func TestMempoolRateLimit(t *testing.T) {
 
 	// Create a new pool object that sets the min fees to zero. This object should
 	// accept all of the transactions we're about to create without fail.
-	mpNoMinFees := NewBitCloutMempool(
+	mpNoMinFees, err := NewBitCloutMempool(
 		chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
 
 	// Create a transaction that sends 1 BitClout to the recipient as its
 	// zeroth output.
@@ -123,15 +148,16 @@ func TestMempoolRateLimit(t *testing.T) {
 		senderPkString, recipientPkString, senderPrivString, nil)
 
 	// Validate this txn with the no-fee mempool.
-	_, err := mpNoMinFees.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	_, err = mpNoMinFees.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
 	require.NoError(err)
 
 	// If we set a min fee, the transactions should just be immediately rejected
 	// if we set rateLimit to true.
-	mpWithMinFee := NewBitCloutMempool(
+	mpWithMinFee, err := NewBitCloutMempool(
 		chain, 0, /* rateLimitFeeRateNanosPerKB */
 		100 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
 	_, err = mpWithMinFee.processTransaction(txn1, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
 	require.Error(err)
 	require.Contains(err.Error(), TxErrorInsufficientFeeMinFee)
@@ -177,10 +203,11 @@ func TestMempoolRateLimit(t *testing.T) {
 	// Processing 24 transactions very quickly should cause our rate
 	// limit to trigger if it's set even if we don't have a hard min
 	// feerate set since 24 transactions should be ~2400 bytes.
-	mpWithRateLimit := NewBitCloutMempool(
+	mpWithRateLimit, err := NewBitCloutMempool(
 		chain, 100, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
 	processingErrors := []error{}
 	for _, txn := range txnsCreated {
 		_, err := mpWithRateLimit.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
@@ -298,10 +325,11 @@ func TestMempoolAugmentedUtxoViewTransactionChain(t *testing.T) {
 
 	// Create a new pool object. Set the min fees to zero since we're
 	// not testing that here.
-	mp := NewBitCloutMempool(
+	mp, err := NewBitCloutMempool(
 		chain, 0, /* rateLimitFeeRateNanosPerKB */
 		0 /* minFeeRateNanosPerKB */, "", true,
-		"" /*dataDir*/, "")
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
 
 	// Process the first transaction.
 	mempoolTx1, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
@@ -422,3 +450,4793 @@ func TestMempoolAugmentedUtxoViewTransactionChain(t *testing.T) {
 
 	_, _, _, _, _ = mempoolTx1, mempoolTx2, mempoolTx3, mempoolTx4, params
 }
+
+func TestMempoolSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	// Don't run the readOnly view updater so that the snapshot only changes when
+	// we regenerate it explicitly.
+	mp := _newTestMempool(t, chain)
+
+	// A fresh pool should return an empty snapshot rather than nil.
+	snapshot := mp.GetMempoolSnapshot()
+	require.NotNil(snapshot)
+	require.Equal(0, len(snapshot.Txns))
+	require.Equal(0, len(snapshot.TxnMap))
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// The snapshot shouldn't change until the readOnly view is regenerated.
+	require.Equal(snapshot, mp.GetMempoolSnapshot())
+	require.NoError(mp.regenerateReadOnlyView())
+
+	newSnapshot := mp.GetMempoolSnapshot()
+	require.Equal(snapshot.SequenceNumber+1, newSnapshot.SequenceNumber)
+	require.Equal(1, len(newSnapshot.Txns))
+	require.Contains(newSnapshot.TxnMap, *txn1.Hash())
+	basicTransferStats := newSnapshot.SummaryStats[TxnTypeBasicTransfer.String()]
+	require.NotNil(basicTransferStats)
+	require.Equal(uint32(1), basicTransferStats.Count)
+	require.Equal(newSnapshot.Txns[0].TxSizeBytes, basicTransferStats.TotalBytes)
+
+	// The old snapshot should be unaffected by the regeneration.
+	require.Equal(0, len(snapshot.Txns))
+}
+
+// Create two unconnected txns that spend the same output of a parent txn and
+// make sure the one paying the higher feerate is the one that gets promoted
+// once the parent arrives.
+func TestMempoolCompetingUnconnectedTxns(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	// The parent sends 10 nanos to the recipient as its zeroth output.
+	parentTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	parentTxnHash := parentTxn.Hash()
+
+	// Both children spend the recipient's output from the parent. The first
+	// pays a fee of 1 nano and the second pays a fee of 9 nanos.
+	makeChild := func(amountNanos uint64) *MsgBitCloutTxn {
+		childTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{
+				&BitCloutInput{
+					TxID:  *parentTxnHash,
+					Index: 0,
+				},
+			},
+			TxOutputs: []*BitCloutOutput{
+				&BitCloutOutput{
+					PublicKey:   senderPkBytes,
+					AmountNanos: amountNanos,
+				},
+			},
+			PublicKey: recipientPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, childTxn, recipientPrivString)
+		return childTxn
+	}
+	lowFeeChild := makeChild(9)
+	highFeeChild := makeChild(1)
+
+	// Run this a few times since map iteration order is random.
+	for ii := 0; ii < 10; ii++ {
+		mp := _newTestMempool(t, chain)
+
+		_, err := mp.processTransaction(lowFeeChild, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		_, err = mp.processTransaction(highFeeChild, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.Equal(2, len(mp.unconnectedTxns))
+
+		acceptedTxns, err := mp.processTransaction(parentTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.Equal(2, len(acceptedTxns))
+		require.Equal(*highFeeChild.Hash(), *acceptedTxns[1].Hash)
+
+		// The losing child is a double-spend now so it should have been removed.
+		require.Equal(0, len(mp.unconnectedTxns))
+	}
+}
+
+func TestMempoolRejectsOutputsExceedingInputs(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Send 10 nanos to the recipient.
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Have the recipient try to send 11 nanos using only that output.
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 11,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorTxnOutputExceedsInput)
+
+	// Spending exactly what's available should still be fine.
+	txn2.TxOutputs[0].AmountNanos = 10
+	_signTxn(t, txn2, recipientPrivString)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+}
+
+// Use a fake clock to make sure unconnected txns expire without having to
+// actually wait for them to.
+func TestMempoolUnconnectedTxnExpirationFakeClock(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	// Make two txns that each spend an output of a txn the pool has never seen.
+	makeUnconnectedTxn := func(amountNanos uint64) *MsgBitCloutTxn {
+		unconnectedTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{
+				&BitCloutInput{
+					TxID:  BlockHash{0x01},
+					Index: 0,
+				},
+			},
+			TxOutputs: []*BitCloutOutput{
+				&BitCloutOutput{
+					PublicKey:   senderPkBytes,
+					AmountNanos: amountNanos,
+				},
+			},
+			PublicKey: recipientPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, unconnectedTxn, recipientPrivString)
+		return unconnectedTxn
+	}
+	unconnectedTxn1 := makeUnconnectedTxn(1)
+	unconnectedTxn2 := makeUnconnectedTxn(2)
+
+	_, err := mp.processTransaction(unconnectedTxn1, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.unconnectedTxns))
+
+	// Advancing the clock by less than the expiration interval shouldn't expire
+	// anything.
+	fakeNow = fakeNow.Add(UnconnectedTxnExpirationInterval / 2)
+	_, err = mp.processTransaction(unconnectedTxn2, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(mp.unconnectedTxns))
+
+	// Advancing it past the first txn's expiration should evict it the next time
+	// the pool checks, but leave the second one alone.
+	fakeNow = fakeNow.Add(UnconnectedTxnExpirationInterval/2 + time.Second)
+	require.NoError(mp.limitNumUnconnectedTxns())
+	require.Equal(1, len(mp.unconnectedTxns))
+	require.Contains(mp.unconnectedTxns, *unconnectedTxn2.Hash())
+}
+
+// Use a fake clock to make sure removeExpiredTransactions only evicts txns that are
+// older than maxTxnAge, along with the txns that depend on them.
+func TestMempoolRemoveExpiredTransactions(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	startTime := time.Unix(1600000000, 0)
+	fakeNow := startTime
+	mp.nowFunc = func() time.Time { return fakeNow }
+	mp.maxTxnAge = time.Hour
+
+	processTxn := func(txn *MsgBitCloutTxn, privKey string) *MempoolTx {
+		_signTxn(t, txn, privKey)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.Equal(1, len(acceptedTxs))
+		return acceptedTxs[0]
+	}
+	// Spend different block rewards so that the txns don't depend on each other.
+	utxoEntries, err := chain.GetSpendableUtxosForPublicKey(senderPkBytes, nil, nil)
+	require.NoError(err)
+	require.GreaterOrEqual(len(utxoEntries), 2)
+	spendUtxo := func(utxoEntry *UtxoEntry) *MempoolTx {
+		return processTxn(&MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{(*BitCloutInput)(utxoEntry.UtxoKey)},
+			TxOutputs: []*BitCloutOutput{
+				{PublicKey: recipientPkBytes, AmountNanos: utxoEntry.AmountNanos - 1000},
+			},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}, senderPrivString)
+	}
+
+	oldTx := spendUtxo(utxoEntries[0])
+	fakeNow = fakeNow.Add(30 * time.Minute)
+	childTx := processTxn(&MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: *oldTx.Hash, Index: 0}},
+		TxOutputs: []*BitCloutOutput{{PublicKey: senderPkBytes, AmountNanos: 1}},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}, recipientPrivString)
+	newTx := spendUtxo(utxoEntries[1])
+
+	// Nothing has been in the pool for an hour yet.
+	fakeNow = startTime.Add(time.Hour)
+	numExpired, evictedTxns := mp.removeExpiredTransactions()
+	require.Equal(0, numExpired)
+	require.Empty(evictedTxns)
+	require.Equal(3, len(mp.poolMap))
+
+	// The oldest txn expires and takes its child with it, even though the child
+	// itself is only half an hour old.
+	fakeNow = startTime.Add(time.Hour + time.Second)
+	numExpired, evictedTxns = mp.removeExpiredTransactions()
+	require.Equal(1, numExpired)
+	evictedReasons := make(map[BlockHash]string)
+	for _, evicted := range evictedTxns {
+		evictedReasons[*evicted.mempoolTx.Hash] = evicted.reason
+	}
+	require.Equal(map[BlockHash]string{
+		*oldTx.Hash:   EvictReasonExpired,
+		*childTx.Hash: EvictReasonDependencyEvicted,
+	}, evictedReasons)
+	require.Equal(1, len(mp.poolMap))
+
+	// The surviving txn keeps the time it was originally added rather than the time
+	// of the rebuild.
+	require.Contains(mp.poolMap, *newTx.Hash)
+	require.Equal(startTime.Add(30*time.Minute), mp.poolMap[*newTx.Hash].Added)
+
+	// A zero maxTxnAge means txns never expire.
+	mp.maxTxnAge = 0
+	fakeNow = fakeNow.Add(24 * time.Hour)
+	numExpired, _ = mp.removeExpiredTransactions()
+	require.Equal(0, numExpired)
+	require.Equal(1, len(mp.poolMap))
+
+	// Disconnecting a block keeps the time the txns were originally added too, so a
+	// reorg doesn't restart their clock.
+	mp.maxTxnAge = time.Hour
+	fakeNow = startTime.Add(time.Hour)
+	mp.UpdateAfterDisconnectBlock(&MsgBitCloutBlock{
+		Header: &MsgBitCloutHeader{Height: uint64(chain.blockTip().Height)},
+		Txns:   []*MsgBitCloutTxn{{TxnMeta: &BlockRewardMetadataa{}}},
+	})
+	require.Equal(startTime.Add(30*time.Minute), mp.poolMap[*newTx.Hash].Added)
+	fakeNow = startTime.Add(90*time.Minute + time.Second)
+	numExpired, _ = mp.removeExpiredTransactions()
+	require.Equal(1, numExpired)
+	require.Empty(mp.poolMap)
+}
+
+func TestMempoolUnconnectedTxnReofferBackoff(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+	firstExpiration := fakeNow.Add(UnconnectedTxnExpirationInterval)
+
+	unconnectedTxn := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  BlockHash{0x01},
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, unconnectedTxn, recipientPrivString)
+	offerTxn := func() error {
+		_, err := mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 1 /*peerID*/, false /*verifySignatures*/)
+		return err
+	}
+
+	// Each time the peer drops and re-sends the txn it keeps its first expiration.
+	for ii := 0; ii < MaxUnconnectedTxnOffers; ii++ {
+		require.NoError(offerTxn())
+		require.Equal(firstExpiration, mp.unconnectedTxns[*unconnectedTxn.Hash()].expiration)
+		require.Equal(1, mp.RemoveUnconnectedTxnsFromPeer(1))
+		fakeNow = fakeNow.Add(time.Minute)
+	}
+
+	// Past the limit the txn is refused.
+	err := offerTxn()
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorUnconnectedTxnOfferedTooOften)
+	require.Empty(mp.unconnectedTxns)
+
+	// Once it would have expired it can be offered again with a new expiration.
+	fakeNow = firstExpiration.Add(time.Second)
+	require.NoError(offerTxn())
+	require.Equal(fakeNow.Add(UnconnectedTxnExpirationInterval),
+		mp.unconnectedTxns[*unconnectedTxn.Hash()].expiration)
+
+	// A pool rebuild doesn't push the expiration back either.
+	fakeNow = fakeNow.Add(time.Minute)
+	mp.UpdateAfterConnectBlock(&MsgBitCloutBlock{
+		Txns: []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}},
+	})
+	require.Equal(fakeNow.Add(-time.Minute).Add(UnconnectedTxnExpirationInterval),
+		mp.unconnectedTxns[*unconnectedTxn.Hash()].expiration)
+}
+
+func TestMempoolGetTransactionWithAncestors(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// txn1 sends 10 nanos to the recipient, txn2 sends them back to the sender,
+	// and txn3 sends them back to the recipient again.
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	txn3 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn2.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   recipientPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn3, senderPrivString)
+
+	for _, txn := range []*MsgBitCloutTxn{txn1, txn2, txn3} {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+
+	txnsWithAncestors := mp.GetTransactionWithAncestors(txn3.Hash())
+	require.Equal(3, len(txnsWithAncestors))
+	require.Equal(*txn1.Hash(), *txnsWithAncestors[0].Hash())
+	require.Equal(*txn2.Hash(), *txnsWithAncestors[1].Hash())
+	require.Equal(*txn3.Hash(), *txnsWithAncestors[2].Hash())
+
+	// txn1 only spends confirmed utxos so it has no ancestors.
+	txnsWithAncestors = mp.GetTransactionWithAncestors(txn1.Hash())
+	require.Equal(1, len(txnsWithAncestors))
+	require.Equal(*txn1.Hash(), *txnsWithAncestors[0].Hash())
+
+	require.Nil(mp.GetTransactionWithAncestors(&BlockHash{0x01}))
+}
+
+func TestMempoolGetTransactionMetadata(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	for _, txn := range []*MsgBitCloutTxn{txn1, txn2} {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+
+	// The cached metadata should be returned as-is.
+	mempoolTx := mp.GetTransaction(txn2.Hash())
+	require.NotNil(mempoolTx.TxMeta)
+	require.True(mempoolTx.TxMeta == mp.GetTransactionMetadata(txn2.Hash()))
+
+	// Without cached metadata it should be recomputed on top of txn1.
+	cachedTxMeta := mempoolTx.TxMeta
+	mempoolTx.TxMeta = nil
+	txnMeta := mp.GetTransactionMetadata(txn2.Hash())
+	require.NotNil(txnMeta)
+	require.Equal(cachedTxMeta.TxnType, txnMeta.TxnType)
+	require.Equal(cachedTxMeta.TransactorPublicKeyBase58Check, txnMeta.TransactorPublicKeyBase58Check)
+	require.Equal(len(cachedTxMeta.AffectedPublicKeys), len(txnMeta.AffectedPublicKeys))
+
+	// The MempoolTx shared with the readOnly view is left alone. A copy carrying the
+	// metadata takes its place in the pool instead.
+	require.Nil(mempoolTx.TxMeta)
+	require.True(mp.poolMap[*txn2.Hash()].TxMeta == txnMeta)
+	require.True(mp.universalTransactionList[1] == mp.poolMap[*txn2.Hash()])
+	require.NoError(mp.regenerateReadOnlyView())
+	require.True(mp.GetTransactionMetadata(txn2.Hash()) == txnMeta)
+
+	require.Nil(mp.GetTransactionMetadata(&BlockHash{0x01}))
+}
+
+func TestMempoolSkipComputeMetadataOnAccept(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	mp.SetComputeMetadataOnAccept(false)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Nil(acceptedTxs[0].TxMeta)
+
+	// Rebuilding the pool doesn't compute it either.
+	mp.rebuildPool(EvictReasonRemoved)
+	require.Nil(mp.poolMap[*txn.Hash()].TxMeta)
+
+	// It's still available on demand.
+	txnMeta := mp.GetTransactionMetadata(txn.Hash())
+	require.NotNil(txnMeta)
+	require.Equal(TxnTypeBasicTransfer.String(), txnMeta.TxnType)
+}
+
+func TestMempoolLocalTxnsNotRateLimited(t *testing.T) {
+	require := require.New(t)
+
+	// With a zero limit every low-fee txn from a peer gets rate-limited.
+	oldLimit := LowFeeTxLimitBytesPerTenMinutes
+	LowFeeTxLimitBytesPerTenMinutes = 0
+	defer func() { LowFeeTxLimitBytesPerTenMinutes = oldLimit }()
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp, err := NewBitCloutMempool(
+		chain, 100, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, _, err = mp.TryAcceptTransaction(txn1, true /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorInsufficientFeeRateLimit)
+
+	// The same txn submitted locally should go right in.
+	_, mempoolTx, err := mp.TryAcceptLocalTransaction(txn1, true /*verifySignatures*/)
+	require.NoError(err)
+	require.True(mempoolTx.Local)
+}
+
+func TestMempoolLocalTxnsExemptFromFeeEviction(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Spend different block rewards so that evicting one txn doesn't take any of the
+	// others with it.
+	utxoEntries, err := chain.GetSpendableUtxosForPublicKey(senderPkBytes, nil, nil)
+	require.NoError(err)
+	require.GreaterOrEqual(len(utxoEntries), 3)
+	makeTxn := func(utxoEntry *UtxoEntry, feeNanos uint64) *MsgBitCloutTxn {
+		txn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{(*BitCloutInput)(utxoEntry.UtxoKey)},
+			TxOutputs: []*BitCloutOutput{
+				{PublicKey: recipientPkBytes, AmountNanos: utxoEntry.AmountNanos - feeNanos},
+			},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, txn, senderPrivString)
+		return txn
+	}
+
+	peerTxn := makeTxn(utxoEntries[0], 300)
+	_, _, err = mp.TryAcceptTransaction(peerTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	localTxn := makeTxn(utxoEntries[1], 100)
+	_, _, err = mp.TryAcceptLocalTransaction(localTxn, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// The local txn pays the lowest fee but can't be evicted, and the new txn doesn't
+	// outbid the peer txn, so there's no room for it.
+	newTxn := makeTxn(utxoEntries[2], 200)
+	mp.SetTxnTypeLimits(map[TxnType]int{TxnTypeBasicTransfer: 2})
+	_, _, err = mp.TryAcceptTransaction(newTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeLimitReached)
+	require.Contains(mp.poolMap, *localTxn.Hash())
+	require.Contains(mp.poolMap, *peerTxn.Hash())
+
+	// Same goes for the byte limit.
+	mp.SetTxnTypeLimits(nil)
+	mp.SetTxnTypeByteLimits(map[TxnType]uint64{
+		TxnTypeBasicTransfer: mp.txnTypeToTotalBytes[TxnTypeBasicTransfer],
+	})
+	_, _, err = mp.TryAcceptTransaction(newTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeByteLimitReached)
+	require.Contains(mp.poolMap, *localTxn.Hash())
+	require.Contains(mp.poolMap, *peerTxn.Hash())
+
+	// The local txn stays local, and so stays exempt, after a block is disconnected.
+	mp.UpdateAfterDisconnectBlock(&MsgBitCloutBlock{
+		Header: &MsgBitCloutHeader{Height: uint64(chain.blockTip().Height)},
+		Txns:   []*MsgBitCloutTxn{{TxnMeta: &BlockRewardMetadataa{}}},
+	})
+	require.True(mp.poolMap[*localTxn.Hash()].Local)
+	require.False(mp.poolMap[*peerTxn.Hash()].Local)
+	_, _, err = mp.TryAcceptTransaction(newTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeByteLimitReached)
+	require.Contains(mp.poolMap, *localTxn.Hash())
+}
+
+func TestMempoolGetTransactionsInRelayOrder(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	utxoEntries, err := chain.GetSpendableUtxosForPublicKey(senderPkBytes, nil, nil)
+	require.NoError(err)
+	require.GreaterOrEqual(len(utxoEntries), 2)
+	makeTxn := func(utxoEntry *UtxoEntry) *MsgBitCloutTxn {
+		txn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{(*BitCloutInput)(utxoEntry.UtxoKey)},
+			TxOutputs: []*BitCloutOutput{
+				{PublicKey: recipientPkBytes, AmountNanos: utxoEntry.AmountNanos},
+			},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, txn, senderPrivString)
+		return txn
+	}
+
+	// Two txns from peers, then a local txn that spends from the second one.
+	peerTxn := makeTxn(utxoEntries[0])
+	_, _, err = mp.TryAcceptTransaction(peerTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	parentTxn := makeTxn(utxoEntries[1])
+	_, _, err = mp.TryAcceptTransaction(parentTxn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	localTxn := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: *parentTxn.Hash(), Index: 0}},
+		TxOutputs: []*BitCloutOutput{{PublicKey: senderPkBytes, AmountNanos: 1}},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, localTxn, recipientPrivString)
+	_, _, err = mp.TryAcceptLocalTransaction(localTxn, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// The local txn goes first, right after the txn it spends from.
+	relayHashes := []BlockHash{}
+	for _, mempoolTx := range mp.GetTransactionsInRelayOrder() {
+		relayHashes = append(relayHashes, *mempoolTx.Hash)
+	}
+	require.Equal([]BlockHash{*parentTxn.Hash(), *localTxn.Hash(), *peerTxn.Hash()}, relayHashes)
+}
+
+func TestMempoolRemoveFromPubKeyOutputMap(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxs, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.PublicKeyTxnMap(senderPkBytes)))
+	require.Equal(1, len(mp.PublicKeyTxnMap(recipientPkBytes)))
+
+	// Removing the only txn for each public key should delete their entries
+	// entirely rather than leaving empty maps behind.
+	mp._removeMempoolTxFromPubKeyOutputMap(mempoolTxs[0])
+	require.Equal(0, len(mp.pubKeyToTxnMap))
+
+	// Removing it again should be a no-op.
+	mp._removeMempoolTxFromPubKeyOutputMap(mempoolTxs[0])
+	require.Equal(0, len(mp.pubKeyToTxnMap))
+}
+
+func TestMempoolReadOnlyViewLag(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Equal(int64(0), mp.GetReadOnlyViewLag())
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(int64(1), mp.GetReadOnlyViewLag())
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	require.Equal(int64(0), mp.GetReadOnlyViewLag())
+}
+
+func TestMempoolLightweightMode(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	mp.SetLightweightMode(true)
+	require.Nil(mp.backupUniversalUtxoView)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Nil(mp.backupUniversalUtxoView)
+
+	// A txn that builds on the first should validate against a copy of the
+	// universal view that includes it.
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	mempoolTxs, err := mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NotNil(mempoolTxs[0].TxMeta)
+	require.Nil(mp.backupUniversalUtxoView)
+
+	// Double-spending the same output should fail without breaking the pool.
+	txn3 := &MsgBitCloutTxn{
+		TxInputs:  txn2.TxInputs,
+		TxOutputs: []*BitCloutOutput{txn2.TxOutputs[0], txn2.TxOutputs[0]},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn3, recipientPrivString)
+	_, err = mp.processTransaction(txn3, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Nil(mp.backupUniversalUtxoView)
+	require.Equal(2, len(mp.poolMap))
+}
+
+func TestMempoolDiamondMetadataOnBasicTransfer(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Attach a diamond to a basic transfer. The post doesn't exist so no poster
+	// should be added to the affected public keys.
+	postHash := &BlockHash{0x01}
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn.ExtraData = map[string][]byte{
+		DiamondLevelKey:    IntToBuf(2),
+		DiamondPostHashKey: postHash[:],
+	}
+	_signTxn(t, txn, senderPrivString)
+
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mempoolTxs))
+
+	txnMeta := mempoolTxs[0].TxMeta
+	require.NotNil(txnMeta)
+	require.NotNil(txnMeta.DiamondTxindexMetadata)
+	require.Equal(int64(2), txnMeta.DiamondTxindexMetadata.DiamondLevel)
+	require.Equal(hex.EncodeToString(postHash[:]), txnMeta.DiamondTxindexMetadata.PostHashHex)
+	require.Nil(txnMeta.CreatorCoinTransferTxindexMetadata)
+	for _, affectedPk := range txnMeta.AffectedPublicKeys {
+		require.NotEqual("DiamondPosterPublicKeyBase58Check", affectedPk.Metadata)
+	}
+}
+
+func TestMempoolMaxPendingTxnsPerPublicKey(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	mp.SetMaxPendingTxnsPerPublicKey(2)
+
+	// The sender can get two txns into the pool but not a third.
+	senderTxns := []*MsgBitCloutTxn{}
+	for ii := 0; ii < 2; ii++ {
+		// Regenerate the readOnly view so that inputs spent by the previous txn
+		// aren't picked again.
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, uint64(10+ii), 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		senderTxns = append(senderTxns, txn)
+	}
+	require.Equal(2, mp.GetPendingTxnCountForPublicKey(senderPkBytes))
+
+	require.NoError(mp.regenerateReadOnlyView())
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 12, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTooManyPendingForPublicKey)
+
+	// Being the recipient of the sender's txns doesn't count against the
+	// recipient's cap.
+	require.Equal(0, mp.GetPendingTxnCountForPublicKey(recipientPkBytes))
+	txn = &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *senderTxns[0].Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn, recipientPrivString)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, mp.GetPendingTxnCountForPublicKey(recipientPkBytes))
+}
+
+func TestMempoolRejectsNilTxnMeta(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn.TxnMeta = nil
+
+	_, err := mp.ProcessTransaction(txn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorNilTxnMeta)
+
+	_, _, err = mp.TryAcceptTransaction(txn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorNilTxnMeta)
+}
+
+func TestMempoolAddTransactionRollsBackOnUniversalViewFailure(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	totalTxSizeBytes := mp.totalTxSizeBytes
+
+	// A txn that double-spends txn1 can only get this far if something has gone
+	// badly wrong with the backup view, so call addTransaction directly to
+	// simulate that.
+	txn1DoubleSpend := &MsgBitCloutTxn{
+		TxInputs: txn1.TxInputs,
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   recipientPkBytes,
+				AmountNanos: 11,
+			},
+		},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn1DoubleSpend, senderPrivString)
+	bestHeight := uint32(chain.blockTip().Height + 1)
+	_, err = mp.addTransaction(txn1DoubleSpend, bestHeight, 0, false /*updateBackupView*/)
+	require.Error(err)
+
+	// None of the double-spend's bookkeeping should have stuck.
+	require.Equal(1, len(mp.poolMap))
+	require.Equal(1, len(mp.txFeeMinheap))
+	require.Equal(1, len(mp.universalTransactionList))
+	require.Equal(totalTxSizeBytes, mp.totalTxSizeBytes)
+	for _, txIn := range txn1.TxInputs {
+		require.Equal(txn1, mp.outpoints[UtxoKey(*txIn)])
+	}
+	for _, mempoolTx := range mp.PublicKeyTxnMap(recipientPkBytes) {
+		require.Equal(*txn1.Hash(), *mempoolTx.Hash)
+	}
+
+	// The universal view should still be usable for txns that build on txn1.
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(mp.poolMap))
+}
+
+func TestMempoolAddTransactionLeavesPoolUnchangedOnBackupViewFailure(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn1DoubleSpend := &MsgBitCloutTxn{
+		TxInputs: txn1.TxInputs,
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   recipientPkBytes,
+				AmountNanos: 11,
+			},
+		},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn1DoubleSpend, senderPrivString)
+
+	// Spend txn1's inputs in the backup view only so that txn1 connects to the
+	// universal view but not to the backup view.
+	bestHeight := uint32(chain.blockTip().Height + 1)
+	_, _, _, _, err := mp.backupUniversalUtxoView._connectTransaction(
+		txn1DoubleSpend, txn1DoubleSpend.Hash(), 0, bestHeight, false, /*verifySignatures*/
+		false, /*checkMerkleProof*/
+		0, false /*ignoreUtxos*/)
+	require.NoError(err)
+
+	_, err = mp.addTransaction(txn1, bestHeight, 0, true /*updateBackupView*/)
+	require.Error(err)
+
+	// None of txn1's bookkeeping should have been applied.
+	require.Equal(0, len(mp.poolMap))
+	require.Equal(0, len(mp.txFeeMinheap))
+	require.Equal(0, len(mp.universalTransactionList))
+	require.Equal(0, len(mp.outpoints))
+	require.Equal(uint64(0), mp.totalTxSizeBytes)
+	require.Equal(0, len(mp.PublicKeyTxnMap(recipientPkBytes)))
+	for _, txIn := range txn1.TxInputs {
+		utxoEntry := mp.universalUtxoView.GetUtxoEntryForUtxoKey((*UtxoKey)(txIn))
+		require.NotNil(utxoEntry)
+		require.False(utxoEntry.isSpent)
+	}
+
+	// The backup view was rebuilt from the pool so txn1 can now be added.
+	_, err = mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.poolMap))
+}
+
+func TestMempoolFeeHistogram(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txns := []*MsgBitCloutTxn{}
+	for _, feeRateNanosPerKB := range []uint64{0, 10000} {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		txns = append(txns, txn)
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+	lowFeeTx := mp.GetTransaction(txns[0].Hash())
+	highFeeTx := mp.GetTransaction(txns[1].Hash())
+	require.True(highFeeTx.FeePerKB >= 5000)
+
+	// Buckets don't need to be passed in sorted order.
+	feeHistogram := mp.GetFeeHistogram([]uint64{5000, 0, 1000000})
+	require.Equal(3, len(feeHistogram))
+	require.Equal(SummaryStats{Count: 1, TotalBytes: lowFeeTx.TxSizeBytes}, feeHistogram[0])
+	require.Equal(SummaryStats{Count: 1, TotalBytes: highFeeTx.TxSizeBytes}, feeHistogram[5000])
+	require.Equal(SummaryStats{}, feeHistogram[1000000])
+
+	// Txns below the lowest bucket aren't counted.
+	feeHistogram = mp.GetFeeHistogram([]uint64{5000})
+	require.Equal(uint32(1), feeHistogram[5000].Count)
+}
+
+func TestMempoolEstimateInclusionWithinBlocks(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Nothing is ahead of a txn in an empty pool.
+	require.Equal(1.0, mp.EstimateInclusionWithinBlocks(0, 1))
+	require.Equal(0.0, mp.EstimateInclusionWithinBlocks(0, 0))
+
+	mempoolTxs := []*MempoolTx{}
+	for _, feeRateNanosPerKB := range []uint64{1000, 2000, 3000} {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+
+	// Pretend blocks only have room for the highest-fee txn.
+	highFeeTx := mempoolTxs[2]
+	mp.avgBlockFillRate = float64(highFeeTx.TxSizeBytes) / float64(params.MinerMaxBlockSizeBytes)
+
+	require.Equal(1.0, mp.EstimateInclusionWithinBlocks(highFeeTx.FeePerKB+1, 1))
+	require.InDelta(0.5, mp.EstimateInclusionWithinBlocks(highFeeTx.FeePerKB, 1), 0.01)
+	// More blocks make inclusion more likely.
+	require.Greater(mp.EstimateInclusionWithinBlocks(0, 3), mp.EstimateInclusionWithinBlocks(0, 1))
+
+	// The estimate never decreases as the fee rate goes up.
+	prevEstimate := 0.0
+	for feePerKB := uint64(0); feePerKB <= highFeeTx.FeePerKB+1000; feePerKB += 100 {
+		estimate := mp.EstimateInclusionWithinBlocks(feePerKB, 1)
+		require.GreaterOrEqual(estimate, prevEstimate)
+		prevEstimate = estimate
+	}
+}
+
+func TestMempoolTryAcceptTransactionAtHeight(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// The txn spends a block reward, which is immature as of the block it was
+	// mined in.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	utxoView, err := NewUtxoView(chain.db, chain.params, nil)
+	require.NoError(err)
+	utxoKey := UtxoKey(*txn.TxInputs[0])
+	utxoEntry := utxoView.GetUtxoEntryForUtxoKey(&utxoKey)
+	require.Equal(UtxoTypeBlockReward, utxoEntry.UtxoType)
+	_, _, err = mp.TryAcceptTransactionAtHeight(
+		txn, false /*rateLimit*/, true /*verifySignatures*/, utxoEntry.BlockHeight)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorInputSpendsImmatureBlockReward)
+
+	validationHeight := uint32(chain.blockTip().Height + 1)
+	_, mempoolTx, err := mp.TryAcceptTransactionAtHeight(
+		txn, false /*rateLimit*/, true /*verifySignatures*/, validationHeight)
+	require.NoError(err)
+	require.Equal(validationHeight, mempoolTx.Height)
+}
+
+func TestMempoolRemoveUnconnectedTxnsFromPeer(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Send two unconnected txns from peer 1 and one from peer 2.
+	for ii, peerID := range []uint64{1, 1, 2} {
+		unconnectedTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{
+				&BitCloutInput{
+					TxID:  BlockHash{0x01},
+					Index: uint32(ii),
+				},
+			},
+			TxOutputs: []*BitCloutOutput{
+				&BitCloutOutput{
+					PublicKey:   senderPkBytes,
+					AmountNanos: 1,
+				},
+			},
+			PublicKey: recipientPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, unconnectedTxn, recipientPrivString)
+		_, err := mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, peerID, false /*verifySignatures*/)
+		require.NoError(err)
+	}
+	require.Equal(3, len(mp.unconnectedTxns))
+
+	require.Equal(2, mp.RemoveUnconnectedTxnsFromPeer(1))
+	require.Equal(1, len(mp.unconnectedTxns))
+	for _, unconnectedTxn := range mp.unconnectedTxns {
+		require.Equal(uint64(2), unconnectedTxn.peerID)
+	}
+	require.Equal(1, len(mp.unconnectedTxnsByPrev))
+
+	// Removing txns for a peer that didn't send any is a no-op.
+	require.Equal(0, mp.RemoveUnconnectedTxnsFromPeer(3))
+	require.Equal(1, len(mp.unconnectedTxns))
+}
+
+func TestMempoolMaxCombinedTxSizeBytes(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	connectedTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err := mp.processTransaction(connectedTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	addUnconnectedTxn := func(index uint32) (*MsgBitCloutTxn, error) {
+		unconnectedTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{
+				&BitCloutInput{
+					TxID:  BlockHash{0x01},
+					Index: index,
+				},
+			},
+			TxOutputs: []*BitCloutOutput{
+				&BitCloutOutput{
+					PublicKey:   senderPkBytes,
+					AmountNanos: 1,
+				},
+			},
+			PublicKey: recipientPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, unconnectedTxn, recipientPrivString)
+		_, err := mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+		return unconnectedTxn, err
+	}
+
+	// Without a cap, unconnected txns are tracked but don't count toward the pool's
+	// size.
+	totalTxSizeBytes := mp.totalTxSizeBytes
+	oldestTxn, err := addUnconnectedTxn(0)
+	require.NoError(err)
+	newerTxn, err := addUnconnectedTxn(1)
+	require.NoError(err)
+	require.Equal(totalTxSizeBytes, mp.totalTxSizeBytes)
+	require.Equal(mp.unconnectedTxns[*oldestTxn.Hash()].txSizeBytes+
+		mp.unconnectedTxns[*newerTxn.Hash()].txSizeBytes, mp.totalUnconnectedTxSizeBytes)
+
+	// With the cap leaving room for only a couple of them, the one closest to
+	// expiring is evicted to make room for a new one.
+	mp.SetMaxCombinedTxSizeBytes(mp.totalTxSizeBytes + mp.totalUnconnectedTxSizeBytes + 10)
+	newestTxn, err := addUnconnectedTxn(2)
+	require.NoError(err)
+	require.Equal(2, len(mp.unconnectedTxns))
+	require.NotContains(mp.unconnectedTxns, *oldestTxn.Hash())
+	require.Contains(mp.unconnectedTxns, *newerTxn.Hash())
+	require.Contains(mp.unconnectedTxns, *newestTxn.Hash())
+	require.Equal(mp.unconnectedTxns[*newerTxn.Hash()].txSizeBytes+
+		mp.unconnectedTxns[*newestTxn.Hash()].txSizeBytes, mp.totalUnconnectedTxSizeBytes)
+	require.LessOrEqual(mp.totalTxSizeBytes+mp.totalUnconnectedTxSizeBytes, mp.maxCombinedTxSizeBytes)
+
+	// Re-offering a txn that's closest to expiring when there's only room for it
+	// replaces its old entry and evicts the other one, with its size counted once.
+	newerTxSizeBytes := mp.unconnectedTxns[*newerTxn.Hash()].txSizeBytes
+	mp.SetMaxCombinedTxSizeBytes(mp.totalTxSizeBytes + newerTxSizeBytes)
+	require.NoError(mp.tryAddUnconnectedTxn(newerTxn, 0 /*peerID*/))
+	require.Equal(1, len(mp.unconnectedTxns))
+	require.Contains(mp.unconnectedTxns, *newerTxn.Hash())
+	require.Equal(newerTxSizeBytes, mp.totalUnconnectedTxSizeBytes)
+	require.Contains(mp.unconnectedTxnsByPrev[UtxoKey(*newerTxn.TxInputs[0])], *newerTxn.Hash())
+
+	// When the connected txns alone leave no room, unconnected txns are rejected.
+	mp.SetMaxCombinedTxSizeBytes(mp.totalTxSizeBytes + 10)
+	_, err = addUnconnectedTxn(3)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorCombinedTxSizeLimitReached)
+	require.Equal(1, len(mp.unconnectedTxns))
+
+	require.Equal(1, mp.RemoveUnconnectedTxnsFromPeer(0))
+	require.Equal(uint64(0), mp.totalUnconnectedTxSizeBytes)
+}
+
+func TestMempoolTotalPendingFees(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Equal(uint64(0), mp.GetTotalPendingFees())
+
+	mempoolTxs := []*MempoolTx{}
+	for _, feeRateNanosPerKB := range []uint64{1000, 2000} {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+	}
+	require.NotZero(mempoolTxs[0].Fee)
+	require.Equal(mempoolTxs[0].Fee+mempoolTxs[1].Fee, mp.GetTotalPendingFees())
+
+	// Removing a txn rebuilds the pool, which should leave only the other txn's fee.
+	mp.InefficientRemoveTransaction(mempoolTxs[1].Tx)
+	require.Equal(mempoolTxs[0].Fee, mp.GetTotalPendingFees())
+
+	// So should removing it directly.
+	mp.RemoveTransactionAndDescendants(mp.universalTransactionList[0].Hash)
+	require.Equal(uint64(0), mp.GetTotalPendingFees())
+
+	// Decrementing past zero clamps instead of wrapping around.
+	mp.totalFeeNanos = 1
+	mp._decrementTotalFeeNanos(2)
+	require.Equal(uint64(0), mp.GetTotalPendingFees())
+}
+
+func TestMempoolTotalTxSizeBytesDrift(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txSizeBytes := acceptedTxs[0].TxSizeBytes
+
+	// Nothing to correct when the accounting is consistent.
+	oldTotal, newTotal := mp.RecomputeTotalTxSizeBytes()
+	require.Equal(txSizeBytes, oldTotal)
+	require.Equal(txSizeBytes, newTotal)
+
+	// Decrementing past zero clamps instead of wrapping around.
+	mp._decrementTotalTxSizeBytes(txSizeBytes + 1)
+	require.Equal(uint64(0), mp.totalTxSizeBytes)
+
+	// Incrementing past the max saturates.
+	mp.totalTxSizeBytes = math.MaxUint64 - 1
+	mp._incrementTotalTxSizeBytes(2)
+	require.Equal(uint64(math.MaxUint64), mp.totalTxSizeBytes)
+
+	// A pool that looks full rejects new txns until the drift is corrected.
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorInsufficientFeePriorityQueue)
+
+	oldTotal, newTotal = mp.RecomputeTotalTxSizeBytes()
+	require.Equal(uint64(math.MaxUint64), oldTotal)
+	require.Equal(txSizeBytes, newTotal)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+}
+
+func TestMempoolClone(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.regenerateReadOnlyView())
+
+	clone, err := mp.Clone()
+	require.NoError(err)
+	require.Equal(1, len(clone.poolMap))
+	require.Equal(mp.totalTxSizeBytes, clone.totalTxSizeBytes)
+	require.Equal(1, clone.Count())
+	require.False(mp.poolMap[*txn1.Hash()] == clone.poolMap[*txn1.Hash()])
+	require.True(clone.poolMap[*txn1.Hash()] == clone.universalTransactionList[0])
+
+	// txn2 spends txn1's output. Adding it to the clone shouldn't affect the
+	// original, and the original should still be able to accept it on its own.
+	txn2 := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txn1.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 10,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, recipientPrivString)
+	_, err = clone.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(clone.poolMap))
+	require.Equal(1, len(mp.poolMap))
+	require.Equal(1, len(mp.universalTransactionList))
+	require.Equal(1, len(mp.txFeeMinheap))
+	require.Equal(1, len(mp.PublicKeyTxnMap(senderPkBytes)))
+
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(mp.poolMap))
+}
+
+func TestMempoolAsJSON(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.regenerateReadOnlyView())
+
+	mempoolJSON, err := mp.GetMempoolAsJSON()
+	require.NoError(err)
+	decodedTxns := []map[string]interface{}{}
+	require.NoError(json.Unmarshal(mempoolJSON, &decodedTxns))
+	require.Equal(1, len(decodedTxns))
+
+	decodedTxn := decodedTxns[0]
+	require.Equal(hex.EncodeToString(txn.Hash()[:]), decodedTxn["Hash"])
+	require.Equal(TxnTypeBasicTransfer.String(), decodedTxn["TxnType"])
+	require.Equal(senderPkString, decodedTxn["TransactorPublicKeyBase58Check"])
+	require.Equal(float64(mempoolTxs[0].TxSizeBytes), decodedTxn["TxSizeBytes"])
+	require.NotContains(decodedTxn, "index")
+	require.NotContains(decodedTxn, "TxMeta")
+
+	txBytes, err := hex.DecodeString(decodedTxn["TxHex"].(string))
+	require.NoError(err)
+	decodedTx := &MsgBitCloutTxn{}
+	require.NoError(decodedTx.FromBytes(txBytes))
+	require.Equal(*txn.Hash(), *decodedTx.Hash())
+}
+
+func TestMempoolGetTransactionQueuePosition(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	mempoolTxs := []*MempoolTx{}
+	for ii := 0; ii < 3; ii++ {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, uint64(10+ii), 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+
+	position, totalAhead, bytesAhead := mp.GetTransactionQueuePosition(mempoolTxs[0].Hash)
+	require.Equal(1, position)
+	require.Equal(0, totalAhead)
+	require.Equal(uint64(0), bytesAhead)
+
+	position, totalAhead, bytesAhead = mp.GetTransactionQueuePosition(mempoolTxs[2].Hash)
+	require.Equal(3, position)
+	require.Equal(2, totalAhead)
+	require.Equal(mempoolTxs[0].TxSizeBytes+mempoolTxs[1].TxSizeBytes, bytesAhead)
+
+	position, _, _ = mp.GetTransactionQueuePosition(&BlockHash{0x01})
+	require.Equal(-1, position)
+}
+
+func TestMempoolOnEvict(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	// The callback calls back into the pool, which would deadlock if it were
+	// invoked with the lock held.
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		mp.GetTotalPendingFees()
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	mempoolTxs := []*MempoolTx{}
+	for _, amountNanos := range []uint64{10, 11} {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, amountNanos, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+	}
+
+	// The second txn spends the first txn's change so removing the first txn
+	// evicts both.
+	mp.InefficientRemoveTransaction(mempoolTxs[0].Tx)
+	require.Equal(map[BlockHash]string{
+		*mempoolTxs[0].Hash: EvictReasonRemoved,
+		*mempoolTxs[1].Hash: EvictReasonDependencyEvicted,
+	}, evictedReasons)
+	require.Equal(0, len(mp.poolMap))
+
+	// Expiring a txn should report it as well.
+	require.NoError(mp.regenerateReadOnlyView())
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 12, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	mp.maxTxnAge = time.Hour
+	fakeNow = fakeNow.Add(2 * time.Hour)
+	require.Equal(1, mp.RemoveExpiredTransactions())
+	require.Equal(EvictReasonExpired, evictedReasons[*acceptedTxs[0].Hash])
+	require.Equal(3, len(evictedReasons))
+	require.Equal(0, len(mp.poolMap))
+}
+
+func TestMempoolGetTransactionsSpendingOutput(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// A connected txn spending one of the sender's utxos.
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	spendingTxns := mp.GetTransactionsSpendingOutput(UtxoKey(*txn1.TxInputs[0]))
+	require.Equal(1, len(spendingTxns))
+	require.Equal(txn1.Hash(), spendingTxns[0].Hash())
+
+	// Two unconnected txns that conflict over an output the pool has never seen.
+	missingOutpoint := UtxoKey{TxID: BlockHash{0x01}, Index: 0}
+	for _, amountNanos := range []uint64{1, 2} {
+		unconnectedTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{
+				(*BitCloutInput)(&missingOutpoint),
+			},
+			TxOutputs: []*BitCloutOutput{
+				&BitCloutOutput{
+					PublicKey:   senderPkBytes,
+					AmountNanos: amountNanos,
+				},
+			},
+			PublicKey: recipientPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, unconnectedTxn, recipientPrivString)
+		_, err = mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+		require.NoError(err)
+	}
+	require.Equal(2, len(mp.GetTransactionsSpendingOutput(missingOutpoint)))
+
+	// An outpoint nobody spends.
+	require.Empty(mp.GetTransactionsSpendingOutput(UtxoKey{TxID: BlockHash{0x02}, Index: 0}))
+}
+
+func TestMempoolGetSpentOutpoints(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Empty(mp.GetSpentOutpoints())
+
+	txns := []*MsgBitCloutTxn{}
+	for ii := 0; ii < 2; ii++ {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		txns = append(txns, txn)
+	}
+
+	expectedOutpoints := make(map[UtxoKey]*BlockHash)
+	for _, txn := range txns {
+		for _, txIn := range txn.TxInputs {
+			expectedOutpoints[UtxoKey(*txIn)] = txn.Hash()
+		}
+	}
+	spentOutpoints := mp.GetSpentOutpoints()
+	require.Equal(expectedOutpoints, spentOutpoints)
+
+	// The result is a copy.
+	delete(spentOutpoints, UtxoKey(*txns[0].TxInputs[0]))
+	require.Contains(mp.outpoints, UtxoKey(*txns[0].TxInputs[0]))
+}
+
+func TestMempoolArePendingSpent(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	spentOp := UtxoKey(*txn.TxInputs[0])
+	unspentOp := UtxoKey{TxID: *txn.Hash(), Index: 0}
+	ops := []UtxoKey{spentOp, unspentOp}
+
+	// The txn isn't accounted for until the readOnly view is regenerated.
+	require.Equal(map[UtxoKey]bool{spentOp: false, unspentOp: false}, mp.ArePendingSpent(ops))
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	require.Equal(map[UtxoKey]bool{spentOp: true, unspentOp: false}, mp.ArePendingSpent(ops))
+	require.Equal(txn, mp.CheckSpend(spentOp))
+	require.Nil(mp.CheckSpend(unspentOp))
+}
+
+func TestMempoolGetTransactionsOrderedByFeeRate(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Empty(mp.GetTransactionsOrderedByFeeRate())
+
+	addTxn := func(feeRateNanosPerKB uint64) *MempoolTx {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		return acceptedTxs[0]
+	}
+	lowFeeTx := addTxn(1000)
+	highFeeTx := addTxn(3000)
+	require.NoError(mp.regenerateReadOnlyView())
+
+	sortedTxns := mp.GetTransactionsOrderedByFeeRate()
+	require.Equal([]*MempoolTx{highFeeTx, lowFeeTx}, sortedTxns)
+
+	// New txns only show up once the readOnly view is regenerated.
+	midFeeTx := addTxn(2000)
+	require.Equal(sortedTxns, mp.GetTransactionsOrderedByFeeRate())
+
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal([]*MempoolTx{highFeeTx, midFeeTx, lowFeeTx}, mp.GetTransactionsOrderedByFeeRate())
+}
+
+func TestMempoolGetTransactionsOrderedByTimeAddedWithLimit(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Empty(mp.GetTransactionsOrderedByTimeAddedWithLimit(2, true /*newestFirst*/))
+
+	mempoolTxs := []*MempoolTx{}
+	for ii := 0; ii < 3; ii++ {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+
+	require.Equal([]*MempoolTx{mempoolTxs[2], mempoolTxs[1]},
+		mp.GetTransactionsOrderedByTimeAddedWithLimit(2, true /*newestFirst*/))
+	require.Equal([]*MempoolTx{mempoolTxs[0], mempoolTxs[1]},
+		mp.GetTransactionsOrderedByTimeAddedWithLimit(2, false /*newestFirst*/))
+
+	// A limit that's non-positive or past the end returns everything.
+	require.Equal(mempoolTxs, mp.GetTransactionsOrderedByTimeAddedWithLimit(0, false /*newestFirst*/))
+	require.Equal([]*MempoolTx{mempoolTxs[2], mempoolTxs[1], mempoolTxs[0]},
+		mp.GetTransactionsOrderedByTimeAddedWithLimit(10, true /*newestFirst*/))
+
+	// The sorted txns are cached until the readOnly view is regenerated, and the
+	// oldest txns are sliced straight out of the cache.
+	cachedTxns := mp.timeSortedTxns
+	oldestTxns := mp.GetTransactionsOrderedByTimeAddedWithLimit(2, false /*newestFirst*/)
+	require.True(&cachedTxns[0] == &oldestTxns[0])
+	require.Equal(2, cap(oldestTxns))
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal([]*MempoolTx{acceptedTxs[0]},
+		mp.GetTransactionsOrderedByTimeAddedWithLimit(1, true /*newestFirst*/))
+	require.Equal(4, len(mp.timeSortedTxns))
+}
+
+func TestMempoolGetMedianFeeRate(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	defer func(medianFeeRateMinTxns int) {
+		MedianFeeRateMinTxns = medianFeeRateMinTxns
+	}(MedianFeeRateMinTxns)
+	MedianFeeRateMinTxns = 3
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		500 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal(uint64(500), mp.GetMedianFeeRate())
+
+	addTxn := func(feeRateNanosPerKB uint64) *MempoolTx {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		return acceptedTxs[0]
+	}
+
+	// Too few txns to go by.
+	addTxn(1000)
+	addTxn(5000)
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal(uint64(500), mp.GetMedianFeeRate())
+
+	midFeeTx := addTxn(2000)
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal(midFeeTx.FeePerKB, mp.GetMedianFeeRate())
+
+	// New txns only count once the readOnly view is regenerated.
+	highFeeTx := addTxn(4000)
+	require.Equal(midFeeTx.FeePerKB, mp.GetMedianFeeRate())
+
+	require.NoError(mp.regenerateReadOnlyView())
+	require.Equal((midFeeTx.FeePerKB+highFeeTx.FeePerKB)/2, mp.GetMedianFeeRate())
+}
+
+func TestMempoolStopFlushesDump(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+
+	// The txn is added well before the periodic dumper would run, and without
+	// regenerating the readOnly view, so only the final dump can pick it up.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	mp.Stop()
+
+	newMp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+	defer newMp.Stop()
+	require.Contains(newMp.poolMap, *txn.Hash())
+}
+
+func TestMempoolOptionsAppliedBeforeLoad(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	mp.Stop()
+
+	// The txn loaded from the dump should already be handled the way the options say.
+	opts := DefaultMempoolOptions()
+	opts.ComputeMetadataOnAccept = false
+	opts.LightweightMode = true
+	opts.DumpInterval = time.Minute
+	opts.NumRetainedDumpGenerations = 1000
+	newMp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, opts)
+	require.NoError(err)
+	defer newMp.Stop()
+	require.Contains(newMp.poolMap, *txn.Hash())
+	require.Nil(newMp.poolMap[*txn.Hash()].TxMeta)
+	require.Nil(newMp.backupUniversalUtxoView)
+	require.Equal(time.Minute, newMp._getDumpInterval())
+	require.Equal(255, newMp.numRetainedDumpGenerations)
+}
+
+func TestMempoolBulkIndexTxnsOnLoad(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	newPool := func() *BitCloutMempool {
+		mp, err := NewBitCloutMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, mempoolDir, nil)
+		require.NoError(err)
+		return mp
+	}
+
+	mp := newPool()
+	for ii := 0; ii < 3; ii++ {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	mp.Stop()
+
+	// The load done by NewBitCloutMempool can't be configured, so each pool is created
+	// without a dir and loads the dump afterwards.
+	loadPool := func(bulkIndexTxnsOnLoad bool) *BitCloutMempool {
+		mp, err := NewBitCloutMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, "" /*mempoolDir*/, nil)
+		require.NoError(err)
+		mp.SetBulkIndexTxnsOnLoad(bulkIndexTxnsOnLoad)
+		mp.SetComputeMetadataOnAccept(true)
+		mp.mempoolDir = mempoolDir
+		mp.LoadTxnsFromDB()
+		mp.Stop()
+		return mp
+	}
+	incrementalMp := loadPool(false)
+	bulkMp := loadPool(true)
+
+	// Both ways of loading the dump end up with the same indexes.
+	require.Equal(3, len(bulkMp.poolMap))
+	require.False(bulkMp.deferTxnIndexing)
+	require.Equal(incrementalMp.txnTypeToTotalBytes, bulkMp.txnTypeToTotalBytes)
+	require.Equal(len(incrementalMp.txnTypeToTxnMap), len(bulkMp.txnTypeToTxnMap))
+	for txnType, txnsForType := range incrementalMp.txnTypeToTxnMap {
+		require.Equal(len(txnsForType), len(bulkMp.txnTypeToTxnMap[txnType]))
+		for txHash := range txnsForType {
+			require.Contains(bulkMp.txnTypeToTxnMap[txnType], txHash)
+		}
+	}
+	require.Equal(len(incrementalMp.postHashToTxnMap), len(bulkMp.postHashToTxnMap))
+	for postHash, txnsForPost := range incrementalMp.postHashToTxnMap {
+		require.Equal(len(txnsForPost), len(bulkMp.postHashToTxnMap[postHash]))
+	}
+	requireSamePkIndex := func(incrementalIndex, bulkIndex map[PkMapKey]map[BlockHash]*MempoolTx) {
+		require.Equal(len(incrementalIndex), len(bulkIndex))
+		for pkMapKey, txnsForPk := range incrementalIndex {
+			require.Equal(len(txnsForPk), len(bulkIndex[pkMapKey]))
+			for txHash := range txnsForPk {
+				require.True(bulkIndex[pkMapKey][txHash] == bulkMp.poolMap[txHash])
+			}
+		}
+	}
+	requireSamePkIndex(incrementalMp.pubKeyToTxnMap, bulkMp.pubKeyToTxnMap)
+	requireSamePkIndex(incrementalMp.profilePkToTxnMap, bulkMp.profilePkToTxnMap)
+	require.NotEmpty(bulkMp.affectedPubKeyToTxnMap)
+	requireSamePkIndex(incrementalMp.affectedPubKeyToTxnMap, bulkMp.affectedPubKeyToTxnMap)
+}
+
+func TestMempoolDumpsAlternateSlots(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+
+	addTxnAndDump := func(txn *MsgBitCloutTxn) {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.NoError(mp.RegenerateReadOnlyView())
+		mp.DumpTxnsToDB()
+	}
+	slotSize := func(slot byte) int {
+		keysFound, _ := _enumerateKeysForPrefix(mp.dumpDB, DbPrefixForMempoolDumpSlot(slot))
+		return len(keysFound)
+	}
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	addTxnAndDump(txn1)
+	activeSlot, exists := DbGetMempoolDumpActiveSlot(mp.dumpDB)
+	require.True(exists)
+	require.Equal(byte(0), activeSlot)
+	require.Equal(1, slotSize(0))
+
+	// The next dump goes to the other slot and the old one is cleared. The same
+	// badger handle is used for both dumps.
+	dumpDB := mp.dumpDB
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	addTxnAndDump(txn2)
+	require.Equal(dumpDB, mp.dumpDB)
+	activeSlot, _ = DbGetMempoolDumpActiveSlot(mp.dumpDB)
+	require.Equal(byte(1), activeSlot)
+	require.Equal(0, slotSize(0))
+	require.Equal(2, slotSize(1))
+
+	mp.Stop()
+	newMp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+	defer newMp.Stop()
+	require.Contains(newMp.poolMap, *txn1.Hash())
+	require.Contains(newMp.poolMap, *txn2.Hash())
+}
+
+func TestMempoolDumpGenerations(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	newPool := func() *BitCloutMempool {
+		mp, err := NewBitCloutMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, mempoolDir, nil)
+		require.NoError(err)
+		return mp
+	}
+	mp := newPool()
+	mp.SetNumRetainedDumpGenerations(2)
+
+	addTxnAndDump := func(txn *MsgBitCloutTxn) {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.NoError(mp.RegenerateReadOnlyView())
+		mp.DumpTxnsToDB()
+	}
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	addTxnAndDump(txn1)
+	require.Equal([]byte{0}, DbGetMempoolDumpGenerations(mp.dumpDB))
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	addTxnAndDump(txn2)
+	require.Equal([]byte{1, 0}, DbGetMempoolDumpGenerations(mp.dumpDB))
+
+	// The third dump reuses the oldest generation's slot once it's been dropped.
+	txn3 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	addTxnAndDump(txn3)
+	require.Equal([]byte{2, 1}, DbGetMempoolDumpGenerations(mp.dumpDB))
+	keysFound, _ := _enumerateKeysForPrefix(mp.dumpDB, DbPrefixForMempoolDumpSlot(0))
+	require.Empty(keysFound)
+	// Shut the pool down without the final dump Stop would do.
+	close(mp.quit)
+	<-mp.mempoolDBDumperDone
+	require.NoError(mp.dumpDB.Close())
+	mp.dumpDB = nil
+
+	// Corrupt the newest generation. Loading falls back to the one before it, which
+	// doesn't have txn3.
+	dumpDBOpts := badger.DefaultOptions(mp.dumpDBDir())
+	dumpDBOpts.ValueDir = mp.dumpDBDir()
+	dumpDB, err := badger.Open(dumpDBOpts)
+	require.NoError(err)
+	corruptKey := append(DbPrefixForMempoolDumpSlot(2), make([]byte, 8+HashSizeBytes)...)
+	require.NoError(dumpDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(corruptKey, []byte{0xff})
+	}))
+	require.NoError(dumpDB.Close())
+
+	loadedMp := newPool()
+	defer loadedMp.Stop()
+	require.Contains(loadedMp.poolMap, *txn1.Hash())
+	require.Contains(loadedMp.poolMap, *txn2.Hash())
+	require.NotContains(loadedMp.poolMap, *txn3.Hash())
+}
+
+func TestMempoolLoadCorruptLegacyDump(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	// Write a legacy dump with a txn that can't be decoded.
+	latestDir := filepath.Join(mempoolDir, "latest_mempool_dump")
+	dumpDBOpts := badger.DefaultOptions(latestDir)
+	dumpDBOpts.ValueDir = latestDir
+	dumpDB, err := badger.Open(dumpDBOpts)
+	require.NoError(err)
+	corruptKey := append(append([]byte{}, _PrefixMempoolTxnHashToMsgBitCloutTxn...), make([]byte, 8+HashSizeBytes)...)
+	require.NoError(dumpDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(corruptKey, []byte{0xff})
+	}))
+	require.NoError(dumpDB.Close())
+
+	// Loading it logs the problem and starts with an empty pool rather than exiting.
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	require.NoError(err)
+	defer mp.Stop()
+	require.Equal(0, len(mp.poolMap))
+
+	// The legacy dirs are cleaned up once the dump db is opened for the first dump.
+	require.DirExists(latestDir)
+	mp.DumpTxnsToDB()
+	require.NoDirExists(latestDir)
+}
+
+// Measures how long a dump of a moderately sized pool takes. Run it with e.g.
+// go test -run=^$ -bench=MempoolDumpTxnsToDB
+func BenchmarkMempoolDumpTxnsToDB(b *testing.B) {
+	chain, _, _ := NewLowDifficultyBlockchain()
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(mempoolDir)
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, mempoolDir, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer mp.Stop()
+
+	// Dumping doesn't validate txns so the same txn can stand in for all of them as
+	// long as each one gets its own key.
+	txn := &MsgBitCloutTxn{
+		TxOutputs: []*BitCloutOutput{{PublicKey: make([]byte, 33), AmountNanos: 1}},
+		PublicKey: make([]byte, 33),
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	for ii := 0; ii < 5000; ii++ {
+		txHash := &BlockHash{}
+		copy(txHash[:], EncodeUint64(uint64(ii)))
+		mp.readOnlyUniversalTransactionList = append(mp.readOnlyUniversalTransactionList, &MempoolTx{
+			Tx:    txn,
+			Hash:  txHash,
+			Added: time.Unix(0, int64(ii)),
+		})
+	}
+
+	b.ResetTimer()
+	for ii := 0; ii < b.N; ii++ {
+		mp.DumpTxnsToDB()
+	}
+}
+
+// Measures how long restoring a large dump takes with and without deferring the
+// indexes. Run it with e.g. go test -run=^$ -bench=MempoolLoadTxnsFromDB
+func BenchmarkMempoolLoadTxnsFromDB(b *testing.B) {
+	const numTxns = 50000
+
+	chain, _, db := NewLowDifficultyBlockchain()
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	if err != nil {
+		b.Fatal(err)
+	}
+	recipientPkBytes, _, err := Base58CheckDecode(recipientPkString)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(mempoolDir)
+
+	// Each txn spends its own utxo, written straight to the db, so that all of them
+	// connect. Signatures aren't checked on load so the txns are left unsigned.
+	mempoolTxns := []*MempoolTx{}
+	for batchStart := 0; batchStart < numTxns; batchStart += 1000 {
+		err := db.Update(func(txn *badger.Txn) error {
+			for ii := batchStart; ii < batchStart+1000; ii++ {
+				utxoKey := &UtxoKey{Index: 0}
+				copy(utxoKey.TxID[:], EncodeUint64(uint64(ii+1)))
+				utxoEntry := &UtxoEntry{
+					AmountNanos: 1000,
+					PublicKey:   senderPkBytes,
+					BlockHeight: 1,
+					UtxoType:    UtxoTypeOutput,
+					UtxoKey:     utxoKey,
+				}
+				if err := PutMappingsForUtxoWithTxn(txn, utxoKey, utxoEntry); err != nil {
+					return err
+				}
+
+				tx := &MsgBitCloutTxn{
+					TxInputs:  []*BitCloutInput{(*BitCloutInput)(utxoKey)},
+					TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 1000}},
+					PublicKey: senderPkBytes,
+					TxnMeta:   &BasicTransferMetadata{},
+				}
+				mempoolTxns = append(mempoolTxns, &MempoolTx{
+					Tx:    tx,
+					Hash:  tx.Hash(),
+					Added: time.Unix(0, int64(ii)),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	newPool := func() *BitCloutMempool {
+		mp, err := NewBitCloutMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, "" /*mempoolDir*/, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		mp.mempoolDir = mempoolDir
+		return mp
+	}
+	mp := newPool()
+	if err := mp._openTempDBAndDumpTxns(mempoolTxns); err != nil {
+		b.Fatal(err)
+	}
+	mp.dumpDB.Close()
+
+	for _, bulkIndexTxnsOnLoad := range []bool{false, true} {
+		b.Run(fmt.Sprintf("bulkIndexTxnsOnLoad=%v", bulkIndexTxnsOnLoad), func(b *testing.B) {
+			for ii := 0; ii < b.N; ii++ {
+				b.StopTimer()
+				mp := newPool()
+				mp.SetBulkIndexTxnsOnLoad(bulkIndexTxnsOnLoad)
+				b.StartTimer()
+
+				mp.LoadTxnsFromDB()
+
+				b.StopTimer()
+				if len(mp.poolMap) != numTxns {
+					b.Fatalf("Expected %d txns in the pool but got %d", numTxns, len(mp.poolMap))
+				}
+				mp.dumpDB.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestMempoolWALReplayAndTruncate(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mempoolDir, err := ioutil.TempDir("", "mempool_dump")
+	require.NoError(err)
+	defer os.RemoveAll(mempoolDir)
+
+	newPool := func() *BitCloutMempool {
+		opts := DefaultMempoolOptions()
+		opts.EnableWAL = true
+		mp, err := NewBitCloutMempool(
+			chain, 0, /* rateLimitFeeRateNanosPerKB */
+			0 /* minFeeRateNanosPerKB */, "", false,
+			"" /*dataDir*/, mempoolDir, opts)
+		require.NoError(err)
+		return mp
+	}
+	walSize := func() int64 {
+		walInfo, err := os.Stat(filepath.Join(mempoolDir, "mempool_wal"))
+		require.NoError(err)
+		return walInfo.Size()
+	}
+
+	mp := newPool()
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Greater(walSize(), int64(0))
+
+	// Simulate a crash before any dump happens. The txn only survives in the WAL.
+	close(mp.quit)
+	mp.walFile.Close()
+
+	newMp := newPool()
+	defer newMp.Stop()
+	require.Contains(newMp.poolMap, *txn.Hash())
+	require.Empty(newMp.GetLastReprocessDrops())
+
+	// Once the txn is in a full dump the WAL no longer needs it.
+	require.NoError(newMp.RegenerateReadOnlyView())
+	newMp.DumpTxnsToDB()
+	require.Equal(int64(0), walSize())
+
+	// Accept a few more txns after the readOnly view the next dump uses was taken.
+	// Each spends a different block reward so they don't depend on each other.
+	senderPkBytes, _, err := Base58CheckDecode(senderPkString)
+	require.NoError(err)
+	recipientPkBytes, _, err := Base58CheckDecode(recipientPkString)
+	require.NoError(err)
+	utxoEntries, err := chain.GetSpendableUtxosForPublicKey(senderPkBytes, nil, nil)
+	require.NoError(err)
+	newTxns := []*MsgBitCloutTxn{}
+	for _, utxoEntry := range utxoEntries {
+		if _, isSpent := newMp.outpoints[*utxoEntry.UtxoKey]; isSpent {
+			continue
+		}
+		newTxn := &MsgBitCloutTxn{
+			TxInputs: []*BitCloutInput{(*BitCloutInput)(utxoEntry.UtxoKey)},
+			TxOutputs: []*BitCloutOutput{
+				{PublicKey: recipientPkBytes, AmountNanos: utxoEntry.AmountNanos},
+			},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, newTxn, senderPrivString)
+		_, err = newMp.processTransaction(newTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		newTxns = append(newTxns, newTxn)
+		if len(newTxns) == 3 {
+			break
+		}
+	}
+	require.Equal(3, len(newTxns))
+	minedTxn, evictedTxn, keptTxn := newTxns[0], newTxns[1], newTxns[2]
+
+	// One gets mined and one gets evicted before the dump happens.
+	newMp.UpdateAfterConnectBlock(&MsgBitCloutBlock{
+		Header: &MsgBitCloutHeader{Height: uint64(chain.blockTip().Height)},
+		Txns:   []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}, minedTxn},
+	})
+	newMp.RemoveTransactionAndDescendants(evictedTxn.Hash())
+	require.NotContains(newMp.poolMap, *minedTxn.Hash())
+	require.NotContains(newMp.poolMap, *evictedTxn.Hash())
+
+	// Only the txn that's still in the pool but missing from the dump is kept.
+	newMp.DumpTxnsToDB()
+	walTxns, err := newMp.readWAL()
+	require.NoError(err)
+	require.Equal(1, len(walTxns))
+	require.Equal(*keptTxn.Hash(), *walTxns[0].Hash())
+}
+
+func TestMempoolBackgroundIntervals(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Equal(DefaultMempoolDBDumpInterval, mp.dumpInterval)
+	require.Equal(time.Duration(ReadOnlyUtxoViewRegenerationIntervalSeconds)*time.Second,
+		mp.readOnlyViewRegenerationInterval)
+
+	mp.SetDumpInterval(time.Minute)
+	mp.SetReadOnlyViewRegenerationInterval(5 * time.Second)
+	require.Equal(time.Minute, mp.dumpInterval)
+	require.Equal(5*time.Second, mp.readOnlyViewRegenerationInterval)
+
+	// Zero intervals fall back to the defaults.
+	mp.SetDumpInterval(0)
+	mp.SetReadOnlyViewRegenerationInterval(0)
+	require.Equal(DefaultMempoolDBDumpInterval, mp.dumpInterval)
+	require.Equal(time.Duration(ReadOnlyUtxoViewRegenerationIntervalSeconds)*time.Second,
+		mp.readOnlyViewRegenerationInterval)
+}
+
+func TestMempoolRejectsDuplicateInputs(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// A txn that lists one of the sender's utxos twice.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txn.TxInputs = append(txn.TxInputs, txn.TxInputs[0])
+	_signTxn(t, txn, senderPrivString)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorDuplicateInputs)
+	require.Empty(mp.poolMap)
+	require.Empty(mp.outpoints)
+
+	// The same goes for a txn that would otherwise be unconnected.
+	unconnectedTxn := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{TxID: BlockHash{0x01}, Index: 0},
+			&BitCloutInput{TxID: BlockHash{0x01}, Index: 0},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, unconnectedTxn, recipientPrivString)
+	_, err = mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorDuplicateInputs)
+	require.Empty(mp.unconnectedTxns)
+}
+
+func TestMempoolTxnTypeLimits(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	mp.SetTxnTypeLimits(map[TxnType]int{TxnTypeBasicTransfer: 2})
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	processTxn := func(amountNanos uint64, feeRateNanosPerKB uint64, senderPk string,
+		recipientPk string, senderPriv string) (*MsgBitCloutTxn, error) {
+
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, amountNanos, feeRateNanosPerKB,
+			senderPk, recipientPk, senderPriv, mp)
+		_, err := mp.ProcessTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		return txn, err
+	}
+
+	// Fill up the limit. The second txn pays itself so that the recipient's only
+	// output in the pool comes from the first txn.
+	txn1, err := processTxn(10000, 3000, senderPkString, recipientPkString, senderPrivString)
+	require.NoError(err)
+	txn2, err := processTxn(10, 1000, senderPkString, senderPkString, senderPrivString)
+	require.NoError(err)
+	require.Equal(2, len(mp.txnTypeToTxnMap[TxnTypeBasicTransfer]))
+
+	// A txn paying a higher fee rate than txn2 evicts it.
+	txn3, err := processTxn(10, 2000, recipientPkString, senderPkString, recipientPrivString)
+	require.NoError(err)
+	require.Equal(2, len(mp.txnTypeToTxnMap[TxnTypeBasicTransfer]))
+	require.Contains(mp.poolMap, *txn1.Hash())
+	require.NotContains(mp.poolMap, *txn2.Hash())
+	require.Contains(mp.poolMap, *txn3.Hash())
+	require.Equal(map[BlockHash]string{*txn2.Hash(): EvictReasonTxnTypeLimit}, evictedReasons)
+
+	// A txn paying a lower fee rate than everything of its type is rejected.
+	_, err = processTxn(10, 500, senderPkString, senderPkString, senderPrivString)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeLimitReached)
+	require.Equal(2, len(mp.poolMap))
+
+	// The recipient's only output in the pool is txn3's change, so this txn spends
+	// from the txn it would have to evict. It's rejected without evicting anything.
+	_, err = processTxn(10, 2500, recipientPkString, senderPkString, recipientPrivString)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeLimitReached)
+	require.Contains(mp.poolMap, *txn1.Hash())
+	require.Contains(mp.poolMap, *txn3.Hash())
+	require.Equal(map[BlockHash]string{*txn2.Hash(): EvictReasonTxnTypeLimit}, evictedReasons)
+}
+
+func TestMempoolTxnTypeByteLimits(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	processTxn := func(amountNanos uint64, feeRateNanosPerKB uint64, senderPk string,
+		recipientPk string, senderPriv string) (*MsgBitCloutTxn, error) {
+
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, amountNanos, feeRateNanosPerKB,
+			senderPk, recipientPk, senderPriv, mp)
+		_, err := mp.ProcessTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		return txn, err
+	}
+
+	txn1, err := processTxn(10000, 3000, senderPkString, recipientPkString, senderPrivString)
+	require.NoError(err)
+	txn2, err := processTxn(10, 1000, senderPkString, senderPkString, senderPrivString)
+	require.NoError(err)
+	txn1Size := mp.poolMap[*txn1.Hash()].TxSizeBytes
+	txn2Size := mp.poolMap[*txn2.Hash()].TxSizeBytes
+	require.Equal(txn1Size+txn2Size, mp.txnTypeToTotalBytes[TxnTypeBasicTransfer])
+
+	// Leave room for about half of another txn.
+	byteLimit := txn1Size + txn2Size + txn1Size/2
+	mp.SetTxnTypeByteLimits(map[TxnType]uint64{TxnTypeBasicTransfer: byteLimit})
+
+	// A txn paying a higher fee rate than txn2 makes room by evicting it.
+	txn3, err := processTxn(10, 2000, recipientPkString, senderPkString, recipientPrivString)
+	require.NoError(err)
+	require.Contains(mp.poolMap, *txn1.Hash())
+	require.NotContains(mp.poolMap, *txn2.Hash())
+	require.Contains(mp.poolMap, *txn3.Hash())
+	require.Equal(map[BlockHash]string{*txn2.Hash(): EvictReasonTxnTypeByteLimit}, evictedReasons)
+	require.Equal(txn1Size+mp.poolMap[*txn3.Hash()].TxSizeBytes,
+		mp.txnTypeToTotalBytes[TxnTypeBasicTransfer])
+	require.LessOrEqual(mp.txnTypeToTotalBytes[TxnTypeBasicTransfer], byteLimit)
+
+	// A txn paying a lower fee rate than everything of its type is rejected.
+	_, err = processTxn(10, 500, senderPkString, senderPkString, senderPrivString)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeByteLimitReached)
+	require.Equal(2, len(mp.poolMap))
+
+	// A txn that's bigger than the whole limit is rejected no matter its fee.
+	mp.SetTxnTypeByteLimits(map[TxnType]uint64{TxnTypeBasicTransfer: txn1Size / 2})
+	_, err = processTxn(10, 100000, senderPkString, senderPkString, senderPrivString)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeByteLimitReached)
+	require.Equal(2, len(mp.poolMap))
+}
+
+func TestMempoolTxnTypeByteLimitsEvictsInOnePass(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	utxoEntries, err := chain.GetSpendableUtxosForPublicKey(senderPkBytes, nil, nil)
+	require.NoError(err)
+	require.GreaterOrEqual(len(utxoEntries), 4)
+	makeTxn := func(inputUtxos []*UtxoEntry, feeNanos uint64) *MsgBitCloutTxn {
+		txn := &MsgBitCloutTxn{
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		totalInputNanos := uint64(0)
+		for _, utxoEntry := range inputUtxos {
+			txn.TxInputs = append(txn.TxInputs, (*BitCloutInput)(utxoEntry.UtxoKey))
+			totalInputNanos += utxoEntry.AmountNanos
+		}
+		txn.TxOutputs = []*BitCloutOutput{
+			{PublicKey: recipientPkBytes, AmountNanos: totalInputNanos - feeNanos},
+		}
+		_signTxn(t, txn, senderPrivString)
+		return txn
+	}
+
+	txnA := makeTxn(utxoEntries[0:1], 100)
+	_, err = mp.ProcessTransaction(txnA, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txnB := makeTxn(utxoEntries[1:2], 200)
+	_, err = mp.ProcessTransaction(txnB, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// There's no room left for another txn of the type.
+	mp.SetTxnTypeByteLimits(map[TxnType]uint64{
+		TxnTypeBasicTransfer: mp.txnTypeToTotalBytes[TxnTypeBasicTransfer],
+	})
+
+	// A txn that spends from one of the txns it would evict is rejected without
+	// evicting anything.
+	childTxn := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{{TxID: *txnA.Hash(), Index: 0}},
+		TxOutputs: []*BitCloutOutput{
+			{PublicKey: senderPkBytes, AmountNanos: txnA.TxOutputs[0].AmountNanos - 1000},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, childTxn, recipientPrivString)
+	_, err = mp.ProcessTransaction(childTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeByteLimitReached)
+	require.Contains(mp.poolMap, *txnA.Hash())
+	require.Contains(mp.poolMap, *txnB.Hash())
+	require.Empty(evictedReasons)
+
+	// A txn with two inputs is bigger than either txn, so both have to go.
+	bigTxn := makeTxn(utxoEntries[2:4], 1000)
+	_, err = mp.ProcessTransaction(bigTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.poolMap))
+	require.Contains(mp.poolMap, *bigTxn.Hash())
+	require.Equal(map[BlockHash]string{
+		*txnA.Hash(): EvictReasonTxnTypeByteLimit,
+		*txnB.Hash(): EvictReasonTxnTypeByteLimit,
+	}, evictedReasons)
+	require.Equal(mp.poolMap[*bigTxn.Hash()].TxSizeBytes, mp.txnTypeToTotalBytes[TxnTypeBasicTransfer])
+}
+
+func TestMempoolEvictionPolicyOldestFirst(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	mp.SetTxnTypeLimits(map[TxnType]int{TxnTypeSubmitPost: 1})
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	assemblePost := func(updaterPkBytes []byte, updaterPriv string, feeRateNanosPerKB uint64) *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: "hi"})
+		require.NoError(err)
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(
+			updaterPkBytes, []byte{}, []byte{}, bodyBytes, []byte{}, false,
+			uint64(time.Now().UnixNano()), make(map[string][]byte), false,
+			feeRateNanosPerKB, mp)
+		require.NoError(err)
+		_signTxn(t, txn, updaterPriv)
+		return txn
+	}
+
+	// Fund the recipient so that its post doesn't depend on the sender's.
+	require.NoError(mp.RegenerateReadOnlyView())
+	fundingTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10000, 1000,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err := mp.ProcessTransaction(fundingTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	post1 := assemblePost(recipientPkBytes, recipientPrivString, 1000)
+	_, err = mp.ProcessTransaction(post1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Under the default policy a lower-fee post can't get in.
+	post2 := assemblePost(senderPkBytes, senderPrivString, 500)
+	_, err = mp.ProcessTransaction(post2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeLimitReached)
+	require.Empty(evictedReasons)
+
+	// Under OldestFirst it evicts the oldest post regardless of fee.
+	mp.SetEvictionPolicy(EvictionPolicyOldestFirst)
+	_, err = mp.ProcessTransaction(post2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Contains(mp.poolMap, *fundingTxn.Hash())
+	require.NotContains(mp.poolMap, *post1.Hash())
+	require.Contains(mp.poolMap, *post2.Hash())
+	require.Equal(map[BlockHash]string{*post1.Hash(): EvictReasonTxnTypeLimit}, evictedReasons)
+}
+
+func TestMempoolGetEvictionOrder(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Empty(mp.GetEvictionOrder(0))
+
+	feeRates := []uint64{2000, 1000, 3000}
+	txnsByFeeRate := make(map[uint64]*MsgBitCloutTxn)
+	for _, feeRate := range feeRates {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRate,
+			senderPkString, senderPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		txnsByFeeRate[feeRate] = txn
+	}
+	heapBefore := make(MempoolTxFeeMinHeap, len(mp.txFeeMinheap))
+	copy(heapBefore, mp.txFeeMinheap)
+
+	evictionOrder := mp.GetEvictionOrder(0)
+	require.Equal(3, len(evictionOrder))
+	require.Equal(*txnsByFeeRate[1000].Hash(), *evictionOrder[0].Hash)
+	require.Equal(*txnsByFeeRate[2000].Hash(), *evictionOrder[1].Hash)
+	require.Equal(*txnsByFeeRate[3000].Hash(), *evictionOrder[2].Hash)
+
+	evictionOrder = mp.GetEvictionOrder(2)
+	require.Equal(2, len(evictionOrder))
+	require.Equal(*txnsByFeeRate[1000].Hash(), *evictionOrder[0].Hash)
+
+	// The heap is left as it was.
+	require.Equal(heapBefore, mp.txFeeMinheap)
+	for ii, mempoolTx := range mp.txFeeMinheap {
+		require.Equal(ii, mempoolTx.index)
+	}
+
+	// The heap itself pops txns with the same fee rate most recently added first,
+	// matching GetEvictionOrder.
+	olderTx := &MempoolTx{FeePerKB: 1000, Added: time.Unix(1600000000, 0)}
+	newerTx := &MempoolTx{FeePerKB: 1000, Added: time.Unix(1600000001, 0)}
+	higherFeeTx := &MempoolTx{FeePerKB: 2000, Added: time.Unix(1600000002, 0)}
+	feeHeap := MempoolTxFeeMinHeap{}
+	for _, mempoolTx := range []*MempoolTx{olderTx, higherFeeTx, newerTx} {
+		heap.Push(&feeHeap, mempoolTx)
+	}
+	require.Equal(newerTx, heap.Pop(&feeHeap))
+	require.Equal(olderTx, heap.Pop(&feeHeap))
+	require.Equal(higherFeeTx, heap.Pop(&feeHeap))
+}
+
+func TestMempoolRebuildKeepsEvictionOrder(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	clockStep := time.Second
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(clockStep)
+		return fakeNow
+	}
+
+	// Txns with the same fee rate are only ordered by Added.
+	for ii := 0; ii < 4; ii++ {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+
+	// popOrder pops copies of the heap's txns, as laid out in the heap, so the pool's
+	// heap indexes aren't touched.
+	popOrder := func() []BlockHash {
+		feeHeap := make(MempoolTxFeeMinHeap, len(mp.txFeeMinheap))
+		for ii, mempoolTx := range mp.txFeeMinheap {
+			mempoolTxCopy := *mempoolTx
+			feeHeap[ii] = &mempoolTxCopy
+		}
+		txHashes := []BlockHash{}
+		for feeHeap.Len() > 0 {
+			txHashes = append(txHashes, *heap.Pop(&feeHeap).(*MempoolTx).Hash)
+		}
+		return txHashes
+	}
+	evictionOrder := func() []BlockHash {
+		txHashes := []BlockHash{}
+		for _, mempoolTx := range mp.GetEvictionOrder(0) {
+			txHashes = append(txHashes, *mempoolTx.Hash)
+		}
+		return txHashes
+	}
+	evictionOrderBefore := evictionOrder()
+	require.Equal(evictionOrderBefore, popOrder())
+
+	// With the clock stepping back during the rebuild, the txns are re-added with
+	// Added times in the opposite order of the ones carried over from the old pool.
+	clockStep = -time.Second
+	require.Empty(mp.rebuildPool(EvictReasonRemoved))
+	require.Equal(evictionOrderBefore, evictionOrder())
+	require.Equal(evictionOrderBefore, popOrder())
+}
+
+func TestMempoolIsReadOnlyViewHealthy(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	// The view has never been regenerated.
+	require.False(mp.IsReadOnlyViewHealthy(time.Minute))
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	require.True(mp.IsReadOnlyViewHealthy(time.Minute))
+
+	fakeNow = fakeNow.Add(2 * time.Minute)
+	require.False(mp.IsReadOnlyViewHealthy(time.Minute))
+	require.True(mp.IsReadOnlyViewHealthy(3 * time.Minute))
+}
+
+func TestMempoolReadOnlyViewRegeneratorRecoversFromPanic(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	defer mp.Stop()
+	mp.readOnlyViewRegenerationInterval = 10 * time.Millisecond
+
+	// Copying a nil view panics on every regeneration.
+	mp.mtx.Lock()
+	universalUtxoView := mp.universalUtxoView
+	mp.universalUtxoView = nil
+	mp.mtx.Unlock()
+	mp.StartReadOnlyUtxoViewRegenerator()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(int64(0), atomic.LoadInt64(&mp.readOnlyUtxoViewSequenceNumber))
+
+	// Once regenerating works again the restarted goroutine picks it back up.
+	mp.mtx.Lock()
+	mp.universalUtxoView = universalUtxoView
+	mp.mtx.Unlock()
+	require.Eventually(func() bool {
+		return mp.IsReadOnlyViewHealthy(time.Second)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMempoolGetLowFeeAccumulatorState(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp, err := NewBitCloutMempool(
+		chain, 100, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	// Nothing has been rate-limited yet.
+	currentBytes, limitBytes, lastUpdateUnix := mp.GetLowFeeAccumulatorState()
+	require.Equal(float64(0), currentBytes)
+	require.Equal(float64(LowFeeTxLimitBytesPerTenMinutes), limitBytes)
+	require.Equal(int64(0), lastUpdateUnix)
+
+	// A zero-fee txn is below the rate-limit feerate so it gets counted.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txnBytes, err := txn.ToBytes(false)
+	require.NoError(err)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	currentBytes, _, lastUpdateUnix = mp.GetLowFeeAccumulatorState()
+	require.Equal(float64(len(txnBytes)), currentBytes)
+	require.Equal(fakeNow.Unix(), lastUpdateUnix)
+
+	// The accumulator halves every ten minutes without being modified.
+	fakeNow = fakeNow.Add(10 * time.Minute)
+	currentBytes, _, lastUpdateUnix = mp.GetLowFeeAccumulatorState()
+	require.Equal(float64(len(txnBytes))/2, currentBytes)
+	require.Equal(fakeNow.Add(-10*time.Minute).Unix(), lastUpdateUnix)
+	require.Equal(float64(len(txnBytes)), mp.lowFeeTxSizeAccumulator)
+}
+
+func TestMempoolTryAcceptTransactionWithContext(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+
+	// While someone else holds the lock the attempt should be abandoned once the
+	// deadline passes.
+	mp.mtx.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := mp.TryAcceptTransactionWithContext(ctx, txn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Equal(context.DeadlineExceeded, err)
+	mp.mtx.Unlock()
+	require.Empty(mp.poolMap)
+
+	// The abandoned attempt shouldn't hang onto the lock so this one gets through.
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel2()
+	_, mempoolTx, err := mp.TryAcceptTransactionWithContext(ctx2, txn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(*txn.Hash(), *mempoolTx.Hash)
+
+	// An abandoned attempt doesn't leave a writer waiting on the lock, which would
+	// keep new readers out.
+	mp.mtx.RLock()
+	ctx4, cancel4 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel4()
+	_, _, err = mp.TryAcceptTransactionWithContext(ctx4, txn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Equal(context.DeadlineExceeded, err)
+	readLocked := make(chan struct{})
+	go func() {
+		mp.mtx.RLock()
+		close(readLocked)
+	}()
+	select {
+	case <-readLocked:
+	case <-time.After(10 * time.Second):
+		require.Fail("New reader was kept out by an abandoned attempt to take the write lock")
+	}
+	mp.mtx.RUnlock()
+	mp.mtx.RUnlock()
+
+	// A context that's already done never touches the pool.
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	cancel3()
+	_, _, err = mp.TryAcceptTransactionWithContext(ctx3, txn, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Equal(context.Canceled, err)
+}
+
+func TestMempoolPreviewTransactionMetadata(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+
+	txnMeta, err := mp.PreviewTransactionMetadata(txn)
+	require.NoError(err)
+	require.Equal(TxnTypeBasicTransfer.String(), txnMeta.TxnType)
+	require.Equal(senderPkString, txnMeta.TransactorPublicKeyBase58Check)
+	affectedPks := []string{}
+	for _, affectedPk := range txnMeta.AffectedPublicKeys {
+		affectedPks = append(affectedPks, affectedPk.PublicKeyBase58Check)
+	}
+	require.Contains(affectedPks, recipientPkString)
+
+	// Nothing about the pool should have changed, so the txn can still be previewed
+	// and then added.
+	require.Empty(mp.poolMap)
+	_, err = mp.PreviewTransactionMetadata(txn)
+	require.NoError(err)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+}
+
+func TestComputeTransactionMetadataParsedMentions(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, senderPkBytes, _ := _setupFiveBlocks(t)
+
+	utxoView, err := NewUtxoView(chain.db, params, nil)
+	require.NoError(err)
+
+	computeMentions := func(body string) []string {
+		bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: body})
+		require.NoError(err)
+		txn := &MsgBitCloutTxn{
+			PublicKey: senderPkBytes,
+			TxnMeta:   &SubmitPostMetadata{Body: bodyBytes},
+		}
+		txnMeta, err := ComputeTransactionMetadata(txn, utxoView, &BlockHash{}, 0, 0, 0, 0, 0, 0)
+		require.NoError(err)
+		return txnMeta.SubmitPostTxindexMetadata.ParsedMentions
+	}
+
+	// None of these profiles exist but the mentions should still be recorded.
+	require.ElementsMatch(
+		[]string{"someone", "nobody", "other"},
+		computeMentions("hi @nobody and $someone, @other.x"))
+
+	// Without '.' as a terminator the last mention runs on.
+	oldTerminators := MentionTerminators
+	MentionTerminators = []rune(" ,")
+	defer func() { MentionTerminators = oldTerminators }()
+	require.ElementsMatch(
+		[]string{"someone", "nobody", "other.x"},
+		computeMentions("hi @nobody and $someone, @other.x"))
+}
+
+func TestMempoolSimulateConnectBlock(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// txnA sends to the recipient and txnC spends txnA's first output.
+	txnA := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txnA, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txnC := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txnA.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txnC, recipientPrivString)
+	_, err = mp.processTransaction(txnC, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// A block that includes txnA evicts it and leaves txnC alone.
+	evicted, conflicts := mp.SimulateConnectBlock(&MsgBitCloutBlock{Txns: []*MsgBitCloutTxn{txnA}})
+	require.Equal([]*BlockHash{txnA.Hash()}, evicted)
+	require.Empty(conflicts)
+
+	// A block with a txn that double-spends txnA's inputs invalidates txnA along with
+	// txnC, which depends on it.
+	txnB := &MsgBitCloutTxn{
+		TxInputs: txnA.TxInputs,
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	evicted, conflicts = mp.SimulateConnectBlock(&MsgBitCloutBlock{Txns: []*MsgBitCloutTxn{txnB}})
+	require.Empty(evicted)
+	require.Equal([]*BlockHash{txnA.Hash(), txnC.Hash()}, conflicts)
+
+	// Nothing should have been removed.
+	require.Equal(2, len(mp.poolMap))
+}
+
+type testStatsHook struct {
+	connectDurations              []time.Duration
+	metadataDurations             []time.Duration
+	unconnectedPromotionDurations []time.Duration
+}
+
+func (hook *testStatsHook) ObserveConnectDuration(d time.Duration) {
+	hook.connectDurations = append(hook.connectDurations, d)
+}
+
+func (hook *testStatsHook) ObserveMetadataDuration(d time.Duration) {
+	hook.metadataDurations = append(hook.metadataDurations, d)
+}
+
+func (hook *testStatsHook) ObserveUnconnectedPromotionDuration(d time.Duration) {
+	hook.unconnectedPromotionDurations = append(hook.unconnectedPromotionDurations, d)
+}
+
+func TestMempoolStatsHook(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	statsHook := &testStatsHook{}
+	mp.SetStatsHook(statsHook)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(statsHook.connectDurations))
+	require.Equal(1, len(statsHook.metadataDurations))
+	require.Equal(1, len(statsHook.unconnectedPromotionDurations))
+
+	// A duplicate is rejected before it's connected so nothing is observed.
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Equal(1, len(statsHook.connectDurations))
+	require.Equal(1, len(statsHook.metadataDurations))
+	require.Equal(1, len(statsHook.unconnectedPromotionDurations))
+}
+
+type testTxnLogger struct {
+	decisions []*TxnDecision
+}
+
+func (logger *testTxnLogger) LogTxnDecision(decision *TxnDecision) {
+	logger.decisions = append(logger.decisions, decision)
+}
+
+func TestMempoolTxnLogger(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	txnLogger := &testTxnLogger{}
+	mp.SetTxnLogger(txnLogger)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 1000,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(txnLogger.decisions))
+	require.Equal(&TxnDecision{
+		Hash:     txn.Hash(),
+		TxnType:  TxnTypeBasicTransfer,
+		Fee:      acceptedTxs[0].Fee,
+		FeePerKB: acceptedTxs[0].FeePerKB,
+		Result:   TxnDecisionResultAccepted,
+	}, txnLogger.decisions[0])
+
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Equal(2, len(txnLogger.decisions))
+	require.Equal(TxnDecisionResultRejected, txnLogger.decisions[1].Result)
+	require.Equal(*txn.Hash(), *txnLogger.decisions[1].Hash)
+	require.Contains(txnLogger.decisions[1].Err.Error(), TxErrorDuplicate)
+
+	// A txn spending an output the pool has never seen is missing its parents.
+	missingOutpoint := UtxoKey{TxID: BlockHash{0x01}, Index: 0}
+	unconnectedTxn := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			(*BitCloutInput)(&missingOutpoint),
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, unconnectedTxn, recipientPrivString)
+	_, err = mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(3, len(txnLogger.decisions))
+	require.Equal(TxnDecisionResultMissingParents, txnLogger.decisions[2].Result)
+	require.Nil(txnLogger.decisions[2].Err)
+
+	// Going back to glog stops the logger from receiving decisions.
+	mp.SetTxnLogger(nil)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Equal(3, len(txnLogger.decisions))
+}
+
+func TestMempoolRemoveTransactionAndDescendants(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	// txnA sends to the recipient and txnC spends txnA's first output.
+	txnA := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txnA, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txnC := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  *txnA.Hash(),
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txnC, recipientPrivString)
+	_, err = mp.processTransaction(txnC, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Removing a txn that isn't in the pool is a no-op.
+	require.Nil(mp.RemoveTransactionAndDescendants(&BlockHash{0x01}))
+	require.Equal(2, len(mp.poolMap))
+
+	// Removing txnA takes txnC with it.
+	removedHashes := mp.RemoveTransactionAndDescendants(txnA.Hash())
+	require.Equal([]*BlockHash{txnA.Hash(), txnC.Hash()}, removedHashes)
+	require.Empty(mp.poolMap)
+	require.Empty(mp.outpoints)
+	require.Empty(mp.txFeeMinheap)
+	require.Empty(mp.universalTransactionList)
+	require.Empty(mp.txnTypeToTxnMap)
+	require.Equal(uint64(0), mp.totalTxSizeBytes)
+	require.Equal(uint64(0), mp.totalFeeNanos)
+	require.Equal(map[BlockHash]string{
+		*txnA.Hash(): EvictReasonRemoved,
+		*txnC.Hash(): EvictReasonDependencyEvicted,
+	}, evictedReasons)
+
+	// The views no longer have txnA's inputs spent so it can be added again.
+	_, err = mp.processTransaction(txnA, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.poolMap))
+}
+
+func TestMempoolUniversalTransactionListMatchesPoolMap(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	checkInSync := func(expectedLen int) {
+		require.Equal(expectedLen, len(mp.poolMap))
+		require.Equal(len(mp.poolMap), len(mp.universalTransactionList))
+		for _, mempoolTx := range mp.universalTransactionList {
+			require.True(mp.poolMap[*mempoolTx.Hash] == mempoolTx)
+		}
+	}
+	addTxn := func() *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		return txn
+	}
+
+	// Each txn spends the change of the one before it.
+	txns := []*MsgBitCloutTxn{}
+	for ii := 0; ii < 5; ii++ {
+		txns = append(txns, addTxn())
+	}
+	checkInSync(5)
+
+	// Removing a txn removes the txns after it too.
+	mp.RemoveTransactionAndDescendants(txns[3].Hash())
+	checkInSync(3)
+
+	mp.InefficientRemoveTransaction(txns[2])
+	checkInSync(2)
+
+	addTxn()
+	checkInSync(3)
+
+	mp.RemoveTransactionAndDescendants(txns[0].Hash())
+	require.NotContains(mp.poolMap, *txns[1].Hash())
+	checkInSync(len(mp.poolMap))
+}
+
+func TestMempoolMaxTxnSizeBytes(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	txnBytes, err := txn.ToBytes(false)
+	require.NoError(err)
+
+	// A txn one byte over the cap is rejected regardless of its fee.
+	mp.SetMaxTxnSizeBytes(uint64(len(txnBytes) - 1))
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTooLarge)
+	require.Empty(mp.poolMap)
+
+	// A txn right at the cap is fine.
+	mp.SetMaxTxnSizeBytes(uint64(len(txnBytes)))
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mp.poolMap))
+}
+
+func TestMempoolMaxInputsPerTxn(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// A txn spending three outputs the pool has never seen.
+	txn := &MsgBitCloutTxn{
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	for ii := uint32(0); ii < 3; ii++ {
+		txn.TxInputs = append(txn.TxInputs, &BitCloutInput{TxID: BlockHash{0x01}, Index: ii})
+	}
+	_signTxn(t, txn, recipientPrivString)
+
+	// It's rejected before its inputs are even looked up.
+	mp.SetMaxInputsPerTxn(2)
+	_, err := mp.processTransaction(txn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTooManyInputs)
+	require.Empty(mp.unconnectedTxns)
+
+	// At the cap its inputs are looked up and it's found to be unconnected.
+	mp.SetMaxInputsPerTxn(3)
+	_, err = mp.processTransaction(txn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.NoError(err)
+	require.Contains(mp.unconnectedTxns, *txn.Hash())
+}
+
+func TestMempoolRejectTxnsWhileSyncing(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+
+	isSyncing := true
+	mp.SetIsSyncingFunc(func() bool { return isSyncing })
+	_, err := mp.ProcessTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Equal(TxErrorStillSyncing, err)
+	require.Equal(0, len(mp.poolMap))
+
+	// Once the node has caught up the same txn goes through.
+	isSyncing = false
+	_, err = mp.ProcessTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Contains(mp.poolMap, *txn.Hash())
+
+	// Without a callback txns are never rejected for this.
+	isSyncing = true
+	mp.SetIsSyncingFunc(nil)
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err = mp.ProcessTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+}
+
+func TestMempoolRequireTransactorUtxos(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// The recipient has no utxos so a txn from it can only spend the sender's.
+	senderTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	spamTxn := &MsgBitCloutTxn{
+		TxInputs:  senderTxn.TxInputs,
+		TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, spamTxn, recipientPrivString)
+
+	// Without the pre-check it's only rejected once it's connected.
+	_, err := mp.processTransaction(spamTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorInputWithPublicKeyDifferentFromTxnPublicKey)
+
+	mp.SetRequireTransactorUtxos(true)
+	_, err = mp.processTransaction(spamTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTransactorHasNoUtxos)
+
+	// The sender's own txn passes, as does a PrivateMessage, which needs no inputs.
+	_, err = mp.processTransaction(senderTxn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.True(mp._transactorHasUtxos(&MsgBitCloutTxn{
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &PrivateMessageMetadata{},
+	}))
+
+	// A txn with no inputs and no outputs spends nothing so it passes too, but one
+	// with outputs and no inputs doesn't.
+	require.True(mp._transactorHasUtxos(&MsgBitCloutTxn{
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &FollowMetadata{},
+	}))
+	require.False(mp._transactorHasUtxos(&MsgBitCloutTxn{
+		TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &FollowMetadata{},
+	}))
+}
+
+// BenchmarkMempoolRejectTxnWithoutTransactorUtxos compares rejecting spam from a key
+// that owns none of the txn's inputs by connecting it against rejecting it with the
+// requireTransactorUtxos pre-check.
+func BenchmarkMempoolRejectTxnWithoutTransactorUtxos(b *testing.B) {
+	chain, _, _ := NewLowDifficultyBlockchain()
+
+	mp, err := NewBitCloutMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Give the owner a utxo that the spammer tries to spend.
+	ownerPkBytes := make([]byte, 33)
+	ownerPkBytes[0] = 0x02
+	spammerPkBytes := make([]byte, 33)
+	spammerPkBytes[0] = 0x03
+	utxoKey := &UtxoKey{TxID: BlockHash{0x01}, Index: 0}
+	if err := mp.universalUtxoView._setUtxoMappings(&UtxoEntry{
+		AmountNanos: 1000,
+		PublicKey:   ownerPkBytes,
+		UtxoKey:     utxoKey,
+	}); err != nil {
+		b.Fatal(err)
+	}
+	mp.rebuildBackupView()
+
+	spamTxn := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{(*BitCloutInput)(utxoKey)},
+		TxOutputs: []*BitCloutOutput{{PublicKey: ownerPkBytes, AmountNanos: 1}},
+		PublicKey: spammerPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+
+	for _, requireTransactorUtxos := range []bool{false, true} {
+		b.Run(fmt.Sprintf("requireTransactorUtxos=%v", requireTransactorUtxos), func(b *testing.B) {
+			mp.requireTransactorUtxos = requireTransactorUtxos
+			for ii := 0; ii < b.N; ii++ {
+				if _, err := mp.processTransaction(spamTxn, false /*allowUnconnectedTxn*/, false, /*rateLimit*/
+					0 /*peerID*/, false /*verifySignatures*/); err == nil {
+					b.Fatal("spam txn was accepted")
+				}
+			}
+		})
+	}
+}
+
+func TestMempoolGetLastRejectionReason(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	// Txns without inputs are rejected.
+	newInvalidTxn := func(amountNanos uint64) *MsgBitCloutTxn {
+		txn := &MsgBitCloutTxn{
+			TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: amountNanos}},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, txn, senderPrivString)
+		return txn
+	}
+	processTxn := func(txn *MsgBitCloutTxn) error {
+		_, err := mp.ProcessTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		return err
+	}
+
+	invalidTxn := newInvalidTxn(1)
+	require.Error(processTxn(invalidTxn))
+	reason, when, ok := mp.GetLastRejectionReason(invalidTxn.Hash())
+	require.True(ok)
+	require.Contains(reason, RuleErrorTxnMustHaveAtLeastOneInput)
+	require.Equal(fakeNow, when)
+
+	// An evicted txn gets the eviction reason, which is forgotten if it's accepted
+	// again. Resubmitting a txn that's already in the pool isn't recorded.
+	require.NoError(mp.RegenerateReadOnlyView())
+	validTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	require.NoError(processTxn(validTxn))
+	_, _, ok = mp.GetLastRejectionReason(validTxn.Hash())
+	require.False(ok)
+	mp.InefficientRemoveTransaction(validTxn)
+	reason, _, ok = mp.GetLastRejectionReason(validTxn.Hash())
+	require.True(ok)
+	require.Equal("evicted: "+EvictReasonRemoved, reason)
+	require.NoError(processTxn(validTxn))
+	require.Error(processTxn(validTxn))
+	_, _, ok = mp.GetLastRejectionReason(validTxn.Hash())
+	require.False(ok)
+
+	// Only the most recent reasons are kept.
+	mp.SetDroppedTxnReasonsCacheSize(1)
+	_, _, ok = mp.GetLastRejectionReason(invalidTxn.Hash())
+	require.False(ok)
+	invalidTxn2 := newInvalidTxn(2)
+	require.Error(processTxn(invalidTxn))
+	require.Error(processTxn(invalidTxn2))
+	_, _, ok = mp.GetLastRejectionReason(invalidTxn.Hash())
+	require.False(ok)
+	_, _, ok = mp.GetLastRejectionReason(invalidTxn2.Hash())
+	require.True(ok)
+}
+
+func TestMempoolTryAcceptTransactionBatchAtomic(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	conflictingTxn := &MsgBitCloutTxn{
+		TxInputs:  txn1.TxInputs,
+		TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 11}},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, conflictingTxn, senderPrivString)
+
+	// txn2 spends txn1's change.
+	changeIndex := uint32(len(txn1.TxOutputs) - 1)
+	require.Equal(senderPkBytes, txn1.TxOutputs[changeIndex].PublicKey)
+	txn2 := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: *txn1.Hash(), Index: changeIndex}},
+		TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, senderPrivString)
+
+	// A batch with a txn that fails to connect leaves the pool untouched.
+	_, err := mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{txn1, conflictingTxn}, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorInputSpendsPreviouslySpentOutput)
+	require.Empty(mp.poolMap)
+
+	// txn2 can't be accepted without txn1.
+	_, err = mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{txn2}, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Empty(mp.poolMap)
+
+	mempoolTxns, err := mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{txn1, txn2}, false /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(mempoolTxns))
+	require.Equal(*txn1.Hash(), *mempoolTxns[0].Hash)
+	require.Equal(*txn2.Hash(), *mempoolTxns[1].Hash)
+	require.Equal(2, len(mp.poolMap))
+
+	// The backup view was rolled back after the failed batches, so it agrees with
+	// the pool.
+	_, err = mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{conflictingTxn}, false /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Equal(2, len(mp.poolMap))
+}
+
+func TestMempoolTryAcceptTransactionBatchAtomicNoSideEffects(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	// Zero-fee txns are below the rate-limit feerate so they count toward the low-fee
+	// accumulator.
+	mp, err := NewBitCloutMempool(
+		chain, 100, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", false,
+		"" /*dataDir*/, "", nil)
+	require.NoError(err)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+	mp.SetTxnTypeLimits(map[TxnType]int{TxnTypeBasicTransfer: 1})
+	subscription, unsubscribe := mp.SubscribePublicKey(recipientPkBytes)
+	defer unsubscribe()
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	changeIndex := uint32(len(txn1.TxOutputs) - 1)
+	txn2 := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: *txn1.Hash(), Index: changeIndex}},
+		TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 1}},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txn2, senderPrivString)
+
+	// Both txns connect but the second one is over the type limit. Since the batch is
+	// rejected before anything is added, txn1 never counts toward the accumulator and
+	// the subscriber never hears about it.
+	_, err = mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{txn1, txn2}, true /*rateLimit*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorTxnTypeLimitReached)
+	require.Empty(mp.poolMap)
+	require.Equal(float64(0), mp.lowFeeTxSizeAccumulator)
+	require.Empty(subscription)
+
+	// With room for both the batch goes in, and only now do they count.
+	mp.SetTxnTypeLimits(nil)
+	mempoolTxns, err := mp.TryAcceptTransactionBatchAtomic([]*MsgBitCloutTxn{txn1, txn2}, true /*rateLimit*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(2, len(mp.poolMap))
+	require.Equal(float64(mempoolTxns[0].TxSizeBytes+mempoolTxns[1].TxSizeBytes), mp.lowFeeTxSizeAccumulator)
+	require.Equal(2, len(subscription))
+}
+
+func TestMempoolGetAllStats(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 1000,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	allStats := mp.GetAllStats()
+	require.Equal(float64(1), allStats["pool_count"])
+	require.Equal(float64(mempoolTxs[0].TxSizeBytes), allStats["pool_bytes"])
+	require.Equal(float64(0), allStats["unconnected_count"])
+	require.Equal(float64(mempoolTxs[0].Fee), allStats["total_fee_nanos"])
+	require.Equal(float64(0), allStats["low_fee_accumulator_bytes"])
+	require.Equal(float64(LowFeeTxLimitBytesPerTenMinutes), allStats["low_fee_limit_bytes"])
+	require.Equal(float64(0), allStats["read_only_view_lag"])
+	require.Equal(float64(1), allStats["type_count_basic_transfer"])
+	require.Equal(float64(mempoolTxs[0].TxSizeBytes), allStats["type_bytes_basic_transfer"])
+	require.Contains(allStats, "read_only_sequence_number")
+}
+
+func TestMempoolGetLastReprocessDrops(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Empty(mp.GetLastReprocessDrops())
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Disconnect a block containing a txn that spends an output that doesn't exist.
+	// It can't be re-added to the pool so it should be recorded as a drop, while the
+	// txn that was already in the pool survives.
+	badTxn := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			&BitCloutInput{
+				TxID:  BlockHash{0x01},
+				Index: 0,
+			},
+		},
+		TxOutputs: []*BitCloutOutput{
+			&BitCloutOutput{
+				PublicKey:   senderPkBytes,
+				AmountNanos: 1,
+			},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	blk := &MsgBitCloutBlock{
+		Header: &MsgBitCloutHeader{Height: uint64(chain.blockTip().Height)},
+		Txns:   []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}, badTxn},
+	}
+	mp.UpdateAfterDisconnectBlock(blk)
+	require.Contains(mp.poolMap, *txn.Hash())
+
+	reprocessDrops := mp.GetLastReprocessDrops()
+	require.Equal(1, len(reprocessDrops))
+	require.Equal(*badTxn.Hash(), *reprocessDrops[0].Hash)
+	require.Contains(reprocessDrops[0].Err.Error(), TxErrorUnconnectedTxnNotAllowed)
+
+	// The next rebuild replaces the drops.
+	mp.UpdateAfterConnectBlock(&MsgBitCloutBlock{
+		Txns: []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}},
+	})
+	require.Empty(mp.GetLastReprocessDrops())
+}
+
+func TestMempoolRejectsRecentlyConfirmedTxns(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Once the txn is mined, a re-relayed copy of it is rejected as a duplicate.
+	blk := &MsgBitCloutBlock{
+		Header: &MsgBitCloutHeader{Height: uint64(chain.blockTip().Height)},
+		Txns:   []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}, txn},
+	}
+	mp.UpdateAfterConnectBlock(blk)
+	require.NotContains(mp.poolMap, *txn.Hash())
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorDuplicate)
+	require.Empty(mp.poolMap)
+
+	// Disconnecting the block forgets the txn, which goes back into the pool.
+	mp.UpdateAfterDisconnectBlock(blk)
+	require.Contains(mp.poolMap, *txn.Hash())
+	require.False(mp._isRecentlyConfirmedTxn(txn.Hash()))
+
+	// Nothing is remembered when the cache is disabled.
+	mp.SetRecentlyConfirmedTxnsCacheSize(0)
+	mp.UpdateAfterConnectBlock(blk)
+	require.NotContains(mp.poolMap, *txn.Hash())
+	require.False(mp._isRecentlyConfirmedTxn(txn.Hash()))
+}
+
+func TestComputeTransactionMetadataCreatorCoinTransferWithoutProfile(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	utxoView, err := NewUtxoView(chain.db, params, nil)
+	require.NoError(err)
+
+	// The creator has no profile in the view.
+	txn := &MsgBitCloutTxn{
+		PublicKey: senderPkBytes,
+		TxnMeta: &CreatorCoinTransferMetadataa{
+			ProfilePublicKey:           senderPkBytes,
+			CreatorCoinToTransferNanos: 10,
+			ReceiverPublicKey:          recipientPkBytes,
+		},
+	}
+	txnMeta, err := ComputeTransactionMetadata(txn, utxoView, &BlockHash{}, 0, 0, 0, 0, 0, 0)
+	require.NoError(err)
+	require.Equal("", txnMeta.CreatorCoinTransferTxindexMetadata.CreatorUsername)
+	require.Equal(uint64(10), txnMeta.CreatorCoinTransferTxindexMetadata.CreatorCoinToTransferNanos)
+
+	// Once it has one, its username is filled in.
+	utxoView._setProfileEntryMappings(&ProfileEntry{PublicKey: senderPkBytes, Username: []byte("alice")})
+	txnMeta, err = ComputeTransactionMetadata(txn, utxoView, &BlockHash{}, 0, 0, 0, 0, 0, 0)
+	require.NoError(err)
+	require.Equal("alice", txnMeta.CreatorCoinTransferTxindexMetadata.CreatorUsername)
+}
+
+func TestComputeTransactionMetadataMaxMentionedAffectedPublicKeys(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	utxoView, err := NewUtxoView(chain.db, params, nil)
+	require.NoError(err)
+	utxoView._setProfileEntryMappings(&ProfileEntry{PublicKey: senderPkBytes, Username: []byte("alice")})
+	utxoView._setProfileEntryMappings(&ProfileEntry{PublicKey: recipientPkBytes, Username: []byte("bob")})
+
+	computeMentionedPks := func() []string {
+		bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: "hi @alice and @bob"})
+		require.NoError(err)
+		txn := &MsgBitCloutTxn{
+			PublicKey: senderPkBytes,
+			TxnMeta:   &SubmitPostMetadata{Body: bodyBytes},
+		}
+		txnMeta, err := ComputeTransactionMetadata(txn, utxoView, &BlockHash{}, 0, 0, 0, 0, 0, 0)
+		require.NoError(err)
+		require.Equal(2, len(txnMeta.SubmitPostTxindexMetadata.ParsedMentions))
+
+		mentionedPks := []string{}
+		for _, affectedPk := range txnMeta.AffectedPublicKeys {
+			if affectedPk.Metadata == "MentionedPublicKeyBase58Check" {
+				mentionedPks = append(mentionedPks, affectedPk.PublicKeyBase58Check)
+			}
+		}
+		return mentionedPks
+	}
+
+	require.ElementsMatch([]string{senderPkString, recipientPkString}, computeMentionedPks())
+
+	// Only one mention makes it into the AffectedPublicKeys once capped, but both
+	// are still parsed.
+	oldMax := MaxMentionedAffectedPublicKeys
+	MaxMentionedAffectedPublicKeys = 1
+	defer func() { MaxMentionedAffectedPublicKeys = oldMax }()
+	mentionedPks := computeMentionedPks()
+	require.Equal(1, len(mentionedPks))
+	require.Contains([]string{senderPkString, recipientPkString}, mentionedPks[0])
+}
+
+func TestMempoolSetBlockchain(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	evictedReasons := make(map[BlockHash]string)
+	mp.SetOnEvict(func(mempoolTx *MempoolTx, reason string) {
+		evictedReasons[*mempoolTx.Hash] = reason
+	})
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// A chain for a different network is refused.
+	mainnetChain, _, _ := NewLowDifficultyBlockchainWithParams(&BitCloutMainnetParams)
+	require.Error(mp.SetBlockchain(mainnetChain))
+	require.Equal(chain, mp.bc)
+	require.Contains(mp.poolMap, *txn.Hash())
+
+	// Swapping in the same chain keeps everything.
+	require.NoError(mp.SetBlockchain(chain))
+	require.Contains(mp.poolMap, *txn.Hash())
+	require.Empty(evictedReasons)
+
+	// A fresh chain hasn't mined the blocks that fund the txn so it gets evicted.
+	newChain, _, _ := NewLowDifficultyBlockchain()
+	require.NoError(mp.SetBlockchain(newChain))
+	require.Equal(newChain, mp.bc)
+	require.Empty(mp.poolMap)
+	require.Empty(mp.readOnlyUniversalTransactionList)
+	require.Equal(map[BlockHash]string{*txn.Hash(): EvictReasonBlockchainChanged}, evictedReasons)
+}
+
+func TestMempoolShouldRelay(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// A zero-fee txn is admitted and, with no relay feerate set, relayed.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.True(mp.ShouldRelay(mempoolTxs[0]))
+
+	// Once a relay feerate is set it stays in the pool but isn't relayed.
+	mp.SetRelayFeeRate(1000)
+	require.False(mp.ShouldRelay(mempoolTxs[0]))
+	require.Contains(mp.poolMap, *txn.Hash())
+	require.True(mp.ShouldRelay(&MempoolTx{FeePerKB: 1000}))
+
+	// Local txns are always relayed.
+	require.True(mp.ShouldRelay(&MempoolTx{FeePerKB: 0, Local: true}))
+}
+
+func TestMempoolFetchTransactions(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// Hashes that aren't in the pool are left out.
+	missingHash := &BlockHash{0x01}
+	foundTxns := mp.FetchTransactions([]*BlockHash{txn.Hash(), missingHash})
+	require.Equal(1, len(foundTxns))
+	require.Equal(mp.FetchTransaction(txn.Hash()), foundTxns[*txn.Hash()])
+	require.NotContains(foundTxns, *missingHash)
+
+	require.Empty(mp.FetchTransactions(nil))
+
+	foundTxn, err := mp.FetchTransactionByHashString(hex.EncodeToString(txn.Hash()[:]))
+	require.NoError(err)
+	require.Equal(mp.FetchTransaction(txn.Hash()), foundTxn)
+	foundTxn, err = mp.FetchTransactionByHashString(hex.EncodeToString(missingHash[:]))
+	require.NoError(err)
+	require.Nil(foundTxn)
+	_, err = mp.FetchTransactionByHashString("not hex")
+	require.Error(err)
+	_, err = mp.FetchTransactionByHashString("abcd")
+	require.Error(err)
+}
+
+func TestMempoolGetTransactionsByHeight(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// Txns are added at the tip height plus one.
+	tipHeight := uint32(chain.blockTip().Height)
+	heightTxns := mp.GetTransactionsByHeight(tipHeight + 1)
+	require.Equal(1, len(heightTxns))
+	require.Equal(*txn.Hash(), *heightTxns[0].Hash)
+
+	require.Empty(mp.GetTransactionsByHeight(tipHeight))
+}
+
+func TestMempoolGetTransactionsByTypes(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	processTxn := func(txn *MsgBitCloutTxn) {
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		require.NoError(mp.RegenerateReadOnlyView())
+	}
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	transfer1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	processTxn(transfer1)
+
+	bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: "hi"})
+	require.NoError(err)
+	post, _, _, _, err := chain.CreateSubmitPostTxn(
+		senderPkBytes, []byte{}, []byte{}, bodyBytes, []byte{}, false,
+		uint64(time.Now().UnixNano()), make(map[string][]byte), false,
+		1000 /*feeRateNanosPerKB*/, mp)
+	require.NoError(err)
+	_signTxn(t, post, senderPrivString)
+	processTxn(post)
+
+	transfer2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	processTxn(transfer2)
+
+	// The union comes back ordered by Added, and duplicate types are ignored.
+	txnsByTypes := mp.GetTransactionsByTypes([]TxnType{
+		TxnTypeSubmitPost, TxnTypeBasicTransfer, TxnTypeSubmitPost})
+	require.Equal(3, len(txnsByTypes))
+	require.Equal(*transfer1.Hash(), *txnsByTypes[0].Hash)
+	require.Equal(*post.Hash(), *txnsByTypes[1].Hash)
+	require.Equal(*transfer2.Hash(), *txnsByTypes[2].Hash)
+
+	postTxns := mp.GetTransactionsByTypes([]TxnType{TxnTypeSubmitPost})
+	require.Equal(1, len(postTxns))
+	require.Equal(*post.Hash(), *postTxns[0].Hash)
+
+	require.Empty(mp.GetTransactionsByTypes([]TxnType{TxnTypeCreatorCoin}))
+	require.Empty(mp.GetTransactionsByTypes(nil))
+}
+
+func TestMempoolGetTransactionCountByType(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Equal(uint32(0), mp.GetTransactionCountByType(TxnTypeBasicTransfer))
+
+	txns := []*MsgBitCloutTxn{}
+	for ii := 0; ii < 2; ii++ {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		txns = append(txns, txn)
+	}
+
+	// The count is up to date without regenerating the readOnly view.
+	require.Equal(uint32(2), mp.GetTransactionCountByType(TxnTypeBasicTransfer))
+	require.Equal(uint32(0), mp.GetTransactionCountByType(TxnTypeSubmitPost))
+
+	mp.InefficientRemoveTransaction(txns[1])
+	require.Equal(uint32(1), mp.GetTransactionCountByType(TxnTypeBasicTransfer))
+}
+
+func TestMempoolGetTransactionsReferencingPost(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	processTxn := func(txn *MsgBitCloutTxn) {
+		_signTxn(t, txn, senderPrivString)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	submitPost := func(parentStakeID []byte, recloutPostHashBytes []byte) *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: "hi"})
+		require.NoError(err)
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(
+			senderPkBytes, []byte{}, parentStakeID, bodyBytes, recloutPostHashBytes,
+			len(recloutPostHashBytes) > 0, uint64(time.Now().UnixNano()), make(map[string][]byte),
+			false, 1000 /*feeRateNanosPerKB*/, mp)
+		require.NoError(err)
+		processTxn(txn)
+		return txn
+	}
+
+	post := submitPost([]byte{}, []byte{})
+	postHash := post.Hash()
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	like, _, _, _, err := chain.CreateLikeTxn(senderPkBytes, *postHash, false /*isUnlike*/, 1000, mp)
+	require.NoError(err)
+	processTxn(like)
+
+	comment := submitPost(postHash[:], []byte{})
+	reclout := submitPost([]byte{}, postHash[:])
+
+	// A comment on a public key rather than a post isn't a reference.
+	submitPost(senderPkBytes, []byte{})
+
+	referencingTxns := mp.GetTransactionsReferencingPost(postHash)
+	require.Equal(3, len(referencingTxns))
+	require.Equal(*like.Hash(), *referencingTxns[0].Hash)
+	require.Equal(*comment.Hash(), *referencingTxns[1].Hash)
+	require.Equal(*reclout.Hash(), *referencingTxns[2].Hash)
+
+	// The post itself doesn't reference anything.
+	require.Empty(mp.GetTransactionsReferencingPost(like.Hash()))
+
+	// Removing a txn removes it from the index.
+	mp.InefficientRemoveTransaction(reclout)
+	referencingTxns = mp.GetTransactionsReferencingPost(postHash)
+	require.Equal(2, len(referencingTxns))
+	require.NotContains([]BlockHash{*referencingTxns[0].Hash, *referencingTxns[1].Hash}, *reclout.Hash())
+}
+
+func TestMempoolGetTransactionsAffectingPublicKey(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	processTxn := func(txn *MsgBitCloutTxn, privKey string) {
+		_signTxn(t, txn, privKey)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	submitPost := func(posterPkBytes []byte, posterPriv string, parentStakeID []byte) *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		bodyBytes, err := json.Marshal(&BitCloutBodySchema{Body: "hi"})
+		require.NoError(err)
+		txn, _, _, _, err := chain.CreateSubmitPostTxn(
+			posterPkBytes, []byte{}, parentStakeID, bodyBytes, []byte{},
+			false, uint64(time.Now().UnixNano()), make(map[string][]byte),
+			false, 1000 /*feeRateNanosPerKB*/, mp)
+		require.NoError(err)
+		processTxn(txn, posterPriv)
+		return txn
+	}
+
+	// Fund the recipient so it can post.
+	require.NoError(mp.RegenerateReadOnlyView())
+	fundingTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10000, 1000,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	processTxn(fundingTxn, senderPrivString)
+
+	post := submitPost(recipientPkBytes, recipientPrivString, []byte{})
+	comment := submitPost(senderPkBytes, senderPrivString, post.Hash()[:])
+
+	// The comment only affects the recipient as the parent poster, which
+	// pubKeyToTxnMap doesn't cover.
+	require.NotContains(mp.pubKeyToTxnMap[MakePkMapKey(recipientPkBytes)], *comment.Hash())
+	affectingTxns := mp.GetTransactionsAffectingPublicKey(recipientPkBytes)
+	require.Equal(3, len(affectingTxns))
+	require.Equal(*fundingTxn.Hash(), *affectingTxns[0].Hash)
+	require.Equal(*post.Hash(), *affectingTxns[1].Hash)
+	require.Equal(*comment.Hash(), *affectingTxns[2].Hash)
+
+	// Removing a txn removes it from the index.
+	mp.InefficientRemoveTransaction(comment)
+	require.Equal(2, len(mp.GetTransactionsAffectingPublicKey(recipientPkBytes)))
+	for _, mempoolTx := range mp.GetTransactionsAffectingPublicKey(senderPkBytes) {
+		require.NotEqual(*comment.Hash(), *mempoolTx.Hash)
+	}
+}
+
+func TestMempoolRefreshAllTransactionMetadata(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	mempoolTx1 := mp.poolMap[*txn1.Hash()]
+	require.NotNil(mempoolTx1.TxMeta)
+	origMeta := mempoolTx1.TxMeta
+
+	// Swap in stale metadata that doesn't affect the recipient.
+	mp._removeMempoolTxFromAffectedPubKeyMap(mempoolTx1)
+	mempoolTx1.TxMeta = &TransactionMetadata{TxnType: origMeta.TxnType}
+	require.Equal(0, len(mp.GetTransactionsAffectingPublicKey(recipientPkBytes)))
+
+	// A txn without metadata is connected but its metadata is left to be computed
+	// on demand.
+	mp.SetComputeMetadataOnAccept(false)
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	staleMeta := mempoolTx1.TxMeta
+	require.NoError(mp.RefreshAllTransactionMetadata())
+
+	// The MempoolTx readers may still hold isn't modified. A copy with the new
+	// metadata takes its place everywhere instead.
+	require.Equal(staleMeta, mempoolTx1.TxMeta)
+	refreshedTx1 := mp.poolMap[*txn1.Hash()]
+	require.False(refreshedTx1 == mempoolTx1)
+	require.Equal(origMeta, refreshedTx1.TxMeta)
+	require.True(mp.txFeeMinheap[refreshedTx1.index] == refreshedTx1)
+	require.True(mp.universalTransactionList[0] == refreshedTx1)
+	require.True(mp.txnTypeToTxnMap[TxnTypeBasicTransfer][*txn1.Hash()] == refreshedTx1)
+	require.Nil(mp.poolMap[*txn2.Hash()].TxMeta)
+	affectingTxns := mp.GetTransactionsAffectingPublicKey(recipientPkBytes)
+	require.Equal(1, len(affectingTxns))
+	require.Equal(*txn1.Hash(), *affectingTxns[0].Hash)
+
+	// A txn whose metadata can't be computed is skipped without stopping the rest
+	// from being refreshed. A txn spending an input that doesn't exist is put at the
+	// front of the universalTransactionList to stand in for one.
+	mp.SetComputeMetadataOnAccept(true)
+	txn3 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(txn3, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	mempoolTx3 := mp.poolMap[*txn3.Hash()]
+	mp._removeMempoolTxFromAffectedPubKeyMap(mempoolTx3)
+	mempoolTx3.TxMeta = &TransactionMetadata{TxnType: mempoolTx3.TxMeta.TxnType}
+
+	brokenTxn := *txn1
+	brokenTxn.TxInputs = []*BitCloutInput{{Index: 1234}}
+	brokenMempoolTx := &MempoolTx{Tx: &brokenTxn, Hash: brokenTxn.Hash(), TxMeta: staleMeta}
+	mp.universalTransactionList = append([]*MempoolTx{brokenMempoolTx}, mp.universalTransactionList...)
+	require.NoError(mp.RefreshAllTransactionMetadata())
+	require.True(mp.universalTransactionList[0] == brokenMempoolTx)
+	require.Equal(staleMeta, brokenMempoolTx.TxMeta)
+	require.NotEqual(mempoolTx3.TxMeta, mp.poolMap[*txn3.Hash()].TxMeta)
+	require.Equal(2, len(mp.GetTransactionsAffectingPublicKey(recipientPkBytes)))
+}
+
+func TestMempoolGetTransactionsForUsername(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time {
+		fakeNow = fakeNow.Add(time.Second)
+		return fakeNow
+	}
+
+	processTxn := func(txn *MsgBitCloutTxn) {
+		_signTxn(t, txn, senderPrivString)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	profileTxn, _, _, _, err := chain.CreateUpdateProfileTxn(
+		senderPkBytes, nil, "sender", "", "", 5000, /*CreatorBasisPoints*/
+		12500 /*StakeMultiple*/, false /*isHidden*/, 0, /*additionalFees*/
+		1000 /*feeRateNanosPerKB*/, mp)
+	require.NoError(err)
+	processTxn(profileTxn)
+
+	// The profile only resolves once the readOnly view has caught up with it.
+	_, err = mp.GetTransactionsForUsername("sender")
+	require.Error(err)
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	transferTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	processTxn(transferTxn)
+
+	// Usernames are case-insensitive.
+	mempoolTxs, err := mp.GetTransactionsForUsername("Sender")
+	require.NoError(err)
+	require.Equal(2, len(mempoolTxs))
+	require.Equal(*profileTxn.Hash(), *mempoolTxs[0].Hash)
+	require.Equal(*transferTxn.Hash(), *mempoolTxs[1].Hash)
+
+	_, err = mp.GetTransactionsForUsername("nobody")
+	require.Error(err)
+}
+
+func TestMempoolGetProfileModifyingTxns(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	processTxn := func(txn *MsgBitCloutTxn, privKey string) {
+		_signTxn(t, txn, privKey)
+		_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+	}
+	updateProfile := func(updaterPkBytes []byte, updaterPriv string, username string) *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn, _, _, _, err := chain.CreateUpdateProfileTxn(
+			updaterPkBytes, nil, username, "", "", 5000, /*CreatorBasisPoints*/
+			12500 /*StakeMultiple*/, false /*isHidden*/, 0, /*additionalFees*/
+			1000 /*feeRateNanosPerKB*/, mp)
+		require.NoError(err)
+		processTxn(txn, updaterPriv)
+		return txn
+	}
+
+	// Fund the recipient so it can create a profile too.
+	require.NoError(mp.RegenerateReadOnlyView())
+	fundingTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10000, 1000,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	processTxn(fundingTxn, senderPrivString)
+
+	senderProfileTxn := updateProfile(senderPkBytes, senderPrivString, "sender")
+	recipientProfileTxn := updateProfile(recipientPkBytes, recipientPrivString, "recipient")
+
+	senderTxns := mp.GetProfileModifyingTxns(senderPkBytes)
+	require.Equal(1, len(senderTxns))
+	require.Equal(*senderProfileTxn.Hash(), *senderTxns[0].Hash)
+	recipientTxns := mp.GetProfileModifyingTxns(recipientPkBytes)
+	require.Equal(1, len(recipientTxns))
+	require.Equal(*recipientProfileTxn.Hash(), *recipientTxns[0].Hash)
+
+	// Removing a txn removes it from the index.
+	mp.InefficientRemoveTransaction(senderProfileTxn)
+	require.Empty(mp.GetProfileModifyingTxns(senderPkBytes))
+	require.Equal(1, len(mp.GetProfileModifyingTxns(recipientPkBytes)))
+
+	// A SwapIdentity modifies both profiles, and other txns don't modify any.
+	swapTxn := &MsgBitCloutTxn{
+		PublicKey: senderPkBytes,
+		TxnMeta: &SwapIdentityMetadataa{
+			FromPublicKey: senderPkBytes,
+			ToPublicKey:   recipientPkBytes,
+		},
+	}
+	require.Equal([][]byte{senderPkBytes, recipientPkBytes}, _getProfilePublicKeysModifiedByTxn(swapTxn))
+	require.Empty(_getProfilePublicKeysModifiedByTxn(fundingTxn))
+}
+
+func TestMempoolTransactionTags(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	acceptTxn := func(tags map[string]string) *MsgBitCloutTxn {
+		require.NoError(mp.RegenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		_, mempoolTx, err := mp.TryAcceptTransactionWithTags(txn, false /*rateLimit*/, true /*verifySignatures*/, tags)
+		require.NoError(err)
+		require.NotNil(mempoolTx)
+		require.Equal(tags, mempoolTx.Tags)
+		return txn
+	}
+
+	tags := map[string]string{"source": "api", "requestID": "1"}
+	txn1 := acceptTxn(tags)
+	txn2 := acceptTxn(map[string]string{"source": "api", "requestID": "2"})
+	txn3 := acceptTxn(nil)
+
+	// The tags are copied so modifying the caller's map has no effect.
+	tags["source"] = "modified"
+
+	checkTags := func() {
+		apiTxns := mp.GetTransactionsByTag("source", "api")
+		require.Equal(2, len(apiTxns))
+		require.Equal(*txn1.Hash(), *apiTxns[0].Hash)
+		require.Equal(*txn2.Hash(), *apiTxns[1].Hash)
+		requestTxns := mp.GetTransactionsByTag("requestID", "2")
+		require.Equal(1, len(requestTxns))
+		require.Equal(*txn2.Hash(), *requestTxns[0].Hash)
+		require.Empty(mp.GetTransactionsByTag("source", "modified"))
+		require.Nil(mp.poolMap[*txn3.Hash()].Tags)
+	}
+	checkTags()
+
+	// The tags survive the pool being rebuilt.
+	mp.rebuildPool(EvictReasonRemoved)
+	checkTags()
+	require.Equal("1", mp.poolMap[*txn1.Hash()].Tags["requestID"])
+
+	// Removing a txn drops its tags.
+	mp.InefficientRemoveTransaction(txn2)
+	require.Empty(mp.GetTransactionsByTag("requestID", "2"))
+	require.Equal(1, len(mp.GetTransactionsByTag("source", "api")))
+	require.Equal(1, len(mp.txnTags))
+}
+
+func TestMempoolFeePerKBRoundsAtMinFeeBoundary(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Find a txn whose exact feerate has a fractional part of at least one half, so
+	// truncating it and rounding it give different answers.
+	var txn *MsgBitCloutTxn
+	var fee, txnSize uint64
+	var err error
+	for feeRate := uint64(1000); feeRate < 2000; feeRate++ {
+		txn = &MsgBitCloutTxn{
+			TxOutputs: []*BitCloutOutput{{PublicKey: recipientPkBytes, AmountNanos: 10}},
+			PublicKey: senderPkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_, _, _, fee, err = chain.AddInputsAndChangeToTransaction(txn, feeRate, nil)
+		require.NoError(err)
+		_signTxn(t, txn, senderPrivString)
+		txBytes, err := txn.ToBytes(false)
+		require.NoError(err)
+		txnSize = uint64(len(txBytes))
+		if (fee*1000)%txnSize*2 >= txnSize {
+			break
+		}
+		txn = nil
+	}
+	require.NotNil(txn)
+
+	// Require exactly the txn's feerate. Truncating would put it just below this.
+	minFeeRate := (fee*1000 + txnSize/2) / txnSize
+	require.Less(fee*1000/txnSize, minFeeRate)
+	mp.SetMinFeeRate(minFeeRate)
+
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mempoolTxs))
+	require.Equal(minFeeRate, mempoolTxs[0].FeePerKB)
+}
+
+func TestMempoolGetPublicKeysWithPendingTxns(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	require.Empty(mp.GetPublicKeysWithPendingTxns())
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	publicKeys := mp.GetPublicKeysWithPendingTxns()
+	require.ElementsMatch([][]byte{senderPkBytes, recipientPkBytes}, publicKeys)
+
+	// Modifying a returned key doesn't touch the pool's index.
+	publicKeys[0][0] ^= 0xff
+	require.ElementsMatch([][]byte{senderPkBytes, recipientPkBytes}, mp.GetPublicKeysWithPendingTxns())
+}
+
+func TestMempoolGetMempoolTxsForPublicKeys(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	fakeNow = fakeNow.Add(time.Second)
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, senderPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Duplicate keys are fine and keys without pending txns are left out.
+	unknownPkBytes := make([]byte, len(senderPkBytes))
+	mempoolTxsByPublicKey := mp.GetMempoolTxsForPublicKeys(
+		[][]byte{senderPkBytes, recipientPkBytes, senderPkBytes, unknownPkBytes})
+	require.Equal(2, len(mempoolTxsByPublicKey))
+
+	senderTxs := mempoolTxsByPublicKey[MakePkMapKey(senderPkBytes)]
+	require.Equal(2, len(senderTxs))
+	require.Equal(*txn1.Hash(), *senderTxs[0].Hash)
+	require.Equal(*txn2.Hash(), *senderTxs[1].Hash)
+
+	recipientTxs := mempoolTxsByPublicKey[MakePkMapKey(recipientPkBytes)]
+	require.Equal(1, len(recipientTxs))
+	require.Equal(*txn1.Hash(), *recipientTxs[0].Hash)
+
+	require.Empty(mp.GetMempoolTxsForPublicKeys(nil))
+}
+
+func TestMempoolSubscribePublicKey(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	recipientChan, unsubscribe := mp.SubscribePublicKey(recipientPkBytes)
+
+	// A txn paying the recipient is sent, once, even though the recipient's key is
+	// indexed for it more than once.
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(recipientChan))
+	receivedTx := <-recipientChan
+	require.Equal(*txn1.Hash(), *receivedTx.Hash)
+	// The txn is only sent once it's complete, metadata included.
+	require.NotNil(receivedTx.TxMeta)
+	require.True(receivedTx == mp.poolMap[*txn1.Hash()])
+
+	// The same goes for the flags and tags set by the TryAccept* entry points.
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn3 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, _, err = mp.TryAcceptTransactionWithTags(txn3, false /*rateLimit*/, true, /*verifySignatures*/
+		map[string]string{"source": "test"})
+	require.NoError(err)
+	require.Equal(1, len(recipientChan))
+	receivedTx = <-recipientChan
+	require.Equal(*txn3.Hash(), *receivedTx.Hash)
+	require.NotNil(receivedTx.TxMeta)
+	require.Equal(map[string]string{"source": "test"}, receivedTx.Tags)
+
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn4 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, mp)
+	_, _, err = mp.TryAcceptLocalTransaction(txn4, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(recipientChan))
+	receivedTx = <-recipientChan
+	require.True(receivedTx.Local)
+	require.NotNil(receivedTx.TxMeta)
+
+	// A txn that doesn't touch the recipient isn't.
+	require.NoError(mp.RegenerateReadOnlyView())
+	txn2 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, senderPkString, senderPrivString, mp)
+	_, err = mp.processTransaction(txn2, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(0, len(recipientChan))
+
+	// Unsubscribing closes the channel and can safely be done twice.
+	unsubscribe()
+	unsubscribe()
+	_, isOpen := <-recipientChan
+	require.False(isOpen)
+	require.Empty(mp.publicKeySubscriptions)
+}
+
+func TestMempoolDemoteOrphanedBitcoinExchange(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	basicTxn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+
+	// Only minedBlockHash is on the main Bitcoin chain.
+	minedBlockHash := BlockHash{0x01}
+	orphanedBlockHash := BlockHash{0x02}
+	oldBitcoinManager := chain.bitcoinManager
+	chain.bitcoinManager = &BitcoinManager{
+		bestHeaderChainMap: map[BlockHash]*BlockNode{minedBlockHash: {}},
+	}
+	defer func() { chain.bitcoinManager = oldBitcoinManager }()
+
+	bitcoinTxn := wire.NewMsgTx(1)
+	bitcoinTxn.AddTxOut(wire.NewTxOut(10000, []byte{}))
+	makeExchangeTxn := func(bitcoinBlockHash BlockHash) *MsgBitCloutTxn {
+		return &MsgBitCloutTxn{
+			TxnMeta: &BitcoinExchangeMetadata{
+				BitcoinTransaction: bitcoinTxn,
+				BitcoinBlockHash:   &bitcoinBlockHash,
+				BitcoinMerkleRoot:  &BlockHash{0x03},
+			},
+		}
+	}
+
+	// Txns mined into a Bitcoin block that's still on the main chain are untouched.
+	minedTxn := makeExchangeTxn(minedBlockHash)
+	require.Equal(minedTxn, mp._demoteOrphanedBitcoinExchange(minedTxn))
+
+	// Txns mined into an orphaned Bitcoin block become unmined copies with the same hash.
+	orphanedTxn := makeExchangeTxn(orphanedBlockHash)
+	demotedTxn := mp._demoteOrphanedBitcoinExchange(orphanedTxn)
+	require.True(IsUnminedBitcoinExchange(demotedTxn.TxnMeta.(*BitcoinExchangeMetadata)))
+	require.Equal(*orphanedTxn.Hash(), *demotedTxn.Hash())
+	require.False(IsUnminedBitcoinExchange(orphanedTxn.TxnMeta.(*BitcoinExchangeMetadata)))
+
+	// Other txns are returned as-is.
+	require.Equal(basicTxn, mp._demoteOrphanedBitcoinExchange(basicTxn))
+}
+
+func TestMempoolRejectsBitcoinExchangeWithoutBurnOutput(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	makeExchangeTxn := func(bitcoinTxn *wire.MsgTx) *MsgBitCloutTxn {
+		return &MsgBitCloutTxn{
+			TxnMeta: &BitcoinExchangeMetadata{
+				BitcoinTransaction: bitcoinTxn,
+				BitcoinBlockHash:   &BlockHash{},
+				BitcoinMerkleRoot:  &BlockHash{},
+			},
+		}
+	}
+
+	// A Bitcoin txn with no outputs at all.
+	_, _, err := mp.tryAcceptBitcoinExchangeTxn(makeExchangeTxn(wire.NewMsgTx(1)), chain.blockTip().Height+1)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorBitcoinExchangeHasNoOutputs)
+
+	// A Bitcoin txn whose only output doesn't pay the burn address.
+	bitcoinTxn := wire.NewMsgTx(1)
+	bitcoinTxn.AddTxOut(wire.NewTxOut(10000, []byte{}))
+	_, _, err = mp.tryAcceptBitcoinExchangeTxn(makeExchangeTxn(bitcoinTxn), chain.blockTip().Height+1)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorBitcoinExchangeHasNoBurnOutput)
+	require.Equal(0, len(mp.poolMap))
+}
+
+func TestMempoolTrustedPeerIDsSkipSignatureVerification(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// Sign the sender's txn with the wrong key.
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, recipientPrivString, nil)
+
+	peerID := uint64(7)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, peerID, true /*verifySignatures*/)
+	require.Error(err)
+
+	mp.SetTrustedPeerIDs([]uint64{peerID})
+	mempoolTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, peerID, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mempoolTxs))
+}
+
+func TestMempoolGetConflictingTransactions(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxs, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Equal(1, len(mempoolTxs))
+
+	// A txn already in the pool doesn't conflict with itself.
+	require.Empty(mp.GetConflictingTransactions(txn1))
+
+	// A txn spending the same inputs conflicts with txn1, and it's only reported once
+	// even though every input conflicts.
+	conflictingTxn := &MsgBitCloutTxn{
+		TxInputs: txn1.TxInputs,
+		TxOutputs: []*BitCloutOutput{
+			{PublicKey: recipientPkBytes, AmountNanos: 20},
+		},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, conflictingTxn, senderPrivString)
+	require.Equal([]*MempoolTx{mempoolTxs[0]}, mp.GetConflictingTransactions(conflictingTxn))
+
+	// A txn spending an outpoint nobody spends has no conflicts.
+	unrelatedTxn := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: BlockHash{0x01}, Index: 0}},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	require.Empty(mp.GetConflictingTransactions(unrelatedTxn))
+
+	// Looking for conflicts doesn't add anything to the pool.
+	require.Equal(1, len(mp.poolMap))
+}
+
+func TestMempoolGetMinReplacementFee(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	// txnC spends txnA's first output so replacing txnA would drop txnC too.
+	txnA := _assembleBasicTransferTxnFullySigned(t, chain, 10, 1000,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	mempoolTxsA, err := mp.processTransaction(txnA, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txnC := &MsgBitCloutTxn{
+		TxInputs: []*BitCloutInput{
+			{TxID: *txnA.Hash(), Index: 0},
+		},
+		TxOutputs: []*BitCloutOutput{
+			{PublicKey: senderPkBytes, AmountNanos: 1},
+		},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, txnC, recipientPrivString)
+	mempoolTxsC, err := mp.processTransaction(txnC, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	txA, txC := mempoolTxsA[0], mempoolTxsC[0]
+	require.NotZero(txA.Fee)
+
+	minFee, err := mp.GetMinReplacementFee(txnA.Hash())
+	require.NoError(err)
+	require.Equal(txA.Fee+txC.Fee, minFee)
+
+	// The bump is applied to the size of the replaced txn only.
+	mp.SetReplacementFeeBump(1000)
+	minFee, err = mp.GetMinReplacementFee(txnA.Hash())
+	require.NoError(err)
+	require.Equal(txA.Fee+txC.Fee+txA.TxSizeBytes, minFee)
+
+	// Replacing the leaf txn only has to cover its own fee.
+	minFee, err = mp.GetMinReplacementFee(txnC.Hash())
+	require.NoError(err)
+	require.Equal(txC.Fee+txC.TxSizeBytes, minFee)
+
+	_, err = mp.GetMinReplacementFee(&BlockHash{0x01})
+	require.Error(err)
+}
+
+func TestMempoolWithCachedAugmentedUniversalView(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	getCachedView := func() *UtxoView {
+		var cachedView *UtxoView
+		require.NoError(mp.WithCachedAugmentedUniversalView(func(view *UtxoView) error {
+			cachedView = view
+			return nil
+		}))
+		return cachedView
+	}
+
+	// The view is only copied once while the readOnly view stays the same.
+	view1 := getCachedView()
+	require.True(view1 == getCachedView())
+	require.True(mp.readOnlyUtxoView != view1)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// Once the readOnly view is regenerated the cached view picks up the new txn.
+	require.NoError(mp.WithCachedAugmentedUniversalView(func(view *UtxoView) error {
+		require.True(view != view1)
+		utxoEntries, err := view.GetUnspentUtxoEntrysForPublicKey(recipientPkBytes)
+		require.NoError(err)
+		require.Equal(1, len(utxoEntries))
+		require.Equal(*txn.Hash(), utxoEntries[0].UtxoKey.TxID)
+		return nil
+	}))
+
+	// Errors from fn are passed through.
+	fnErr := fmt.Errorf("fn failed")
+	require.Equal(fnErr, mp.WithCachedAugmentedUniversalView(func(view *UtxoView) error {
+		return fnErr
+	}))
+}
+
+func TestMempoolWithReadOnlyView(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.RegenerateReadOnlyView())
+
+	// The txn's output is read straight out of the readOnly view's maps.
+	outputKey := &UtxoKey{TxID: *txn.Hash(), Index: 0}
+	require.NoError(mp.WithReadOnlyView(func(view *UtxoView) error {
+		require.True(view != mp.readOnlyUtxoView)
+		utxoEntry := view.GetUtxoEntryForUtxoKey(outputKey)
+		require.NotNil(utxoEntry)
+		require.True(utxoEntry == mp.readOnlyUtxoView.UtxoKeyToUtxoEntry[*outputKey])
+		return nil
+	}))
+
+	// A lookup that would fall through to the DB fails without caching anything in
+	// the shared view.
+	numUtxoEntries := len(mp.readOnlyUtxoView.UtxoKeyToUtxoEntry)
+	err = mp.WithReadOnlyView(func(view *UtxoView) error {
+		view.GetUtxoEntryForUtxoKey(&UtxoKey{TxID: BlockHash{0x01}, Index: 0})
+		return nil
+	})
+	require.Error(err)
+	require.Contains(err.Error(), "WithReadOnlyView")
+	require.Equal(numUtxoEntries, len(mp.readOnlyUtxoView.UtxoKeyToUtxoEntry))
+
+	// Errors from fn are passed through.
+	fnErr := fmt.Errorf("fn failed")
+	require.Equal(fnErr, mp.WithReadOnlyView(func(view *UtxoView) error {
+		return fnErr
+	}))
+}
+
+func TestMempoolGetReadOnlyViewSequenceNumber(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	seqNum1 := mp.GetReadOnlyViewSequenceNumber()
+	view1, err := mp.GetAugmentedUniversalView()
+	require.NoError(err)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	// Accepting a txn doesn't move the sequence number until the readOnly view is
+	// regenerated, at which point views taken before it are stale.
+	require.Equal(seqNum1, mp.GetReadOnlyViewSequenceNumber())
+	require.NoError(mp.RegenerateReadOnlyView())
+	require.Equal(seqNum1+1, mp.GetReadOnlyViewSequenceNumber())
+	utxoEntries, err := view1.GetUnspentUtxoEntrysForPublicKey(recipientPkBytes)
+	require.NoError(err)
+	require.Empty(utxoEntries)
+	view2, err := mp.GetAugmentedUniversalView()
+	require.NoError(err)
+	utxoEntries, err = view2.GetUnspentUtxoEntrysForPublicKey(recipientPkBytes)
+	require.NoError(err)
+	require.Equal(1, len(utxoEntries))
+	require.Equal(*txn.Hash(), utxoEntries[0].UtxoKey.TxID)
+}
+
+func TestMempoolGetValidationHeight(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	tipHeight := uint32(chain.blockTip().Height)
+	require.Equal(tipHeight+1, mp.GetValidationHeight())
+
+	// The height only moves once the pool is updated for the chain's new tip.
+	bestChain := chain.BestChain()
+	chain.SetBestChain(bestChain[:len(bestChain)-1])
+	require.Equal(tipHeight+1, mp.GetValidationHeight())
+	emptyBlock := &MsgBitCloutBlock{
+		Txns: []*MsgBitCloutTxn{&MsgBitCloutTxn{TxnMeta: &BlockRewardMetadataa{}}},
+	}
+	mp.UpdateAfterDisconnectBlock(emptyBlock)
+	require.Equal(tipHeight, mp.GetValidationHeight())
+
+	chain.SetBestChain(bestChain)
+	mp.UpdateAfterConnectBlock(emptyBlock)
+	require.Equal(tipHeight+1, mp.GetValidationHeight())
+}
+
+func TestMempoolGetOldestAndHighestFeeTransaction(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	require.Nil(mp.GetOldestTransaction())
+	require.Nil(mp.GetHighestFeeTransaction())
+
+	mempoolTxs := []*MempoolTx{}
+	for _, feeRateNanosPerKB := range []uint64{1000, 3000, 2000} {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, feeRateNanosPerKB,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+		fakeNow = fakeNow.Add(time.Minute)
+	}
+	require.Equal(mempoolTxs[0], mp.GetOldestTransaction())
+	require.Equal(mempoolTxs[1], mp.GetHighestFeeTransaction())
+
+	// Rebuilds can leave newer txns at the front of the universalTransactionList.
+	// The oldest txn should still be found.
+	mp.universalTransactionList = []*MempoolTx{mempoolTxs[2], mempoolTxs[1], mempoolTxs[0]}
+	require.Equal(mempoolTxs[0], mp.GetOldestTransaction())
+}
+
+func TestMempoolGetTransactionsAddedAfter(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	startTime := time.Unix(1600000000, 0)
+	fakeNow := startTime
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	mempoolTxs := []*MempoolTx{}
+	for ii := 0; ii < 3; ii++ {
+		require.NoError(mp.regenerateReadOnlyView())
+		txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+			senderPkString, recipientPkString, senderPrivString, mp)
+		acceptedTxs, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+		require.NoError(err)
+		mempoolTxs = append(mempoolTxs, acceptedTxs[0])
+		fakeNow = fakeNow.Add(time.Minute)
+	}
+	require.NoError(mp.regenerateReadOnlyView())
+
+	// The cutoff is exclusive.
+	require.Equal(mempoolTxs, mp.GetTransactionsAddedAfter(time.Time{}))
+	require.Equal(mempoolTxs[1:], mp.GetTransactionsAddedAfter(startTime))
+	require.Empty(mp.GetTransactionsAddedAfter(startTime.Add(2 * time.Minute)))
+
+	// The result is in Added order even when the list isn't.
+	mp.readOnlyUniversalTransactionList = []*MempoolTx{mempoolTxs[2], mempoolTxs[0], mempoolTxs[1]}
+	require.Equal(mempoolTxs[1:], mp.GetTransactionsAddedAfter(startTime))
+}
+
+func TestMempoolHaveTransaction(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, senderPkBytes, recipientPkBytes := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+
+	unconnectedTxn := &MsgBitCloutTxn{
+		TxInputs:  []*BitCloutInput{{TxID: BlockHash{0x01}, Index: 0}},
+		TxOutputs: []*BitCloutOutput{{PublicKey: senderPkBytes, AmountNanos: 1}},
+		PublicKey: recipientPkBytes,
+		TxnMeta:   &BasicTransferMetadata{},
+	}
+	_signTxn(t, unconnectedTxn, recipientPrivString)
+	_, err = mp.processTransaction(unconnectedTxn, true /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, false /*verifySignatures*/)
+	require.NoError(err)
+
+	// Connected txns are checked against the readOnly view so they only show up once
+	// it's been regenerated.
+	inPool, unconnected := mp.HaveTransaction(txn.Hash())
+	require.False(inPool)
+	require.False(unconnected)
+	require.NoError(mp.regenerateReadOnlyView())
+	inPool, unconnected = mp.HaveTransaction(txn.Hash())
+	require.True(inPool)
+	require.False(unconnected)
+
+	inPool, unconnected = mp.HaveTransaction(unconnectedTxn.Hash())
+	require.False(inPool)
+	require.True(unconnected)
+
+	inPool, unconnected = mp.HaveTransaction(&BlockHash{0x02})
+	require.False(inPool)
+	require.False(unconnected)
+}
+
+func TestMempoolSetFeeRates(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	mp := _newTestMempool(t, chain)
+	fakeNow := time.Unix(1600000000, 0)
+	mp.nowFunc = func() time.Time { return fakeNow }
+
+	txn := _assembleBasicTransferTxnFullySigned(t, chain, 10, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+
+	// Raising the min feerate rejects a zero-fee txn outright.
+	mp.SetMinFeeRate(1000)
+	_, err := mp.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorInsufficientFeeMinFee)
+	mp.SetMinFeeRate(0)
+
+	// Fill up the low-fee allowance so that rate-limited txns are rejected. A zero
+	// rate-limit feerate means no txn counts as low-fee.
+	mp.lowFeeTxSizeAccumulator = float64(LowFeeTxLimitBytesPerTenMinutes)
+	mp.lastLowFeeTxUnixTime = fakeNow.Unix()
+	mp.SetRateLimitFeeRate(1000)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), TxErrorInsufficientFeeRateLimit)
+
+	mp.SetRateLimitFeeRate(0)
+	_, err = mp.processTransaction(txn, false /*allowUnconnectedTxn*/, true /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.Contains(mp.poolMap, *txn.Hash())
+}
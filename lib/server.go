@@ -293,6 +293,7 @@ func NewServer(
 	_runReadOnlyUtxoViewUpdater bool,
 	_dataDir string,
 	_mempoolDumpDir string,
+	_mempoolOpts *MempoolOptions,
 	_disableNetworking bool,
 	_readOnlyMode bool,
 	_ignoreInboundPeerInvMessages bool,
@@ -364,9 +365,12 @@ func NewServer(
 
 	// Create a mempool to store transactions until they're ready to be mined into
 	// blocks.
-	_mempool := NewBitCloutMempool(_chain, _rateLimitFeerateNanosPerKB,
+	_mempool, err := NewBitCloutMempool(_chain, _rateLimitFeerateNanosPerKB,
 		_minFeeRateNanosPerKB, _blockCypherAPIKey, _runReadOnlyUtxoViewUpdater, _dataDir,
-		_mempoolDumpDir)
+		_mempoolDumpDir, _mempoolOpts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem initializing mempool")
+	}
 
 	// Useful for debugging. Every second, it outputs the contents of the mempool
 	// and the contents of the addrmanager.
@@ -981,6 +985,10 @@ func (srv *Server) _handleDonePeer(pp *Peer) {
 
 	srv._cleanupDonePeerPeerState(pp)
 
+	// Drop any unconnectedTxns the peer sent us so they don't linger until they
+	// expire.
+	srv.mempool.RemoveUnconnectedTxnsFromPeer(pp.ID)
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer and if our blockchain isn't current.
 	if srv.SyncPeer == pp && srv.blockchain.isSyncing() {
@@ -998,7 +1006,10 @@ func (srv *Server) _relayTransactions() {
 	// For each peer, compute the transactions they're missing from the mempool and
 	// send them an inv.
 	allPeers := srv.cmgr.GetAllPeers()
-	txnList := srv.mempool.readOnlyUniversalTransactionList
+	// Relay txns that were submitted to this node, along with the txns they spend
+	// from, ahead of everything else.
+	txnList := srv.mempool.GetTransactionsInRelayOrder()
+
 	for _, pp := range allPeers {
 		if !pp.canReceiveInvMessagess {
 			glog.Debugf("Skipping invs for peer %v because not ready "+
@@ -119,6 +119,16 @@ func (m *RWMutex) Lock() {
 	lock(m.mu.Lock, m)
 }
 
+// TryLock tries to lock rw for writing and reports whether it succeeded.
+// Unlike Lock it never blocks, so it never waits on readers or writers that
+// hold the lock and never excludes new readers.
+//
+// Unless deadlock detection is disabled, a successful TryLock is tracked the
+// same way as a Lock.
+func (m *RWMutex) TryLock() bool {
+	return trylock(m.mu.TryLock, m)
+}
+
 // Unlock unlocks the mutex for writing.  It is a run-time error if rw is
 // not locked for writing on entry to Unlock.
 //
@@ -235,6 +245,18 @@ func lock(lockFn func(), ptr interface{}) {
 	postLock(4, ptr)
 }
 
+func trylock(lockFn func() bool, ptr interface{}) bool {
+	if Opts.Disable {
+		return lockFn()
+	}
+	preLock(4, ptr)
+	if !lockFn() {
+		return false
+	}
+	postLock(4, ptr)
+	return true
+}
+
 type lockOrder struct {
 	mu    sync.Mutex
 	cur   map[interface{}]stackGID // stacktraces + gids for the locks currently taken.